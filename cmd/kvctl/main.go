@@ -0,0 +1,186 @@
+// kvctl operates the key-value service from the command line
+//
+//	kvctl get key1
+//	echo -n value1 | kvctl set key1 -
+//	kvctl --server http://localhost:8080 keys user:
+//	kvctl export > dump.jsonl && kvctl import < dump.jsonl
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang-web-service-template/pkg/kvclient"
+)
+
+// exit codes, scripts can tell a missing key apart from a failed request
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+)
+
+const usage = `usage: kvctl [flags] <command> [arguments]
+
+commands:
+  get <key>            print the raw value of the key
+  set <key> <value|->  set the key, - reads the value from stdin
+  delete <key>         delete the key
+  keys [prefix]        list the keys starting with prefix, one per line
+  export               write a dump of the store to stdout
+  import               restore a dump read from stdin
+  stats                print the store statistics as JSON
+
+flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line and returns the exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("kvctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", envOr("KVCTL_SERVER", "http://localhost:8080"), "base URL of the service")
+	adminServer := fs.String("admin-server", os.Getenv("KVCTL_ADMIN_SERVER"), "base URL of the admin endpoints if the service serves them on a dedicated address")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request, 0 means no timeout")
+	apiKey := fs.String("api-key", os.Getenv("KVCTL_API_KEY"), "API key sent with every request")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	options := []kvclient.Option{kvclient.WithTimeout(*timeout)}
+	if *apiKey != "" {
+		options = append(options, kvclient.WithAPIKey(*apiKey))
+	}
+	if *adminServer != "" {
+		options = append(options, kvclient.WithAdminURL(*adminServer))
+	}
+	client, err := kvclient.New(*server, nil, options...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	cmd := command{client: client, stdin: stdin, stdout: stdout}
+	err = cmd.run(context.Background(), fs.Arg(0), fs.Args()[1:])
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(stderr, err)
+		fs.Usage()
+		return exitUsage
+	case errors.Is(err, kvclient.ErrKeyNotFound):
+		fmt.Fprintln(stderr, err)
+		return exitNotFound
+	default:
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+}
+
+var errUsage = errors.New("invalid arguments")
+
+// command runs a single subcommand against the client
+type command struct {
+	client *kvclient.Client
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func (c command) run(ctx context.Context, name string, args []string) error {
+	switch name {
+	case "get":
+		if len(args) != 1 {
+			return fmt.Errorf("%w: get <key>", errUsage)
+		}
+		value, err := c.client.Get(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(c.stdout, value)
+		return err
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: set <key> <value|->", errUsage)
+		}
+		value := args[1]
+		if value == "-" {
+			b, err := io.ReadAll(c.stdin)
+			if err != nil {
+				return err
+			}
+			value = string(b)
+		}
+		return c.client.Set(ctx, args[0], value)
+	case "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("%w: delete <key>", errUsage)
+		}
+		return c.client.Delete(ctx, args[0])
+	case "keys":
+		if len(args) > 1 {
+			return fmt.Errorf("%w: keys [prefix]", errUsage)
+		}
+		prefix := ""
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		keys, err := c.client.Keys(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Fprintln(c.stdout, key)
+		}
+		return nil
+	case "export":
+		return c.client.Export(ctx, c.stdout)
+	case "import":
+		n, err := c.client.Import(ctx, c.stdin)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "restored %d keys\n", n)
+		return nil
+	case "stats":
+		stats, err := c.client.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, name)
+	}
+}
+
+// envOr returns the value of the environment variable, or def if it is unset or empty
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang-web-service-template/pkg/kvservice"
+)
+
+// kvctl runs the command line against the server and returns the exit code with stdout and stderr
+func kvctl(t *testing.T, server *httptest.Server, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"--server", server.URL}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestKvctl(t *testing.T) {
+	server := httptest.NewServer(kvservice.NewHandler(kvservice.Config{}))
+	defer server.Close()
+
+	if code, _, _ := kvctl(t, server, "", "set", "user:1", "alice"); code != exitOK {
+		t.Fatalf("set: expected exit code %d but got %d", exitOK, code)
+	}
+	if code, _, _ := kvctl(t, server, "line one\nline two\n", "set", "user:2", "-"); code != exitOK {
+		t.Fatalf("set from stdin: expected exit code %d but got %d", exitOK, code)
+	}
+
+	// get prints the raw value so it can be piped
+	code, stdout, _ := kvctl(t, server, "", "get", "user:2")
+	if code != exitOK || stdout != "line one\nline two\n" {
+		t.Errorf("get: expected the raw value but got %d %q", code, stdout)
+	}
+
+	code, stdout, _ = kvctl(t, server, "", "keys", "user:")
+	if code != exitOK || stdout != "user:1\nuser:2\n" {
+		t.Errorf("keys: expected both keys but got %d %q", code, stdout)
+	}
+
+	code, dump, _ := kvctl(t, server, "", "export")
+	if code != exitOK || strings.Count(dump, "\n") != 2 {
+		t.Fatalf("export: expected 2 records but got %d %q", code, dump)
+	}
+
+	if code, _, _ := kvctl(t, server, "", "delete", "user:1"); code != exitOK {
+		t.Errorf("delete: expected exit code %d but got %d", exitOK, code)
+	}
+	if code, _, _ := kvctl(t, server, "", "get", "user:1"); code != exitNotFound {
+		t.Errorf("get deleted key: expected exit code %d but got %d", exitNotFound, code)
+	}
+	if code, _, _ := kvctl(t, server, "", "delete", "user:1"); code != exitNotFound {
+		t.Errorf("delete deleted key: expected exit code %d but got %d", exitNotFound, code)
+	}
+
+	code, stdout, _ = kvctl(t, server, dump, "import")
+	if code != exitOK || stdout != "restored 2 keys\n" {
+		t.Errorf("import: expected 2 restored keys but got %d %q", code, stdout)
+	}
+	if code, stdout, _ := kvctl(t, server, "", "get", "user:1"); code != exitOK || stdout != "alice" {
+		t.Errorf("get imported key: expected %q but got %d %q", "alice", code, stdout)
+	}
+
+	code, stdout, _ = kvctl(t, server, "", "stats")
+	var stats struct {
+		Keys int `json:"keys"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &stats); code != exitOK || err != nil || stats.Keys != 2 {
+		t.Errorf("stats: expected 2 keys but got %d %q", code, stdout)
+	}
+}
+
+func TestKvctl_ExitCodes(t *testing.T) {
+	server := httptest.NewServer(kvservice.NewHandler(kvservice.Config{ReadOnly: true}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "no command", args: nil, want: exitUsage},
+		{name: "unknown command", args: []string{"frobnicate"}, want: exitUsage},
+		{name: "missing argument", args: []string{"get"}, want: exitUsage},
+		{name: "missing key", args: []string{"get", "missing"}, want: exitNotFound},
+		{name: "server error", args: []string{"set", "key", "value"}, want: exitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _, stderr := kvctl(t, server, "", tt.args...); code != tt.want {
+				t.Errorf("expected exit code %d but got %d: %s", tt.want, code, stderr)
+			}
+		})
+	}
+
+	// a server that is not reachable is a transport error
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--server", "http://127.0.0.1:1", "get", "key"}, nil, &stdout, &stderr); code != exitError {
+		t.Errorf("unreachable server: expected exit code %d but got %d", exitError, code)
+	}
+}
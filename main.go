@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang-web-service-template/pkg/kvservice"
+)
+
+// version, commit and date are only set at build time and read once in main, everything else gets them via Config.Build
+// go build -ldflags "-X main.version=1.5.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o main .
+var (
+	version string
+	commit  string
+	date    string
+)
+
+func main() {
+	env, err := kvservice.ParseConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := env.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	env.ServiceName = "key-value-service-v1"
+	env.Build = kvservice.NewBuildInfo(version, commit, date)
+
+	logOutput, err := kvservice.OpenLogOutput(env.LogOutput)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(kvservice.NewLogger(logOutput))
+
+	slog.Info("configuration", "config", env)
+
+	err = run(env)
+	if err != nil {
+		slog.Error("server failed", "error", err)
+	}
+	// os.Exit skips deferred calls, so the log output is closed first for the last lines to reach the file
+	if err := logOutput.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	// a shutdown that timed out or lost the final snapshot exits with --shutdown-exit-code, other failures with 1
+	os.Exit(env.ExitCode(err))
+}
+
+// run serves until SIGTERM or an interrupt, SIGHUP reloads the configuration instead of stopping the server
+// and SIGQUIT logs the stacks of all goroutines, the server keeps serving
+func run(env kvservice.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	stopDumps := kvservice.LogGoroutinesOn(syscall.SIGQUIT)
+	defer stopDumps()
+
+	server, err := kvservice.Listen(env)
+	if err != nil {
+		return err
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			if err := server.Reload(); err != nil {
+				slog.Error("failed to reload configuration", "error", err)
+			}
+		}
+	}()
+
+	return server.Run(ctx)
+}
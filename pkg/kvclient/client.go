@@ -0,0 +1,328 @@
+// Package kvclient is a client for the key-value service
+package kvclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrKeyNotFound is returned when the key does not exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// Error is returned for every response that is not a success and not a missing key
+// Code and Message are taken from the JSON error body if the server sent one, otherwise Message holds the plain body
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("kvclient: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("kvclient: %d: %s", e.StatusCode, e.Message)
+}
+
+// Client talks to the key-value service at a base URL, it is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+	apiKey     string
+	// adminBaseURL is where stats, export and import are sent when the admin endpoints have their own address
+	adminBaseURL string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTimeout limits every call to the given duration in addition to the deadline of its context
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithAPIKey sends the key in the X-API-Key header of every request
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithAdminURL sends the admin requests (stats, export and import) to the given base URL
+// it is needed when the server serves the admin endpoints on a dedicated address
+func WithAdminURL(adminURL string) Option {
+	return func(c *Client) {
+		c.adminBaseURL = strings.TrimSuffix(adminURL, "/")
+	}
+}
+
+// New returns a client for the service at baseURL e.g. http://localhost:8080, a nil httpClient uses http.DefaultClient
+func New(baseURL string, httpClient *http.Client, options ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("kvclient: invalid base URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("kvclient: invalid base URL %q, expected http://host:port", baseURL)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c, nil
+}
+
+// Get returns the value of the key or ErrKeyNotFound
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var response struct {
+		Value    *string `json:"value"`
+		Encoding string  `json:"encoding"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/get", map[string]string{"key": key}, &response); err != nil {
+		return "", err
+	}
+	// a server started with --missing-key-status 200 answers a missing key with a null value
+	if response.Value == nil {
+		return "", ErrKeyNotFound
+	}
+	// values that are not valid UTF-8 are sent base64 encoded
+	if response.Encoding == "base64" {
+		b, err := base64.StdEncoding.DecodeString(*response.Value)
+		if err != nil {
+			return "", fmt.Errorf("decode value: %w", err)
+		}
+		return string(b), nil
+	}
+	return *response.Value, nil
+}
+
+// Set stores the value under the key, overwriting an existing value
+// a value that is not valid UTF-8 is sent base64 encoded so binary values arrive unchanged
+func (c *Client) Set(ctx context.Context, key, value string) error {
+	if !utf8.ValidString(value) {
+		return c.do(ctx, http.MethodPost, "/set", map[string]string{"key": key, "value": base64.StdEncoding.EncodeToString([]byte(value)), "encoding": "base64"}, nil)
+	}
+	return c.do(ctx, http.MethodPost, "/set", map[string]string{"key": key, "value": value}, nil)
+}
+
+// Delete removes the key or returns ErrKeyNotFound if it does not exist
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, "/kv/"+url.PathEscape(key), nil, nil)
+}
+
+// MGet returns the values of all keys that exist, missing keys are left out of the map
+// the keys are fetched one by one, so the result is not a consistent snapshot of the store
+func (c *Client) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, err := c.Get(ctx, key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get %q: %w", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// do sends the request for a key with the JSON encoded body and decodes a successful JSON response into out if it is not nil
+// a 404 means the key does not exist and is returned as ErrKeyNotFound
+func (c *Client) do(ctx context.Context, method, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	resp, err := c.send(ctx, method, c.baseURL+path, reader, "application/json")
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusNotFound {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// get sends a GET request and decodes the JSON response into out, it returns the response header
+func (c *Client) get(ctx context.Context, url string, out any) (http.Header, error) {
+	resp, err := c.send(ctx, http.MethodGet, url, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends the request and returns the response if it is a success, the caller must close its body
+// the timeout of the client covers reading the body, so it is only released once the body is closed
+func (c *Client) send(ctx context.Context, method, url string, body io.Reader, contentType string) (*http.Response, error) {
+	var cancel context.CancelFunc = func() {}
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if body != nil && contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer cancel()
+		defer resp.Body.Close()
+		return nil, newError(resp)
+	}
+
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the timeout of a request once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// adminURL is the base URL of the admin endpoints, by default the same as the base URL
+func (c *Client) adminURL() string {
+	if c.adminBaseURL != "" {
+		return c.adminBaseURL
+	}
+	return c.baseURL
+}
+
+// newError builds the Error for a failed response from its JSON error body or its plain text
+func newError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, e) == nil {
+		return e
+	}
+	e.Message = strings.TrimSpace(string(body))
+	return e
+}
+
+// Stats are the counters of the store as reported by the stats endpoint
+type Stats struct {
+	Keys          int     `json:"keys"`
+	Bytes         int64   `json:"bytes"`
+	GetHits       uint64  `json:"get_hits"`
+	GetMisses     uint64  `json:"get_misses"`
+	Sets          uint64  `json:"sets"`
+	Deletes       uint64  `json:"deletes"`
+	Evictions     uint64  `json:"evictions"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Version       string  `json:"version"`
+}
+
+// Keys returns the keys starting with prefix in sorted order, an empty prefix returns all keys
+// the keys are fetched page by page, keys written during the listing may or may not be included
+func (c *Client) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"prefix": {prefix}, "limit": {"1000"}}
+	for {
+		var items []struct {
+			Key string `json:"key"`
+		}
+		header, err := c.get(ctx, c.baseURL+"/scan?"+query.Encode(), &items)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+
+		cursor := header.Get("X-Next-Cursor")
+		if cursor == "" {
+			return keys, nil
+		}
+		query.Set("cursor", cursor)
+	}
+}
+
+// Stats returns the counters of the store
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	_, err := c.get(ctx, c.adminURL()+"/stats", &stats)
+	return stats, err
+}
+
+// Export writes a dump of the whole store as newline delimited JSON to w
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, c.adminURL()+"/dump", nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Import writes the keys of a dump read from r into the store and returns how many were restored
+func (c *Client) Import(ctx context.Context, r io.Reader) (int, error) {
+	resp, err := c.send(ctx, http.MethodPost, c.adminURL()+"/restore", r, "application/x-ndjson")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Restored int `json:"restored"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	return response.Restored, err
+}
@@ -0,0 +1,130 @@
+package kvclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang-web-service-template/pkg/kvservice"
+)
+
+func newTestClient(t *testing.T, cfg kvservice.Config, options ...Option) *Client {
+	t.Helper()
+	server := httptest.NewServer(kvservice.NewHandler(cfg))
+	t.Cleanup(server.Close)
+
+	c, err := New(server.URL, server.Client(), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, kvservice.Config{})
+
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("get missing key: expected ErrKeyNotFound but got %v", err)
+	}
+
+	for key, value := range map[string]string{"key": "value", "with/slash": "other"} {
+		if err := c.Set(ctx, key, value); err != nil {
+			t.Fatalf("set %q: %v", key, err)
+		}
+		got, err := c.Get(ctx, key)
+		if err != nil || got != value {
+			t.Errorf("get %q: expected %v but got %v (%v)", key, value, got, err)
+		}
+	}
+
+	values, err := c.MGet(ctx, "key", "missing", "with/slash")
+	if err != nil {
+		t.Fatalf("mget: %v", err)
+	}
+	want := map[string]string{"key": "value", "with/slash": "other"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("mget: expected %v but got %v", want, values)
+	}
+
+	if err := c.Delete(ctx, "key"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := c.Delete(ctx, "key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("delete missing key: expected ErrKeyNotFound but got %v", err)
+	}
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("get deleted key: expected ErrKeyNotFound but got %v", err)
+	}
+
+	// binary values round trip base64 encoded
+	blob := string([]byte{0xde, 0xad, 0xbe, 0xef, 0x00})
+	if err := c.Set(ctx, "blob", blob); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get(ctx, "blob"); err != nil || got != blob {
+		t.Errorf("get blob: expected %x but got %x (%v)", blob, got, err)
+	}
+
+	// a server answering 200 for missing keys is reported the same
+	c = newTestClient(t, kvservice.Config{MissingKeyStatus: http.StatusOK})
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("get missing key with status 200: expected ErrKeyNotFound but got %v", err)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	// the read-only error carries a JSON envelope
+	c := newTestClient(t, kvservice.Config{ReadOnly: true})
+	var e *Error
+	if err := c.Set(ctx, "key", "value"); !errors.As(err, &e) || e.StatusCode != http.StatusForbidden || e.Code != "READ_ONLY" {
+		t.Errorf("expected a 403 READ_ONLY error but got %v", err)
+	}
+
+	// a full store answers with plain text
+	c = newTestClient(t, kvservice.Config{MaxStoreBytes: 4, Eviction: kvservice.EvictionReject})
+	if err := c.Set(ctx, "key", "value"); !errors.As(err, &e) || e.StatusCode != http.StatusInsufficientStorage || e.Message != "Store is full" {
+		t.Errorf("expected a 507 error but got %v", err)
+	}
+}
+
+func TestClient_Options(t *testing.T) {
+	apiKeys := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKeys <- r.Header.Get("X-API-Key")
+		// the client gives up long before this, closing the connection cancels the request context
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, nil, WithAPIKey("secret"), WithTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(context.Background(), "key", "value"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout but got %v", err)
+	}
+	select {
+	case apiKey := <-apiKeys:
+		if apiKey != "secret" {
+			t.Errorf("expected API key %v but got %q", "secret", apiKey)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request did not reach the server")
+	}
+}
+
+func TestNew_InvalidURL(t *testing.T) {
+	if _, err := New("localhost:8080", nil); err == nil {
+		t.Error("expected an error for a URL without scheme")
+	}
+}
@@ -0,0 +1,62 @@
+package kvservice
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemd passes the sockets of a socket unit starting at this file descriptor, see sd_listen_fds(3)
+//
+//	# kv.socket
+//	[Socket]
+//	ListenStream=8080
+//	ListenStream=127.0.0.1:8081
+
+// listenFDsStart is the first file descriptor passed by socket activation, tests move it to the descriptors they own
+var listenFDsStart = 3
+
+// activationListeners returns the listeners passed by systemd socket activation in the order of the socket unit, nil without activation
+// activation is detected by LISTEN_PID naming this process and LISTEN_FDS counting the sockets,
+// the variables are unset afterwards so processes started by the service do not take the sockets for their own
+func activationListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// the variables were meant for another process, e.g. inherited from a parent that did not unset them
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("socket activation: invalid LISTEN_FDS %q", fds)
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for i := range n {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(f)
+		// the listener holds a duplicate of the descriptor
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket activation: socket %s: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
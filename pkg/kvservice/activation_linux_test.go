@@ -0,0 +1,94 @@
+package kvservice
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// passSockets hands listeners on the loopback interface to this process the way systemd does:
+// their descriptors are moved to consecutive numbers starting at listenFDsStart and announced in LISTEN_PID and LISTEN_FDS
+func passSockets(t *testing.T, n int) []string {
+	t.Helper()
+	start := listenFDsStart
+	t.Cleanup(func() { listenFDsStart = start })
+	// descriptors high enough not to be taken by the test binary
+	listenFDsStart = 200
+
+	var addresses []string
+	for i := range n {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := listener.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Dup3(int(f.Fd()), listenFDsStart+i, 0); err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, listener.Addr().String())
+		f.Close()
+		listener.Close()
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", strconv.Itoa(n))
+	t.Setenv("LISTEN_FDNAMES", "public:local")
+	return addresses
+}
+
+func TestServer_Run_SocketActivation(t *testing.T) {
+	addresses := passSockets(t, 2)
+
+	// the configured address is not listened on, the passed sockets replace it
+	server, _ := startServer(t, Config{ServerAddress: "127.0.0.1:1", ShutdownTimeout: time.Second})
+	addrs := server.Addrs()
+	if len(addrs) != 2 || addrs[0].String() != addresses[0] || addrs[1].String() != addresses[1] {
+		t.Fatalf("expected the passed sockets %v but got %v", addresses, addrs)
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if v, ok := os.LookupEnv(name); ok {
+			t.Errorf("expected %s to be unset but got %q", name, v)
+		}
+	}
+
+	for _, address := range addresses {
+		resp, err := http.Get("http://" + address + "/healthz")
+		if err != nil {
+			t.Fatalf("healthz on %v failed: %v", address, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("healthz on %v: expected status %v but got %v", address, http.StatusOK, resp.StatusCode)
+		}
+	}
+	http.DefaultClient.CloseIdleConnections()
+}
+
+func TestActivationListeners_OtherProcess(t *testing.T) {
+	passSockets(t, 1)
+	// the variables were inherited from a parent that was activated itself
+	t.Setenv("LISTEN_PID", "1")
+
+	listeners, err := activationListeners()
+	if err != nil || listeners != nil {
+		t.Errorf("expected no listeners for another process but got %v %v", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("expected the variables to be unset")
+	}
+	syscall.Close(listenFDsStart)
+}
+
+func TestActivationListeners_InvalidCount(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "many")
+	if _, err := activationListeners(); err == nil {
+		t.Error("expected an invalid LISTEN_FDS to fail")
+	}
+}
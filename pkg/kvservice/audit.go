@@ -0,0 +1,205 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --audit-log /var/log/kv/audit.jsonl --audit-log-max-bytes 104857600
+// {"time":"2024-05-01T12:00:00Z","request_id":"abc","client":"10.0.0.1:52100","namespace":"default","operation":"set","key":"key1","value_size":6}
+
+const (
+	// auditBuffer is the number of records that may wait for the sink before further records are dropped
+	auditBuffer = 1024
+	// auditLogBackups is the number of rotated audit files kept next to the current one
+	auditLogBackups = 3
+)
+
+// AuditRecord describes a successful mutation, it never contains the value itself
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Client is the remote address of the connection the mutation was received on
+	Client    string `json:"client"`
+	Namespace string `json:"namespace"`
+	Operation string `json:"operation"`
+	Key       Key    `json:"key,omitempty"`
+	// To is the key a rename moved Key to
+	To        Key `json:"to,omitempty"`
+	ValueSize int `json:"value_size,omitempty"`
+	// Count is the number of keys a flush or restore affected
+	Count int `json:"count,omitempty"`
+}
+
+// AuditLog writes audit records as newline delimited JSON to a sink in the background
+// recording never blocks, when the sink falls behind and the buffer is full the record is dropped and counted
+type AuditLog struct {
+	records chan AuditRecord
+	dropped atomic.Uint64
+	sink    io.WriteCloser
+	done    chan struct{}
+}
+
+// NewAuditLog starts writing the records to the sink, Close stops it and closes the sink
+func NewAuditLog(sink io.WriteCloser, buffer int) *AuditLog {
+	a := &AuditLog{
+		records: make(chan AuditRecord, buffer),
+		sink:    sink,
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// openAuditLog opens the sink named by --audit-log, stderr or a file rotated at maxBytes
+func openAuditLog(path string, maxBytes int64) (*AuditLog, error) {
+	if path == "stderr" {
+		return NewAuditLog(nopCloser{os.Stderr}, auditBuffer), nil
+	}
+	f, err := openRotatingFile(path, maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return NewAuditLog(f, auditBuffer), nil
+}
+
+func (a *AuditLog) run() {
+	defer close(a.done)
+	enc := json.NewEncoder(a.sink)
+	for record := range a.records {
+		if err := enc.Encode(record); err != nil {
+			slog.Error("failed to write audit record", "error", err, "operation", record.Operation, "key", record.Key)
+		}
+	}
+}
+
+// Record queues the record for the sink or drops it if the buffer is full
+func (a *AuditLog) Record(record AuditRecord) {
+	select {
+	case a.records <- record:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of records that were dropped because the sink could not keep up
+func (a *AuditLog) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Close writes the queued records and closes the sink, no record may be added afterwards
+func (a *AuditLog) Close() error {
+	close(a.records)
+	<-a.done
+	return a.sink.Close()
+}
+
+// SetAuditLog records every successful mutation of the store and all its namespaces, nil disables auditing
+// it must be called before the store is served
+func (kv *KeyValueStore) SetAuditLog(a *AuditLog) {
+	kv.root.auditLog = a
+}
+
+// audit records a successful mutation of the request in the audit log if one is configured
+// valueSize is the size of the written value for a set, count the number of keys affected by a flush or restore
+func (kv *KeyValueStore) audit(r *http.Request, operation string, key Key, valueSize, count int) {
+	kv.auditRecord(r, AuditRecord{Operation: operation, Key: key, ValueSize: valueSize, Count: count})
+}
+
+// auditRename records a rename of from to to, which moved a value of valueSize
+func (kv *KeyValueStore) auditRename(r *http.Request, from, to Key, valueSize int) {
+	kv.auditRecord(r, AuditRecord{Operation: "rename", Key: from, To: to, ValueSize: valueSize})
+}
+
+// auditRecord completes the record with the time, the request and the namespace and records it if an audit log is configured
+func (kv *KeyValueStore) auditRecord(r *http.Request, record AuditRecord) {
+	a := kv.root.auditLog
+	if a == nil {
+		return
+	}
+	record.Time = time.Now().UTC()
+	record.RequestID = r.Header.Get("X-Request-ID")
+	record.Client = r.RemoteAddr
+	record.Namespace = kv.name
+	a.Record(record)
+}
+
+// rotatingFile appends to a file and renames it to path.1 once it would grow beyond maxBytes, older files shift to path.2 and so on
+// it is only written by the audit goroutine, the mutex guards against a Close racing with a write
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	size     int64
+	f        *os.File
+}
+
+// openRotatingFile opens the file for appending, a maxBytes of 0 never rotates
+func openRotatingFile(path string, maxBytes int64) (*rotatingFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotatingFile{path: path, maxBytes: maxBytes, size: info.Size(), f: f}, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, shifts the backups and starts a new empty file
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	for i := auditLogBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	r.f, r.size = f, 0
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// nopCloser keeps a shared writer like stderr open when the audit log is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
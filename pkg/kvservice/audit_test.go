@@ -0,0 +1,168 @@
+package kvservice
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAuditRecords returns the records of the audit file
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+		dec.DisallowUnknownFields()
+		var record AuditRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("malformed audit record %s: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestKeyValueStore_AuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := openAuditLog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Config{}
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetAuditLog(auditLog)
+	handler := env.routes(kv)
+
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/set", `{"key":"a","value":"secret"}`},
+		{http.MethodPut, "/kv/b", "value"},
+		{http.MethodPost, "/mset", `[{"key":"c","value":"1"},{"key":"d","value":"22"}]`},
+		{http.MethodPost, "/get", `{"key":"a"}`},
+		{http.MethodDelete, "/kv/b", ""},
+		{http.MethodDelete, "/kv/missing", ""},
+		{http.MethodPost, "/rename", `{"from":"c","to":"e"}`},
+		{http.MethodPost, "/flush", ""},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+		r.Header.Set("X-Request-ID", "req-"+req.method)
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAuditRecords(t, path)
+	want := []AuditRecord{
+		{Operation: "set", Key: "a", ValueSize: 6, RequestID: "req-POST"},
+		{Operation: "set", Key: "b", ValueSize: 5, RequestID: "req-PUT"},
+		{Operation: "set", Key: "c", ValueSize: 1, RequestID: "req-POST"},
+		{Operation: "set", Key: "d", ValueSize: 2, RequestID: "req-POST"},
+		{Operation: "delete", Key: "b", RequestID: "req-DELETE"},
+		{Operation: "rename", Key: "c", To: "e", ValueSize: 1, RequestID: "req-POST"},
+		{Operation: "flush", Count: 3, RequestID: "req-POST"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records but got %+v", len(want), records)
+	}
+	for i, record := range records {
+		if record.Time.IsZero() || record.Client == "" || record.Namespace != DefaultNamespace {
+			t.Errorf("record %d: expected time, client and namespace but got %+v", i, record)
+		}
+		record.Time, record.Client, record.Namespace = time.Time{}, "", ""
+		if record != want[i] {
+			t.Errorf("record %d: expected %+v but got %+v", i, want[i], record)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret") {
+		t.Error("the audit log must not contain values")
+	}
+}
+
+// blockingWriter blocks every write until unblock is closed
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+func (w blockingWriter) Close() error {
+	return nil
+}
+
+func TestAuditLog_Dropped(t *testing.T) {
+	sink := blockingWriter{unblock: make(chan struct{})}
+	auditLog := NewAuditLog(sink, 1)
+
+	// the first record blocks the writer, the second fills the buffer and the rest are dropped
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			auditLog.Record(AuditRecord{Operation: "set"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording blocked on a slow sink")
+	}
+	if dropped := auditLog.Dropped(); dropped < 8 {
+		t.Errorf("expected at least 8 dropped records but got %d", dropped)
+	}
+
+	close(sink.unblock)
+	auditLog.Close()
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := openRotatingFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n", "fifth\n"} {
+		if _, err := io.WriteString(f, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	for file, want := range map[string]string{
+		path:        "fifth\n",
+		path + ".1": "fourth\n",
+		path + ".2": "third\n",
+		path + ".3": "second\n",
+	} {
+		if got, _ := os.ReadFile(file); string(got) != want {
+			t.Errorf("%s: expected %q but got %q", filepath.Base(file), want, got)
+		}
+	}
+	if _, err := os.Stat(path + ".4"); err == nil {
+		t.Errorf("expected at most %d backups", auditLogBackups)
+	}
+}
@@ -0,0 +1,154 @@
+package kvservice
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mux.Handle("/kv/", kvservice.NewStoreHandler(backend))
+// http.ListenAndServe(":8080", kvservice.NewBackendHandler(cfg, backend))
+
+// Store is the key-value API of a backend, KeyValueStore implements it in memory
+// every method takes the context of the request so a remote or disk backend can honor its deadline and cancellation,
+// and returns an error if the backend fails, StoreHandler answers such errors with 500
+type Store interface {
+	// Get returns the value of the key and whether it exists
+	Get(ctx context.Context, key Key) (Value, bool, error)
+	// Set stores the value of the key, ErrStoreFull if the backend has no room for it
+	Set(ctx context.Context, key Key, value Value) error
+	// Delete removes the key and reports whether it existed
+	Delete(ctx context.Context, key Key) (bool, error)
+}
+
+// the methods of the in-memory store only wait for the lock of a shard, the context and the lock wait timeout bound the wait
+// the handlers of /get, /set and /kv/ call them with the context of the request
+var _ Store = (*KeyValueStore)(nil)
+
+// Get returns the string value of the key, errWrongType if the key holds a list or hash
+func (kv *KeyValueStore) Get(ctx context.Context, key Key) (Value, bool, error) {
+	e, ok, err := kv.getEntry(ctx, key)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if e.kind != kindString {
+		return "", true, errWrongType
+	}
+	return e.plain(), true, nil
+}
+
+// getEntry returns the entry of the key of any type, it is Get for the handlers answering with the ETag and modification time as well
+func (kv *KeyValueStore) getEntry(ctx context.Context, key Key) (entry, bool, error) {
+	s := kv.shard(key)
+	if err := s.lockGetContext(ctx); err != nil {
+		return entry{}, false, err
+	}
+	defer s.unlockGet()
+	e, ok := s.get(key)
+	return e, ok, nil
+}
+
+// Set stores the string value of the key
+func (kv *KeyValueStore) Set(ctx context.Context, key Key, value Value) error {
+	s := kv.shard(key)
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	_, err := s.put(key, value)
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+	return kv.syncLog()
+}
+
+// Delete removes the key
+func (kv *KeyValueStore) Delete(ctx context.Context, key Key) (bool, error) {
+	s := kv.shard(key)
+	if err := s.lockContext(ctx); err != nil {
+		return false, err
+	}
+	_, ok, err := s.remove(key)
+	s.Unlock()
+	if err == nil && ok {
+		err = kv.syncLog()
+	}
+	return ok, err
+}
+
+// NewStoreHandler serves the path based API of /kv/{key} from any Store: GET returns the raw value, PUT stores the request body and DELETE removes the key
+// unlike KVHandler it has no ETags or namespaces, which the Store interface does not know about
+// the context of the request is passed to the store, a failing backend is answered with 500 and logged
+func NewStoreHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := Key(strings.TrimPrefix(r.URL.Path, "/kv/"))
+		if key == "" {
+			http.Error(w, "Key is required", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			value, ok, err := store.Get(r.Context(), key)
+			if err != nil {
+				writeBackendError(w, r, key, err)
+				return
+			}
+			if !ok {
+				http.Error(w, "Key not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+			if r.Method == http.MethodHead {
+				return
+			}
+			io.WriteString(w, string(value))
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if err := store.Set(r.Context(), key, Value(body)); err != nil {
+				writeBackendError(w, r, key, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			ok, err := store.Delete(r.Context(), key)
+			if err != nil {
+				writeBackendError(w, r, key, err)
+				return
+			}
+			if !ok {
+				http.Error(w, "Key not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// writeBackendError answers an error returned by a Store, the errors of the in-memory store keep their usual status
+// and any other error is a failure of the backend, answered with 500 without details, which only go to the log
+func writeBackendError(w http.ResponseWriter, r *http.Request, key Key, err error) {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errLockTimeout):
+		writeLockError(w, r, err)
+	case errors.Is(err, errWrongType):
+		writeWrongType(w, key)
+	case errors.Is(err, ErrStoreFull) || errors.Is(err, ErrWriteAheadLog):
+		writeStoreError(w, err)
+	default:
+		slog.Error("store failed", "error", err, "method", r.Method, "key", key)
+		writeError(w, http.StatusInternalServerError, "STORE_ERROR", "Store failed")
+	}
+}
@@ -0,0 +1,216 @@
+package kvservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingStore is a backend whose every call fails with err, it records the context it was called with
+type failingStore struct {
+	err error
+	ctx context.Context
+}
+
+func (f *failingStore) Get(ctx context.Context, key Key) (Value, bool, error) {
+	f.ctx = ctx
+	return "", false, f.err
+}
+
+func (f *failingStore) Set(ctx context.Context, key Key, value Value) error {
+	f.ctx = ctx
+	return f.err
+}
+
+func (f *failingStore) Delete(ctx context.Context, key Key) (bool, error) {
+	f.ctx = ctx
+	return false, f.err
+}
+
+func TestStoreHandler_BackendError(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "backend failure", err: errors.New("connection refused"), wantCode: http.StatusInternalServerError},
+		{name: "backend full", err: ErrStoreFull, wantCode: http.StatusInsufficientStorage},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, wantCode: http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingStore{err: tt.err}
+			handler := NewStoreHandler(store)
+			for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+				type ctxKey struct{}
+				r := httptest.NewRequest(method, "/kv/key", strings.NewReader("value"))
+				r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, method))
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != tt.wantCode {
+					t.Errorf("%s: expected status %v but got %v %s", method, tt.wantCode, w.Code, w.Body.String())
+				}
+				if strings.Contains(w.Body.String(), "connection refused") {
+					t.Errorf("%s: expected the backend error to stay in the log but got %s", method, w.Body.String())
+				}
+				if store.ctx == nil || store.ctx.Value(ctxKey{}) != method {
+					t.Errorf("%s: expected the store to be called with the context of the request", method)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreHandler_KeyValueStore(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxBytes: 20})
+	handler := NewStoreHandler(kv)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve(http.MethodPut, "/kv/key", "value"); w.Code != http.StatusNoContent {
+		t.Errorf("put: expected status %v but got %v", http.StatusNoContent, w.Code)
+	}
+	if w := serve(http.MethodGet, "/kv/key", ""); w.Code != http.StatusOK || w.Body.String() != "value" {
+		t.Errorf("get: expected the value but got %v %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPut, "/kv/big", strings.Repeat("x", 30)); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("put: expected status %v but got %v", http.StatusInsufficientStorage, w.Code)
+	}
+	if w := serve(http.MethodDelete, "/kv/key", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: expected status %v but got %v", http.StatusNoContent, w.Code)
+	}
+	if w := serve(http.MethodGet, "/kv/key", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get: expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+
+	kv.push("list", "item", false)
+	if w := serve(http.MethodGet, "/kv/list", ""); w.Code != http.StatusConflict {
+		t.Errorf("get of a list: expected status %v but got %v", http.StatusConflict, w.Code)
+	}
+}
+
+func TestKeyValueStore_Store(t *testing.T) {
+	var store Store = NewKeyValueStore(StoreOptions{})
+	// the in-memory store only looks at the context while it waits for a lock, without a wait even a cancelled one works
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	cancel()
+
+	if err := store.Set(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := store.Get(ctx, "key"); err != nil || !ok || value != "value" {
+		t.Errorf("expected the value but got %q %v %v", value, ok, err)
+	}
+	if ok, err := store.Delete(ctx, "key"); err != nil || !ok {
+		t.Errorf("expected the key to be deleted but got %v %v", ok, err)
+	}
+	if _, ok, err := store.Get(ctx, "key"); err != nil || ok {
+		t.Errorf("expected the key to be gone but got %v %v", ok, err)
+	}
+}
+
+func TestNewBackendHandler(t *testing.T) {
+	backend := NewKeyValueStore(StoreOptions{})
+	handler := NewBackendHandler(Config{}, backend)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve(http.MethodPut, "/kv/key", "value"); w.Code != http.StatusNoContent {
+		t.Errorf("put: expected status %v but got %v", http.StatusNoContent, w.Code)
+	}
+	if value, ok, _ := backend.Get(context.Background(), "key"); !ok || value != "value" {
+		t.Errorf("expected the value to be written to the backend but got %q %v", value, ok)
+	}
+	if w := serve(http.MethodGet, "/kv/key", ""); w.Code != http.StatusOK || w.Body.String() != "value" {
+		t.Errorf("get: expected the value but got %v %s", w.Code, w.Body.String())
+	}
+	// the endpoints of the in-memory store are not served next to the backend
+	for _, path := range []string{"/get", "/list/push", "/dump", "/stats"} {
+		if w := serve(http.MethodPost, path, `{"key":"key"}`); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %v but got %v", path, http.StatusNotFound, w.Code)
+		}
+	}
+	if w := serve(http.MethodGet, "/healthz", ""); w.Code != http.StatusOK {
+		t.Errorf("healthz: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+
+	handler = NewBackendHandler(Config{}, &failingStore{err: errors.New("connection refused")})
+	if w := serve(http.MethodGet, "/kv/key", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected a failing backend to be answered with %v but got %v", http.StatusInternalServerError, w.Code)
+	}
+}
+
+// blockingStore is a slow backend, every call blocks until its context is done and returns the error of the context
+type blockingStore struct {
+	calls atomic.Int32
+}
+
+func (b *blockingStore) block(ctx context.Context) error {
+	b.calls.Add(1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingStore) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return "", false, b.block(ctx)
+}
+
+func (b *blockingStore) Set(ctx context.Context, key Key, value Value) error {
+	return b.block(ctx)
+}
+
+func (b *blockingStore) Delete(ctx context.Context, key Key) (bool, error) {
+	return false, b.block(ctx)
+}
+
+func TestStoreHandler_Cancellation(t *testing.T) {
+	store := &blockingStore{}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		// the client goes away while the backend is still working, the handler returns without answering
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		NewStoreHandler(store).ServeHTTP(w, httptest.NewRequest(method, "/kv/key", strings.NewReader("value")).WithContext(ctx))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: expected the cancelled request to return promptly but it took %v", method, elapsed)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("%s: expected no response to a client that went away but got %v %s", method, w.Code, w.Body.String())
+		}
+
+		// the deadline of the request ends the call with 503
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+		w = httptest.NewRecorder()
+		NewStoreHandler(store).ServeHTTP(w, httptest.NewRequest(method, "/kv/key", strings.NewReader("value")).WithContext(ctx))
+		cancel()
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "DEADLINE_EXCEEDED") {
+			t.Errorf("%s: expected the deadline to be answered with %v but got %v %s", method, http.StatusServiceUnavailable, w.Code, w.Body.String())
+		}
+
+		// the handler timeout of the service cancels the call
+		w = httptest.NewRecorder()
+		start = time.Now()
+		NewBackendHandler(Config{HandlerTimeout: 20 * time.Millisecond}, store).ServeHTTP(w, httptest.NewRequest(method, "/kv/key", strings.NewReader("value")))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected the handler timeout to be answered with %v but got %v", method, http.StatusServiceUnavailable, w.Code)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: expected the request to end at the handler timeout but it took %v", method, elapsed)
+		}
+	}
+	if n := store.calls.Load(); n != 9 {
+		t.Errorf("expected every request to reach the backend but got %d calls", n)
+	}
+}
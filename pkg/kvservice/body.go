@@ -0,0 +1,48 @@
+package kvservice
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// DefaultMaxBodyBytes is the largest request body the data endpoints accept unless another limit is configured
+const DefaultMaxBodyBytes = 32 << 20
+
+// MiddlewareMaxBodyBytes answers 413 to a request whose body is larger than limit, a limit of 0 or less accepts any body
+// a declared Content-Length is rejected up front, a chunked body fails the read once it exceeds the limit and the handler answers with writeBodyError
+func MiddlewareMaxBodyBytes(limit int64, next http.HandlerFunc) http.HandlerFunc {
+	if limit <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
+
+// writeBodyError answers the error reading the request body, 413 if the body exceeded the limit and 400 otherwise
+func writeBodyError(w http.ResponseWriter, err error) {
+	if limit, ok := bodyLimit(err); ok {
+		writeBodyTooLarge(w, limit)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// bodyLimit reports whether err is caused by a body exceeding the limit of MiddlewareMaxBodyBytes and returns the limit
+func bodyLimit(err error) (int64, bool) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return tooLarge.Limit, true
+	}
+	return 0, false
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeError(w, http.StatusRequestEntityTooLarge, "BODY_TOO_LARGE", "the request body must not be larger than "+strconv.FormatInt(limit, 10)+" bytes")
+}
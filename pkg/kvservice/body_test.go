@@ -0,0 +1,46 @@
+package kvservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareMaxBodyBytes(t *testing.T) {
+	env := &Config{MaxBodyBytes: 64}
+	kv := NewKeyValueStore(StoreOptions{})
+	handler := env.routes(kv)
+	large := strings.Repeat("x", 100)
+	batch := `[` + strings.Repeat(`{"key":"a","value":"1"},`, 10) + `{"key":"b","value":"2"}]`
+
+	for _, tt := range []struct {
+		name, method, path, body string
+		// chunked sends the body without a Content-Length so the limit is only hit while reading it
+		chunked bool
+	}{
+		{name: "set", method: http.MethodPost, path: "/set", body: `{"key":"key","value":"` + large + `"}`},
+		{name: "set chunked", method: http.MethodPost, path: "/set", body: `{"key":"key","value":"` + large + `"}`, chunked: true},
+		{name: "put", method: http.MethodPut, path: "/kv/key", body: large, chunked: true},
+		{name: "mset", method: http.MethodPost, path: "/mset", body: batch, chunked: true},
+		{name: "mset duplicates", method: http.MethodPost, path: "/mset?duplicates=reject", body: batch, chunked: true},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected status %v but got %v %s", tt.name, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+		}
+	}
+	if _, ok := kv.peek("key"); ok {
+		t.Error("expected no value of a rejected body to be written")
+	}
+
+	if w := serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"key","value":"small"}`); w.Code != http.StatusOK {
+		t.Errorf("expected a body within the limit to be accepted but got %v %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which a buffer is not returned to bufferPool,
+// so a single large request does not keep its buffer alive for the lifetime of the process
+const maxPooledBuffer = 64 << 10
+
+// maxBodyPrealloc bounds how much of a request's Content-Length is allocated before the body is read,
+// a larger body is not trusted up front and its buffer grows as the body arrives
+const maxBodyPrealloc = 16 << 20
+
+// bufferPool holds the buffers request bodies are read into
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns the buffer to the pool unless it grew beyond maxPooledBuffer
+// nothing may reference the contents of the buffer afterwards
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// readBody reads the whole body into b, sized up front from the Content-Length so a large value is read without repeated growing
+func readBody(b *bytes.Buffer, body io.Reader, contentLength int64) error {
+	if contentLength > 0 {
+		// the extra MinRead lets ReadFrom see the end of the body without growing the buffer again
+		b.Grow(int(min(contentLength, maxBodyPrealloc)) + bytes.MinRead)
+	}
+	_, err := b.ReadFrom(body)
+	return err
+}
+
+// requestFields caches the lower cased JSON names of the fields of every request type decodeRequest has seen
+// a type holding nested structs is cached as nil, unknown fields of those are only detected by the decoder
+var requestFields sync.Map
+
+// jsonFields returns the lower cased JSON names of the fields of the struct type t, encoding/json matches object keys to them case-insensitively
+// it returns nil if a field holds a struct, directly or as an element, as the unknown fields of that struct would need checking as well
+func jsonFields(t reflect.Type) map[string]bool {
+	if fields, ok := requestFields.Load(t); ok {
+		return fields.(map[string]bool)
+	}
+	fields := make(map[string]bool)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() {
+			continue
+		}
+		if holdsStruct(f.Type) {
+			fields = nil
+			break
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = true
+	}
+	requestFields.Store(t, fields)
+	return fields
+}
+
+// holdsStruct reports whether a value of type t is or contains a struct
+func holdsStruct(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return holdsStruct(t.Elem())
+	case reflect.Map:
+		return holdsStruct(t.Key()) || holdsStruct(t.Elem())
+	}
+	return false
+}
+
+// skipValue accepts any JSON value without copying it
+type skipValue struct{}
+
+func (*skipValue) UnmarshalJSON([]byte) error { return nil }
+
+// decodeInPlace reports whether v points to a request type whose unknown fields unknownField can detect
+func decodeInPlace(v any) bool {
+	t := reflect.TypeOf(v)
+	return t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct && jsonFields(t.Elem()) != nil
+}
+
+// unknownField returns the first key, in sorted order, of the JSON object data that no field of the struct v points to matches
+// v must satisfy decodeInPlace
+func unknownField(data []byte, v any) string {
+	var keys map[string]skipValue
+	if json.Unmarshal(data, &keys) != nil {
+		return ""
+	}
+	fields := jsonFields(reflect.TypeOf(v).Elem())
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		if !fields[strings.ToLower(key)] {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        any
+		body     string
+		wantBody string
+	}{
+		{name: "known fields", v: &SetRequest{}, body: `{"key":"a","value":"1"}`},
+		{name: "case-insensitive", v: &SetRequest{}, body: `{"KEY":"a","Value":"1"}`},
+		{name: "unknown field", v: &SetRequest{}, body: `{"key":"a","valeu":"1"}`, wantBody: `Unknown field "valeu" in request body`},
+		{name: "first unknown field", v: &SetRequest{}, body: `{"zz":1,"key":"a","aa":2}`, wantBody: `Unknown field "aa" in request body`},
+		{name: "trailing value", v: &SetRequest{}, body: `{"key":"a"} {"key":"b"}`},
+		{name: "trailing value with unknown field", v: &SetRequest{}, body: `{"key":"a","x":1} {}`, wantBody: `Unknown field "x" in request body`},
+		{name: "nested unknown field", v: &TxnRequest{}, body: `{"ops":[{"type":"set","key":"a","valeu":"1"}]}`, wantBody: `Unknown field "valeu" in request body`},
+		{name: "empty", v: &SetRequest{}, body: " \n", wantBody: "Request body is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ok := decodeRequest(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)), tt.v)
+			if ok != (tt.wantBody == "") || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("expected %q but got %v %q", tt.wantBody, ok, w.Body.String())
+			}
+		})
+	}
+
+	// the decoded value does not alias the pooled buffer the body was read into
+	var payload PatchRequest
+	if !decodeRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"key":"a","merge_patch":{"x":1}}`)), &payload) {
+		t.Fatal("expected the patch request to decode")
+	}
+	for range 10 {
+		decodeRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"key":"overwritten","value":"xxxxxxxxxxxxxxxx"}`)), &SetRequest{})
+	}
+	if string(payload.MergePatch) != `{"x":1}` {
+		t.Errorf("expected the merge patch to be kept but got %s", payload.MergePatch)
+	}
+}
+
+func TestDecodeRequest_Concurrent(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	sizes := []int{1, 100, 4 << 10, maxPooledBuffer, 2 * maxPooledBuffer}
+
+	// every writer sets its own keys to values that name the key, so a buffer shared by two requests shows up as a foreign value
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Go(func() {
+			for i := range 50 {
+				key := Key(fmt.Sprintf("worker-%d-%d", worker, i%5))
+				value := string(key) + ":" + strings.Repeat("x", sizes[i%len(sizes)])
+				body, _ := json.Marshal(SetRequest{Key: key, Value: Value(value)})
+
+				w := httptest.NewRecorder()
+				kv.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", bytes.NewReader(body)))
+				if w.Code != http.StatusOK {
+					t.Errorf("set %s: expected status %v but got %v %s", key, http.StatusOK, w.Code, w.Body.String())
+					return
+				}
+
+				w = httptest.NewRecorder()
+				kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"`+string(key)+`"}`)))
+				var got GetResponse
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+					t.Errorf("get %s: %v", key, err)
+					return
+				}
+				if string(got.Value) != value {
+					t.Errorf("get %s: expected the value just set, %d bytes, but got %.40q", key, len(value), got.Value)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
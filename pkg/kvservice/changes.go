@@ -0,0 +1,213 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// curl 'http://localhost:8080/changes?since=12345'
+// curl 'http://localhost:8080/changes?since=12345&wait=30s'
+// curl 'http://localhost:8080/changes?since=12345&all=true&values=true'
+// {"seq":12346,"namespace":"default","key":"key1","event":"set","version":17,"time":"2024-05-01T12:00:00Z"}
+
+// ChangeEventFlush is the event of a flush, it removes every key of the namespace and has no key
+const ChangeEventFlush = "flush"
+
+const (
+	// changeFeedSize is the number of recent changes kept for /changes, older ones are only available through /dump
+	changeFeedSize = 10000
+	// maxChangesWait bounds how long /changes may wait for a change
+	maxChangesWait = time.Minute
+)
+
+// Change is a mutation as served by /changes, it never contains the value itself
+type Change struct {
+	// Seq is the position of the change in the feed of the store, it starts at 1 with every start of the service
+	Seq       uint64 `json:"seq"`
+	Namespace string `json:"namespace"`
+	Key       Key    `json:"key,omitempty"`
+	// Event is set, delete or flush
+	Event string `json:"event"`
+	// Version is the version of the write for a set
+	Version uint64    `json:"version,omitempty"`
+	Time    time.Time `json:"time"`
+
+	// Value, List and Hash are only set for a set with values=true, they hold the current value of the key which may be newer than the change
+	// all of them are missing if the key was deleted since, its deletion follows later in the feed
+	Value *Value           `json:"value,omitempty"`
+	List  []Value          `json:"list,omitempty"`
+	Hash  map[string]Value `json:"hash,omitempty"`
+}
+
+// changeFeed hands out the sequence numbers and keeps the recent changes of all namespaces in a ring
+// changes are recorded while the shards they change are locked, so the order of the sequence numbers is the order of the changes of every key
+type changeFeed struct {
+	mu sync.Mutex
+	// seq is the sequence number of the last change
+	seq  uint64
+	ring []Change
+	// woken is closed by the next change
+	woken chan struct{}
+}
+
+// record assigns the next sequence number to the change and keeps it, it releases everyone waiting for a change
+func (f *changeFeed) record(c Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ring == nil {
+		f.ring = make([]Change, changeFeedSize)
+	}
+	f.seq++
+	c.Seq = f.seq
+	f.ring[(f.seq-1)%changeFeedSize] = c
+	if f.woken != nil {
+		close(f.woken)
+		f.woken = nil
+	}
+}
+
+// last returns the sequence number of the last change
+func (f *changeFeed) last() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seq
+}
+
+// since returns the changes of the namespace after the sequence number and the sequence number of the last change of any namespace
+// an empty namespace returns the changes of all namespaces
+// it returns false if changes after since are no longer kept, or since is from before the last start of the service
+// the returned channel is closed by the next change
+func (f *changeFeed) since(since uint64, namespace string) ([]Change, uint64, <-chan struct{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if since > f.seq || f.seq-since > changeFeedSize {
+		return nil, f.seq, nil, false
+	}
+	var changes []Change
+	for seq := since + 1; seq <= f.seq; seq++ {
+		if c := f.ring[(seq-1)%changeFeedSize]; namespace == "" || c.Namespace == namespace {
+			changes = append(changes, c)
+		}
+	}
+	if f.woken == nil {
+		f.woken = make(chan struct{})
+	}
+	return changes, f.seq, f.woken, true
+}
+
+// notify records the change of the key in the change feed and tells the webhooks about it, a flush is not sent to webhooks
+// changes made while loading the snapshot are not news to anyone
+// it returns the error of the write-ahead log, the change is applied and readers see it, so it is recorded and sent either way
+func (s *shard) notify(key Key, event string, version uint64) error {
+	if s.kv.Loading() {
+		return nil
+	}
+	err := s.logChange(key, event)
+	c := Change{Namespace: s.kv.name, Key: key, Event: event, Version: version, Time: s.kv.now().UTC()}
+	s.kv.root.feed.record(c)
+	if w := s.kv.root.webhooks; w != nil && event != ChangeEventFlush {
+		w.notify(WebhookNotification{Namespace: c.Namespace, Key: c.Key, Event: c.Event, Version: c.Version, Time: c.Time})
+	}
+	return err
+}
+
+// ChangesHandler streams the changes of the namespace after the since sequence number as newline delimited JSON, oldest first
+// the X-Change-Seq header carries the sequence number of the last change, the since of the next request
+// with all=true the changes of all namespaces are streamed, with values=true every set carries the current value of its key, see Replicator
+// with wait it waits for the first change if there is none yet and answers with no changes once the wait is over
+// if the changes after since are no longer kept it answers 410, the client has to resync via /dump and continue from X-Change-Seq
+// the handler timeout still applies, so waits beyond it are cut short with 503
+// a transaction that is rolled back shows up as its writes followed by the restore of the previous values
+func (kv *KeyValueStore) ChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.readNamespace(w, r, "")
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	var since uint64
+	if v := query.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "Since must be a sequence number", http.StatusBadRequest)
+			return
+		}
+	}
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 || wait > maxChangesWait {
+			http.Error(w, fmt.Sprintf("Wait must be a duration between 0 and %v", maxChangesWait), http.StatusBadRequest)
+			return
+		}
+		// the response must still be written after waiting, even beyond the write timeout of the server
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + time.Second))
+	}
+
+	namespace := kv.name
+	if query.Get("all") == "true" {
+		namespace = ""
+	}
+	values := query.Get("values") == "true"
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		changes, seq, woken, ok := kv.root.feed.since(since, namespace)
+		w.Header().Set("X-Change-Seq", strconv.FormatUint(seq, 10))
+		if !ok {
+			writeError(w, http.StatusGone, "CHANGES_GONE", fmt.Sprintf("changes after %d are no longer kept, resync via /dump", since))
+			return
+		}
+		if len(changes) > 0 || wait == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(w)
+			for _, c := range changes {
+				if values && c.Event == WebhookEventSet {
+					c = kv.Namespace(c.Namespace).withValue(c)
+				}
+				enc.Encode(c)
+			}
+			return
+		}
+
+		select {
+		case <-woken:
+		case <-deadline.C:
+			wait = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// withValue sets the current value of the key of the change, lists are copied as their items are modified in place
+func (kv *KeyValueStore) withValue(c Change) Change {
+	s := kv.shard(c.Key)
+	s.RLock()
+	defer s.RUnlock()
+	e, ok := s.kvMap[c.Key]
+	if !ok {
+		return c
+	}
+	switch e.kind {
+	case kindList:
+		c.List = slices.Clone(e.items)
+	case kindHash:
+		c.Hash = e.fields
+	default:
+		value := e.plain()
+		c.Value = &value
+	}
+	return c
+}
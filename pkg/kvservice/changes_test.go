@@ -0,0 +1,152 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// decodeChanges decodes the newline delimited changes of a /changes response
+func decodeChanges(t *testing.T, w *httptest.ResponseRecorder) []Change {
+	t.Helper()
+	var changes []Change
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		var c Change
+		if err := dec.Decode(&c); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func TestKeyValueStore_ChangesHandler(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"a","value":"1"}`)
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"b","value":"2"}`)
+	b, _ := kv.peek("b")
+	serveNamespace(handler, "team-a", http.MethodPost, "/set", `{"key":"a","value":"3"}`)
+	serveNamespace(handler, "", http.MethodDelete, "/kv/a", "")
+	serveNamespace(handler, "", http.MethodPost, "/flush", "")
+
+	type change struct {
+		seq     uint64
+		key     Key
+		event   string
+		version uint64
+	}
+	tests := []struct {
+		name      string
+		namespace string
+		since     uint64
+		want      []change
+	}{
+		{name: "all", since: 0, want: []change{{1, "a", "set", 1}, {2, "b", "set", b.version}, {4, "a", "delete", 0}, {5, "", "flush", 0}}},
+		{name: "since", since: 2, want: []change{{4, "a", "delete", 0}, {5, "", "flush", 0}}},
+		{name: "up to date", since: 5},
+		{name: "namespace", namespace: "team-a", since: 0, want: []change{{3, "a", "set", 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveNamespace(handler, tt.namespace, http.MethodGet, "/changes?since="+strconv.FormatUint(tt.since, 10), "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %v but got %v %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if seq := w.Header().Get("X-Change-Seq"); seq != "5" {
+				t.Errorf("expected sequence number 5 but got %q", seq)
+			}
+			var got []change
+			for _, c := range decodeChanges(t, w) {
+				got = append(got, change{c.Seq, c.Key, c.Event, c.Version})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v but got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("expected %v but got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_ChangesHandler_Gone(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+	for i := range changeFeedSize + 2 {
+		writeTestValue(kv, Key("key"+strconv.Itoa(i)), "1")
+	}
+	last := uint64(changeFeedSize + 2)
+
+	tests := []struct {
+		name     string
+		since    uint64
+		wantCode int
+	}{
+		{name: "before the window", since: 1, wantCode: http.StatusGone},
+		{name: "oldest in the window", since: last - changeFeedSize, wantCode: http.StatusOK},
+		{name: "after the last change", since: last + 1, wantCode: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveNamespace(handler, "", http.MethodGet, "/changes?since="+strconv.FormatUint(tt.since, 10), "")
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %v but got %v %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if seq := w.Header().Get("X-Change-Seq"); seq != strconv.FormatUint(last, 10) {
+				t.Errorf("expected sequence number %d but got %q", last, seq)
+			}
+			if w.Code == http.StatusOK {
+				if changes := decodeChanges(t, w); len(changes) != changeFeedSize || changes[0].Seq != tt.since+1 {
+					t.Errorf("expected %d changes from %d", changeFeedSize, tt.since+1)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_ChangesHandler_Wait(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+	writeTestValue(kv, "a", "1")
+
+	start := time.Now()
+	w := serveNamespace(handler, "", http.MethodGet, "/changes?since=1&wait=10ms", "")
+	if w.Code != http.StatusOK || len(decodeChanges(t, w)) != 0 {
+		t.Fatalf("expected no changes once the wait is over but got %v %s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("expected to wait 10ms but answered after %v", elapsed)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serveNamespace(handler, "", http.MethodGet, "/changes?since=1&wait=30s", "")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// a change in another namespace does not end the wait
+	serveNamespace(handler, "team-a", http.MethodPost, "/set", `{"key":"b","value":"2"}`)
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"b","value":"2"}`)
+
+	select {
+	case w := <-done:
+		changes := decodeChanges(t, w)
+		if len(changes) != 1 || changes[0].Key != "b" || changes[0].Seq != 3 {
+			t.Errorf("expected the set of b as change 3 but got %+v", changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the wait to end with the change")
+	}
+}
@@ -0,0 +1,77 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// curl -X POST http://localhost:8080/compact
+// {"before_bytes":52811,"after_bytes":20480}
+
+// CompactResponse is the size of the persisted files before and after the compaction
+type CompactResponse struct {
+	BeforeBytes int64 `json:"before_bytes"`
+	AfterBytes  int64 `json:"after_bytes"`
+}
+
+// Compact rewrites the snapshot file from the current state of the store and returns the size of the persisted files in bytes before and after
+// it is the same as Snapshot with the sizes measured around it, so keys deleted since the last snapshot leave the file right away
+// and the write-ahead log is truncated to the new snapshot
+func (s *Snapshotter) Compact() (before, after int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before = s.fileSize()
+	err = s.snapshot()
+	return before, s.fileSize(), err
+}
+
+// fileSize returns the size of the persisted files, the snapshot file and the write-ahead log, a missing snapshot file counts as empty
+func (s *Snapshotter) fileSize() int64 {
+	var size int64
+	if info, err := os.Stat(s.path); err == nil {
+		size = info.Size()
+	}
+	if s.wal != nil {
+		size += s.wal.fileSize()
+	}
+	return size
+}
+
+// fileSize returns the size of the log and of a moved log left behind by a failed snapshot
+func (l *writeAheadLog) fileSize() int64 {
+	l.mu.Lock()
+	size := l.size
+	l.mu.Unlock()
+	if info, err := os.Stat(l.rotatedPath()); err == nil {
+		size += info.Size()
+	}
+	return size
+}
+
+// CompactHandler compacts the persisted files on demand instead of waiting for the next snapshot
+// it answers 409 without a snapshot file and must only be served once the store is loaded
+func (kv *KeyValueStore) CompactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	snapshotter := kv.root.snapshotter
+	if snapshotter == nil {
+		writeError(w, http.StatusConflict, "NO_SNAPSHOT_FILE", "there is nothing to compact, the store is persisted with --snapshot-file")
+		return
+	}
+	before, after, err := snapshotter.Compact()
+	if err != nil {
+		slog.Error("failed to compact", "error", err)
+		writeError(w, http.StatusInternalServerError, "COMPACTION_FAILED", "failed to compact the persisted files")
+		return
+	}
+	slog.Info("compacted", "before_bytes", before, "after_bytes", after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CompactResponse{BeforeBytes: before, AfterBytes: after})
+}
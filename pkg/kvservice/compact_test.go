@@ -0,0 +1,111 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyValueStore_CompactHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(path, kv)
+	handler := (&Config{}).routes(kv)
+
+	for i := range 20 {
+		serveNamespace(handler, "", http.MethodPost, "/set", fmt.Sprintf(`{"key":"key%d","value":"%d"}`, i, i))
+	}
+	serveNamespace(handler, "team-a", http.MethodPost, "/set", `{"key":"a","value":"1"}`)
+	if err := snapshotter.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot file keeps the deleted keys until the next snapshot
+	for i := range 15 {
+		serveNamespace(handler, "", http.MethodDelete, fmt.Sprintf("/kv/key%d", i), "")
+	}
+
+	w := serveNamespace(handler, "", http.MethodPost, "/compact", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v %s", w.Code, w.Body.String())
+	}
+	var resp CompactResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.BeforeBytes == 0 || resp.AfterBytes >= resp.BeforeBytes {
+		t.Errorf("expected the snapshot file to shrink but got %+v", resp)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != resp.AfterBytes {
+		t.Errorf("expected the snapshot file to hold %d bytes but got %v", resp.AfterBytes, err)
+	}
+
+	restored := NewKeyValueStore(StoreOptions{})
+	if _, err := NewSnapshotter(path, restored).Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := testValues(restored), testValues(kv); !maps.Equal(got, want) {
+		t.Errorf("expected the load to restore %v but got %v", want, got)
+	}
+	if got := testValues(restored.Namespace("team-a")); got["a"] != "1" {
+		t.Errorf("expected the namespace to be restored but got %v", got)
+	}
+
+	// without a snapshot file there is nothing to compact
+	handler = (&Config{}).routes(NewKeyValueStore(StoreOptions{}))
+	if w := serveNamespace(handler, "", http.MethodPost, "/compact", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected %v without a snapshot file but got %v", http.StatusConflict, w.Code)
+	}
+}
+
+func TestKeyValueStore_CompactHandler_WriteAheadLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(path, kv)
+	if err := snapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	defer snapshotter.Close()
+	handler := (&Config{}).routes(kv)
+
+	// every overwrite adds a record to the log although the store keeps only the last value
+	for i := range 20 {
+		serveNamespace(handler, "", http.MethodPost, "/set", fmt.Sprintf(`{"key":"counter","value":"%d"}`, i))
+	}
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"gone","value":"1"}`)
+	serveNamespace(handler, "", http.MethodDelete, "/kv/gone", "")
+
+	w := serveNamespace(handler, "", http.MethodPost, "/compact", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v %s", w.Code, w.Body.String())
+	}
+	var resp CompactResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.BeforeBytes == 0 || resp.AfterBytes >= resp.BeforeBytes {
+		t.Errorf("expected the persisted files to shrink but got %+v", resp)
+	}
+	if info, err := os.Stat(path + ".wal"); err != nil || info.Size() != 0 {
+		t.Errorf("expected the log to be truncated but got %v", err)
+	}
+	// the log continues after the compaction
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"after","value":"1"}`)
+
+	restored := NewKeyValueStore(StoreOptions{})
+	restoredSnapshotter := NewSnapshotter(path, restored)
+	if err := restoredSnapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	defer restoredSnapshotter.Close()
+	if _, err := restoredSnapshotter.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := testValues(restored), testValues(kv); !maps.Equal(got, want) {
+		t.Errorf("expected the replay to restore %v but got %v", want, got)
+	}
+}
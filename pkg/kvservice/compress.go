@@ -0,0 +1,89 @@
+package kvservice
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// go run . --compress-threshold 4096
+
+// compressValue returns the gzip compressed value, ok is false if compressing does not make the value smaller
+// gzip.BestSpeed is used since values are compressed under the shard lock, larger levels save little on JSON
+func compressValue(value Value) (Value, bool) {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	io.WriteString(zw, string(value))
+	zw.Close()
+	if buf.Len() >= len(value) {
+		return value, false
+	}
+	return Value(buf.String()), true
+}
+
+// decompressValue returns the value compressed by compressValue, rawLen is its length before compression
+// the store only holds values it compressed itself, so a value that does not decompress is a bug
+func decompressValue(value Value, rawLen int) Value {
+	zr, err := gzip.NewReader(strings.NewReader(string(value)))
+	if err != nil {
+		panic(fmt.Sprintf("decompress stored value: %v", err))
+	}
+	var b strings.Builder
+	b.Grow(rawLen)
+	if _, err := io.Copy(&b, zr); err != nil {
+		panic(fmt.Sprintf("decompress stored value: %v", err))
+	}
+	return Value(b.String())
+}
+
+// compress compresses the value of a string entry larger than the CompressThreshold of the store
+func (kv *KeyValueStore) compress(e entry) entry {
+	threshold := kv.options.CompressThreshold
+	if threshold <= 0 || e.kind != kindString || e.rawLen > 0 || len(e.value) <= threshold {
+		return e
+	}
+	if value, ok := compressValue(e.value); ok {
+		e.rawLen = len(e.value)
+		e.value = value
+	}
+	return e
+}
+
+// plain returns the value of the entry, decompressed if it is stored compressed
+func (e entry) plain() Value {
+	if e.rawLen > 0 {
+		return decompressValue(e.value, e.rawLen)
+	}
+	return e.value
+}
+
+// valueLen returns the length of the value of the entry before compression
+func (e entry) valueLen() int {
+	if e.rawLen > 0 {
+		return e.rawLen
+	}
+	return len(e.value)
+}
+
+// contentLen returns the length of the content of the entry, the value before compression for a string,
+// the items of a list and the fields and their values of a hash
+func (e entry) contentLen() int {
+	n := e.valueLen()
+	for _, item := range e.items {
+		n += len(item)
+	}
+	for field, value := range e.fields {
+		n += len(field) + len(value)
+	}
+	return n
+}
+
+// plain returns the overwritten value, decompressed if it is stored compressed
+func (v historyVersion) plain() Value {
+	if v.rawLen > 0 {
+		return decompressValue(v.value, v.rawLen)
+	}
+	return v.value
+}
@@ -0,0 +1,159 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// jsonValue returns a JSON document of about n bytes that compresses like real data
+func jsonValue(n int) Value {
+	var b strings.Builder
+	b.WriteString(`[`)
+	for i := 0; b.Len() < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(`{"id":` + strconv.Itoa(i) + `,"name":"item","tags":["a","b"]}`)
+	}
+	b.WriteString(`]`)
+	return Value(b.String())
+}
+
+func TestKeyValueStore_Compression(t *testing.T) {
+	large := jsonValue(8192)
+	tests := []struct {
+		name           string
+		threshold      int
+		value          Value
+		wantCompressed bool
+	}{
+		{name: "disabled", threshold: 0, value: large},
+		{name: "at the threshold", threshold: len(large), value: large},
+		{name: "above the threshold", threshold: 4096, value: large, wantCompressed: true},
+		{name: "incompressible", threshold: 16, value: "0123456789abcdefghij"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := NewKeyValueStore(StoreOptions{CompressThreshold: tt.threshold})
+			body, _ := json.Marshal(SetRequest{Key: "key", Value: tt.value})
+			if code := set(t, kv, string(body)); code != http.StatusOK {
+				t.Fatalf("expected status %v but got %v", http.StatusOK, code)
+			}
+
+			e, _ := kv.peek("key")
+			if compressed := e.rawLen > 0; compressed != tt.wantCompressed {
+				t.Errorf("expected compressed %v but got %v", tt.wantCompressed, compressed)
+			}
+			stats := kv.Stats()
+			if want := entrySize("key", tt.value); stats.RawBytes != want {
+				t.Errorf("expected %d raw bytes but got %d", want, stats.RawBytes)
+			}
+			if compressed := stats.Bytes < stats.RawBytes; compressed != tt.wantCompressed {
+				t.Errorf("expected %d bytes to be below %d raw bytes: %v", stats.Bytes, stats.RawBytes, tt.wantCompressed)
+			}
+
+			// clients see the value they set
+			w := httptest.NewRecorder()
+			kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"key"}`)))
+			var got GetResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Value != tt.value {
+				t.Errorf("expected the value that was set but got %d bytes", len(got.Value))
+			}
+
+			s := kv.shard("key")
+			s.Lock()
+			s.remove("key")
+			s.Unlock()
+			if stats := kv.Stats(); stats.Bytes != 0 || stats.RawBytes != 0 {
+				t.Errorf("expected no bytes after the delete but got %d and %d raw", stats.Bytes, stats.RawBytes)
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_Compression_DumpRestore(t *testing.T) {
+	large := jsonValue(8192)
+	values := map[Key]Value{"small": "1", "large": large}
+
+	tests := []struct {
+		name                string
+		source, destination int
+	}{
+		{name: "compressed to uncompressed", source: 4096},
+		{name: "uncompressed to compressed", destination: 4096},
+		{name: "compressed to compressed", source: 4096, destination: 4096},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := NewKeyValueStore(StoreOptions{CompressThreshold: tt.source})
+			for k, v := range values {
+				writeTestValue(source, k, v)
+			}
+			w := httptest.NewRecorder()
+			source.DumpHandler(w, httptest.NewRequest(http.MethodGet, "/dump", nil))
+			if !bytes.Contains(w.Body.Bytes(), []byte(`\"name\":\"item\"`)) {
+				t.Fatal("expected the dump to hold the uncompressed value")
+			}
+
+			destination := NewKeyValueStore(StoreOptions{CompressThreshold: tt.destination})
+			r := httptest.NewRecorder()
+			destination.RestoreHandler(r, httptest.NewRequest(http.MethodPost, "/restore", w.Body))
+			if r.Code != http.StatusOK {
+				t.Fatalf("expected status %v but got %v: %s", http.StatusOK, r.Code, r.Body.String())
+			}
+			got := testValues(destination)
+			if len(got) != len(values) || got["small"] != "1" || got["large"] != large {
+				t.Errorf("expected the values of the source but got %d keys", len(got))
+			}
+			if e, _ := destination.peek("large"); (e.rawLen > 0) != (tt.destination > 0) {
+				t.Errorf("expected the restored value to be compressed by the threshold of the destination")
+			}
+		})
+	}
+}
+
+func BenchmarkKeyValueStore_Compression(b *testing.B) {
+	for _, size := range []struct {
+		name string
+		n    int
+	}{
+		{name: "100KB", n: 100 * 1024},
+		{name: "1MB", n: 1024 * 1024},
+	} {
+		value := jsonValue(size.n)
+		set, _ := json.Marshal(SetRequest{Key: "benchmark-key", Value: value})
+
+		for _, threshold := range []int{0, 4096} {
+			kv := NewKeyValueStore(StoreOptions{CompressThreshold: threshold})
+			name := size.name + " uncompressed"
+			if threshold > 0 {
+				name = size.name + " compressed"
+			}
+
+			b.Run(name+" set", func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					kv.SetHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/set", bytes.NewReader(set)))
+				}
+				// the memory the value takes in the store, compare it with the time per set
+				b.ReportMetric(float64(kv.Bytes()), "stored-bytes")
+			})
+			b.Run(name+" get", func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					kv.GetHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"benchmark-key"}`)))
+				}
+			})
+		}
+	}
+}
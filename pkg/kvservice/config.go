@@ -0,0 +1,851 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// address: localhost:9090
+// shutdown_timeout: 30s
+// max_store_bytes: 1073741824
+// eviction: lru
+
+// fileConfig is the content of the file passed with --config, every key is optional and unknown keys are rejected
+type fileConfig struct {
+	Address                 string     `json:"address"`
+	ShutdownTimeout         duration   `json:"shutdown_timeout"`
+	ShutdownExitCode        int        `json:"shutdown_exit_code"`
+	PreShutdownDelay        duration   `json:"preshutdown_delay"`
+	LoadTimeout             duration   `json:"load_timeout"`
+	HandlerTimeout          duration   `json:"handler_timeout"`
+	LockWaitTimeout         duration   `json:"lock_wait_timeout"`
+	IdempotencyTTL          duration   `json:"idempotency_ttl"`
+	IdempotencyMaxKeys      int        `json:"idempotency_max_keys"`
+	MaxBodyBytes            int64      `json:"max_body_bytes"`
+	SlowRequestThreshold    duration   `json:"slow_request_threshold"`
+	EnableLoggingMiddleware bool       `json:"enable_logging_middleware"`
+	LogHeaders              bool       `json:"log_headers"`
+	RedactHeaders           []string   `json:"redact_headers"`
+	LogHeaderMaxLength      int        `json:"log_header_max_length"`
+	PrettyJSON              bool       `json:"pretty_json"`
+	JSONCase                string     `json:"json_case"`
+	SnapshotFile            string     `json:"snapshot_file"`
+	EncryptionKeyFile       string     `json:"encryption_key_file"`
+	Fsync                   string     `json:"fsync"`
+	SnapshotInterval        duration   `json:"snapshot_interval"`
+	ReplicateFrom           string     `json:"replicate_from"`
+	Seed                    string     `json:"seed"`
+	SeedIgnoreErrors        bool       `json:"seed_ignore_errors"`
+	Peers                   []string   `json:"peers"`
+	PeerAddress             string     `json:"peer_address"`
+	Store                   string     `json:"store"`
+	MaxStoreBytes           int64      `json:"max_store_bytes"`
+	Eviction                string     `json:"eviction"`
+	MaxKeys                 int        `json:"max_keys"`
+	MaxNamespaces           int        `json:"max_namespaces"`
+	SoftDelete              duration   `json:"soft_delete"`
+	MissingKeyStatus        int        `json:"missing_key_status"`
+	HistoryDepth            int        `json:"history_depth"`
+	CompressThreshold       int        `json:"compress_threshold"`
+	SingleWriter            bool       `json:"single_writer"`
+	EnableH2C               bool       `json:"enable_h2c"`
+	EnableDebugEndpoints    bool       `json:"enable_debug_endpoints"`
+	ReadOnly                bool       `json:"read_only"`
+	Maintenance             bool       `json:"maintenance"`
+	MaintenanceRetryAfter   duration   `json:"maintenance_retry_after"`
+	BasePath                string     `json:"base_path"`
+	BasePathAdmin           bool       `json:"base_path_admin"`
+	AdminAddress            string     `json:"admin_address"`
+	MaxConnections          int        `json:"max_connections"`
+	ReadHeaderTimeout       duration   `json:"read_header_timeout"`
+	ReadTimeout             duration   `json:"read_timeout"`
+	WriteTimeout            duration   `json:"write_timeout"`
+	IdleTimeout             duration   `json:"idle_timeout"`
+	MaxHeaderBytes          int        `json:"max_header_bytes"`
+	MaxListKeys             int        `json:"max_list_keys"`
+	SocketMode              string     `json:"socket_mode"`
+	ValueSchema             string     `json:"value_schema"`
+	KeyPattern              string     `json:"key_pattern"`
+	LogLevel                slog.Level `json:"log_level"`
+	LogOutput               string     `json:"log_output"`
+	OtelEndpoint            string     `json:"otel_endpoint"`
+	AuditLog                string     `json:"audit_log"`
+	AuditLogMaxBytes        int64      `json:"audit_log_max_bytes"`
+
+	NamespaceQuotas map[string]NamespaceQuota `json:"namespace_quotas"`
+	Webhooks        []Webhook                 `json:"webhooks"`
+	MetricsBuckets  []float64                 `json:"metrics_buckets"`
+}
+
+// duration lets config files spell durations like flags do e.g. 10s instead of nanoseconds
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// defaultFileConfig returns the built-in defaults, a config file only overrides the keys it sets
+func defaultFileConfig() fileConfig {
+	return fileConfig{
+		Address:               "localhost:8080",
+		ShutdownTimeout:       duration(10 * time.Second),
+		ShutdownExitCode:      1,
+		ReadHeaderTimeout:     duration(5 * time.Second),
+		ReadTimeout:           duration(5 * time.Second),
+		WriteTimeout:          duration(10 * time.Second),
+		IdleTimeout:           duration(120 * time.Second),
+		MaxHeaderBytes:        http.DefaultMaxHeaderBytes,
+		LoadTimeout:           duration(5 * time.Minute),
+		SlowRequestThreshold:  duration(500 * time.Millisecond),
+		Store:                 string(StoreMemory),
+		Eviction:              string(EvictionReject),
+		MissingKeyStatus:      http.StatusNotFound,
+		AuditLogMaxBytes:      100 << 20,
+		LogOutput:             "stderr",
+		LogHeaderMaxLength:    256,
+		JSONCase:              string(JSONCaseSnake),
+		MaxListKeys:           defaultMaxListKeys,
+		IdempotencyTTL:        duration(time.Hour),
+		IdempotencyMaxKeys:    defaultIdempotencyMaxKeys,
+		MaintenanceRetryAfter: duration(DefaultMaintenanceRetryAfter),
+		KeyPattern:            DefaultKeyPattern,
+		MaxBodyBytes:          DefaultMaxBodyBytes,
+		MaxNamespaces:         DefaultMaxNamespaces,
+	}
+}
+
+// loadConfigFile reads a YAML or JSON config file on top of the given defaults
+// JSON is valid YAML so both go through the same strict decoder and an unknown key fails with its name
+func loadConfigFile(path string, defaults fileConfig) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return defaults, fmt.Errorf("read config file: %w", err)
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return defaults, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	cfg := defaults
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		// the YAML was converted to JSON, so name the key without the decoder's JSON wording
+		if key, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return defaults, fmt.Errorf("parse config file %s: unknown key %s", path, key)
+		}
+		return defaults, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig builds the configuration from the command line arguments, the environment and the optional config file
+// there is a hierarchy: provided flags, then environment variables, then the config file, then default values
+// the arguments are parsed twice because the config file named by --config provides the defaults of all other flags
+func ParseConfig(args []string) (Config, error) {
+	var env Config
+	defaults := defaultFileConfig()
+
+	fs := env.flagSet(defaults)
+	fs.SetOutput(io.Discard)
+	// a parse error is reported by the second pass which prints the usage
+	if fs.Parse(args) == nil {
+		if path := fs.Lookup("config").Value.String(); path != "" {
+			var err error
+			if defaults, err = loadConfigFile(path, defaults); err != nil {
+				return env, err
+			}
+		}
+	}
+
+	defaults, err := applyEnv(defaults)
+	if err != nil {
+		return env, err
+	}
+
+	env = Config{args: args, NamespaceQuotas: defaults.NamespaceQuotas, Webhooks: defaults.Webhooks, MetricsBuckets: defaults.MetricsBuckets}
+	if err := env.flagSet(defaults).Parse(args); err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+// applyEnv overrides the config with the environment variables that are set
+// all unparsable values are reported together instead of stopping at the first
+func applyEnv(cfg fileConfig) (fileConfig, error) {
+	var errs []error
+	var err error
+
+	cfg.Address = envOr("SERVER_ADDRESS", cfg.Address)
+	var shutdownTimeout time.Duration
+	shutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", time.Duration(cfg.ShutdownTimeout))
+	cfg.ShutdownTimeout = duration(shutdownTimeout)
+	errs = append(errs, err)
+	cfg.ShutdownExitCode, err = envInt("SHUTDOWN_EXIT_CODE", cfg.ShutdownExitCode)
+	errs = append(errs, err)
+	var preShutdownDelay time.Duration
+	preShutdownDelay, err = envDuration("PRESHUTDOWN_DELAY", time.Duration(cfg.PreShutdownDelay))
+	cfg.PreShutdownDelay = duration(preShutdownDelay)
+	errs = append(errs, err)
+	var loadTimeout time.Duration
+	loadTimeout, err = envDuration("LOAD_TIMEOUT", time.Duration(cfg.LoadTimeout))
+	cfg.LoadTimeout = duration(loadTimeout)
+	errs = append(errs, err)
+	var slowRequestThreshold time.Duration
+	slowRequestThreshold, err = envDuration("SLOW_REQUEST_THRESHOLD", time.Duration(cfg.SlowRequestThreshold))
+	cfg.SlowRequestThreshold = duration(slowRequestThreshold)
+	errs = append(errs, err)
+	var handlerTimeout time.Duration
+	handlerTimeout, err = envDuration("HANDLER_TIMEOUT", time.Duration(cfg.HandlerTimeout))
+	cfg.HandlerTimeout = duration(handlerTimeout)
+	errs = append(errs, err)
+	cfg.MaxBodyBytes, err = envInt64("MAX_BODY_BYTES", cfg.MaxBodyBytes)
+	errs = append(errs, err)
+	var lockWaitTimeout time.Duration
+	lockWaitTimeout, err = envDuration("LOCK_WAIT_TIMEOUT", time.Duration(cfg.LockWaitTimeout))
+	cfg.LockWaitTimeout = duration(lockWaitTimeout)
+	errs = append(errs, err)
+	var idempotencyTTL time.Duration
+	idempotencyTTL, err = envDuration("IDEMPOTENCY_TTL", time.Duration(cfg.IdempotencyTTL))
+	cfg.IdempotencyTTL = duration(idempotencyTTL)
+	errs = append(errs, err)
+	cfg.IdempotencyMaxKeys, err = envInt("IDEMPOTENCY_MAX_KEYS", cfg.IdempotencyMaxKeys)
+	errs = append(errs, err)
+	cfg.EnableLoggingMiddleware, err = envBool("ENABLE_LOGGING_MIDDLEWARE", cfg.EnableLoggingMiddleware)
+	errs = append(errs, err)
+	cfg.PrettyJSON, err = envBool("PRETTY_JSON", cfg.PrettyJSON)
+	errs = append(errs, err)
+	cfg.JSONCase = envOr("JSON_CASE", cfg.JSONCase)
+	cfg.LogHeaders, err = envBool("LOG_HEADERS", cfg.LogHeaders)
+	errs = append(errs, err)
+	if headers := os.Getenv("REDACT_HEADERS"); headers != "" {
+		cfg.RedactHeaders = splitList(headers)
+	}
+	cfg.LogHeaderMaxLength, err = envInt("LOG_HEADER_MAX_LENGTH", cfg.LogHeaderMaxLength)
+	errs = append(errs, err)
+	cfg.SnapshotFile = envOr("SNAPSHOT_FILE", cfg.SnapshotFile)
+	cfg.EncryptionKeyFile = envOr("ENCRYPTION_KEY_FILE", cfg.EncryptionKeyFile)
+	cfg.Fsync = envOr("FSYNC", cfg.Fsync)
+	var snapshotInterval time.Duration
+	snapshotInterval, err = envDuration("SNAPSHOT_INTERVAL", time.Duration(cfg.SnapshotInterval))
+	cfg.SnapshotInterval = duration(snapshotInterval)
+	errs = append(errs, err)
+	cfg.MaxStoreBytes, err = envInt64("MAX_STORE_BYTES", cfg.MaxStoreBytes)
+	errs = append(errs, err)
+	cfg.Store = envOr("STORE", cfg.Store)
+	cfg.Eviction = envOr("EVICTION", cfg.Eviction)
+	cfg.MaxKeys, err = envInt("MAX_KEYS", cfg.MaxKeys)
+	errs = append(errs, err)
+	cfg.MaxNamespaces, err = envInt("MAX_NAMESPACES", cfg.MaxNamespaces)
+	errs = append(errs, err)
+	var softDelete time.Duration
+	softDelete, err = envDuration("SOFT_DELETE", time.Duration(cfg.SoftDelete))
+	cfg.SoftDelete = duration(softDelete)
+	errs = append(errs, err)
+	cfg.MissingKeyStatus, err = envInt("MISSING_KEY_STATUS", cfg.MissingKeyStatus)
+	errs = append(errs, err)
+	cfg.HistoryDepth, err = envInt("HISTORY_DEPTH", cfg.HistoryDepth)
+	errs = append(errs, err)
+	cfg.CompressThreshold, err = envInt("COMPRESS_THRESHOLD", cfg.CompressThreshold)
+	errs = append(errs, err)
+	cfg.SingleWriter, err = envBool("SINGLE_WRITER", cfg.SingleWriter)
+	errs = append(errs, err)
+	cfg.EnableH2C, err = envBool("ENABLE_H2C", cfg.EnableH2C)
+	errs = append(errs, err)
+	cfg.EnableDebugEndpoints, err = envBool("ENABLE_DEBUG_ENDPOINTS", cfg.EnableDebugEndpoints)
+	errs = append(errs, err)
+	cfg.ReadOnly, err = envBool("READ_ONLY", cfg.ReadOnly)
+	errs = append(errs, err)
+	cfg.Maintenance, err = envBool("MAINTENANCE", cfg.Maintenance)
+	errs = append(errs, err)
+	var maintenanceRetryAfter time.Duration
+	maintenanceRetryAfter, err = envDuration("MAINTENANCE_RETRY_AFTER", time.Duration(cfg.MaintenanceRetryAfter))
+	cfg.MaintenanceRetryAfter = duration(maintenanceRetryAfter)
+	errs = append(errs, err)
+	cfg.BasePath = envOr("BASE_PATH", cfg.BasePath)
+	cfg.BasePathAdmin, err = envBool("BASE_PATH_ADMIN", cfg.BasePathAdmin)
+	errs = append(errs, err)
+	cfg.AdminAddress = envOr("ADMIN_ADDRESS", cfg.AdminAddress)
+	cfg.MaxConnections, err = envInt("MAX_CONNECTIONS", cfg.MaxConnections)
+	errs = append(errs, err)
+	var readHeaderTimeout time.Duration
+	readHeaderTimeout, err = envDuration("READ_HEADER_TIMEOUT", time.Duration(cfg.ReadHeaderTimeout))
+	cfg.ReadHeaderTimeout = duration(readHeaderTimeout)
+	errs = append(errs, err)
+	var readTimeout time.Duration
+	readTimeout, err = envDuration("READ_TIMEOUT", time.Duration(cfg.ReadTimeout))
+	cfg.ReadTimeout = duration(readTimeout)
+	errs = append(errs, err)
+	var writeTimeout time.Duration
+	writeTimeout, err = envDuration("WRITE_TIMEOUT", time.Duration(cfg.WriteTimeout))
+	cfg.WriteTimeout = duration(writeTimeout)
+	errs = append(errs, err)
+	var idleTimeout time.Duration
+	idleTimeout, err = envDuration("IDLE_TIMEOUT", time.Duration(cfg.IdleTimeout))
+	cfg.IdleTimeout = duration(idleTimeout)
+	errs = append(errs, err)
+	cfg.MaxHeaderBytes, err = envInt("MAX_HEADER_BYTES", cfg.MaxHeaderBytes)
+	errs = append(errs, err)
+	cfg.MaxListKeys, err = envInt("MAX_LIST_KEYS", cfg.MaxListKeys)
+	errs = append(errs, err)
+	cfg.SocketMode = envOr("SOCKET_MODE", cfg.SocketMode)
+	cfg.ValueSchema = envOr("VALUE_SCHEMA", cfg.ValueSchema)
+	cfg.KeyPattern = envOr("KEY_PATTERN", cfg.KeyPattern)
+	cfg.LogLevel, err = envLevel("LOG_LEVEL", cfg.LogLevel)
+	errs = append(errs, err)
+	cfg.LogOutput = envOr("LOG_OUTPUT", cfg.LogOutput)
+	cfg.OtelEndpoint = envOr("OTEL_ENDPOINT", cfg.OtelEndpoint)
+	cfg.ReplicateFrom = envOr("REPLICATE_FROM", cfg.ReplicateFrom)
+	cfg.Seed = envOr("SEED", cfg.Seed)
+	cfg.SeedIgnoreErrors, err = envBool("SEED_IGNORE_ERRORS", cfg.SeedIgnoreErrors)
+	errs = append(errs, err)
+	if peers := os.Getenv("PEERS"); peers != "" {
+		cfg.Peers = splitList(peers)
+	}
+	cfg.PeerAddress = envOr("PEER_ADDRESS", cfg.PeerAddress)
+	cfg.AuditLog = envOr("AUDIT_LOG", cfg.AuditLog)
+	cfg.AuditLogMaxBytes, err = envInt64("AUDIT_LOG_MAX_BYTES", cfg.AuditLogMaxBytes)
+	errs = append(errs, err)
+
+	return cfg, errors.Join(errs...)
+}
+
+// flagSet defines all flags bound to the fields of env, each flag defaults to the value from the given config
+func (env *Config) flagSet(defaults fileConfig) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.String("config", envOr("CONFIG_FILE", ""), "YAML or JSON file with the configuration, flags and environment variables take precedence over it")
+	fs.StringVar(&env.ServerAddress, "address", defaults.Address, "comma separated server addresses e.g. 10.0.0.5:8080,127.0.0.1:8081, all serving the same routes, use unix:/path/to/socket to listen on a Unix domain socket")
+	fs.StringVar(&env.SocketMode, "socket-mode", defaults.SocketMode, "octal file mode of the Unix socket of a unix: address, e.g. 0660 to let a sidecar in the same group connect, empty leaves it to the umask")
+	fs.DurationVar(&env.ShutdownTimeout, "shutdown-timeout", time.Duration(defaults.ShutdownTimeout), "shutdown timeout e.g. 10s")
+	fs.IntVar(&env.ShutdownExitCode, "shutdown-exit-code", defaults.ShutdownExitCode, "exit code when the connections were not drained within the shutdown timeout or the final snapshot failed, a clean shutdown exits with 0")
+	fs.DurationVar(&env.PreShutdownDelay, "preshutdown-delay", time.Duration(defaults.PreShutdownDelay), "time to keep serving with a failing readiness probe before shutting down, so load balancers stop routing first")
+	fs.DurationVar(&env.LoadTimeout, "load-timeout", time.Duration(defaults.LoadTimeout), "maximum time to load the snapshot or the seed at startup before giving up, 0 means no limit")
+	fs.DurationVar(&env.HandlerTimeout, "handler-timeout", time.Duration(defaults.HandlerTimeout), "maximum time a data endpoint may take before it is answered with 503, 0 means no limit")
+	fs.Int64Var(&env.MaxBodyBytes, "max-body-bytes", defaults.MaxBodyBytes, "maximum size of a request body on the data endpoints including /mset batches, larger bodies are answered with 413, 0 means unlimited")
+	fs.DurationVar(&env.LockWaitTimeout, "lock-wait-timeout", time.Duration(defaults.LockWaitTimeout), "maximum time /get, /set, /setnx, /pop and /kv/ wait for the lock of a key held by another write before answering 503, 0 waits until the request ends")
+	fs.DurationVar(&env.IdempotencyTTL, "idempotency-ttl", time.Duration(defaults.IdempotencyTTL), "how long /set remembers the response to a request with an Idempotency-Key header and replays it to retries, 0 ignores the header")
+	fs.IntVar(&env.IdempotencyMaxKeys, "idempotency-max-keys", defaults.IdempotencyMaxKeys, "maximum number of idempotency keys remembered, the oldest is forgotten first")
+	fs.BoolVar(&env.EnableLoggingMiddleware, "enable-logging-middleware", defaults.EnableLoggingMiddleware, "enable logging middleware")
+	fs.BoolVar(&env.LogHeaders, "log-headers", defaults.LogHeaders, "let the logging middleware log the request headers, credentials like Authorization and Cookie are redacted")
+	env.RedactHeaders = defaults.RedactHeaders
+	fs.Var((*listFlag)(&env.RedactHeaders), "redact-headers", "comma separated names of headers redacted by --log-headers in addition to Authorization, Cookie, Set-Cookie and X-Api-Key")
+	fs.IntVar(&env.LogHeaderMaxLength, "log-header-max-length", defaults.LogHeaderMaxLength, "header values logged by --log-headers are cut off after this many bytes, 0 means no limit")
+	fs.DurationVar(&env.SlowRequestThreshold, "slow-request-threshold", time.Duration(defaults.SlowRequestThreshold), "requests taking longer are logged at warn level even without the logging middleware, 0 disables it")
+	fs.BoolVar(&env.PrettyJSON, "pretty-json", defaults.PrettyJSON, "indent JSON responses by two spaces, a single request can ask for it with ?pretty=true")
+	fs.StringVar((*string)(&env.JSONCase), "json-case", defaults.JSONCase, "name the multi-word fields of JSON responses in snake or camel case")
+	fs.StringVar((*string)(&env.Store), "store", defaults.Store, "what serves the keys: memory serves every endpoint, cow only serves /kv/ from a copy-on-write map whose reads never lock, for workloads that hardly write")
+	fs.Int64Var(&env.MaxStoreBytes, "max-store-bytes", defaults.MaxStoreBytes, "maximum total size of all keys and values of all namespaces in bytes, 0 means unbounded")
+	fs.StringVar((*string)(&env.Eviction), "eviction", defaults.Eviction, "what to do when a write exceeds max-store-bytes: reject or lru")
+	fs.IntVar(&env.MaxKeys, "max-keys", defaults.MaxKeys, "maximum number of keys of all namespaces, inserting beyond it evicts the least recently used key of the namespace, 0 means unbounded")
+	fs.IntVar(&env.MaxNamespaces, "max-namespaces", defaults.MaxNamespaces, "maximum number of namespaces besides the default one, writes to further namespaces are rejected, 0 means unbounded")
+	fs.DurationVar(&env.SoftDelete, "soft-delete", time.Duration(defaults.SoftDelete), "keep deleted keys as tombstones for this long so /undelete can restore them, 0 deletes immediately")
+	fs.IntVar(&env.MissingKeyStatus, "missing-key-status", defaults.MissingKeyStatus, "status of /get for a missing key: 404, or 200 with a null value")
+	fs.IntVar(&env.HistoryDepth, "history-depth", defaults.HistoryDepth, "number of overwritten values kept per key and served by /history, they count towards max-store-bytes, 0 keeps none")
+	fs.IntVar(&env.CompressThreshold, "compress-threshold", defaults.CompressThreshold, "gzip string values larger than this many bytes in memory, max-store-bytes counts their compressed size, 0 disables compression")
+	fs.BoolVar(&env.SingleWriter, "single-writer", defaults.SingleWriter, "apply the writes of /set on one goroutine in batches, each shard is locked once per batch")
+	fs.BoolVar(&env.EnableH2C, "enable-h2c", defaults.EnableH2C, "serve HTTP/2 over cleartext (h2c) next to HTTP/1.1")
+	fs.BoolVar(&env.EnableDebugEndpoints, "enable-debug-endpoints", defaults.EnableDebugEndpoints, "serve pprof and expvar under /debug/")
+	fs.BoolVar(&env.ReadOnly, "read-only", defaults.ReadOnly, "reject all mutations, can be changed at runtime via /admin/readonly")
+	fs.BoolVar(&env.Maintenance, "maintenance", defaults.Maintenance, "answer 503 on all data endpoints and fail the readiness probe, can be changed at runtime via /admin/maintenance")
+	fs.DurationVar(&env.MaintenanceRetryAfter, "maintenance-retry-after", time.Duration(defaults.MaintenanceRetryAfter), "how long clients are asked to wait via Retry-After during maintenance")
+	fs.StringVar(&env.BasePath, "base-path", defaults.BasePath, "prefix for all routes e.g. /kv so /set is served as /kv/set")
+	fs.BoolVar(&env.BasePathAdmin, "base-path-admin", defaults.BasePathAdmin, "also prefix probes, metrics, stats, debug and admin endpoints with the base path, by default they stay at the root")
+	fs.StringVar(&env.AdminAddress, "admin-address", defaults.AdminAddress, "address for probes, metrics, stats, debug and admin endpoints, empty serves them on the server address")
+	fs.IntVar(&env.MaxConnections, "max-connections", defaults.MaxConnections, "maximum number of concurrent connections on each server address, further clients wait until one closes, 0 means unlimited")
+	fs.DurationVar(&env.ReadHeaderTimeout, "read-header-timeout", time.Duration(defaults.ReadHeaderTimeout), "time a client has to send the headers of a request, connections that send them slower are closed, 0 uses the read timeout")
+	fs.DurationVar(&env.ReadTimeout, "read-timeout", time.Duration(defaults.ReadTimeout), "time a client has to send a whole request including its body, 0 means no timeout")
+	fs.DurationVar(&env.WriteTimeout, "write-timeout", time.Duration(defaults.WriteTimeout), "time a handler has to write its response after the request was read, /dump and /changes may stream for longer as long as each write completes in it, 0 means no timeout")
+	fs.DurationVar(&env.IdleTimeout, "idle-timeout", time.Duration(defaults.IdleTimeout), "time a keep-alive connection is kept open waiting for the next request, 0 uses the read timeout")
+	fs.IntVar(&env.MaxHeaderBytes, "max-header-bytes", defaults.MaxHeaderBytes, "maximum size of the request line and headers, larger requests are answered with 431, 0 means 1MB")
+	fs.IntVar(&env.MaxListKeys, "max-list-keys", defaults.MaxListKeys, "maximum number of keys /keys returns however many a client asks for, larger listings are paged through with /scan")
+	fs.StringVar(&env.ValueSchema, "value-schema", defaults.ValueSchema, "JSON Schema file values must match, values that are not JSON are validated as strings, empty accepts every value")
+	fs.StringVar(&env.KeyPattern, "key-pattern", defaults.KeyPattern, "regular expression written keys must match as a whole, other keys are rejected with 400, empty accepts every key")
+	fs.TextVar(&env.LogLevel, "log-level", defaults.LogLevel, "minimum level to log: debug, info, warn or error, can be changed at runtime via /admin/loglevel")
+	fs.StringVar(&env.LogOutput, "log-output", defaults.LogOutput, "where to log: stdout, stderr or a file that is appended to")
+	fs.StringVar(&env.OtelEndpoint, "otel-endpoint", defaults.OtelEndpoint, "OTLP/HTTP collector URL e.g. http://localhost:4318 to export a trace span per request to, empty disables tracing")
+	fs.StringVar(&env.AuditLog, "audit-log", defaults.AuditLog, "file to record every mutation in as newline delimited JSON, stderr writes to standard error, empty disables auditing")
+	fs.Int64Var(&env.AuditLogMaxBytes, "audit-log-max-bytes", defaults.AuditLogMaxBytes, "size at which the audit log file is rotated, 0 never rotates")
+	fs.StringVar(&env.SnapshotFile, "snapshot-file", defaults.SnapshotFile, "file the store is loaded from at startup and written to on shutdown, empty disables persistence")
+	fs.StringVar(&env.EncryptionKeyFile, "encryption-key-file", defaults.EncryptionKeyFile, "file holding a 32 byte key the snapshot file and the write-ahead log are encrypted with using AES-GCM, empty writes them as plaintext")
+	fs.StringVar((*string)(&env.Fsync), "fsync", defaults.Fsync, "also log every change to snapshot-file.wal, synced to disk on every write (always), once per second (interval) or when the OS decides (never), empty only writes snapshots")
+	fs.StringVar(&env.ReplicateFrom, "replicate-from", defaults.ReplicateFrom, "URL of a primary serving /dump to replicate all namespaces from, the replica is read-only and not ready until the initial sync completed")
+	fs.StringVar(&env.Seed, "seed", defaults.Seed, "file or http(s) URL of a dump in the /restore format loaded at startup, with snapshot-file only while the snapshot file does not exist")
+	fs.BoolVar(&env.SeedIgnoreErrors, "seed-ignore-errors", defaults.SeedIgnoreErrors, "skip the malformed lines of the seed instead of failing the startup")
+	env.Peers = defaults.Peers
+	fs.Var((*listFlag)(&env.Peers), "peers", "comma separated addresses of the nodes sharing the keys on a consistent-hash ring, requests for keys of other nodes are forwarded to them, can be changed at runtime via SIGHUP")
+	fs.StringVar(&env.PeerAddress, "peer-address", defaults.PeerAddress, "the entry of peers that is this node, empty means the server address")
+	fs.DurationVar(&env.SnapshotInterval, "snapshot-interval", time.Duration(defaults.SnapshotInterval), "also write the snapshot file this often if the store changed, 0 only writes it on shutdown")
+	return fs
+}
+
+// listFlag is a flag of comma separated values, setting it replaces the default
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = splitList(v)
+	return nil
+}
+
+// splitList splits comma separated values and drops the empty ones
+func splitList(v string) []string {
+	var values []string
+	for _, value := range strings.Split(v, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// envOr returns the value of the environment variable, or def if it is unset or empty
+func envOr[T ~string](name string, def T) T {
+	if v := os.Getenv(name); v != "" {
+		return T(v)
+	}
+	return def
+}
+
+// envParse returns the environment variable converted with parse, or def if it is unset or empty
+// an unparsable value returns def together with an error naming the variable
+func envParse[T any](name string, def T, parse func(string) (T, error)) (T, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	parsed, err := parse(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid value %q for %s: %w", v, name, err)
+	}
+	return parsed, nil
+}
+
+// envDuration returns the environment variable as a duration e.g. 10s
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	return envParse(name, def, time.ParseDuration)
+}
+
+// envBool returns the environment variable as a bool, accepting the values of strconv.ParseBool
+func envBool(name string, def bool) (bool, error) {
+	return envParse(name, def, strconv.ParseBool)
+}
+
+// envInt returns the environment variable as an int
+func envInt(name string, def int) (int, error) {
+	return envParse(name, def, strconv.Atoi)
+}
+
+// envInt64 returns the environment variable as an int64
+func envInt64(name string, def int64) (int64, error) {
+	return envParse(name, def, func(v string) (int64, error) {
+		return strconv.ParseInt(v, 10, 64)
+	})
+}
+
+// envLevel returns the environment variable as a log level e.g. debug
+func envLevel(name string, def slog.Level) (slog.Level, error) {
+	return envParse(name, def, func(v string) (slog.Level, error) {
+		var level slog.Level
+		err := level.UnmarshalText([]byte(v))
+		return level, err
+	})
+}
+
+// LogValue renders the configuration for the startup log, fields tagged secret:"true" are redacted when set
+func (env Config) LogValue() slog.Value {
+	return redactedValue(reflect.ValueOf(env))
+}
+
+// redactedValue turns the exported fields of a struct into a log group, replacing the values of secret fields
+// structs in slices like the webhooks are redacted as well, each as a group named by its index
+func redactedValue(v reflect.Value) slog.Value {
+	attrs := make([]slog.Attr, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		switch {
+		case !field.IsExported():
+		case field.Tag.Get("secret") == "true" && !value.IsZero():
+			attrs = append(attrs, slog.String(field.Name, "REDACTED"))
+		case value.Kind() == reflect.Struct:
+			attrs = append(attrs, slog.Attr{Key: field.Name, Value: redactedValue(value)})
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			elems := make([]slog.Attr, value.Len())
+			for j := range elems {
+				elems[j] = slog.Attr{Key: strconv.Itoa(j), Value: redactedValue(value.Index(j))}
+			}
+			attrs = append(attrs, slog.Attr{Key: field.Name, Value: slog.GroupValue(elems...)})
+		default:
+			attrs = append(attrs, slog.Any(field.Name, value.Interface()))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// redactURL returns only the scheme and host of a URL for logs and stats, its user info, path and query may carry credentials
+// anything that is not an absolute URL, like a file path, is returned as it is
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return raw
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Validate checks the configuration before anything is started and reports all problems at once
+func (env *Config) Validate() error {
+	var errs []error
+
+	addresses := env.serverAddresses()
+	if len(addresses) == 0 {
+		errs = append(errs, errors.New("address must not be empty"))
+	}
+	for i, address := range addresses {
+		if err := validateAddress(address); err != nil {
+			errs = append(errs, fmt.Errorf("address %q: %w", address, err))
+		} else if slices.Contains(addresses[:i], address) {
+			errs = append(errs, fmt.Errorf("address %q is listed twice", address))
+		}
+	}
+	if env.AdminAddress != "" {
+		if err := validateAddress(env.AdminAddress); err != nil {
+			errs = append(errs, fmt.Errorf("admin-address %q: %w", env.AdminAddress, err))
+		} else if slices.Contains(addresses, env.AdminAddress) {
+			errs = append(errs, errors.New("admin-address must differ from address"))
+		}
+	}
+	if env.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown-timeout must be positive, got %v", env.ShutdownTimeout))
+	}
+	// 0 would hide the failure and the codes above 125 mean something else to shells and container runtimes
+	if env.ShutdownExitCode < 1 || env.ShutdownExitCode > 125 {
+		errs = append(errs, fmt.Errorf("shutdown-exit-code must be between 1 and 125, got %d", env.ShutdownExitCode))
+	}
+	if env.PreShutdownDelay < 0 {
+		errs = append(errs, fmt.Errorf("preshutdown-delay must not be negative, got %v", env.PreShutdownDelay))
+	}
+	if env.LogHeaderMaxLength < 0 {
+		errs = append(errs, fmt.Errorf("log-header-max-length must not be negative, got %d", env.LogHeaderMaxLength))
+	}
+	if env.SlowRequestThreshold < 0 {
+		errs = append(errs, fmt.Errorf("slow-request-threshold must not be negative, got %v", env.SlowRequestThreshold))
+	}
+	if env.LoadTimeout < 0 {
+		errs = append(errs, fmt.Errorf("load-timeout must not be negative, got %v", env.LoadTimeout))
+	}
+	if env.HandlerTimeout < 0 {
+		errs = append(errs, fmt.Errorf("handler-timeout must not be negative, got %v", env.HandlerTimeout))
+	}
+	if env.MaxBodyBytes < 0 {
+		errs = append(errs, fmt.Errorf("max-body-bytes must not be negative, got %d", env.MaxBodyBytes))
+	}
+	if env.MaintenanceRetryAfter < 0 {
+		errs = append(errs, fmt.Errorf("maintenance-retry-after must not be negative, got %v", env.MaintenanceRetryAfter))
+	}
+	if env.IdempotencyTTL < 0 {
+		errs = append(errs, fmt.Errorf("idempotency-ttl must not be negative, got %v", env.IdempotencyTTL))
+	}
+	if env.IdempotencyMaxKeys < 0 {
+		errs = append(errs, fmt.Errorf("idempotency-max-keys must not be negative, got %d", env.IdempotencyMaxKeys))
+	}
+	if env.LockWaitTimeout < 0 {
+		errs = append(errs, fmt.Errorf("lock-wait-timeout must not be negative, got %v", env.LockWaitTimeout))
+	}
+	if env.MaxStoreBytes < 0 {
+		errs = append(errs, fmt.Errorf("max-store-bytes must not be negative, got %d", env.MaxStoreBytes))
+	}
+	if env.MaxKeys < 0 {
+		errs = append(errs, fmt.Errorf("max-keys must not be negative, got %d", env.MaxKeys))
+	}
+	if env.MaxNamespaces < 0 {
+		errs = append(errs, fmt.Errorf("max-namespaces must not be negative, got %d", env.MaxNamespaces))
+	}
+	if env.HistoryDepth < 0 {
+		errs = append(errs, fmt.Errorf("history-depth must not be negative, got %d", env.HistoryDepth))
+	}
+	if env.CompressThreshold < 0 {
+		errs = append(errs, fmt.Errorf("compress-threshold must not be negative, got %d", env.CompressThreshold))
+	}
+	if env.SoftDelete < 0 {
+		errs = append(errs, fmt.Errorf("soft-delete must not be negative, got %v", env.SoftDelete))
+	}
+	if env.MissingKeyStatus != http.StatusNotFound && env.MissingKeyStatus != http.StatusOK {
+		errs = append(errs, fmt.Errorf("missing-key-status must be %d or %d, got %d", http.StatusNotFound, http.StatusOK, env.MissingKeyStatus))
+	}
+	if env.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("max-connections must not be negative, got %d", env.MaxConnections))
+	}
+	if env.ReadHeaderTimeout < 0 {
+		errs = append(errs, fmt.Errorf("read-header-timeout must not be negative, got %v", env.ReadHeaderTimeout))
+	}
+	if env.ReadTimeout < 0 {
+		errs = append(errs, fmt.Errorf("read-timeout must not be negative, got %v", env.ReadTimeout))
+	}
+	if env.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("write-timeout must not be negative, got %v", env.WriteTimeout))
+	}
+	if env.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("idle-timeout must not be negative, got %v", env.IdleTimeout))
+	}
+	if env.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("max-header-bytes must not be negative, got %d", env.MaxHeaderBytes))
+	}
+	if env.SocketMode != "" {
+		if mode, err := strconv.ParseUint(env.SocketMode, 8, 32); err != nil || mode > 0o777 {
+			errs = append(errs, fmt.Errorf("socket-mode must be an octal file mode like 0660, got %q", env.SocketMode))
+		}
+	}
+	if env.MaxListKeys < 0 {
+		errs = append(errs, fmt.Errorf("max-list-keys must not be negative, got %d", env.MaxListKeys))
+	}
+	if env.KeyPattern != "" {
+		if _, err := compileKeyPattern(env.KeyPattern); err != nil {
+			errs = append(errs, fmt.Errorf("key-pattern: %w", err))
+		}
+	}
+	if env.JSONCase != "" && env.JSONCase != JSONCaseSnake && env.JSONCase != JSONCaseCamel {
+		errs = append(errs, fmt.Errorf("json-case must be %s or %s, got %q", JSONCaseSnake, JSONCaseCamel, env.JSONCase))
+	}
+	if env.Eviction != EvictionReject && env.Eviction != EvictionLRU {
+		errs = append(errs, fmt.Errorf("eviction must be %s or %s, got %q", EvictionReject, EvictionLRU, env.Eviction))
+	}
+	switch env.Store {
+	case "", StoreMemory:
+	case StoreCOW:
+		// the copy-on-write store only has the keys, the features of the in-memory store would silently apply to a store nobody serves
+		for _, option := range []struct {
+			name string
+			set  bool
+		}{
+			{"snapshot-file", env.SnapshotFile != ""},
+			{"seed", env.Seed != ""},
+			{"replicate-from", env.ReplicateFrom != ""},
+			{"peers", len(env.Peers) > 0},
+			{"eviction lru", env.Eviction == EvictionLRU},
+			{"soft-delete", env.SoftDelete > 0},
+			{"history-depth", env.HistoryDepth > 0},
+			{"compress-threshold", env.CompressThreshold > 0},
+			{"value-schema", env.ValueSchema != ""},
+			{"audit-log", env.AuditLog != ""},
+			{"webhooks", len(env.Webhooks) > 0},
+			{"namespace_quotas", len(env.NamespaceQuotas) > 0},
+			{"single-writer", env.SingleWriter},
+		} {
+			if option.set {
+				errs = append(errs, fmt.Errorf("store %s can not be combined with %s", StoreCOW, option.name))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("store must be %s or %s, got %q", StoreMemory, StoreCOW, env.Store))
+	}
+	if env.BasePath != "" && !strings.HasPrefix(env.BasePath, "/") {
+		errs = append(errs, fmt.Errorf("base-path must start with /, got %q", env.BasePath))
+	}
+	if env.BasePathAdmin && env.BasePath == "" {
+		errs = append(errs, errors.New("base-path-admin requires base-path"))
+	}
+	for _, name := range slices.Sorted(maps.Keys(env.NamespaceQuotas)) {
+		quota := env.NamespaceQuotas[name]
+		if err := validateNamespace(name); err != nil {
+			errs = append(errs, fmt.Errorf("namespace_quotas: %w", err))
+		}
+		if quota.MaxKeys < 0 || quota.MaxBytes < 0 {
+			errs = append(errs, fmt.Errorf("namespace_quotas: quota of namespace %s must not be negative", name))
+		}
+	}
+	for i, hook := range env.Webhooks {
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhooks: url of webhook %d must be an http or https URL, got %q", i, hook.URL))
+		}
+		for _, event := range hook.Events {
+			if event != WebhookEventSet && event != WebhookEventDelete {
+				errs = append(errs, fmt.Errorf("webhooks: event of webhook %d must be %s or %s, got %q", i, WebhookEventSet, WebhookEventDelete, event))
+			}
+		}
+	}
+	for i, bucket := range env.MetricsBuckets {
+		if bucket <= 0 || (i > 0 && bucket <= env.MetricsBuckets[i-1]) {
+			errs = append(errs, fmt.Errorf("metrics_buckets must be positive and increasing, got %v", env.MetricsBuckets))
+			break
+		}
+	}
+	if env.OtelEndpoint != "" {
+		if u, err := url.Parse(env.OtelEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("otel-endpoint must be an http or https URL, got %q", env.OtelEndpoint))
+		}
+	}
+	if env.LogOutput != "" && env.LogOutput != "stdout" && env.LogOutput != "stderr" {
+		if info, err := os.Stat(filepath.Dir(env.LogOutput)); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("log-output %q: directory does not exist", env.LogOutput))
+		}
+	}
+	if env.AuditLogMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("audit-log-max-bytes must not be negative, got %d", env.AuditLogMaxBytes))
+	}
+	if env.AuditLog != "" && env.AuditLog != "stderr" {
+		if info, err := os.Stat(filepath.Dir(env.AuditLog)); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("audit-log %q: directory does not exist", env.AuditLog))
+		}
+	}
+	if env.SnapshotInterval < 0 {
+		errs = append(errs, fmt.Errorf("snapshot-interval must not be negative, got %v", env.SnapshotInterval))
+	} else if env.SnapshotInterval > 0 && env.SnapshotFile == "" {
+		errs = append(errs, errors.New("snapshot-interval requires snapshot-file"))
+	}
+	if env.ReplicateFrom != "" {
+		if u, err := url.Parse(env.ReplicateFrom); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("replicate-from must be an http or https URL, got %q", env.ReplicateFrom))
+		}
+		// a replica copies the primary on every start, a snapshot of its own could only be outdated
+		if env.SnapshotFile != "" {
+			errs = append(errs, errors.New("replicate-from can not be combined with snapshot-file"))
+		}
+		if env.Seed != "" {
+			errs = append(errs, errors.New("replicate-from can not be combined with seed"))
+		}
+	}
+	for _, peer := range env.Peers {
+		if err := validateAddress(peer); err != nil || strings.HasPrefix(peer, "unix:") {
+			errs = append(errs, fmt.Errorf("peer %q must be a host and port", peer))
+		}
+	}
+	if env.PeerAddress != "" && !slices.Contains(env.Peers, env.PeerAddress) {
+		errs = append(errs, fmt.Errorf("peer-address %q must be one of peers", env.PeerAddress))
+	}
+	if env.SnapshotFile != "" {
+		if info, err := os.Stat(filepath.Dir(env.SnapshotFile)); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("snapshot-file %q: directory does not exist", env.SnapshotFile))
+		}
+	}
+	if env.EncryptionKeyFile != "" {
+		if env.SnapshotFile == "" {
+			errs = append(errs, errors.New("encryption-key-file requires snapshot-file"))
+		}
+		// only the size is checked, the key itself is read by Listen so it is not held by the config
+		if info, err := os.Stat(env.EncryptionKeyFile); err != nil || info.Size() != EncryptionKeySize {
+			errs = append(errs, fmt.Errorf("encryption-key-file %q must be a file of %d bytes", env.EncryptionKeyFile, EncryptionKeySize))
+		}
+	}
+	switch env.Fsync {
+	case "":
+	case FsyncAlways, FsyncInterval, FsyncNever:
+		if env.SnapshotFile == "" {
+			errs = append(errs, errors.New("fsync requires snapshot-file"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("fsync must be %s, %s or %s, got %q", FsyncAlways, FsyncInterval, FsyncNever, env.Fsync))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateAddress checks that the address can be passed to listen, a TCP address needs a numeric port
+func validateAddress(address string) error {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		if strings.TrimPrefix(path, "//") == "" {
+			return errors.New("missing socket path")
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// reloadable are the settings a reload applies to the running server, all others need a restart
+var reloadable = map[string]bool{
+	"LogLevel":              true,
+	"ReadOnly":              true,
+	"Maintenance":           true,
+	"MaintenanceRetryAfter": true,
+	"Peers":                 true,
+	"PeerAddress":           true,
+}
+
+// reload parses the configuration again and applies the reloadable settings that changed since the last load
+// a setting that was changed at runtime e.g. via /admin/readonly is only overwritten if its configured value changed
+// the log level and read-only flag are atomic so in-flight requests see either the old or the new value
+func (env *Config) reload(kvStore *KeyValueStore) error {
+	next, err := ParseConfig(env.args)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		return err
+	}
+	next.ServiceName = env.ServiceName
+	next.Build = env.Build
+
+	current, updated := reflect.ValueOf(env).Elem(), reflect.ValueOf(next)
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if !field.IsExported() || reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			continue
+		}
+		if !reloadable[field.Name] {
+			slog.Warn("setting can not be changed at runtime, restart to apply it", "setting", field.Name)
+			continue
+		}
+		slog.Info("setting reloaded", "setting", field.Name, "from", current.Field(i).Interface(), "to", updated.Field(i).Interface())
+	}
+
+	if next.LogLevel != env.LogLevel {
+		logLevel.Set(next.LogLevel)
+		env.LogLevel = next.LogLevel
+	}
+	if next.ReadOnly != env.ReadOnly {
+		kvStore.SetReadOnly(next.ReadOnly)
+		env.ReadOnly = next.ReadOnly
+	}
+	if next.Maintenance != env.Maintenance || next.MaintenanceRetryAfter != env.MaintenanceRetryAfter {
+		enabled, retryAfter := kvStore.Maintenance()
+		if next.Maintenance != env.Maintenance {
+			enabled = next.Maintenance
+		}
+		if next.MaintenanceRetryAfter != env.MaintenanceRetryAfter {
+			retryAfter = next.MaintenanceRetryAfter
+		}
+		kvStore.SetMaintenance(enabled, retryAfter)
+		env.Maintenance, env.MaintenanceRetryAfter = next.Maintenance, next.MaintenanceRetryAfter
+	}
+	if !slices.Equal(next.Peers, env.Peers) || next.PeerAddress != env.PeerAddress {
+		env.Peers, env.PeerAddress = next.Peers, next.PeerAddress
+		if env.router != nil {
+			env.router.SetPeers(env.peerAddress(), env.Peers)
+		}
+	}
+	return nil
+}
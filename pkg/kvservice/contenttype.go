@@ -0,0 +1,22 @@
+package kvservice
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MiddlewareContentType answers 415 for requests whose body is not of the given media type
+// parameters like charset are ignored, so application/json; charset=utf-8 is accepted for application/json
+func MiddlewareContentType(mediaType string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !strings.EqualFold(got, mediaType) {
+			w.Header().Set("Accept-Post", mediaType)
+			writeError(w, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", fmt.Sprintf("the request body must be %s", mediaType))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,70 @@
+package kvservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		wantCode    int
+	}{
+		{name: "json", contentType: "application/json", wantCode: http.StatusAccepted},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", wantCode: http.StatusAccepted},
+		{name: "json in upper case", contentType: "Application/JSON", wantCode: http.StatusAccepted},
+		{name: "form", contentType: "application/x-www-form-urlencoded", wantCode: http.StatusUnsupportedMediaType},
+		{name: "text", contentType: "text/plain", wantCode: http.StatusUnsupportedMediaType},
+		{name: "missing", contentType: "", wantCode: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"a","value":"1"}`))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			MiddlewareContentType("application/json", next)(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v", tt.wantCode, w.Code)
+			}
+			if tt.wantCode == http.StatusUnsupportedMediaType && !strings.Contains(w.Body.String(), "UNSUPPORTED_MEDIA_TYPE") {
+				t.Errorf("expected the code UNSUPPORTED_MEDIA_TYPE but got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestConfig_routes_ContentType(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"a","value":"1"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType || kv.Len() != 0 {
+		t.Errorf("set: expected status %v and no write but got %v with %d keys", http.StatusUnsupportedMediaType, w.Code, kv.Len())
+	}
+
+	// reads and the raw values of the path based API are not restricted
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/kv/a", strings.NewReader("1")))
+	if w.Code != http.StatusCreated {
+		t.Errorf("put: expected status %v but got %v", http.StatusCreated, w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"a"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("get: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+}
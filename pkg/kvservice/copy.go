@@ -0,0 +1,67 @@
+package kvservice
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"src":"a","dst":"b","overwrite":false}' http://localhost:8080/copy
+
+type CopyRequest struct {
+	Src       Key    `json:"src"`
+	Dst       Key    `json:"dst"`
+	Overwrite bool   `json:"overwrite"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// CopyHandler copies the value of src to dst with both keys locked, so no write can slip in between reading and writing
+// it answers 404 if src does not exist and 409 if dst exists and overwrite is not set
+func (kv *KeyValueStore) CopyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	var payload CopyRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	if kv.rejectInvalidKey(w, payload.Dst) {
+		return
+	}
+
+	unlock := kv.lockKeys(payload.Src, payload.Dst)
+
+	src := kv.shard(payload.Src)
+	e, ok := src.kvMap[payload.Src]
+	if !ok {
+		unlock()
+		http.Error(w, "Source key not found", http.StatusNotFound)
+		return
+	}
+	dst := kv.shard(payload.Dst)
+	if _, exists := dst.kvMap[payload.Dst]; exists && !payload.Overwrite {
+		unlock()
+		http.Error(w, "Destination key already exists", http.StatusConflict)
+		return
+	}
+
+	// a list is copied with its items, as the items of the source are modified in place they must not be shared
+	e = entry{value: e.value, rawLen: e.rawLen, kind: e.kind, items: slices.Clone(e.items), fields: e.fields}
+	_, err := dst.writeEntry(payload.Dst, e)
+	unlock()
+	if err == nil {
+		err = kv.syncLog()
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	dst.kv.stats.sets.Add(1)
+	kv.audit(r, "set", payload.Dst, e.valueLen(), 0)
+
+	fmt.Fprintln(w, http.StatusOK)
+}
@@ -0,0 +1,56 @@
+package kvservice
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestKeyValueStore_CopyHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		want     map[Key]Value
+	}{
+		{
+			name:     "copy",
+			body:     `{"src":"a","dst":"c"}`,
+			wantCode: http.StatusOK,
+			want:     map[Key]Value{"a": "1", "b": "2", "c": "1"},
+		},
+		{
+			name:     "missing source",
+			body:     `{"src":"missing","dst":"c"}`,
+			wantCode: http.StatusNotFound,
+			want:     map[Key]Value{"a": "1", "b": "2"},
+		},
+		{
+			name:     "existing destination",
+			body:     `{"src":"a","dst":"b"}`,
+			wantCode: http.StatusConflict,
+			want:     map[Key]Value{"a": "1", "b": "2"},
+		},
+		{
+			name:     "existing destination with overwrite",
+			body:     `{"src":"a","dst":"b","overwrite":true}`,
+			wantCode: http.StatusOK,
+			want:     map[Key]Value{"a": "1", "b": "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newTestStore(map[Key]Value{"a": "1", "b": "2"})
+			env := Config{}
+
+			w := serveNamespace(env.routes(kv), "", http.MethodPost, "/copy", tt.body)
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if got := testValues(kv); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
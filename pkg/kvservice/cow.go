@@ -0,0 +1,141 @@
+package kvservice
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// go run . --store cow --max-keys 100000
+// curl -X PUT --data-binary 'value1' http://localhost:8080/kv/key1
+
+// StoreBackend selects the implementation serving the keys, see Config.Store
+type StoreBackend string
+
+const (
+	// StoreMemory is the sharded in-memory KeyValueStore serving every endpoint
+	StoreMemory StoreBackend = "memory"
+	// StoreCOW is a CowStore serving /kv/ through NewStoreHandler, for workloads that hardly write
+	StoreCOW StoreBackend = "cow"
+)
+
+// CowStore is a copy-on-write Store: the map is replaced as a whole on every write and reads load it through an atomic pointer
+// so a read never takes a lock and never waits for a write, while a write copies the whole map
+// writers queue their changes and whoever holds the write lock applies all queued changes with a single copy,
+// so concurrent writers share the cost of a copy; it only pays off for workloads that read far more often than they write
+type CowStore struct {
+	m atomic.Pointer[cowMap]
+	// writeMu serializes the writers, the one holding it applies the queue
+	writeMu sync.Mutex
+	// queueMu guards the changes waiting for the next copy
+	queueMu sync.Mutex
+	queue   []*cowChange
+	// maxKeys and maxBytes bound the map, 0 is unbounded; a write beyond them is rejected with ErrStoreFull
+	maxKeys  int
+	maxBytes int64
+}
+
+// cowMap is the state of a CowStore, it is never modified once it is published
+type cowMap struct {
+	values map[Key]Value
+	// bytes is the size of the keys and values
+	bytes int64
+}
+
+// cowChange is a set or, with deleted, a deletion waiting in the queue, the writer applying it fills in the result
+type cowChange struct {
+	key     Key
+	value   Value
+	deleted bool
+	// applied is set once the change is part of the published map, existed and err are its result
+	applied bool
+	existed bool
+	err     error
+}
+
+var _ Store = (*CowStore)(nil)
+
+// NewCowStore returns an empty CowStore holding at most maxKeys keys and maxBytes bytes of keys and values, 0 is unbounded
+func NewCowStore(maxKeys int, maxBytes int64) *CowStore {
+	c := &CowStore{maxKeys: maxKeys, maxBytes: maxBytes}
+	c.m.Store(&cowMap{values: map[Key]Value{}})
+	return c
+}
+
+// Get returns the value of the key from the current map without locking, the context is not used since it never waits
+func (c *CowStore) Get(_ context.Context, key Key) (Value, bool, error) {
+	value, ok := c.m.Load().values[key]
+	return value, ok, nil
+}
+
+// Set stores the value of the key, ErrStoreFull if it exceeds the bounds of the store
+func (c *CowStore) Set(ctx context.Context, key Key, value Value) error {
+	change := &cowChange{key: key, value: value}
+	return c.apply(ctx, change)
+}
+
+// Delete removes the key and reports whether it existed
+func (c *CowStore) Delete(ctx context.Context, key Key) (bool, error) {
+	change := &cowChange{key: key, deleted: true}
+	err := c.apply(ctx, change)
+	return change.existed, err
+}
+
+// Len returns the number of keys
+func (c *CowStore) Len() int {
+	return len(c.m.Load().values)
+}
+
+// apply queues the change and returns once it is part of the published map
+// a context that ended before the change was queued returns its error and leaves the store untouched
+func (c *CowStore) apply(ctx context.Context, change *cowChange) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.queueMu.Lock()
+	c.queue = append(c.queue, change)
+	c.queueMu.Unlock()
+
+	// the writer before us may have taken the change with its batch, the result is then set before it released the lock
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !change.applied {
+		c.applyQueue()
+	}
+	return change.err
+}
+
+// applyQueue copies the map once, applies every queued change in order and publishes the copy, the caller holds writeMu
+func (c *CowStore) applyQueue() {
+	c.queueMu.Lock()
+	batch := c.queue
+	c.queue = nil
+	c.queueMu.Unlock()
+
+	current := c.m.Load()
+	next := &cowMap{values: maps.Clone(current.values), bytes: current.bytes}
+	for _, change := range batch {
+		old, existed := next.values[change.key]
+		change.existed, change.applied = existed, true
+		if change.deleted {
+			if existed {
+				delete(next.values, change.key)
+				next.bytes -= int64(len(change.key) + len(old))
+			}
+			continue
+		}
+
+		bytes := next.bytes + int64(len(change.key)+len(change.value))
+		if existed {
+			bytes -= int64(len(change.key) + len(old))
+		}
+		if (c.maxBytes > 0 && bytes > c.maxBytes) || (c.maxKeys > 0 && !existed && len(next.values) >= c.maxKeys) {
+			change.err = ErrStoreFull
+			continue
+		}
+		next.values[change.key] = change.value
+		next.bytes = bytes
+	}
+	c.m.Store(next)
+}
@@ -0,0 +1,211 @@
+package kvservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCowStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewCowStore(2, 20)
+
+	if err := store.Set(ctx, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := store.Get(ctx, "a"); err != nil || !ok || value != "2" {
+		t.Errorf("expected the overwritten value but got %q %v %v", value, ok, err)
+	}
+	if err := store.Set(ctx, "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "c", "3"); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("expected a third key to be rejected but got %v", err)
+	}
+	if err := store.Set(ctx, "b", Value(strings.Repeat("x", 20))); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("expected a value beyond the bytes to be rejected but got %v", err)
+	}
+	if value, _, _ := store.Get(ctx, "b"); value != "2" {
+		t.Errorf("expected a rejected write to leave the value but got %q", value)
+	}
+
+	if ok, err := store.Delete(ctx, "a"); err != nil || !ok {
+		t.Errorf("expected the key to be deleted but got %v %v", ok, err)
+	}
+	if ok, err := store.Delete(ctx, "a"); err != nil || ok {
+		t.Errorf("expected a missing key to be reported but got %v %v", ok, err)
+	}
+	if err := store.Set(ctx, "c", "3"); err != nil {
+		t.Errorf("expected the deletion to make room but got %v", err)
+	}
+	if n := store.Len(); n != 2 {
+		t.Errorf("expected 2 keys but got %d", n)
+	}
+
+	// a write whose request already ended is not applied
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.Set(cancelled, "d", "4"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled write to fail but got %v", err)
+	}
+	if _, ok, _ := store.Get(ctx, "d"); ok {
+		t.Error("expected the cancelled write not to be applied")
+	}
+}
+
+// TestCowStore_Stress runs readers and writers concurrently, run it with -race
+// every value holds its key, so a reader seeing a value of another key or a torn map would notice
+func TestCowStore_Stress(t *testing.T) {
+	ctx := context.Background()
+	store := NewCowStore(0, 0)
+	keys := make([]Key, 16)
+	for i := range keys {
+		keys[i] = Key(fmt.Sprintf("key-%d", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for w := range 4 {
+		wg.Go(func() {
+			for i := range 500 {
+				key := keys[(w+i)%len(keys)]
+				if i%5 == 0 {
+					store.Delete(ctx, key)
+					continue
+				}
+				if err := store.Set(ctx, key, Value(fmt.Sprintf("%s=%d", key, i))); err != nil {
+					errs <- err
+					return
+				}
+			}
+		})
+	}
+	for range 8 {
+		wg.Go(func() {
+			for i := range 5000 {
+				key := keys[i%len(keys)]
+				value, ok, err := store.Get(ctx, key)
+				if err != nil || (ok && !strings.HasPrefix(string(value), string(key)+"=")) {
+					errs <- fmt.Errorf("%s: read %q %v", key, value, err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// the sizes of the published map add up after all the concurrent writes
+	m := store.m.Load()
+	var bytes int64
+	for key, value := range m.values {
+		bytes += int64(len(key) + len(value))
+	}
+	if bytes != m.bytes {
+		t.Errorf("expected the map to account for %d bytes but it does for %d", bytes, m.bytes)
+	}
+}
+
+func TestListen_CowStore(t *testing.T) {
+	server, err := Listen(Config{ServerAddress: "127.0.0.1:0", Store: StoreCOW, KeyPattern: "[a-z]+"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, l := range server.listeners {
+			l.Close()
+		}
+	}()
+	if _, ok := server.env.backend.(*CowStore); !ok {
+		t.Fatalf("expected the copy-on-write store to be served but got %T", server.env.backend)
+	}
+	handler := server.env.routes(server.store)
+
+	if w := serveNamespace(handler, "", http.MethodPut, "/kv/key", "value"); w.Code != http.StatusNoContent {
+		t.Errorf("put: expected status %v but got %v %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if w := serveNamespace(handler, "", http.MethodGet, "/kv/key", ""); w.Code != http.StatusOK || w.Body.String() != "value" {
+		t.Errorf("get: expected the value but got %v %s", w.Code, w.Body.String())
+	}
+	if w := serveNamespace(handler, "", http.MethodPut, "/kv/Key-1", "value"); w.Code != http.StatusBadRequest {
+		t.Errorf("put of a key not matching the key pattern: expected status %v but got %v", http.StatusBadRequest, w.Code)
+	}
+	if n := server.store.Len(); n != 0 {
+		t.Errorf("expected the in-memory store to stay empty but it holds %d keys", n)
+	}
+	// the endpoints of the in-memory store are not served next to the copy-on-write store
+	if w := serveNamespace(handler, "", http.MethodPost, "/get", `{"key":"key"}`); w.Code != http.StatusNotFound {
+		t.Errorf("get: expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+	if w := serveNamespace(handler, "", http.MethodGet, "/ws", ""); w.Code != http.StatusNotFound {
+		t.Errorf("ws: expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+
+	// read-only mode still rejects the writes but serves the reads
+	if w := serveNamespace(handler, "", http.MethodPost, "/admin/readonly", `{"enabled":true}`); w.Code != http.StatusOK {
+		t.Fatalf("readonly: unexpected status %v", w.Code)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if w := serveNamespace(handler, "", method, "/kv/key", "other"); w.Code != http.StatusForbidden {
+			t.Errorf("%s while read-only: expected status %v but got %v", method, http.StatusForbidden, w.Code)
+		}
+	}
+	if w := serveNamespace(handler, "", http.MethodGet, "/kv/key", ""); w.Code != http.StatusOK || w.Body.String() != "value" {
+		t.Errorf("get while read-only: expected the value but got %v %s", w.Code, w.Body.String())
+	}
+}
+
+// BenchmarkStore_ParallelMix measures reads and writes through the Store interface from parallel goroutines at the given share of reads
+// the in-memory store locks a shard per call, with a read lock or, with LRU eviction tracking the recency, a write lock
+// the copy-on-write store reads without locking and copies the map on every write
+func BenchmarkStore_ParallelMix(b *testing.B) {
+	keys := make([]Key, 1024)
+	for i := range keys {
+		keys[i] = Key(fmt.Sprintf("benchmark-key-%d", i))
+	}
+
+	// LRU eviction tracks the recency of every read, which takes the write lock of the shard
+	lru := StoreOptions{MaxBytes: 1 << 30, Eviction: EvictionLRU}
+	for _, impl := range []struct {
+		name     string
+		newStore func() Store
+	}{
+		{name: "Mutex/1 shard", newStore: func() Store { return newKeyValueStore(lru, 1) }},
+		{name: "Mutex/256 shards", newStore: func() Store { return newKeyValueStore(lru, defaultShards) }},
+		{name: "RWMutex/1 shard", newStore: func() Store { return newKeyValueStore(StoreOptions{}, 1) }},
+		{name: "RWMutex/256 shards", newStore: func() Store { return newKeyValueStore(StoreOptions{}, defaultShards) }},
+		{name: "COW", newStore: func() Store { return NewCowStore(0, 0) }},
+	} {
+		for _, reads := range []int{90, 99} {
+			b.Run(fmt.Sprintf("%s/%d%% reads", impl.name, reads), func(b *testing.B) {
+				ctx := context.Background()
+				store := impl.newStore()
+				for _, key := range keys {
+					store.Set(ctx, key, "benchmark-value")
+				}
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						key := keys[i%len(keys)]
+						if i%100 < reads {
+							store.Get(ctx, key)
+						} else {
+							store.Set(ctx, key, "benchmark-value")
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
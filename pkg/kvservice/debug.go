@@ -0,0 +1,79 @@
+package kvservice
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// curl -o heap.pprof http://localhost:8080/debug/pprof/heap
+// curl http://localhost:8080/debug/vars
+// kill -QUIT $(pidof main)
+
+// expvarStore is the store published under /debug/vars, expvar is process wide so only the latest store is reported
+var (
+	expvarStore       atomic.Pointer[KeyValueStore]
+	publishExpvarOnce sync.Once
+)
+
+// debugRoutes returns the pprof and expvar endpoints, they must only be served when debugging is enabled
+func debugRoutes(kvStore *KeyValueStore) http.Handler {
+	expvarStore.Store(kvStore)
+	publishExpvarOnce.Do(func() {
+		expvar.Publish("kv_store", expvar.Func(func() any {
+			kv := expvarStore.Load()
+			return map[string]any{
+				"keys":  kv.Len(),
+				"bytes": kv.Bytes(),
+			}
+		}))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// LogGoroutines logs the stacks of all goroutines at warn level, in the format the runtime prints them on a crash
+func LogGoroutines() {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	slog.Warn("goroutine dump", "goroutines", runtime.NumGoroutine(), "stacks", string(buf))
+}
+
+// LogGoroutinesOn calls LogGoroutines whenever the process receives one of the signals, until stop is called
+// the signals lose their default action, for SIGQUIT that is printing the stacks to stderr and exiting, so a hung server can be inspected and keeps serving
+func LogGoroutinesOn(signals ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range c {
+			LogGoroutines()
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(c)
+		<-done
+	}
+}
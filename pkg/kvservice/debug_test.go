@@ -0,0 +1,51 @@
+package kvservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfig_routes_DebugEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		wantCode int
+	}{
+		{name: "enabled", enabled: true, wantCode: http.StatusOK},
+		{name: "disabled", enabled: false, wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := Config{
+				EnableDebugEndpoints: tt.enabled,
+			}
+			handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+			for _, path := range []string{"/debug/pprof/heap", "/debug/vars"} {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				if w.Code != tt.wantCode {
+					t.Errorf("%s: expected status %v but got %v", path, tt.wantCode, w.Code)
+				}
+				if tt.enabled && w.Body.Len() == 0 {
+					t.Errorf("%s: expected a non-empty response", path)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugRoutes_Expvar(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	set(t, kv, `{"key":"a","value":"1111"}`)
+
+	w := httptest.NewRecorder()
+	debugRoutes(kv).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	if !strings.Contains(w.Body.String(), `"kv_store": {"bytes":5,"keys":1}`) {
+		t.Errorf("expected store stats in expvars but got %v", w.Body.String())
+	}
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package kvservice
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// chanWriter sends every write to the channel, so a test can wait for a log line written by another goroutine
+type chanWriter chan string
+
+func (c chanWriter) Write(p []byte) (int, error) {
+	c <- string(p)
+	return len(p), nil
+}
+
+func TestLogGoroutinesOn(t *testing.T) {
+	_, url := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second})
+	logged := make(chanWriter, 100)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logged, nil)))
+
+	stop := LogGoroutinesOn(syscall.SIGQUIT)
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGQUIT); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for dumped := false; !dumped; {
+		select {
+		case line := <-logged:
+			dumped = strings.Contains(line, "goroutine dump") && strings.Contains(line, "TestLogGoroutinesOn")
+		case <-timeout:
+			t.Fatal("expected the stacks to be logged")
+		}
+	}
+
+	// the server keeps serving
+	resp, err := http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+}
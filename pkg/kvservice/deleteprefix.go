@@ -0,0 +1,74 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"prefix":"tenant-a:"}' http://localhost:8080/deleteprefix
+// curl -H 'Content-Type: application/json' -d '{"prefix":""}' 'http://localhost:8080/deleteprefix?force=true'
+
+type DeletePrefixRequest struct {
+	Prefix    string `json:"prefix"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type DeletePrefixResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// deletePrefix removes every key starting with prefix and returns how many were removed
+// each shard is write locked while its keys are removed, so a key written concurrently to another shard may survive
+// it stops at the first deletion that could not be logged to the write-ahead log, that key is removed as well
+func (kv *KeyValueStore) deletePrefix(prefix string) (int, error) {
+	n := 0
+	for _, s := range kv.shards {
+		s.Lock()
+		for k := range s.kvMap {
+			if strings.HasPrefix(string(k), prefix) {
+				_, _, err := s.remove(k)
+				n++
+				if err != nil {
+					s.Unlock()
+					return n, err
+				}
+			}
+		}
+		s.Unlock()
+	}
+	return n, kv.syncLog()
+}
+
+// DeletePrefixHandler deletes all keys of the namespace starting with the prefix and returns how many were removed
+// an empty prefix matches every key, it is rejected unless force=true is passed to prevent wiping the namespace by accident
+func (kv *KeyValueStore) DeletePrefixHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload DeletePrefixRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	if payload.Prefix == "" && r.URL.Query().Get("force") != "true" {
+		http.Error(w, "An empty prefix deletes every key, pass force=true to confirm", http.StatusBadRequest)
+		return
+	}
+
+	n, err := kv.deletePrefix(payload.Prefix)
+	kv.audit(r, "deleteprefix", Key(payload.Prefix), 0, n)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeletePrefixResponse{Deleted: n})
+}
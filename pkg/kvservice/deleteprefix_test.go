@@ -0,0 +1,55 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueStore_DeletePrefixHandler(t *testing.T) {
+	kv := newTestStore(map[Key]Value{
+		"tenant-a:1": "1",
+		"tenant-a:2": "2",
+		"tenant-a:3": "3",
+		"tenant-b:1": "1",
+		"tenant-ab":  "ab",
+	})
+	env := Config{}
+	handler := env.routes(kv)
+
+	w := serveNamespace(handler, "", http.MethodPost, "/deleteprefix", `{"prefix":"tenant-a:"}`)
+	var response DeletePrefixResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil || response.Deleted != 3 {
+		t.Fatalf("expected 3 deleted keys but got %v %+v (%v)", w.Code, response, err)
+	}
+
+	want := map[Key]Value{"tenant-b:1": "1", "tenant-ab": "ab"}
+	if got := testValues(kv); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the non-matching keys %v to remain but got %v", want, got)
+	}
+
+	w = serveNamespace(handler, "", http.MethodPost, "/deleteprefix", `{"prefix":"missing:"}`)
+	if strings.TrimSpace(w.Body.String()) != `{"deleted":0}` {
+		t.Errorf("expected nothing to be deleted but got %s", w.Body.String())
+	}
+}
+
+func TestKeyValueStore_DeletePrefixHandler_EmptyPrefix(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"a": "1", "b": "2"})
+	env := Config{}
+	handler := env.routes(kv)
+
+	if w := serveNamespace(handler, "", http.MethodPost, "/deleteprefix", `{"prefix":""}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %v without force but got %v", http.StatusBadRequest, w.Code)
+	}
+	if kv.Len() != 2 {
+		t.Fatalf("expected the keys to be kept but %d are left", kv.Len())
+	}
+
+	w := serveNamespace(handler, "", http.MethodPost, "/deleteprefix?force=true", `{"prefix":""}`)
+	if w.Code != http.StatusOK || kv.Len() != 0 {
+		t.Errorf("expected every key to be deleted with force but got %v and %d keys", w.Code, kv.Len())
+	}
+}
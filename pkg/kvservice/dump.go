@@ -0,0 +1,94 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// curl -o dump.jsonl http://localhost:8080/dump
+// curl -o dump.jsonl 'http://localhost:8080/dump?tombstones=true'
+// curl -o all.jsonl 'http://localhost:8080/dump?all=true'
+// curl -X POST --data-binary @dump.jsonl http://localhost:8080/restore
+
+// RestoreResponse is the body returned by the restore endpoint
+type RestoreResponse struct {
+	Restored int `json:"restored"`
+}
+
+// DumpHandler streams the whole namespace as newline delimited JSON in the snapshot format
+// with tombstones=true the deleted keys that can still be undeleted are included so a restore brings them back as well
+// with all=true every namespace is dumped with its tombstones in the snapshot format, each record labelled with its namespace
+// the X-Change-Seq header is the sequence number of the last change before the dump, the dump holds at least every change up to it
+func (kv *KeyValueStore) DumpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.readNamespace(w, r, "")
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="dump-`+time.Now().UTC().Format("20060102T150405Z")+`.jsonl"`)
+	w.Header().Set("X-Change-Seq", strconv.FormatUint(kv.root.feed.last(), 10))
+
+	// the status is already sent, a failure can only be logged and shows up as a truncated download
+	var err error
+	if r.URL.Query().Get("all") == "true" {
+		err = kv.writeNamespaces(r.Context(), w)
+	} else {
+		err = kv.writeRecords(r.Context(), w, "", r.URL.Query().Get("tombstones") == "true")
+	}
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		slog.Debug("dump stopped", "error", err)
+	case err != nil:
+		slog.Error("failed to write dump", "error", err)
+	}
+}
+
+// RestoreHandler writes the records of a dump into the namespace and returns how many keys were restored
+// existing keys are overwritten and keys missing from the dump are kept, a malformed line stops the restore
+func (kv *KeyValueStore) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.requestNamespace(w, r, "")
+	if !ok {
+		return
+	}
+
+	n, err := kv.readRecords(r.Context(), r.Body, false)
+	if n > 0 {
+		kv.audit(r, "restore", "", 0, n)
+	}
+	// the keys restored before a failing record stay restored, they are synced as well
+	if syncErr := kv.syncLog(); err == nil {
+		err = syncErr
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, ErrStoreFull):
+			status = http.StatusInsufficientStorage
+		case errors.Is(err, ErrWriteAheadLog):
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, "Restore failed after "+strconv.Itoa(n)+" keys: "+err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RestoreResponse{Restored: n})
+}
@@ -0,0 +1,121 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueStore_DumpRestore(t *testing.T) {
+	values := map[Key]Value{"a": "1", "b": "2", "with\nnewline": `{"json":"value"}`}
+	source := newTestStore(values)
+
+	w := httptest.NewRecorder()
+	source.DumpHandler(w, httptest.NewRequest(http.MethodGet, "/dump", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("dump: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+		t.Errorf("dump: expected an attachment Content-Disposition but got %q", cd)
+	}
+	if lines := strings.Count(w.Body.String(), "\n"); lines != len(values) {
+		t.Errorf("dump: expected %d lines but got %d", len(values), lines)
+	}
+
+	restored := newTestStore(map[Key]Value{"a": "old", "c": "kept"})
+	r := httptest.NewRecorder()
+	restored.RestoreHandler(r, httptest.NewRequest(http.MethodPost, "/restore", w.Body))
+	if r.Code != http.StatusOK {
+		t.Fatalf("restore: expected status %v but got %v: %s", http.StatusOK, r.Code, r.Body.String())
+	}
+
+	var got RestoreResponse
+	if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.Restored != len(values) {
+		t.Errorf("expected %d restored keys but got %d", len(values), got.Restored)
+	}
+
+	want := map[Key]Value{"a": "1", "b": "2", "c": "kept", "with\nnewline": `{"json":"value"}`}
+	if values := testValues(restored); !reflect.DeepEqual(values, want) {
+		t.Errorf("expected %v but got %v", want, values)
+	}
+}
+
+// cancelingWriter cancels the request once the first record is written, like a client that goes away during a download
+type cancelingWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (cw cancelingWriter) Write(p []byte) (int, error) {
+	cw.cancel()
+	return cw.ResponseRecorder.Write(p)
+}
+
+func TestKeyValueStore_DumpHandler_Canceled(t *testing.T) {
+	values := map[Key]Value{}
+	for i := range 100 {
+		values[Key(fmt.Sprintf("key-%d", i))] = "value"
+	}
+	kv := newTestStore(values)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := cancelingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	kv.DumpHandler(w, httptest.NewRequest(http.MethodGet, "/dump", nil).WithContext(ctx))
+
+	if lines := strings.Count(w.Body.String(), "\n"); lines == 0 || lines >= len(values) {
+		t.Errorf("expected the dump to stop after the first shard but got %d of %d lines", lines, len(values))
+	}
+}
+
+func TestKeyValueStore_RestoreHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  StoreOptions
+		body     string
+		wantCode int
+		wantKeys int
+	}{
+		{name: "malformed line", body: "{\"key\":\"a\",\"value\":\"1\"}\n{\"key\":", wantCode: http.StatusBadRequest, wantKeys: 1},
+		{name: "store full", options: StoreOptions{MaxBytes: 4}, body: "{\"key\":\"a\",\"value\":\"1\"}\n{\"key\":\"b\",\"value\":\"123\"}\n", wantCode: http.StatusInsufficientStorage, wantKeys: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := NewKeyValueStore(tt.options)
+
+			w := httptest.NewRecorder()
+			kv.RestoreHandler(w, httptest.NewRequest(http.MethodPost, "/restore", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v", tt.wantCode, w.Code)
+			}
+			if kv.Len() != tt.wantKeys {
+				t.Errorf("expected %d keys but got %d", tt.wantKeys, kv.Len())
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_DumpRestore_Methods(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+
+	w := httptest.NewRecorder()
+	kv.DumpHandler(w, httptest.NewRequest(http.MethodPost, "/dump", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("dump: expected status %v but got %v", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	kv.RestoreHandler(w, httptest.NewRequest(http.MethodGet, "/restore", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("restore: expected status %v but got %v", http.StatusMethodNotAllowed, w.Code)
+	}
+}
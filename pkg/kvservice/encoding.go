@@ -0,0 +1,136 @@
+package kvservice
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"key":"blob","value":"3q2+7w==","encoding":"base64"}' http://localhost:8080/set
+// curl -d '{"key":"blob","encoding":"base64"}' http://localhost:8080/get
+
+// EncodingBase64 marks a value in a JSON body as standard base64 encoded bytes
+const EncodingBase64 = "base64"
+
+// decodeValue returns the raw bytes of a value sent with the given encoding, an empty encoding is a plain string
+// an unknown encoding or a malformed value is answered with 400 and returns false
+func decodeValue(w http.ResponseWriter, value Value, encoding string) (Value, bool) {
+	decoded, err := decodeEncoding(value, encoding)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return decoded, true
+}
+
+// decodeEncoding returns the raw bytes of a value sent with the given encoding, or an error naming what is wrong with it
+func decodeEncoding(value Value, encoding string) (Value, error) {
+	switch encoding {
+	case "":
+		return value, nil
+	case EncodingBase64:
+		b, err := base64.StdEncoding.DecodeString(string(value))
+		if err != nil {
+			return "", errors.New("Value is not valid base64: " + err.Error())
+		}
+		return Value(b), nil
+	default:
+		return "", errors.New("Unknown encoding " + encoding + ", supported is " + EncodingBase64)
+	}
+}
+
+// newGetResponse returns the response for the value, base64 encoded if the client asked for it
+// a value that is not valid UTF-8 is always base64 encoded as JSON strings can not carry it without loss
+func newGetResponse(value Value, encoding string) GetResponse {
+	if encoding == EncodingBase64 || !utf8.ValidString(string(value)) {
+		return GetResponse{Value: Value(base64.StdEncoding.EncodeToString([]byte(value))), Encoding: EncodingBase64}
+	}
+	return GetResponse{Value: value}
+}
+
+// writeGetResponse writes the response as writeJSON would, including the trailing newline
+// a plain value is written straight from the stored string instead of being encoded into a buffer first,
+// which saves a copy of every large value read
+func writeGetResponse(w io.Writer, c JSONCase, resp GetResponse) error {
+	if resp.Encoding != "" || resp.Version != 0 || !resp.ModifiedAt.IsZero() {
+		return writeJSON(w, c, resp)
+	}
+	if _, err := io.WriteString(w, `{"value":"`); err != nil {
+		return err
+	}
+	if err := writeJSONEscaped(w, string(resp.Value)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\"}\n")
+	return err
+}
+
+// jsonEscapes holds the escape sequence encoding/json writes for an ASCII byte with HTML escaping enabled, empty if the byte is written as is
+var jsonEscapes = func() (escapes [utf8.RuneSelf]string) {
+	for b := range utf8.RuneSelf {
+		switch b {
+		case '\\', '"':
+			escapes[b] = `\` + string(rune(b))
+		case '\b':
+			escapes[b] = `\b`
+		case '\f':
+			escapes[b] = `\f`
+		case '\n':
+			escapes[b] = `\n`
+		case '\r':
+			escapes[b] = `\r`
+		case '\t':
+			escapes[b] = `\t`
+		case '<', '>', '&':
+			escapes[b] = fmt.Sprintf(`\u%04x`, b)
+		default:
+			if b < 0x20 {
+				escapes[b] = fmt.Sprintf(`\u%04x`, b)
+			}
+		}
+	}
+	return escapes
+}()
+
+// writeJSONEscaped writes s escaped for a JSON string exactly like encoding/json escapes it with HTML escaping enabled, without the quotes
+// the runs between escape sequences are written straight from s and the escape sequences are constants, so nothing is allocated
+func writeJSONEscaped(w io.Writer, s string) error {
+	start := 0
+	for i := 0; i < len(s); {
+		var escape string
+		size := 1
+		if b := s[i]; b < utf8.RuneSelf {
+			if escape = jsonEscapes[b]; escape == "" {
+				i++
+				continue
+			}
+		} else {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			switch {
+			case r == utf8.RuneError && n == 1:
+				escape = string(utf8.RuneError)
+			case r == '\u2028':
+				escape = `\u2028`
+			case r == '\u2029':
+				escape = `\u2029`
+			default:
+				i += n
+				continue
+			}
+			size = n
+		}
+		if _, err := io.WriteString(w, s[start:i]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, escape); err != nil {
+			return err
+		}
+		i += size
+		start = i
+	}
+	_, err := io.WriteString(w, s[start:])
+	return err
+}
@@ -0,0 +1,108 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyValueStore_Base64(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+	blob := []byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0xff}
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	if w := serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"blob","value":"`+encoded+`","encoding":"base64"}`); w.Code != http.StatusOK {
+		t.Fatalf("set: expected status %v but got %v %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if got := testValues(kv)["blob"]; got != Value(blob) {
+		t.Fatalf("expected the raw bytes %x to be stored but got %x", blob, got)
+	}
+
+	// the bytes are not valid UTF-8, so they come back base64 encoded whether or not the client asked for it
+	for _, body := range []string{`{"key":"blob","encoding":"base64"}`, `{"key":"blob"}`} {
+		w := serveNamespace(handler, "", http.MethodPost, "/get", body)
+		var response GetResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Encoding != EncodingBase64 || string(response.Value) != encoded {
+			t.Errorf("%s: expected %q base64 encoded but got %+v", body, encoded, response)
+		}
+	}
+
+	// the raw bytes are served unchanged by the path based API
+	if w := serveNamespace(handler, "", http.MethodGet, "/kv/blob", ""); w.Body.String() != string(blob) {
+		t.Errorf("expected the raw bytes but got %x", w.Body.String())
+	}
+
+	// a plain value is only encoded on request
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"text","value":"hello"}`)
+	for body, want := range map[string]GetResponse{
+		`{"key":"text"}`:                     {Value: "hello"},
+		`{"key":"text","encoding":"base64"}`: {Value: "aGVsbG8=", Encoding: EncodingBase64},
+	} {
+		var response GetResponse
+		json.NewDecoder(serveNamespace(handler, "", http.MethodPost, "/get", body).Body).Decode(&response)
+		if response != want {
+			t.Errorf("%s: expected %+v but got %+v", body, want, response)
+		}
+	}
+}
+
+func TestKeyValueStore_Base64_Invalid(t *testing.T) {
+	env := Config{}
+	handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+	for _, body := range []string{
+		`{"key":"blob","value":"not base64!","encoding":"base64"}`,
+		`{"key":"blob","value":"value","encoding":"hex"}`,
+	} {
+		for _, path := range []string{"/set", "/setnx"} {
+			if w := serveNamespace(handler, "", http.MethodPost, path, body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: expected status %v but got %v", path, body, http.StatusBadRequest, w.Code)
+			}
+		}
+	}
+}
+
+func TestWriteGetResponse(t *testing.T) {
+	var all strings.Builder
+	for b := range 256 {
+		all.WriteByte(byte(b))
+	}
+	values := []Value{
+		"",
+		"value",
+		`{"json":"value","html":"<a href='x'>&</a>"}`,
+		"tab\tnewline\nreturn\rbackspace\bformfeed\fnul\x00del\x7f",
+		"unicode \u00e4 \u20ac \U0001f600 and separators \u2028 \u2029",
+		"invalid \xff utf-8 \xc3",
+		Value(all.String()),
+		Value(strings.Repeat("x", 1<<16) + "\"\n" + strings.Repeat("\x00", 1<<10) + "<"),
+	}
+
+	for _, value := range values {
+		for _, resp := range []GetResponse{
+			{Value: value},
+			{Value: value, Encoding: EncodingBase64},
+			{Value: value, Version: 3, ModifiedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		} {
+			var want, got bytes.Buffer
+			if err := json.NewEncoder(&want).Encode(resp); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeGetResponse(&got, JSONCaseSnake, resp); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("expected %q but got %q", want.String(), got.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package kvservice
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// head -c 32 /dev/urandom > snapshot.key
+// go run . --snapshot-file data.jsonl --encryption-key-file snapshot.key
+
+// EncryptionKeySize is the size of the key read from the encryption key file, it selects AES-256
+const EncryptionKeySize = 32
+
+// encryptedSnapshotHeader is the plaintext of the first record of an encrypted snapshot, opening it checks the key before any data is read
+const encryptedSnapshotHeader = "kvservice encrypted snapshot v1"
+
+// errSnapshotAuthentication is returned when a record of an encrypted snapshot does not open, the key is wrong or the file was modified
+var errSnapshotAuthentication = errors.New("wrong encryption key or tampered snapshot")
+
+// ReadEncryptionKey reads the key of the encryption key file, which must hold exactly EncryptionKeySize bytes
+// the key is never part of an error, so errors can be logged
+func ReadEncryptionKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read encryption key: %w", err)
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key file %s must hold %d bytes, got %d", path, EncryptionKeySize, len(key))
+	}
+	return key, nil
+}
+
+// newSnapshotCipher returns AES-GCM with the key
+func newSnapshotCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// recordIndex returns the additional data of the record at index i, it binds every record to its position
+// so records can not be reordered or removed without failing authentication
+func recordIndex(i uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, i)
+}
+
+// sealWriter encrypts every line written to it with a random nonce and writes it as a line of base64 nonce and ciphertext
+// the records are preceded by a header record and followed by an empty trailer record, so a truncated snapshot is detected
+type sealWriter struct {
+	aead cipher.AEAD
+	w    io.Writer
+	// line holds the start of a line until its newline is written
+	line []byte
+	n    uint64
+}
+
+// newSealWriter writes the header record to w and returns a writer for the records
+func newSealWriter(aead cipher.AEAD, w io.Writer) (*sealWriter, error) {
+	sw := &sealWriter{aead: aead, w: w}
+	if err := sw.seal([]byte(encryptedSnapshotHeader)); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (sw *sealWriter) Write(p []byte) (int, error) {
+	sw.line = append(sw.line, p...)
+	for {
+		i := bytes.IndexByte(sw.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := sw.seal(sw.line[:i]); err != nil {
+			return 0, err
+		}
+		sw.line = sw.line[i+1:]
+	}
+}
+
+// seal writes the plaintext as the next record
+func (sw *sealWriter) seal(plaintext []byte) error {
+	line := sealRecord(sw.aead, plaintext, sw.n)
+	sw.n++
+	_, err := sw.w.Write(line)
+	return err
+}
+
+// sealRecord encrypts the plaintext as the record at index i with a random nonce
+// and returns the line of base64 nonce and ciphertext, terminated by a newline
+func sealRecord(aead cipher.AEAD, plaintext []byte, i uint64) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	rand.Read(nonce)
+	record := aead.Seal(nonce, nonce, plaintext, recordIndex(i))
+	line := base64.StdEncoding.AppendEncode(nil, record)
+	return append(line, '\n')
+}
+
+// openRecord decrypts the line of the record at index i written by sealRecord, with or without its newline
+func openRecord(aead cipher.AEAD, line []byte, i uint64) ([]byte, error) {
+	record, err := base64.StdEncoding.AppendDecode(nil, bytes.TrimSuffix(line, []byte("\n")))
+	if err != nil || len(record) < aead.NonceSize() {
+		return nil, fmt.Errorf("record %d: malformed", i)
+	}
+	nonce, ciphertext := record[:aead.NonceSize()], record[aead.NonceSize():]
+	plaintext, err := aead.Open(ciphertext[:0], nonce, ciphertext, recordIndex(i))
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", i, errSnapshotAuthentication)
+	}
+	return plaintext, nil
+}
+
+// Close writes the trailer record, it does not close the underlying writer
+func (sw *sealWriter) Close() error {
+	if len(sw.line) > 0 {
+		return errors.New("the last record is not terminated by a newline")
+	}
+	return sw.seal(nil)
+}
+
+// openReader decrypts the records written by a sealWriter and returns them as newline delimited lines
+type openReader struct {
+	aead cipher.AEAD
+	r    *bufio.Reader
+	n    uint64
+	// buf holds the rest of the current record
+	buf  []byte
+	done bool
+}
+
+// newOpenReader reads the header record from r, it fails if the key does not match the snapshot
+func newOpenReader(aead cipher.AEAD, r *bufio.Reader) (*openReader, error) {
+	or := &openReader{aead: aead, r: r}
+	if b, err := r.Peek(1); err == nil && b[0] == '{' {
+		return nil, errors.New("the snapshot is not encrypted")
+	}
+	header, err := or.open()
+	if err != nil {
+		return nil, err
+	}
+	if string(header) != encryptedSnapshotHeader {
+		return nil, errSnapshotAuthentication
+	}
+	return or, nil
+}
+
+// open reads and decrypts the next record
+func (or *openReader) open() ([]byte, error) {
+	line, err := or.r.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, errors.New("the snapshot is truncated")
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	plaintext, err := openRecord(or.aead, line, or.n)
+	if err != nil {
+		return nil, err
+	}
+	or.n++
+	return plaintext, nil
+}
+
+func (or *openReader) Read(p []byte) (int, error) {
+	for len(or.buf) == 0 {
+		if or.done {
+			return 0, io.EOF
+		}
+		plaintext, err := or.open()
+		if err != nil {
+			return 0, err
+		}
+		// the empty trailer ends the snapshot, a record is never empty
+		if len(plaintext) == 0 {
+			or.done = true
+			continue
+		}
+		or.buf = append(plaintext, '\n')
+	}
+	n := copy(p, or.buf)
+	or.buf = or.buf[n:]
+	return n, nil
+}
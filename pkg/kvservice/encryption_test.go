@@ -0,0 +1,230 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey returns a key of EncryptionKeySize bytes filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, EncryptionKeySize)
+}
+
+// encryptedSnapshot writes an encrypted snapshot of a store holding a few keys and returns its path
+func encryptedSnapshot(t *testing.T) string {
+	t.Helper()
+	kv := newTestStore(map[Key]Value{"a": "1", "b": "secret value"})
+	writeTestValue(kv.Namespace("team-a"), "c", "3")
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	snapshotter := NewSnapshotter(path, kv)
+	if err := snapshotter.SetEncryptionKey(testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshotter.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadEncrypted loads the snapshot at path with the key into a new store
+func loadEncrypted(path string, key []byte) (*KeyValueStore, error) {
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(path, kv)
+	if err := snapshotter.SetEncryptionKey(key); err != nil {
+		return nil, err
+	}
+	_, err := snapshotter.Load(context.Background())
+	return kv, err
+}
+
+func TestSnapshotter_Encryption(t *testing.T) {
+	path := encryptedSnapshot(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret value")) || bytes.Contains(data, []byte(`"key"`)) {
+		t.Fatalf("expected the snapshot to be encrypted but got %s", data)
+	}
+
+	kv, err := loadEncrypted(path, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testValues(kv); len(got) != 2 || got["a"] != "1" || got["b"] != "secret value" {
+		t.Errorf("expected the values of the snapshot but got %v", got)
+	}
+	if got := testValues(kv.Namespace("team-a")); got["c"] != "3" {
+		t.Errorf("expected the namespace of the snapshot but got %v", got)
+	}
+}
+
+func TestSnapshotter_Encryption_Errors(t *testing.T) {
+	// tamper decodes the record at the line, lets modify change it and encodes it again
+	tamper := func(lines []string, line int, modify func([]byte) []byte) []string {
+		record, err := base64.StdEncoding.DecodeString(lines[line])
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines[line] = base64.StdEncoding.EncodeToString(modify(record))
+		return lines
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		modify  func(lines []string) []string
+		wantErr string
+	}{
+		{name: "wrong key", key: testKey(2), wantErr: errSnapshotAuthentication.Error()},
+		{
+			name: "tampered ciphertext",
+			key:  testKey(1),
+			modify: func(lines []string) []string {
+				return tamper(lines, 2, func(record []byte) []byte {
+					record[len(record)-20] ^= 1
+					return record
+				})
+			},
+			wantErr: errSnapshotAuthentication.Error(),
+		},
+		{
+			name: "reordered records",
+			key:  testKey(1),
+			modify: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			wantErr: errSnapshotAuthentication.Error(),
+		},
+		{
+			name: "truncated",
+			key:  testKey(1),
+			modify: func(lines []string) []string {
+				return lines[:len(lines)-1]
+			},
+			wantErr: "truncated",
+		},
+		{
+			name: "not encrypted",
+			key:  testKey(1),
+			modify: func([]string) []string {
+				return []string{`{"key":"a","value":"1"}`}
+			},
+			wantErr: "not encrypted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := encryptedSnapshot(t)
+			if tt.modify != nil {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := tt.modify(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+				if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			kv, err := loadEncrypted(path, tt.key)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q but got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == errSnapshotAuthentication.Error() && !errors.Is(err, errSnapshotAuthentication) {
+				t.Errorf("expected %v but got %v", errSnapshotAuthentication, err)
+			}
+			// a record that does not open is never restored
+			if got := testValues(kv); got["b"] != "" && got["b"] != "secret value" {
+				t.Errorf("expected no tampered value in the store but got %q", got["b"])
+			}
+		})
+	}
+}
+
+func TestSnapshotter_Encryption_WriteAheadLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	// open opens the snapshotter at path with the key and its write-ahead log
+	open := func(kv *KeyValueStore, key []byte) *Snapshotter {
+		t.Helper()
+		snapshotter := NewSnapshotter(path, kv)
+		if key != nil {
+			if err := snapshotter.SetEncryptionKey(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := snapshotter.SetFsync(FsyncAlways); err != nil {
+			t.Fatal(err)
+		}
+		return snapshotter
+	}
+
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := open(kv, testKey(1))
+	writeTestValue(kv, "a", "secret value")
+	writeTestValue(kv.Namespace("team-a"), "b", "2")
+	if err := snapshotter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret value")) || bytes.Contains(data, []byte(`"key"`)) {
+		t.Fatalf("expected the write-ahead log to be encrypted but got %s", data)
+	}
+	// a crash cut off the next record, the log continues after the complete ones
+	if err := os.WriteFile(path+".wal", append(data, "AAAA"...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kv = NewKeyValueStore(StoreOptions{})
+	snapshotter = open(kv, testKey(1))
+	if _, err := snapshotter.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	writeTestValue(kv, "c", "3")
+	// a snapshot moves the log aside, the new log starts with its own header
+	if err := snapshotter.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestValue(kv, "d", "4")
+	if err := snapshotter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kv = NewKeyValueStore(StoreOptions{})
+	snapshotter = open(kv, testKey(1))
+	defer snapshotter.Close()
+	if _, err := snapshotter.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testValues(kv); len(got) != 3 || got["a"] != "secret value" || got["c"] != "3" || got["d"] != "4" {
+		t.Errorf("expected the values of the snapshot and the log but got %v", got)
+	}
+	if got := testValues(kv.Namespace("team-a")); got["b"] != "2" {
+		t.Errorf("expected the namespace of the snapshot but got %v", got)
+	}
+
+	// the snapshot is checked first, so only the log is tried with another key
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string][]byte{"another key": testKey(2), "no key": nil} {
+		kv := NewKeyValueStore(StoreOptions{})
+		snapshotter := open(kv, key)
+		_, err := snapshotter.Load(context.Background())
+		snapshotter.Close()
+		if err == nil {
+			t.Errorf("%s: expected the encrypted log to fail to load", name)
+		}
+	}
+}
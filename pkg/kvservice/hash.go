@@ -0,0 +1,232 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"key":"user:1","field":"email","value":"a@b"}' http://localhost:8080/hset
+// curl -d '{"key":"user:1","field":"email"}' http://localhost:8080/hget
+// curl -d '{"key":"user:1"}' http://localhost:8080/hgetall
+// curl -H 'Content-Type: application/json' -d '{"key":"user:1","field":"email"}' http://localhost:8080/hdel
+
+type HashSetRequest struct {
+	Key       Key    `json:"key"`
+	Field     string `json:"field"`
+	Value     Value  `json:"value"`
+	Namespace string `json:"namespace,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+}
+
+type HashSetResponse struct {
+	// Created reports whether the field was added rather than overwritten
+	Created bool `json:"created"`
+}
+
+// HashFieldRequest addresses a single field of a hash for /hget and /hdel
+type HashFieldRequest struct {
+	Key       Key    `json:"key"`
+	Field     string `json:"field"`
+	Namespace string `json:"namespace,omitempty"`
+	// Encoding is only used by /hget and works like the encoding of /get
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HashGetAllRequest struct {
+	Key       Key    `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// hset sets the field of the hash of the key, creating the hash if the key does not exist
+// it reports whether the field is new and returns errWrongType if the key holds another type of value
+func (kv *KeyValueStore) hset(key Key, field string, value Value) (bool, error) {
+	var created bool
+	err := kv.update(key, func(current entry, exists bool) (func(s *shard) error, error) {
+		if exists && current.kind != kindHash {
+			return nil, errWrongType
+		}
+
+		_, had := current.fields[field]
+		fields := maps.Clone(current.fields)
+		if fields == nil {
+			fields = make(map[string]Value, 1)
+		}
+		fields[field] = value
+		return func(s *shard) error {
+			_, err := s.writeEntry(key, entry{kind: kindHash, fields: fields})
+			if !writeApplied(err) {
+				return err
+			}
+			s.kv.stats.sets.Add(1)
+			created = !had
+			return err
+		}, nil
+	})
+	return created, err
+}
+
+// hdel removes the field of the hash of the key, a hash without fields is removed
+// it reports whether the field existed and returns errWrongType if the key holds another type of value
+func (kv *KeyValueStore) hdel(key Key, field string) (bool, error) {
+	var deleted bool
+	err := kv.update(key, func(current entry, exists bool) (func(s *shard) error, error) {
+		if exists && current.kind != kindHash {
+			return nil, errWrongType
+		}
+		if _, ok := current.fields[field]; !ok {
+			return func(s *shard) error { return nil }, nil
+		}
+
+		var fields map[string]Value
+		if len(current.fields) > 1 {
+			fields = maps.Clone(current.fields)
+			delete(fields, field)
+		}
+		return func(s *shard) error {
+			var err error
+			if fields == nil {
+				_, _, err = s.unlink(key)
+			} else {
+				// the hash shrinks so the write can not exceed any limit, only logging it can fail
+				_, err = s.writeEntry(key, entry{kind: kindHash, fields: fields})
+			}
+			s.kv.stats.deletes.Add(1)
+			deleted = true
+			return err
+		}, nil
+	})
+	return deleted, err
+}
+
+// hash returns the entry of the key for a hash read, answering 404 if it does not exist and 409 if it is not a hash
+func (s *shard) hash(w http.ResponseWriter, key Key) (entry, bool) {
+	s.lockGet()
+	e, ok := s.get(key)
+	s.unlockGet()
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return entry{}, false
+	}
+	if e.kind != kindHash {
+		writeWrongType(w, key)
+		return entry{}, false
+	}
+	return e, true
+}
+
+// HashSetHandler sets a single field of a hash without rewriting the other fields
+// it answers 409 with the code WRONG_TYPE if the key holds another type of value
+func (kv *KeyValueStore) HashSetHandler(w http.ResponseWriter, r *http.Request) {
+	var payload HashSetRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	if payload.Field == "" {
+		http.Error(w, "Field is required", http.StatusBadRequest)
+		return
+	}
+	if payload.Value, ok = decodeValue(w, payload.Value, payload.Encoding); !ok {
+		return
+	}
+	if kv.rejectInvalidKey(w, payload.Key) || kv.rejectInvalidValue(w, payload.Value) {
+		return
+	}
+
+	created, err := kv.hset(payload.Key, payload.Field, payload.Value)
+	if errors.Is(err, errWrongType) {
+		writeWrongType(w, payload.Key)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	kv.audit(r, "hset", payload.Key, len(payload.Value), 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HashSetResponse{Created: created})
+}
+
+// HashGetHandler returns a single field of a hash like GetHandler returns a value
+func (kv *KeyValueStore) HashGetHandler(w http.ResponseWriter, r *http.Request) {
+	var payload HashFieldRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.readNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	e, ok := kv.shard(payload.Key).hash(w, payload.Key)
+	if !ok {
+		return
+	}
+	value, ok := e.fields[payload.Field]
+	if !ok {
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newGetResponse(value, payload.Encoding))
+}
+
+// HashGetAllHandler returns all fields of a hash as a JSON object
+// binary field values are not encoded, use HashGetHandler for those
+func (kv *KeyValueStore) HashGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	var payload HashGetAllRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.readNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	e, ok := kv.shard(payload.Key).hash(w, payload.Key)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e.fields)
+}
+
+// HashDeleteHandler removes a single field of a hash, removing the last field removes the key
+// it answers 404 if the field does not exist
+func (kv *KeyValueStore) HashDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	var payload HashFieldRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	deleted, err := kv.hdel(payload.Key, payload.Field)
+	if errors.Is(err, errWrongType) {
+		writeWrongType(w, payload.Key)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	}
+	kv.audit(r, "hdel", payload.Key, 0, 0)
+
+	w.WriteHeader(http.StatusNoContent)
+}
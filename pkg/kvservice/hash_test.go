@@ -0,0 +1,147 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeyValueStore_Hash(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+
+	for _, tt := range []struct {
+		body string
+		want string
+	}{
+		{`{"key":"user:1","field":"email","value":"a@b"}`, `{"created":true}`},
+		{`{"key":"user:1","field":"name","value":"Ada"}`, `{"created":true}`},
+		{`{"key":"user:1","field":"email","value":"c@d"}`, `{"created":false}`},
+	} {
+		w := serveNamespace(handler, "", http.MethodPost, "/hset", tt.body)
+		if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || got != tt.want {
+			t.Errorf("hset %s: expected %s but got %v %s", tt.body, tt.want, w.Code, got)
+		}
+	}
+	if got, want := kv.Bytes(), int64(len("user:1")+len("email")+len("c@d")+len("name")+len("Ada")); got != want {
+		t.Errorf("expected the fields to be accounted with %d bytes but got %d", want, got)
+	}
+
+	w := serveNamespace(handler, "", http.MethodPost, "/hget", `{"key":"user:1","field":"email"}`)
+	var response GetResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	if w.Code != http.StatusOK || response.Value != "c@d" {
+		t.Errorf("hget: expected %q but got %v %q", "c@d", w.Code, response.Value)
+	}
+	for _, body := range []string{`{"key":"user:1","field":"phone"}`, `{"key":"user:2","field":"email"}`} {
+		if w := serveNamespace(handler, "", http.MethodPost, "/hget", body); w.Code != http.StatusNotFound {
+			t.Errorf("hget %s: expected status %v but got %v", body, http.StatusNotFound, w.Code)
+		}
+	}
+
+	w = serveNamespace(handler, "", http.MethodPost, "/hgetall", `{"key":"user:1"}`)
+	var fields map[string]Value
+	json.NewDecoder(w.Body).Decode(&fields)
+	if want := map[string]Value{"email": "c@d", "name": "Ada"}; w.Code != http.StatusOK || !reflect.DeepEqual(fields, want) {
+		t.Errorf("hgetall: expected %v but got %v %v", want, w.Code, fields)
+	}
+
+	if w := serveNamespace(handler, "", http.MethodPost, "/hdel", `{"key":"user:1","field":"email"}`); w.Code != http.StatusNoContent {
+		t.Errorf("hdel: expected status %v but got %v", http.StatusNoContent, w.Code)
+	}
+	if w := serveNamespace(handler, "", http.MethodPost, "/hdel", `{"key":"user:1","field":"email"}`); w.Code != http.StatusNotFound {
+		t.Errorf("hdel of a missing field: expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+
+	// deleting the last field removes the key
+	serveNamespace(handler, "", http.MethodPost, "/hdel", `{"key":"user:1","field":"name"}`)
+	if kv.Len() != 0 || kv.Bytes() != 0 {
+		t.Errorf("expected an empty store but got %d keys with %d bytes", kv.Len(), kv.Bytes())
+	}
+}
+
+func TestKeyValueStore_Hash_WrongType(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"string": "value"})
+	env := Config{}
+	handler := env.routes(kv)
+	serveNamespace(handler, "", http.MethodPost, "/hset", `{"key":"hash","field":"f","value":"v"}`)
+
+	for _, req := range []struct{ path, body string }{
+		{"/hset", `{"key":"string","field":"f","value":"v"}`},
+		{"/hget", `{"key":"string","field":"f"}`},
+		{"/hgetall", `{"key":"string"}`},
+		{"/hdel", `{"key":"string","field":"f"}`},
+		{"/get", `{"key":"hash"}`},
+		{"/list/push", `{"key":"hash","value":"v"}`},
+	} {
+		w := serveNamespace(handler, "", http.MethodPost, req.path, req.body)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "WRONG_TYPE") {
+			t.Errorf("%s %s: expected status %v but got %v %s", req.path, req.body, http.StatusConflict, w.Code, w.Body.String())
+		}
+	}
+	if got := testValues(kv)["string"]; got != "value" {
+		t.Errorf("expected the string to be untouched but got %q", got)
+	}
+}
+
+func TestKeyValueStore_Hash_Concurrent(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+
+	const writers = 16
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			field := "f" + strconv.Itoa(i)
+			serveNamespace(handler, "", http.MethodPost, "/hset", `{"key":"user:1","field":"`+field+`","value":"`+field+`"}`)
+		}()
+	}
+	wg.Wait()
+
+	// every field must survive, no writer may overwrite the hash with a copy lacking the fields of another
+	e, _ := kv.peek("user:1")
+	if len(e.fields) != writers {
+		t.Fatalf("expected %d fields but got %v", writers, e.fields)
+	}
+	for field, value := range e.fields {
+		if Value(field) != value {
+			t.Errorf("field %s: expected %q but got %q", field, field, value)
+		}
+	}
+}
+
+func TestKeyValueStore_Hash_Snapshot(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"string": "value"})
+	env := Config{}
+	serveNamespace(env.routes(kv), "", http.MethodPost, "/hset", `{"key":"user:1","field":"email","value":"a@b"}`)
+
+	var buf bytes.Buffer
+	if err := kv.writeRecords(context.Background(), &buf, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"hash":{"email":"a@b"}`) {
+		t.Errorf("expected the hash to be written as an object but got %s", buf.String())
+	}
+	restored := NewKeyValueStore(StoreOptions{})
+	if _, err := restored.readRecords(context.Background(), &buf, false); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := restored.peek("user:1")
+	if want := map[string]Value{"email": "a@b"}; e.kind != kindHash || !reflect.DeepEqual(e.fields, want) {
+		t.Errorf("expected the hash to be restored but got %+v", e)
+	}
+	if got := testValues(restored)["string"]; got != "value" {
+		t.Errorf("expected the string to be restored but got %q", got)
+	}
+}
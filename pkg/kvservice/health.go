@@ -0,0 +1,113 @@
+package kvservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// curl -i http://localhost:8080/health
+// {"status":"failing","components":{"store":{"status":"ok"},"wal":{"status":"failing","error":"sync: input/output error"}},"uptime_seconds":42.1}
+
+const (
+	// HealthOK is the status of a component whose check passed, and of the service if all passed
+	HealthOK = "ok"
+	// HealthFailing is the status of a component whose check failed, and of the service if any failed
+	HealthFailing = "failing"
+)
+
+// healthCheckTimeout bounds each check, a check that does not return in time fails
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck reports the health of a component, it returns nil if the component is healthy and why it is not otherwise
+// it must return once ctx is done
+type HealthCheck func(ctx context.Context) error
+
+// ComponentHealth is the result of the check of a component
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthResponse is the body returned by the health endpoint, Status is the worst status of the components
+type HealthResponse struct {
+	Status        string                     `json:"status"`
+	Components    map[string]ComponentHealth `json:"components"`
+	UptimeSeconds float64                    `json:"uptime_seconds"`
+}
+
+// healthChecks are the checks registered by the components of the service in the order they were registered
+type healthChecks struct {
+	mu     sync.Mutex
+	names  []string
+	checks map[string]HealthCheck
+}
+
+// RegisterHealthCheck adds the check of the named component to /health, registering a name again replaces its check
+// the store registers itself as store, the server adds wal and replication when they are enabled
+func (kv *KeyValueStore) RegisterHealthCheck(name string, check HealthCheck) {
+	h := &kv.root.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.checks == nil {
+		h.checks = make(map[string]HealthCheck)
+	}
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// checkStore fails while the store is loading its data or the server is shutting down, the times the data endpoints are unavailable
+func (kv *KeyValueStore) checkStore(context.Context) error {
+	switch {
+	case kv.Loading():
+		return errors.New("loading")
+	case kv.ShuttingDown():
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// HealthHandler runs the check of every component and reports their status, 200 if all are ok and 503 if any is failing
+// unlike the probes it says which component is the problem, it is meant for dashboards and operators
+func (kv *KeyValueStore) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h := &kv.root.health
+	h.mu.Lock()
+	names := append([]string{"store"}, h.names...)
+	checks := map[string]HealthCheck{"store": kv.checkStore}
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.Unlock()
+
+	resp := HealthResponse{Status: HealthOK, Components: make(map[string]ComponentHealth, len(checks)), UptimeSeconds: time.Since(startTime).Seconds()}
+	for _, name := range names {
+		if _, done := resp.Components[name]; done {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		err := checks[name](ctx)
+		cancel()
+		if err != nil {
+			resp.Components[name] = ComponentHealth{Status: HealthFailing, Error: err.Error()}
+			resp.Status = HealthFailing
+			continue
+		}
+		resp.Components[name] = ComponentHealth{Status: HealthOK}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, kv.root.jsonCase, resp)
+}
@@ -0,0 +1,102 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyValueStore_HealthHandler(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	health := func() (int, HealthResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		kv.HealthHandler(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var resp HealthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	code, resp := health()
+	if code != http.StatusOK || resp.Status != HealthOK || resp.Components["store"].Status != HealthOK || resp.UptimeSeconds <= 0 {
+		t.Errorf("expected a healthy store but got %v %+v", code, resp)
+	}
+
+	// one failing component fails the service, the others are still reported
+	kv.RegisterHealthCheck("cache", func(context.Context) error { return nil })
+	kv.RegisterHealthCheck("disk", func(context.Context) error { return errors.New("disk full") })
+	code, resp = health()
+	if code != http.StatusServiceUnavailable || resp.Status != HealthFailing {
+		t.Errorf("expected the failing component to fail the service but got %v %v", code, resp.Status)
+	}
+	if got := resp.Components["disk"]; got.Status != HealthFailing || got.Error != "disk full" {
+		t.Errorf("expected the disk to fail with its error but got %+v", got)
+	}
+	if got := resp.Components["cache"]; got.Status != HealthOK {
+		t.Errorf("expected the cache to be ok but got %+v", got)
+	}
+
+	// registering a component again replaces its check
+	kv.RegisterHealthCheck("disk", func(context.Context) error { return nil })
+	if code, resp = health(); code != http.StatusOK || len(resp.Components) != 3 {
+		t.Errorf("expected all 3 components to be ok but got %v %+v", code, resp)
+	}
+
+	// a check that hangs fails once its timeout is over
+	kv.RegisterHealthCheck("remote", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	start := time.Now()
+	if _, resp = health(); resp.Components["remote"].Status != HealthFailing || time.Since(start) > 2*healthCheckTimeout {
+		t.Errorf("expected the hanging check to fail after its timeout but got %+v after %v", resp.Components["remote"], time.Since(start))
+	}
+
+	kv.RegisterHealthCheck("remote", func(context.Context) error { return nil })
+	kv.SetLoading(true)
+	if code, resp = health(); code != http.StatusServiceUnavailable || resp.Components["store"].Error != "loading" {
+		t.Errorf("expected the loading store to fail but got %v %+v", code, resp.Components["store"])
+	}
+}
+
+func TestServer_Health_WAL(t *testing.T) {
+	server, url := startServer(t, Config{
+		ServerAddress:   "127.0.0.1:0",
+		ShutdownTimeout: time.Second,
+		SnapshotFile:    filepath.Join(t.TempDir(), "snapshot.jsonl"),
+		Fsync:           FsyncAlways,
+	})
+	// the load of the missing snapshot completes right away
+	for deadline := time.Now().Add(5 * time.Second); server.store.Loading() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Get(url + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health HealthResponse
+	json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || health.Components["wal"].Status != HealthOK {
+		t.Errorf("expected a healthy write-ahead log but got %v %+v", resp.StatusCode, health)
+	}
+
+	server.snapshotter.wal.setFailure(errors.New("sync: input/output error"))
+	resp, err = http.Get(url + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || health.Components["wal"].Error != "sync: input/output error" {
+		t.Errorf("expected the failed sync to fail the health check but got %v %+v", resp.StatusCode, health)
+	}
+}
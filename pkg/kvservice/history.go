@@ -0,0 +1,76 @@
+package kvservice
+
+import (
+	"net/http"
+	"time"
+)
+
+// --history-depth 5
+// curl -d '{"key":"key1"}' http://localhost:8080/history
+
+// historyVersion is an overwritten value together with its version and the time it was overwritten
+type historyVersion struct {
+	value Value
+	// rawLen is the length of the value before compression, 0 if the value is not compressed
+	rawLen   int
+	version  uint64
+	replaced time.Time
+}
+
+type HistoryRequest struct {
+	Key       Key    `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// HistoryVersion is a version of a key as returned by the history endpoint
+type HistoryVersion struct {
+	Version uint64 `json:"version"`
+	Value   Value  `json:"value"`
+	// ReplacedAt is the time the value was overwritten, it is omitted for the current value
+	ReplacedAt time.Time `json:"replaced_at,omitzero"`
+}
+
+// pushHistory returns the history of an entry that is about to be overwritten, the current value is added as the newest version
+// the oldest versions are dropped beyond the history depth, the history of current is not modified as readers may still hold it
+// only string values have a history, overwriting a list starts a new one
+func (kv *KeyValueStore) pushHistory(current entry) []historyVersion {
+	depth := kv.options.HistoryDepth
+	if depth <= 0 || current.kind != kindString {
+		return nil
+	}
+	history := make([]historyVersion, 0, min(len(current.history)+1, depth))
+	history = append(history, historyVersion{value: current.value, rawLen: current.rawLen, version: current.version, replaced: kv.now()})
+	return append(history, current.history[:min(len(current.history), depth-1)]...)
+}
+
+// HistoryHandler returns the versions of a key newest first, the current value followed by the values it overwrote
+// it answers 404 if the key does not exist, a deleted key has no history
+func (kv *KeyValueStore) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload HistoryRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.readNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	e, ok := kv.peek(payload.Key)
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if e.kind != kindString {
+		writeWrongType(w, payload.Key)
+		return
+	}
+
+	versions := make([]HistoryVersion, 0, len(e.history)+1)
+	versions = append(versions, HistoryVersion{Version: e.version, Value: e.plain()})
+	for _, v := range e.history {
+		versions = append(versions, HistoryVersion{Version: v.version, Value: v.plain(), ReplacedAt: v.replaced})
+	}
+	writeJSON(w, kv.root.jsonCase, versions)
+}
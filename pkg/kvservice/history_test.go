@@ -0,0 +1,78 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeyValueStore_History(t *testing.T) {
+	const depth = 3
+	kv := NewKeyValueStore(StoreOptions{HistoryDepth: depth})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	kv.clock = func() time.Time { return now }
+	env := Config{}
+	handler := env.routes(kv)
+
+	// the first write and depth+2 overwrites leave v0 and v1 beyond the depth
+	for i := 0; i <= depth+2; i++ {
+		now = now.Add(time.Minute)
+		serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"key","value":"v`+strconv.Itoa(i)+`"}`)
+	}
+
+	w := serveNamespace(handler, "", http.MethodPost, "/history", `{"key":"key"}`)
+	var versions []HistoryVersion
+	if err := json.NewDecoder(w.Body).Decode(&versions); err != nil {
+		t.Fatalf("expected versions but got %v %v", w.Code, err)
+	}
+	want := []Value{"v5", "v4", "v3", "v2"}
+	if len(versions) != len(want) {
+		t.Fatalf("expected the current value and %d prior versions but got %+v", depth, versions)
+	}
+	for i, v := range versions {
+		if v.Value != want[i] {
+			t.Errorf("version %d: expected %q but got %q", i, want[i], v.Value)
+		}
+		if i > 0 && v.Version >= versions[i-1].Version {
+			t.Errorf("version %d: expected versions newest first but got %+v", i, versions)
+		}
+		if i > 1 && !v.ReplacedAt.Before(versions[i-1].ReplacedAt) {
+			t.Errorf("version %d: expected older versions to be replaced earlier but got %+v", i, versions)
+		}
+	}
+	if !versions[0].ReplacedAt.IsZero() || !versions[1].ReplacedAt.Equal(now) {
+		t.Errorf("expected only prior versions to carry the time they were replaced but got %+v", versions[:2])
+	}
+
+	// the history is accounted and dropped with the key
+	if got, want := kv.Bytes(), int64(len("key")+4*len("v0")); got != want {
+		t.Errorf("expected %d bytes including the history but got %d", want, got)
+	}
+	serveNamespace(handler, "", http.MethodDelete, "/kv/key", "")
+	if kv.Bytes() != 0 {
+		t.Errorf("expected no bytes after the delete but got %d", kv.Bytes())
+	}
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"key","value":"new"}`)
+	w = serveNamespace(handler, "", http.MethodPost, "/history", `{"key":"key"}`)
+	versions = nil
+	if err := json.NewDecoder(w.Body).Decode(&versions); err != nil || len(versions) != 1 {
+		t.Errorf("expected no history after the delete but got %+v (%v)", versions, err)
+	}
+}
+
+func TestKeyValueStore_History_Disabled(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"key": "old"})
+	writeTestValue(kv, "key", "new")
+	env := Config{}
+
+	w := serveNamespace(env.routes(kv), "", http.MethodPost, "/history", `{"key":"key"}`)
+	var versions []HistoryVersion
+	if err := json.NewDecoder(w.Body).Decode(&versions); err != nil || len(versions) != 1 || versions[0].Value != "new" {
+		t.Errorf("expected only the current value but got %+v (%v)", versions, err)
+	}
+	if w := serveNamespace(env.routes(kv), "", http.MethodPost, "/history", `{"key":"missing"}`); w.Code != http.StatusNotFound {
+		t.Errorf("missing key: expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+}
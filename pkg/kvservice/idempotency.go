@@ -0,0 +1,199 @@
+package kvservice
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// curl -H 'Idempotency-Key: 4f1c2a' -H 'Content-Type: application/json' -d '{"key":"key1","value":"value1"}' http://localhost:8080/set
+// a retry with the same key and body within the TTL is answered with the first response and the header Idempotent-Replayed: true
+
+const (
+	// idempotencyKeyHeader names the key a client sends to make the retries of a request safe
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed from the cache
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds the keys so clients can not fill the memory of the cache with a few requests
+	maxIdempotencyKeyLength = 255
+	// defaultIdempotencyMaxKeys is the number of idempotency keys remembered unless another number is configured
+	defaultIdempotencyMaxKeys = 10000
+)
+
+// idempotencyCache remembers the responses of the requests carrying an idempotency key for the TTL
+// it holds at most max keys, once full the oldest key is forgotten first, and as all keys live for the same TTL the oldest is also the first to expire
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*idempotentResult
+	// order holds the keys oldest first
+	order *list.List
+}
+
+// idempotentResult is the response to the first request with a key, it is complete once done is set
+type idempotentResult struct {
+	// fingerprint identifies the request, a retry must send the same namespace and body
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	elem        *list.Element
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+}
+
+// SetIdempotency makes /set of all namespaces remember the responses to requests with an Idempotency-Key header for ttl,
+// at most maxKeys of them or 10000 if maxKeys is 0, a ttl of 0 ignores the header; it must be called before the store is served
+func (kv *KeyValueStore) SetIdempotency(ttl time.Duration, maxKeys int) {
+	if ttl <= 0 {
+		kv.root.idempotency = nil
+		return
+	}
+	if maxKeys <= 0 {
+		maxKeys = defaultIdempotencyMaxKeys
+	}
+	kv.root.idempotency = &idempotencyCache{ttl: ttl, max: maxKeys, entries: make(map[string]*idempotentResult), order: list.New()}
+}
+
+// begin returns the result of the key and whether the caller is the first with it and has to complete it by calling finish
+func (c *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte, now time.Time) (*idempotentResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for front := c.order.Front(); front != nil && !now.Before(c.entries[front.Value.(string)].expires); front = c.order.Front() {
+		c.remove(front.Value.(string))
+	}
+
+	if res, ok := c.entries[key]; ok {
+		return res, false
+	}
+	if c.order.Len() >= c.max {
+		c.remove(c.order.Front().Value.(string))
+	}
+	res := &idempotentResult{fingerprint: fingerprint, expires: now.Add(c.ttl)}
+	res.elem = c.order.PushBack(key)
+	c.entries[key] = res
+	return res, true
+}
+
+// finish completes the result of the first request with the key, a server error is forgotten so a retry applies the request again
+func (c *idempotencyCache) finish(key string, res *idempotentResult, status int, contentType string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if status >= http.StatusInternalServerError {
+		if c.entries[key] == res {
+			c.remove(key)
+		}
+		return
+	}
+	res.done, res.status, res.contentType, res.body = true, status, contentType, body
+}
+
+// remove forgets the key, the caller must hold mu
+func (c *idempotencyCache) remove(key string) {
+	c.order.Remove(c.entries[key].elem)
+	delete(c.entries, key)
+}
+
+// snapshot returns the response of the result under the lock, ok is false while the first request is still being handled
+func (c *idempotencyCache) snapshot(res *idempotentResult) (status int, contentType string, body []byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return res.status, res.contentType, res.body, res.done
+}
+
+// MiddlewareIdempotency answers a request repeating the Idempotency-Key of an earlier one with the response to that request instead of handling it again
+// a key reused for another namespace or body is answered 422 and a key whose first request is still being handled 409, as the IETF draft on the header suggests
+// requests without the header, and all requests unless SetIdempotency enabled the cache, are handled as usual
+func (kv *KeyValueStore) MiddlewareIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cache := kv.root.idempotency
+		key := r.Header.Get(idempotencyKeyHeader)
+		if cache == nil || key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", "the Idempotency-Key header must not be longer than 255 bytes")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h := sha256.New()
+		io.WriteString(h, r.Header.Get("X-Namespace"))
+		h.Write([]byte{0})
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		h.Sum(fingerprint[:0])
+
+		res, first := cache.begin(key, fingerprint, kv.now())
+		if !first {
+			if res.fingerprint != fingerprint {
+				writeError(w, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "the Idempotency-Key was already used for another request")
+				return
+			}
+			status, contentType, cached, ok := cache.snapshot(res)
+			if !ok {
+				writeError(w, http.StatusConflict, "IDEMPOTENCY_KEY_IN_USE", "a request with the Idempotency-Key is still being handled")
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(status)
+			w.Write(cached)
+			return
+		}
+
+		// a handler that panics leaves no response to replay, the key is forgotten like after a server error
+		completed := false
+		defer func() {
+			if !completed {
+				cache.finish(key, res, http.StatusInternalServerError, "", nil)
+			}
+		}()
+		rw := &recordingWriter{ResponseWriter: w}
+		next(rw, r)
+		completed = true
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		cache.finish(key, res, status, w.Header().Get("Content-Type"), rw.body.Bytes())
+	}
+}
+
+// recordingWriter keeps a copy of the status and body a handler writes
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
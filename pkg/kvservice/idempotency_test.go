@@ -0,0 +1,120 @@
+package kvservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveIdempotent sends the set with the idempotency key to the handler
+func serveIdempotent(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestKeyValueStore_MiddlewareIdempotency(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetIdempotency(time.Hour, 0)
+	handler := (&Config{}).routes(kv)
+
+	first := serveIdempotent(handler, "retry-1", `{"key":"counter","value":"1"}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v %s", first.Code, first.Body.String())
+	}
+	// another client changes the key before the retry arrives, the retry must not apply the old value again
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"counter","value":"2"}`)
+
+	retry := serveIdempotent(handler, "retry-1", `{"key":"counter","value":"1"}`)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected the first response %v %q but got %v %q", first.Code, first.Body.String(), retry.Code, retry.Body.String())
+	}
+	if got := retry.Header().Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("expected the retry to be marked as replayed but got %q", got)
+	}
+	if e, _ := kv.peek("counter"); e.plain() != "2" {
+		t.Errorf("expected the set to be applied once but the value is %q", e.plain())
+	}
+
+	if w := serveIdempotent(handler, "retry-1", `{"key":"counter","value":"3"}`); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "IDEMPOTENCY_KEY_REUSED") {
+		t.Errorf("expected a reused key to be rejected but got %v %s", w.Code, w.Body.String())
+	}
+	if w := serveIdempotent(handler, "retry-2", `{"key":"counter","value":"3"}`); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected a new key to be applied but got %v %s", w.Code, w.Body.String())
+	}
+	if e, _ := kv.peek("counter"); e.plain() != "3" {
+		t.Errorf("expected the value of the new key but got %q", e.plain())
+	}
+}
+
+func TestKeyValueStore_MiddlewareIdempotency_Expiry(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetIdempotency(time.Minute, 2)
+	now := time.Now()
+	kv.clock = func() time.Time { return now }
+	handler := (&Config{}).routes(kv)
+
+	set := func(idempotencyKey, value string) bool {
+		t.Helper()
+		w := serveIdempotent(handler, idempotencyKey, `{"key":"key","value":"`+value+`"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %v %s", w.Code, w.Body.String())
+		}
+		return w.Header().Get("Idempotent-Replayed") == "true"
+	}
+
+	set("a", "1")
+	now = now.Add(59 * time.Second)
+	if !set("a", "1") {
+		t.Error("expected the retry within the TTL to be replayed")
+	}
+	now = now.Add(time.Second)
+	if set("a", "1") {
+		t.Error("expected the retry after the TTL to be applied again")
+	}
+
+	// the cache holds 2 keys, the oldest is forgotten first
+	set("b", "1")
+	set("c", "1")
+	if set("a", "1") {
+		t.Error("expected the oldest key to be forgotten")
+	}
+	if !set("c", "1") {
+		t.Error("expected the newer key to be remembered")
+	}
+}
+
+func TestKeyValueStore_MiddlewareIdempotency_InFlight(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetIdempotency(time.Hour, 0)
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := kv.MiddlewareIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusInsufficientStorage)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveIdempotent(handler, "slow", `{}`)
+	}()
+	<-started
+	if w := serveIdempotent(handler, "slow", `{}`); w.Code != http.StatusConflict {
+		t.Errorf("expected a retry while the first request is handled to be answered %v but got %v", http.StatusConflict, w.Code)
+	}
+	close(release)
+	<-done
+
+	// a server error is not remembered, the retry is handled again
+	handled := false
+	handler = kv.MiddlewareIdempotency(func(w http.ResponseWriter, r *http.Request) { handled = true })
+	if w := serveIdempotent(handler, "slow", `{}`); w.Code != http.StatusOK || !handled {
+		t.Errorf("expected the retry after a server error to be handled but got %v", w.Code)
+	}
+}
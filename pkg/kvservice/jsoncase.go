@@ -0,0 +1,183 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// go run . --json-case camel
+
+// JSONCase is how the multi-word fields of JSON responses are named
+type JSONCase string
+
+const (
+	// JSONCaseSnake names the fields like the struct tags e.g. modified_at
+	JSONCaseSnake JSONCase = "snake"
+	// JSONCaseCamel names the fields in lower camel case e.g. modifiedAt
+	JSONCaseCamel JSONCase = "camel"
+)
+
+// SetJSONCase sets how writeJSON names the fields of the responses of all namespaces, it must be called before the store is served
+func (kv *KeyValueStore) SetJSONCase(c JSONCase) {
+	kv.root.jsonCase = c
+}
+
+// writeJSON writes v as json.Encoder would, with the fields of the structs in v named in the given case
+// the keys of maps are data and kept, like hash fields and namespace names, and so are json.RawMessage and other values that marshal themselves
+func writeJSON(w io.Writer, c JSONCase, v any) error {
+	if c != JSONCaseCamel {
+		return json.NewEncoder(w).Encode(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := recase(&out, dec, reflect.TypeOf(v)); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// recase copies the next JSON value from dec to out, renaming the fields of the objects that t marshals as a struct to camel case
+// t is nil for values of unknown type, their objects are copied unchanged
+func recase(out *bytes.Buffer, dec *json.Decoder, t reflect.Type) error {
+	t = marshaledType(t)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		var fields map[string]reflect.Type
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = structFields(t)
+		}
+		out.WriteByte('{')
+		for i := 0; dec.More(); i++ {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name := tok.(string)
+			var elem reflect.Type
+			switch {
+			case fields != nil:
+				elem = fields[name]
+				name = camelCase(name)
+			case t != nil && t.Kind() == reflect.Map:
+				elem = t.Elem()
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			key, _ := json.Marshal(name)
+			out.Write(key)
+			out.WriteByte(':')
+			if err := recase(out, dec, elem); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		out.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := recase(out, dec, elem); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	default:
+		value, err := json.Marshal(tok)
+		if err != nil {
+			return err
+		}
+		out.Write(value)
+		return nil
+	}
+	// the closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+var marshalerType = reflect.TypeFor[json.Marshaler]()
+
+// marshaledType returns the type behind the pointers of t, nil if it is unknown or marshals itself
+func marshaledType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface || t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return nil
+	}
+	return t
+}
+
+// structFieldsCache holds the result of structFields by type, the responses are few types written over and over
+var structFieldsCache sync.Map
+
+// structFields returns the types of the fields of the struct by their JSON name, including the promoted fields of embedded structs
+func structFields(t reflect.Type) map[string]reflect.Type {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.(map[string]reflect.Type)
+	}
+	fields := make(map[string]reflect.Type)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && (f.Type.Kind() == reflect.Struct || f.Type.Kind() == reflect.Pointer && f.Type.Elem().Kind() == reflect.Struct) {
+			// its fields are visible on their own
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := fields[name]; !ok {
+			fields[name] = f.Type
+		}
+	}
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+// camelCase turns a snake case name like modified_at into modifiedAt
+func camelCase(name string) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+	var b strings.Builder
+	upper := false
+	for i, r := range name {
+		switch {
+		case r == '_' && i > 0:
+			upper = true
+		case upper:
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteJSON(t *testing.T) {
+	type nested struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Untagged  int
+	}
+	type response struct {
+		BuildInfo
+		FailedOp *int                `json:"failed_op,omitempty"`
+		Items    []nested            `json:"items_list"`
+		ByName   map[string]nested   `json:"by_name"`
+		Document json.RawMessage     `json:"merge_patch"`
+		Any      any                 `json:"any_value"`
+		Skipped  string              `json:"-"`
+		Hash     map[string]Value    `json:"hash_fields"`
+		Lists    map[string][]nested `json:"lists,omitempty"`
+	}
+	op := 2
+	v := response{
+		BuildInfo: BuildInfo{Version: "1.0", BuildDate: "today"},
+		FailedOp:  &op,
+		Items:     []nested{{ExpiresAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Untagged: 1}},
+		ByName:    map[string]nested{"team_a": {Untagged: 2}},
+		Document:  json.RawMessage(`{"keep_me":1}`),
+		Any:       map[string]any{"keep_me": 1.5},
+		Hash:      map[string]Value{"field_name": "<value>"},
+	}
+
+	var snake bytes.Buffer
+	if err := writeJSON(&snake, JSONCaseSnake, v); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	json.NewEncoder(&want).Encode(v)
+	if snake.String() != want.String() {
+		t.Errorf("expected snake case to be written as json.Encoder does\n%s but got\n%s", want.String(), snake.String())
+	}
+
+	var camel bytes.Buffer
+	if err := writeJSON(&camel, JSONCaseCamel, v); err != nil {
+		t.Fatal(err)
+	}
+	wantCamel := `{"version":"1.0","commit":"","buildDate":"today","goVersion":"","failedOp":2,` +
+		`"itemsList":[{"expiresAt":"2024-05-01T00:00:00Z","Untagged":1}],"byName":{"team_a":{"expiresAt":"0001-01-01T00:00:00Z","Untagged":2}},` +
+		`"mergePatch":{"keep_me":1},"anyValue":{"keep_me":1.5},"hashFields":{"field_name":"\u003cvalue\u003e"}}` + "\n"
+	if camel.String() != wantCamel {
+		t.Errorf("expected\n%s but got\n%s", wantCamel, camel.String())
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"value":          "value",
+		"modified_at":    "modifiedAt",
+		"uptime_seconds": "uptimeSeconds",
+		"_private":       "_private",
+	} {
+		if got := camelCase(name); got != want {
+			t.Errorf("%s: expected %s but got %s", name, want, got)
+		}
+	}
+}
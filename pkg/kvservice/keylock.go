@@ -0,0 +1,113 @@
+package kvservice
+
+import (
+	"errors"
+	"sync"
+)
+
+// errKeyNotFound is returned by modify for a key that does not exist
+var errKeyNotFound = errors.New("key not found")
+
+// keyedMutex hands out a mutex per key, the mutex of a key is only kept while it is held or waited for
+// so the table only ever holds the keys being operated on
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[Key]*keyLock
+}
+
+// keyLock is the mutex of a key together with the number of holders and waiters
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the key and returns the function unlocking it
+func (m *keyedMutex) lock(key Key) (unlock func()) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[Key]*keyLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// len returns the number of keys in the table
+func (m *keyedMutex) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.locks)
+}
+
+// modify replaces the string value of the key with what fn computes from it and returns the stored entry
+// it returns errKeyNotFound if the key does not exist, errWrongType if it does not hold a string, and the error of fn or of the write otherwise
+func (kv *KeyValueStore) modify(key Key, fn func(Value) (Value, error)) (entry, error) {
+	var e entry
+	err := kv.update(key, func(current entry, exists bool) (func(s *shard) error, error) {
+		if !exists {
+			return nil, errKeyNotFound
+		}
+		if current.kind != kindString {
+			return nil, errWrongType
+		}
+		value, err := fn(current.plain())
+		if err != nil {
+			return nil, err
+		}
+		return func(s *shard) (err error) {
+			e, err = s.put(key, value)
+			return err
+		}, nil
+	})
+	return e, err
+}
+
+// update is a read-modify-write of the entry of the key, exists reports whether the key exists
+// fn computes the change from the entry and returns commit to apply it, commit runs under the lock of the shard
+// fn runs with only the key locked, so a slow computation neither blocks the other keys of the shard nor the reads of the key,
+// and concurrent updates of the key never lose each other's changes
+// the writes that do not lock the key, like /set, are caught by the version: if the key changed while fn ran, fn runs again on the new entry
+// fn must not modify the entry, commit may, readers copy what is modified in place under the lock of the shard
+// the change is synced to the write-ahead log after the shard is unlocked, see syncLog
+func (kv *KeyValueStore) update(key Key, fn func(current entry, exists bool) (commit func(s *shard) error, err error)) error {
+	unlock := kv.keyLocks.lock(key)
+	defer unlock()
+
+	s := kv.shard(key)
+	for {
+		s.RLock()
+		current, exists := s.kvMap[key]
+		s.RUnlock()
+		commit, err := fn(current, exists)
+		if err != nil {
+			return err
+		}
+
+		s.Lock()
+		if latest, ok := s.kvMap[key]; ok != exists || ok && latest.version != current.version {
+			s.Unlock()
+			continue
+		}
+		err = commit(s)
+		s.Unlock()
+		if err != nil {
+			return err
+		}
+		return kv.syncLog()
+	}
+}
@@ -0,0 +1,262 @@
+package kvservice
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var m keyedMutex
+	counts := make(map[Key]int)
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Go(func() {
+			key := Key(fmt.Sprintf("key-%d", i%10))
+			for range 100 {
+				unlock := m.lock(key)
+				counts[key]++
+				unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	for key, n := range counts {
+		if n != 1000 {
+			t.Errorf("%s: expected 1000 increments but got %d", key, n)
+		}
+	}
+	if n := m.len(); n != 0 {
+		t.Errorf("expected the keys to be removed once unlocked but %d are left", n)
+	}
+}
+
+func TestKeyValueStore_Modify(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"counter": "0"})
+	incr := func(value Value) (Value, error) {
+		n, err := strconv.Atoi(string(value))
+		return Value(strconv.Itoa(n + 1)), err
+	}
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			for range 20 {
+				if _, err := kv.modify("counter", incr); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if e, _ := kv.peek("counter"); e.plain() != "1000" {
+		t.Errorf("expected every increment to be kept but got %s", e.plain())
+	}
+
+	if _, err := kv.modify("missing", incr); !errors.Is(err, errKeyNotFound) {
+		t.Errorf("expected errKeyNotFound but got %v", err)
+	}
+}
+
+func TestKeyValueStore_Modify_ConcurrentSet(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"doc": "a"})
+
+	// a set that does not lock the key lands while fn runs, fn runs again on top of it
+	var calls atomic.Int32
+	e, err := kv.modify("doc", func(value Value) (Value, error) {
+		if calls.Add(1) == 1 {
+			w := httptest.NewRecorder()
+			kv.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"doc","value":"b"}`)))
+		}
+		return value + "+", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 || e.plain() != "b+" {
+		t.Errorf("expected the modification to be applied to the set value but got %s after %d calls", e.plain(), calls.Load())
+	}
+}
+
+// BenchmarkKeyValueStore_Modify increments 1000 distinct keys concurrently in a bounded store, which has a single shard
+// shard lock holds the shard lock while computing the new value as the handlers did before modify
+func BenchmarkKeyValueStore_Modify(b *testing.B) {
+	const keys = 1000
+	incr := func(value Value) (Value, error) {
+		n, err := strconv.Atoi(string(value))
+		return Value(strconv.Itoa(n + 1)), err
+	}
+	for _, tt := range []struct {
+		name   string
+		modify func(kv *KeyValueStore, key Key) error
+	}{
+		{name: "shard lock", modify: func(kv *KeyValueStore, key Key) error {
+			s := kv.shard(key)
+			s.Lock()
+			defer s.Unlock()
+			value, err := incr(s.kvMap[key].plain())
+			if err != nil {
+				return err
+			}
+			_, err = s.put(key, value)
+			return err
+		}},
+		{name: "key lock", modify: func(kv *KeyValueStore, key Key) error {
+			_, err := kv.modify(key, incr)
+			return err
+		}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			kv := NewKeyValueStore(StoreOptions{MaxKeys: 2 * keys})
+			for i := range keys {
+				writeTestValue(kv, Key(fmt.Sprintf("key-%d", i)), "0")
+			}
+			var worker atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := int(worker.Add(1))
+				for i := 0; pb.Next(); i++ {
+					if err := tt.modify(kv, Key(fmt.Sprintf("key-%d", (id*7919+i)%keys))); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestKeyValueStore_Update_ListHash(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Go(func() {
+			for j := range 20 {
+				if _, err := kv.push("list", Value(strconv.Itoa(i)), j%2 == 0); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := kv.hset("hash", fmt.Sprintf("%d-%d", i, j), "value"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if e, _ := kv.peek("list"); len(e.items) != 1000 {
+		t.Errorf("expected every push to be kept but the list holds %d items", len(e.items))
+	}
+	if e, _ := kv.peek("hash"); len(e.fields) != 1000 {
+		t.Errorf("expected every field to be kept but the hash holds %d fields", len(e.fields))
+	}
+
+	for i := range 50 {
+		wg.Go(func() {
+			for j := range 20 {
+				if _, ok, _, err := kv.pop("list", i%2 == 0, false); !ok || err != nil {
+					t.Errorf("expected an item but got %v %v", ok, err)
+					return
+				}
+				if deleted, err := kv.hdel("hash", fmt.Sprintf("%d-%d", i, j)); !deleted || err != nil {
+					t.Errorf("expected the field to be deleted but got %v %v", deleted, err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if n := kv.Len(); n != 0 {
+		t.Errorf("expected the emptied list and hash to be removed but %d keys are left", n)
+	}
+
+	writeTestValue(kv, "string", "value")
+	if _, err := kv.push("string", "item", false); !errors.Is(err, errWrongType) {
+		t.Errorf("expected errWrongType but got %v", err)
+	}
+	if _, err := kv.hset("string", "field", "value"); !errors.Is(err, errWrongType) {
+		t.Errorf("expected errWrongType but got %v", err)
+	}
+}
+
+// BenchmarkKeyValueStore_ListHash pushes and pops list items and sets hash fields of 1000 keys concurrently in a bounded store, which has a single shard
+// shard lock applies the operation under the shard lock as the handlers did before update, key lock is the update of the handlers
+// run it with -cpu 1,4,8, the hashes hold 64 fields so copying them before a set is not free
+func BenchmarkKeyValueStore_ListHash(b *testing.B) {
+	const keys, fields = 1000, 64
+	shardPushPop := func(kv *KeyValueStore, key Key) error {
+		s := kv.shard(key)
+		s.Lock()
+		defer s.Unlock()
+		current := s.kvMap[key]
+		if _, err := s.writeEntry(key, entry{kind: kindList, items: append(current.items, "item")}); err != nil {
+			return err
+		}
+		current = s.kvMap[key]
+		_, err := s.writeEntry(key, entry{kind: kindList, items: current.items[1:]})
+		return err
+	}
+	shardHSet := func(kv *KeyValueStore, key Key) error {
+		s := kv.shard(key)
+		s.Lock()
+		defer s.Unlock()
+		fields := maps.Clone(s.kvMap[key].fields)
+		fields["field-0"] = "value"
+		_, err := s.writeEntry(key, entry{kind: kindHash, fields: fields})
+		return err
+	}
+	for _, tt := range []struct {
+		name string
+		hash bool
+		op   func(kv *KeyValueStore, key Key) error
+	}{
+		{name: "push pop/shard lock", op: shardPushPop},
+		{name: "push pop/key lock", op: func(kv *KeyValueStore, key Key) error {
+			if _, err := kv.push(key, "item", false); err != nil {
+				return err
+			}
+			_, _, _, err := kv.pop(key, true, false)
+			return err
+		}},
+		{name: "hset/shard lock", hash: true, op: shardHSet},
+		{name: "hset/key lock", hash: true, op: func(kv *KeyValueStore, key Key) error {
+			_, err := kv.hset(key, "field-0", "value")
+			return err
+		}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			kv := NewKeyValueStore(StoreOptions{MaxKeys: 2 * keys})
+			for i := range keys {
+				key := Key(fmt.Sprintf("key-%d", i))
+				if !tt.hash {
+					kv.push(key, "item", false)
+					continue
+				}
+				for j := range fields {
+					kv.hset(key, fmt.Sprintf("field-%d", j), "value")
+				}
+			}
+			var worker atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := int(worker.Add(1))
+				for i := 0; pb.Next(); i++ {
+					if err := tt.op(kv, Key(fmt.Sprintf("key-%d", (id*7919+i)%keys))); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package kvservice
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// go run . --key-pattern '[a-z0-9:]+'
+// curl -d '{"key":"bad key","value":"1"}' http://localhost:8080/set
+// {"code":"INVALID_KEY","message":"key \"bad key\" does not match the key pattern [a-z0-9:]+"}
+
+// DefaultKeyPattern is the key pattern of the default configuration, it keeps control characters, whitespace and separators out of the keys
+const DefaultKeyPattern = `[A-Za-z0-9_.:-]+`
+
+// compileKeyPattern compiles the pattern keys must match, the pattern has to match the whole key
+func compileKeyPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("compile key pattern: %w", err)
+	}
+	return re, nil
+}
+
+// SetKeyPattern makes the write handlers of all namespaces reject keys that do not match the pattern as a whole, empty accepts every key
+// keys that are already stored stay readable and deletable, it must be called before the store is served
+func (kv *KeyValueStore) SetKeyPattern(pattern string) error {
+	if pattern == "" {
+		kv.root.keyPattern, kv.root.keyPatternSource = nil, ""
+		return nil
+	}
+	re, err := compileKeyPattern(pattern)
+	if err != nil {
+		return err
+	}
+	kv.root.keyPattern, kv.root.keyPatternSource = re, pattern
+	return nil
+}
+
+// validateKey checks the key against the pattern if one is configured
+func (kv *KeyValueStore) validateKey(key Key) error {
+	re := kv.root.keyPattern
+	if re == nil || re.MatchString(string(key)) {
+		return nil
+	}
+	return fmt.Errorf("key %q does not match the key pattern %s", key, kv.root.keyPatternSource)
+}
+
+// rejectInvalidKey answers 400 and returns true if the key does not match the pattern
+func (kv *KeyValueStore) rejectInvalidKey(w http.ResponseWriter, key Key) bool {
+	err := kv.validateKey(key)
+	if err == nil {
+		return false
+	}
+	writeError(w, http.StatusBadRequest, "INVALID_KEY", err.Error())
+	return true
+}
+
+// MiddlewareKeyPattern rejects a PUT to /kv/{key} whose key does not match the pattern, for the handler of a backend that does not know it
+func (kv *KeyValueStore) MiddlewareKeyPattern(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && kv.rejectInvalidKey(w, Key(strings.TrimPrefix(r.URL.Path, "/kv/"))) {
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,87 @@
+package kvservice
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueStore_SetHandler_KeyPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		wantCode int
+	}{
+		{name: "matching key", key: "user:1.name_v-2", wantCode: http.StatusOK},
+		{name: "space", key: "user 1", wantCode: http.StatusBadRequest},
+		{name: "control character", key: "user\n1", wantCode: http.StatusBadRequest},
+		{name: "slash", key: "user/1", wantCode: http.StatusBadRequest},
+		{name: "matching prefix only", key: "user:1é", wantCode: http.StatusBadRequest},
+		{name: "empty key", key: "", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := NewKeyValueStore(StoreOptions{})
+			if err := kv.SetKeyPattern(DefaultKeyPattern); err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			kv.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(fmt.Sprintf(`{"key":%q,"value":"1"}`, tt.key))))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %v but got %v: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if _, stored := kv.peek(Key(tt.key)); stored != (tt.wantCode == http.StatusOK) {
+				t.Error("expected the value to be stored only if the key matches the pattern")
+			}
+			if tt.wantCode == http.StatusBadRequest && !strings.Contains(w.Body.String(), "INVALID_KEY") {
+				t.Errorf("expected error code INVALID_KEY but got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_KeyPattern_WriteEndpoints(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	if err := kv.SetKeyPattern(`[a-z]+`); err != nil {
+		t.Fatal(err)
+	}
+	handler := (&Config{}).routes(kv)
+	if w := serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"src","value":"1"}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v %s", w.Code, w.Body.String())
+	}
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPut, "/kv/Bad", "1"},
+		{http.MethodPost, "/setnx", `{"key":"Bad","value":"1"}`},
+		{http.MethodPost, "/hset", `{"key":"Bad","field":"name","value":"Ada"}`},
+		{http.MethodPost, "/list/push", `{"key":"Bad","value":"job"}`},
+		{http.MethodPost, "/mset", `[{"key":"ok","value":"1"},{"key":"Bad","value":"1"}]`},
+		{http.MethodPost, "/copy", `{"src":"src","dst":"Bad"}`},
+		{http.MethodPost, "/rename", `{"from":"src","to":"Bad"}`},
+		{http.MethodPost, "/txn", `{"ops":[{"type":"set","key":"Bad","value":"1"}]}`},
+	} {
+		if w := serveNamespace(handler, "", req.method, req.path, req.body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected status %v but got %v %s", req.method, req.path, http.StatusBadRequest, w.Code, w.Body.String())
+		}
+	}
+	if _, ok := kv.peek("Bad"); ok {
+		t.Error("expected no write to store the key")
+	}
+	if _, ok := kv.peek("src"); !ok {
+		t.Error("expected the rejected rename to keep the source")
+	}
+}
+
+func TestListen_InvalidKeyPattern(t *testing.T) {
+	_, err := Listen(Config{ServerAddress: "127.0.0.1:0", KeyPattern: "[a-z"})
+	if err == nil {
+		t.Fatal("expected an invalid key pattern to fail the startup")
+	}
+	if !strings.Contains(err.Error(), "key pattern") {
+		t.Errorf("expected the error to name the key pattern but got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package kvservice
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// curl 'http://localhost:8080/keys?prefix=user:&limit=100'
+
+// defaultMaxListKeys is the cap on the keys listed by /keys if none is configured
+const defaultMaxListKeys = 10000
+
+// KeysResponse is the body returned by the keys endpoint
+type KeysResponse struct {
+	Keys []Key `json:"keys"`
+	// Truncated reports that more keys match than were returned, page through them with /scan
+	Truncated bool `json:"truncated"`
+}
+
+// keysWithPrefix returns up to limit keys starting with prefix in key order, truncated reports whether more keys match
+// it visits every shard, ctx is checked before each one so a request that ended stops the walk
+func (kv *KeyValueStore) keysWithPrefix(ctx context.Context, prefix string, limit int) (keys []Key, truncated bool, err error) {
+	keys = []Key{}
+	for _, s := range kv.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		s.RLock()
+		for k := range s.kvMap {
+			if strings.HasPrefix(string(k), prefix) {
+				keys = append(keys, k)
+			}
+		}
+		s.RUnlock()
+	}
+	slices.Sort(keys)
+	if len(keys) > limit {
+		return keys[:limit], true, nil
+	}
+	return keys, false, nil
+}
+
+// KeysHandler returns the keys of the namespace starting with the prefix parameter in key order, without their values
+// the limit parameter asks for fewer keys, at most max-list-keys are returned however many are asked for
+// if keys were left out the response says so with truncated, /scan pages through any number of keys
+func (env *Config) KeysHandler(kvStore *KeyValueStore) http.HandlerFunc {
+	maxKeys := env.MaxListKeys
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxListKeys
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		kv, ok := kvStore.readNamespace(w, r, "")
+		if !ok {
+			return
+		}
+		query := r.URL.Query()
+		limit := maxKeys
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "Limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxKeys)
+		}
+
+		keys, truncated, err := kv.keysWithPrefix(r.Context(), query.Get("prefix"), limit)
+		if err != nil {
+			writeContextError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, kv.root.jsonCase, KeysResponse{Keys: keys, Truncated: truncated})
+	}
+}
@@ -0,0 +1,79 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfig_KeysHandler(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	for i := range 25 {
+		writeTestValue(kv, Key(fmt.Sprintf("user:%02d", i)), "value")
+	}
+	writeTestValue(kv, "other", "value")
+
+	tests := []struct {
+		name          string
+		maxListKeys   int
+		query         string
+		wantCode      int
+		wantKeys      int
+		wantFirst     Key
+		wantTruncated bool
+	}{
+		{name: "all keys", query: "", wantCode: http.StatusOK, wantKeys: 26, wantFirst: "other"},
+		{name: "prefix", query: "?prefix=user:", wantCode: http.StatusOK, wantKeys: 25, wantFirst: "user:00"},
+		{name: "client limit", query: "?prefix=user:&limit=5", wantCode: http.StatusOK, wantKeys: 5, wantFirst: "user:00", wantTruncated: true},
+		{name: "capped", maxListKeys: 10, query: "?prefix=user:", wantCode: http.StatusOK, wantKeys: 10, wantFirst: "user:00", wantTruncated: true},
+		{name: "limit above the cap", maxListKeys: 10, query: "?prefix=user:&limit=20", wantCode: http.StatusOK, wantKeys: 10, wantFirst: "user:00", wantTruncated: true},
+		{name: "cap not reached", maxListKeys: 25, query: "?prefix=user:", wantCode: http.StatusOK, wantKeys: 25, wantFirst: "user:00"},
+		{name: "no match", query: "?prefix=none", wantCode: http.StatusOK},
+		{name: "invalid limit", query: "?limit=0", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := Config{MaxListKeys: tt.maxListKeys}
+			w := httptest.NewRecorder()
+			env.KeysHandler(kv)(w, httptest.NewRequest(http.MethodGet, "/keys"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %v but got %v %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var got KeysResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Keys) != tt.wantKeys || got.Truncated != tt.wantTruncated {
+				t.Errorf("expected %d keys, truncated %v, but got %d, %v", tt.wantKeys, tt.wantTruncated, len(got.Keys), got.Truncated)
+			}
+			if got.Keys == nil || len(got.Keys) > 0 && got.Keys[0] != tt.wantFirst {
+				t.Errorf("expected the keys in order starting with %q but got %v", tt.wantFirst, got.Keys)
+			}
+		})
+	}
+}
+
+func TestConfig_KeysHandler_DefaultCap(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	for i := range defaultMaxListKeys + 1 {
+		writeTestValue(kv, Key(fmt.Sprintf("key-%05d", i)), "")
+	}
+
+	env := Config{}
+	w := httptest.NewRecorder()
+	env.KeysHandler(kv)(w, httptest.NewRequest(http.MethodGet, "/keys?limit=20000", nil))
+	var got KeysResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Keys) != defaultMaxListKeys || !got.Truncated {
+		t.Errorf("expected %d keys and truncated but got %d, %v", defaultMaxListKeys, len(got.Keys), got.Truncated)
+	}
+}
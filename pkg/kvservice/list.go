@@ -0,0 +1,254 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"key":"q","value":"job1","side":"right"}' http://localhost:8080/list/push
+// curl -H 'Content-Type: application/json' -d '{"key":"q","side":"left","wait":"10s"}' http://localhost:8080/list/pop
+
+// errWrongType is returned for an operation on a key holding another type of value
+var errWrongType = errors.New("wrong type")
+
+// maxListWait bounds how long a pop may block waiting for an item
+const maxListWait = time.Minute
+
+const (
+	ListLeft  = "left"
+	ListRight = "right"
+)
+
+type ListPushRequest struct {
+	Key   Key   `json:"key"`
+	Value Value `json:"value"`
+	// Side is the end the value is added to, right by default
+	Side      string `json:"side,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+}
+
+type ListPushResponse struct {
+	Length int `json:"length"`
+}
+
+type ListPopRequest struct {
+	Key Key `json:"key"`
+	// Side is the end the value is taken from, left by default so pushing right and popping left is a FIFO queue
+	Side string `json:"side,omitempty"`
+	// Wait is a duration like 10s to block for while the list is empty, empty does not block
+	Wait      string `json:"wait,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// writeWrongType answers 409 for an operation on a key holding another type of value
+func writeWrongType(w http.ResponseWriter, key Key) {
+	writeError(w, http.StatusConflict, "WRONG_TYPE", fmt.Sprintf("key %q holds a value of another type", key))
+}
+
+// push adds the value to the list of the key, creating the list if the key does not exist
+// it returns errWrongType if the key holds another type of value
+func (kv *KeyValueStore) push(key Key, value Value, left bool) (int, error) {
+	var n int
+	err := kv.update(key, func(current entry, exists bool) (func(s *shard) error, error) {
+		if exists && current.kind != kindList {
+			return nil, errWrongType
+		}
+		// a left push copies the list, which is done before the shard is locked, a right push appends in place under the lock
+		var items []Value
+		if left {
+			items = make([]Value, 0, len(current.items)+1)
+			items = append(append(items, value), current.items...)
+		}
+		return func(s *shard) error {
+			if !left {
+				items = append(current.items, value)
+			}
+			_, err := s.writeEntry(key, entry{kind: kindList, items: items})
+			if !writeApplied(err) {
+				return err
+			}
+			s.kv.stats.sets.Add(1)
+			s.wake(key)
+			n = len(items)
+			return err
+		}, nil
+	})
+	return n, err
+}
+
+// pop removes and returns the value at one end of the list of the key, an emptied list is removed
+// it returns false if the key does not exist and errWrongType if it holds another type of value
+// with wait a missing key returns the channel closed by the next push to it, registered before the shard is unlocked so no push is missed
+func (kv *KeyValueStore) pop(key Key, left, wait bool) (value Value, ok bool, woken <-chan struct{}, err error) {
+	err = kv.update(key, func(current entry, exists bool) (func(s *shard) error, error) {
+		if !exists {
+			return func(s *shard) error {
+				if wait {
+					woken = s.waiter(key)
+				}
+				return nil
+			}, nil
+		}
+		if current.kind != kindList {
+			return nil, errWrongType
+		}
+
+		items, end := current.items[:len(current.items)-1], len(current.items)-1
+		if left {
+			items, end = current.items[1:], 0
+		}
+		return func(s *shard) error {
+			value = current.items[end]
+			var err error
+			if len(items) == 0 {
+				_, _, err = s.unlink(key)
+			} else {
+				// the list shrinks so the write can not exceed any limit, only logging it can fail
+				_, err = s.writeEntry(key, entry{kind: kindList, items: items})
+			}
+			// the slot is cleared only after the write, the size of the replaced entry still counts it
+			current.items[end] = ""
+			s.kv.stats.deletes.Add(1)
+			ok = true
+			return err
+		}, nil
+	})
+	return value, ok, woken, err
+}
+
+// wake releases the pops waiting for an item in the list of the key
+func (s *shard) wake(key Key) {
+	if ch, ok := s.waiters[key]; ok {
+		close(ch)
+		delete(s.waiters, key)
+	}
+}
+
+// waiter returns the channel closed by the next push to the list of the key
+func (s *shard) waiter(key Key) <-chan struct{} {
+	ch, ok := s.waiters[key]
+	if !ok {
+		if s.waiters == nil {
+			s.waiters = make(map[Key]chan struct{})
+		}
+		ch = make(chan struct{})
+		s.waiters[key] = ch
+	}
+	return ch
+}
+
+// listSide reports whether side names the left end, an empty side is def
+func listSide(side, def string) (left bool, err error) {
+	if side == "" {
+		side = def
+	}
+	switch side {
+	case ListLeft:
+		return true, nil
+	case ListRight:
+		return false, nil
+	}
+	return false, fmt.Errorf("side must be %s or %s, got %q", ListLeft, ListRight, side)
+}
+
+// ListPushHandler adds a value to a list and returns the new length of the list
+// it answers 409 with the code WRONG_TYPE if the key holds a string
+func (kv *KeyValueStore) ListPushHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ListPushRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	left, err := listSide(payload.Side, ListRight)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.Value, ok = decodeValue(w, payload.Value, payload.Encoding); !ok {
+		return
+	}
+	if kv.rejectInvalidKey(w, payload.Key) || kv.rejectInvalidValue(w, payload.Value) {
+		return
+	}
+
+	n, err := kv.push(payload.Key, payload.Value, left)
+	if errors.Is(err, errWrongType) {
+		writeWrongType(w, payload.Key)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	kv.audit(r, "push", payload.Key, len(payload.Value), 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListPushResponse{Length: n})
+}
+
+// ListPopHandler removes and returns a value from one end of a list
+// with wait it blocks until a value is pushed or the wait is over, an empty or missing list is answered with 404
+// the handler timeout still applies, so waits beyond it are cut short with 503
+func (kv *KeyValueStore) ListPopHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ListPopRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	left, err := listSide(payload.Side, ListLeft)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var wait time.Duration
+	if payload.Wait != "" {
+		if wait, err = time.ParseDuration(payload.Wait); err != nil || wait < 0 || wait > maxListWait {
+			http.Error(w, fmt.Sprintf("Wait must be a duration between 0 and %v", maxListWait), http.StatusBadRequest)
+			return
+		}
+		// the response must still be written after waiting, even beyond the write timeout of the server
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + time.Second))
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		value, ok, woken, err := kv.pop(payload.Key, left, wait > 0)
+		if errors.Is(err, errWrongType) {
+			writeWrongType(w, payload.Key)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if ok {
+			kv.audit(r, "pop", payload.Key, 0, 0)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newGetResponse(value, ""))
+			return
+		}
+		if woken == nil {
+			http.Error(w, "List is empty", http.StatusNotFound)
+			return
+		}
+
+		select {
+		case <-woken:
+		case <-deadline.C:
+			wait = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
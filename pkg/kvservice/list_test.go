@@ -0,0 +1,150 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// popValue pops from the list and returns the status and the popped value
+func popValue(t *testing.T, handler http.Handler, body string) (int, Value) {
+	t.Helper()
+	w := serveNamespace(handler, "", http.MethodPost, "/list/pop", body)
+	if w.Code != http.StatusOK {
+		return w.Code, ""
+	}
+	var response GetResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, response.Value
+}
+
+func TestKeyValueStore_List(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+
+	for i, value := range []string{"job1", "job2", "job3"} {
+		w := serveNamespace(handler, "", http.MethodPost, "/list/push", `{"key":"q","value":"`+value+`"}`)
+		if want := `{"length":` + string(rune('1'+i)) + `}`; strings.TrimSpace(w.Body.String()) != want {
+			t.Fatalf("push %s: expected %s but got %v %s", value, want, w.Code, w.Body.String())
+		}
+	}
+	if got := kv.Bytes(); got != int64(len("q")+3*len("job1")) {
+		t.Errorf("expected the items to be accounted but got %d bytes", got)
+	}
+
+	// pushing right and popping left is first in first out
+	for _, want := range []Value{"job1", "job2"} {
+		if code, got := popValue(t, handler, `{"key":"q"}`); code != http.StatusOK || got != want {
+			t.Errorf("expected %q but got %v %q", want, code, got)
+		}
+	}
+
+	// the other ends make it last in first out
+	serveNamespace(handler, "", http.MethodPost, "/list/push", `{"key":"q","value":"first","side":"left"}`)
+	serveNamespace(handler, "", http.MethodPost, "/list/push", `{"key":"q","value":"last"}`)
+	if code, got := popValue(t, handler, `{"key":"q","side":"right"}`); code != http.StatusOK || got != "last" {
+		t.Errorf("pop right: expected %q but got %v %q", "last", code, got)
+	}
+	if code, got := popValue(t, handler, `{"key":"q","side":"left"}`); code != http.StatusOK || got != "first" {
+		t.Errorf("pop left: expected %q but got %v %q", "first", code, got)
+	}
+
+	// popping the last item removes the key
+	popValue(t, handler, `{"key":"q"}`)
+	if code, _ := popValue(t, handler, `{"key":"q"}`); code != http.StatusNotFound || kv.Len() != 0 || kv.Bytes() != 0 {
+		t.Errorf("expected status %v and an empty store but got %v with %d keys", http.StatusNotFound, code, kv.Len())
+	}
+}
+
+func TestKeyValueStore_List_BlockingPop(t *testing.T) {
+	env := Config{}
+	handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+	type result struct {
+		code  int
+		value Value
+	}
+	popped := make(chan result, 1)
+	go func() {
+		code, value := popValue(t, handler, `{"key":"q","wait":"10s"}`)
+		popped <- result{code, value}
+	}()
+
+	select {
+	case r := <-popped:
+		t.Fatalf("expected the pop to block on the empty list but got %v %q", r.code, r.value)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	serveNamespace(handler, "", http.MethodPost, "/list/push", `{"key":"q","value":"job1"}`)
+	select {
+	case r := <-popped:
+		if r.code != http.StatusOK || r.value != "job1" {
+			t.Errorf("expected the pushed value but got %v %q", r.code, r.value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pop was not woken by the push")
+	}
+
+	start := time.Now()
+	if code, _ := popValue(t, handler, `{"key":"q","wait":"20ms"}`); code != http.StatusNotFound || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("expected status %v after the wait but got %v after %v", http.StatusNotFound, code, time.Since(start))
+	}
+}
+
+func TestKeyValueStore_List_WrongType(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"string": "value"})
+	env := Config{}
+	handler := env.routes(kv)
+	serveNamespace(handler, "", http.MethodPost, "/list/push", `{"key":"list","value":"item"}`)
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/list/push", `{"key":"string","value":"item"}`},
+		{http.MethodPost, "/list/pop", `{"key":"string"}`},
+		{http.MethodPost, "/get", `{"key":"list"}`},
+		{http.MethodPost, "/pop", `{"key":"list"}`},
+		{http.MethodGet, "/kv/list", ""},
+	} {
+		w := serveNamespace(handler, "", req.method, req.path, req.body)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "WRONG_TYPE") {
+			t.Errorf("%s %s: expected status %v but got %v %s", req.path, req.body, http.StatusConflict, w.Code, w.Body.String())
+		}
+	}
+	if got := testValues(kv)["string"]; got != "value" {
+		t.Errorf("expected the string to be untouched but got %q", got)
+	}
+}
+
+func TestKeyValueStore_List_Snapshot(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"string": "value"})
+	env := Config{}
+	handler := env.routes(kv)
+	for _, value := range []string{"a", "b"} {
+		serveNamespace(handler, "", http.MethodPost, "/list/push", `{"key":"q","value":"`+value+`"}`)
+	}
+
+	var buf bytes.Buffer
+	if err := kv.writeRecords(context.Background(), &buf, "", false); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewKeyValueStore(StoreOptions{})
+	if _, err := restored.readRecords(context.Background(), &buf, false); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := restored.peek("q")
+	if e.kind != kindList || !reflect.DeepEqual(e.items, []Value{"a", "b"}) {
+		t.Errorf("expected the list to be restored but got %+v", e)
+	}
+	if got := testValues(restored)["string"]; got != "value" {
+		t.Errorf("expected the string to be restored but got %q", got)
+	}
+}
@@ -0,0 +1,154 @@
+package kvservice
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// curl -d '{"name":"job-x","owner":"worker-7","ttl":"30s"}' http://localhost:8080/lock/acquire
+// curl -d '{"name":"job-x","owner":"worker-7","token":1714564800000000001}' http://localhost:8080/lock/release
+
+// maxLockTTL bounds the TTL of a lock so a forgotten lock frees up eventually
+const maxLockTTL = 24 * time.Hour
+
+type LockAcquireRequest struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	// TTL is a duration like 30s after which the lock expires unless it is acquired again by its owner
+	TTL       string `json:"ttl"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type LockReleaseRequest struct {
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`
+	Token     uint64 `json:"token"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// LockResponse is returned for an acquired lock
+// Token is the fencing token, it is greater than the token of every earlier holder of any lock in the namespace
+type LockResponse struct {
+	Token     uint64    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// heldLock is a lock and its holder until it expires
+type heldLock struct {
+	owner   string
+	token   uint64
+	expires time.Time
+}
+
+// lockTable holds the locks of a namespace, they live next to the keys and are neither persisted nor replicated
+// the zero value is an empty table
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]heldLock
+	// token is the last fencing token handed out, it starts at the current time in nanoseconds so tokens keep growing across restarts
+	token uint64
+}
+
+// acquire takes the lock for the owner if it is free or expired, the owner holding it already extends it and keeps its token
+// if another owner holds the lock it returns that lock and false
+func (t *lockTable) acquire(name, owner string, ttl time.Duration, now time.Time) (heldLock, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	held, ok := t.locks[name]
+	if ok && now.Before(held.expires) {
+		if held.owner != owner {
+			return held, false
+		}
+		held.expires = now.Add(ttl)
+		t.locks[name] = held
+		return held, true
+	}
+
+	if t.locks == nil {
+		t.locks = make(map[string]heldLock)
+	}
+	// expired locks would otherwise stay until their name is used again, so every new acquisition sweeps them
+	for n, l := range t.locks {
+		if !now.Before(l.expires) {
+			delete(t.locks, n)
+		}
+	}
+	t.token = max(t.token+1, uint64(now.UnixNano()))
+	held = heldLock{owner: owner, token: t.token, expires: now.Add(ttl)}
+	t.locks[name] = held
+	return held, true
+}
+
+// release frees the lock if it is held by the owner with the token, it reports whether the lock was held at all
+func (t *lockTable) release(name, owner string, token uint64, now time.Time) (held bool, released bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.locks[name]
+	if !ok || !now.Before(l.expires) {
+		return false, false
+	}
+	if l.owner != owner || l.token != token {
+		return true, false
+	}
+	delete(t.locks, name)
+	return true, true
+}
+
+// LockAcquireHandler acquires a lock for the owner and answers with the fencing token
+// it answers 409 with the code LOCKED while another owner holds the lock and it has not expired
+func (kv *KeyValueStore) LockAcquireHandler(w http.ResponseWriter, r *http.Request) {
+	var payload LockAcquireRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	if payload.Name == "" || payload.Owner == "" {
+		http.Error(w, "Name and owner are required", http.StatusBadRequest)
+		return
+	}
+	ttl, err := time.ParseDuration(payload.TTL)
+	if err != nil || ttl <= 0 || ttl > maxLockTTL {
+		http.Error(w, fmt.Sprintf("TTL must be a duration between 0 and %v", maxLockTTL), http.StatusBadRequest)
+		return
+	}
+
+	held, ok := kv.locks.acquire(payload.Name, payload.Owner, ttl, kv.now())
+	if !ok {
+		writeError(w, http.StatusConflict, "LOCKED", fmt.Sprintf("lock %s is held by %s until %s", payload.Name, held.owner, held.expires.UTC().Format(time.RFC3339)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, kv.root.jsonCase, LockResponse{Token: held.token, ExpiresAt: held.expires.UTC()})
+}
+
+// LockReleaseHandler releases a lock held by the owner with the token
+// it answers 404 if the lock is not held or expired and 409 with the code NOT_OWNER if it is held by someone else or with another token
+func (kv *KeyValueStore) LockReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	var payload LockReleaseRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	held, released := kv.locks.release(payload.Name, payload.Owner, payload.Token, kv.now())
+	if !held {
+		http.Error(w, "Lock not held", http.StatusNotFound)
+		return
+	}
+	if !released {
+		writeError(w, http.StatusConflict, "NOT_OWNER", fmt.Sprintf("lock %s is not held by %s with token %d", payload.Name, payload.Owner, payload.Token))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// acquireLock sends an acquire request and returns the status and the token if the lock was acquired
+func acquireLock(t *testing.T, handler http.Handler, name, owner, ttl string) (int, uint64) {
+	t.Helper()
+	w := serveNamespace(handler, "", http.MethodPost, "/lock/acquire", `{"name":"`+name+`","owner":"`+owner+`","ttl":"`+ttl+`"}`)
+	if w.Code != http.StatusOK {
+		return w.Code, 0
+	}
+	var response LockResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, response.Token
+}
+
+// releaseLock sends a release request and returns the status
+func releaseLock(handler http.Handler, name, owner string, token uint64) int {
+	body := `{"name":"` + name + `","owner":"` + owner + `","token":` + strconv.FormatUint(token, 10) + `}`
+	return serveNamespace(handler, "", http.MethodPost, "/lock/release", body).Code
+}
+
+func TestKeyValueStore_Lock(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	kv.clock = func() time.Time { return now }
+	env := Config{}
+	handler := env.routes(kv)
+
+	code, first := acquireLock(t, handler, "job-x", "worker-1", "30s")
+	if code != http.StatusOK {
+		t.Fatalf("acquire: expected status %v but got %v", http.StatusOK, code)
+	}
+	if code, _ := acquireLock(t, handler, "job-x", "worker-2", "30s"); code != http.StatusConflict {
+		t.Errorf("acquire of a held lock: expected status %v but got %v", http.StatusConflict, code)
+	}
+	if code, token := acquireLock(t, handler, "job-x", "worker-1", "30s"); code != http.StatusOK || token != first {
+		t.Errorf("acquire by the holder: expected the lock to be extended with token %d but got %v %d", first, code, token)
+	}
+
+	// a crashed holder does not wedge the lock, it expires after its TTL
+	now = now.Add(31 * time.Second)
+	code, second := acquireLock(t, handler, "job-x", "worker-2", "30s")
+	if code != http.StatusOK || second <= first {
+		t.Fatalf("acquire of an expired lock: expected a token greater than %d but got %v %d", first, code, second)
+	}
+
+	if code := releaseLock(handler, "job-x", "worker-1", first); code != http.StatusConflict {
+		t.Errorf("release by the previous holder: expected status %v but got %v", http.StatusConflict, code)
+	}
+	if code := releaseLock(handler, "job-x", "worker-2", second); code != http.StatusNoContent {
+		t.Errorf("release: expected status %v but got %v", http.StatusNoContent, code)
+	}
+	if code := releaseLock(handler, "job-x", "worker-2", second); code != http.StatusNotFound {
+		t.Errorf("release of a free lock: expected status %v but got %v", http.StatusNotFound, code)
+	}
+}
+
+func TestKeyValueStore_Lock_Invalid(t *testing.T) {
+	env := Config{}
+	handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+	for _, ttl := range []string{"", "0s", "-1s", "soon", "25h"} {
+		if code, _ := acquireLock(t, handler, "job-x", "worker-1", ttl); code != http.StatusBadRequest {
+			t.Errorf("ttl %q: expected status %v but got %v", ttl, http.StatusBadRequest, code)
+		}
+	}
+	if code, _ := acquireLock(t, handler, "job-x", "", "30s"); code != http.StatusBadRequest {
+		t.Errorf("without owner: expected status %v but got %v", http.StatusBadRequest, code)
+	}
+}
+
+func TestKeyValueStore_Lock_Concurrent(t *testing.T) {
+	env := Config{}
+	handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+	const workers, rounds = 8, 10
+	var (
+		holders atomic.Int32
+		mu      sync.Mutex
+		tokens  []uint64
+		wg      sync.WaitGroup
+	)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			owner := "worker-" + strconv.Itoa(i)
+			for range rounds {
+				code, token := acquireLock(t, handler, "job-x", owner, "1m")
+				for code == http.StatusConflict {
+					code, token = acquireLock(t, handler, "job-x", owner, "1m")
+				}
+				if code != http.StatusOK {
+					t.Errorf("acquire: expected status %v but got %v", http.StatusOK, code)
+					return
+				}
+
+				if n := holders.Add(1); n != 1 {
+					t.Errorf("expected a single holder but got %d", n)
+				}
+				mu.Lock()
+				tokens = append(tokens, token)
+				mu.Unlock()
+				holders.Add(-1)
+
+				if code := releaseLock(handler, "job-x", owner, token); code != http.StatusNoContent {
+					t.Errorf("release: expected status %v but got %v", http.StatusNoContent, code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the tokens were recorded while holding the lock, so they are in acquisition order
+	if len(tokens) != workers*rounds {
+		t.Fatalf("expected %d acquisitions but got %d", workers*rounds, len(tokens))
+	}
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i] <= tokens[i-1] {
+			t.Fatalf("expected increasing fencing tokens but got %d after %d", tokens[i], tokens[i-1])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package kvservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// go run . --lock-wait-timeout 200ms
+// curl -i -d '{"key":"key1"}' http://localhost:8080/get
+// HTTP/1.1 503 Service Unavailable
+// Retry-After: 1
+// {"code":"LOCK_TIMEOUT","message":"timed out waiting for the lock of the key"}
+
+// errLockTimeout is returned when a handler waited longer than the lock wait timeout for the lock of a shard
+var errLockTimeout = errors.New("timed out waiting for the lock of the key")
+
+// lockTimeoutRetryAfter is the Retry-After in seconds of a request that timed out waiting for a lock
+const lockTimeoutRetryAfter = 1
+
+// SetLockWaitTimeout makes the handlers of /get, /set, /setnx, /pop and /kv/ of all namespaces answer 503 instead of waiting longer than d
+// for the lock of a shard, e.g. while a long write holds it, so requests do not pile up behind it; 0 waits until the request ends
+// it must be called before the store is served
+func (kv *KeyValueStore) SetLockWaitTimeout(d time.Duration) {
+	kv.root.lockWaitTimeout = d
+}
+
+// LockTimeouts returns the number of requests of all namespaces answered 503 because they timed out waiting for a lock
+func (kv *KeyValueStore) LockTimeouts() uint64 {
+	return kv.root.lockTimeouts.Load()
+}
+
+// newLockWaits returns the histogram of the time the handlers waited for the lock of a shard
+func newLockWaits() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kv_lock_wait_seconds",
+		Help:    "Time the handlers waited for the lock of a shard, including the waits that timed out.",
+		Buckets: []float64{.0001, .001, .01, .05, .1, .25, .5, 1, 5},
+	})
+}
+
+// lockContext write locks the shard, it gives up with errLockTimeout after the lock wait timeout or with the error of ctx once it is done
+func (s *shard) lockContext(ctx context.Context) error {
+	return s.acquire(ctx, s.TryLock, s.Lock, s.Unlock)
+}
+
+// lockGetContext locks the shard like lockGet, it gives up like lockContext
+func (s *shard) lockGetContext(ctx context.Context) error {
+	if s.lru != nil {
+		return s.lockContext(ctx)
+	}
+	return s.acquire(ctx, s.TryRLock, s.RLock, s.RUnlock)
+}
+
+// acquire takes the lock with tryLock if it is free and otherwise waits for lock in a goroutine until the lock wait timeout or ctx end the wait
+// a lock the goroutine gets after the caller gave up is released right away
+// without a timeout only ctx ends the wait, a context that is never done waits without a goroutine
+func (s *shard) acquire(ctx context.Context, tryLock func() bool, lock, unlock func()) error {
+	root := s.kv.root
+	if tryLock() {
+		root.observeLockWait(0)
+		return nil
+	}
+
+	start := time.Now()
+	defer func() { root.observeLockWait(time.Since(start)) }()
+	if root.lockWaitTimeout <= 0 && ctx.Done() == nil {
+		lock()
+		return nil
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		lock()
+		close(acquired)
+	}()
+	var timeout <-chan time.Time
+	if root.lockWaitTimeout > 0 {
+		timer := time.NewTimer(root.lockWaitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-acquired:
+		return nil
+	case <-timeout:
+		root.lockTimeouts.Add(1)
+		err = errLockTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	go func() {
+		<-acquired
+		unlock()
+	}()
+	return err
+}
+
+// observeLockWait records the wait for a lock, stores built by newKeyValueStore without NewKeyValueStore have no histogram
+func (kv *KeyValueStore) observeLockWait(d time.Duration) {
+	if kv.lockWaits != nil {
+		kv.lockWaits.Observe(d.Seconds())
+	}
+}
+
+// writeLockError answers a request that gave up waiting for a lock, a timeout with 503 and a Retry-After header
+func writeLockError(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, errLockTimeout) {
+		writeContextError(w, r, err)
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(lockTimeoutRetryAfter))
+	writeError(w, http.StatusServiceUnavailable, "LOCK_TIMEOUT", err.Error())
+}
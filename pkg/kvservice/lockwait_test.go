@@ -0,0 +1,155 @@
+package kvservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyValueStore_LockWaitTimeout(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetLockWaitTimeout(50 * time.Millisecond)
+	handler := (&Config{}).routes(kv)
+	writeTestValue(kv, "key", "value")
+
+	// a long write holds the lock of the key, e.g. a large value being compressed
+	s := kv.shard("key")
+	s.Lock()
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/get", `{"key":"key"}`},
+		{http.MethodPost, "/set", `{"key":"key","value":"new"}`},
+		{http.MethodPost, "/setnx", `{"key":"key","value":"new"}`},
+		{http.MethodPost, "/pop", `{"key":"key"}`},
+		{http.MethodGet, "/kv/key", ""},
+		{http.MethodPut, "/kv/key", "new"},
+		{http.MethodDelete, "/kv/key", ""},
+	} {
+		start := time.Now()
+		w := serveNamespace(handler, "", req.method, req.path, req.body)
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "LOCK_TIMEOUT") {
+			t.Errorf("%s %s: expected status %v with code LOCK_TIMEOUT but got %v %s", req.method, req.path, http.StatusServiceUnavailable, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("%s %s: expected Retry-After 1 but got %q", req.method, req.path, got)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s %s: expected the request to give up after the lock wait timeout but it took %v", req.method, req.path, elapsed)
+		}
+	}
+	if n := kv.LockTimeouts(); n != 7 {
+		t.Errorf("expected 7 lock timeouts but got %d", n)
+	}
+	s.Unlock()
+
+	// the waits that gave up released the lock once they got it
+	if w := serveNamespace(handler, "", http.MethodPost, "/get", `{"key":"key"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"value"`) {
+		t.Errorf("expected the unchanged value once the lock is free but got %v %s", w.Code, w.Body.String())
+	}
+	if w := serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"key","value":"new"}`); w.Code != http.StatusOK {
+		t.Errorf("expected the set to succeed once the lock is free but got %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestKeyValueStore_LockWaitTimeout_ReadersWaitForShortLocks(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetLockWaitTimeout(time.Second)
+	writeTestValue(kv, "key", "value")
+
+	s := kv.shard("key")
+	s.Lock()
+	time.AfterFunc(50*time.Millisecond, s.Unlock)
+
+	w := httptest.NewRecorder()
+	kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"key"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the reader to get the lock within the timeout but got %v %s", w.Code, w.Body.String())
+	}
+	if n := kv.LockTimeouts(); n != 0 {
+		t.Errorf("expected no lock timeouts but got %d", n)
+	}
+}
+
+func TestKeyValueStore_LockWaitTimeout_ClientGone(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetLockWaitTimeout(time.Minute)
+
+	s := kv.shard("key")
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"key"}`)).WithContext(ctx))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "DEADLINE_EXCEEDED") {
+		t.Errorf("expected the deadline of the request to end the wait but got %v %s", w.Code, w.Body.String())
+	}
+	if n := kv.LockTimeouts(); n != 0 {
+		t.Errorf("expected the deadline not to count as a lock timeout but got %d", n)
+	}
+}
+
+func TestKeyValueStore_ClientGone_WithoutLockWaitTimeout(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	handler := (&Config{}).routes(kv)
+	writeTestValue(kv, "key", "value")
+
+	// without a lock wait timeout only the end of the request stops the wait for a held lock
+	s := kv.shard("key")
+	s.Lock()
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/get", `{"key":"key"}`},
+		{http.MethodPost, "/set", `{"key":"key","value":"new"}`},
+		{http.MethodPost, "/setnx", `{"key":"key","value":"new"}`},
+		{http.MethodPost, "/pop", `{"key":"key"}`},
+		{http.MethodGet, "/kv/key", ""},
+		{http.MethodPut, "/kv/key", "new"},
+		{http.MethodDelete, "/kv/key", ""},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)).WithContext(ctx)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(w, r)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s %s: expected the cancelled request to return promptly but it took %v", req.method, req.path, elapsed)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("%s %s: expected no response to a client that went away but got %v %s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+	s.Unlock()
+
+	// the abandoned waits released the lock once they got it
+	if w := serveNamespace(handler, "", http.MethodPost, "/get", `{"key":"key"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"value"`) {
+		t.Errorf("expected the unchanged value once the lock is free but got %v %s", w.Code, w.Body.String())
+	}
+
+	// a full walk like /keys checks the context before each shard
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/keys", nil).WithContext(ctx))
+	if w.Body.Len() != 0 {
+		t.Errorf("expected /keys to stop for a client that went away but got %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMetricsHandler_LockWaits(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	writeTestValue(kv, "key", "value")
+	kv.GetHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"key"}`)))
+
+	w := httptest.NewRecorder()
+	NewMetricsHandler(kv).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{"kv_lock_wait_seconds_count 1", "kv_lock_timeouts_total 0"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected the metrics to contain %q", want)
+		}
+	}
+}
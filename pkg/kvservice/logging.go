@@ -0,0 +1,204 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// curl http://localhost:8080/admin/loglevel
+// curl -X PUT -d '{"level": "debug"}' http://localhost:8080/admin/loglevel
+// curl -d '{"level": "warn"}' http://localhost:8080/admin/loglevel
+
+// logLevel is the threshold of the default logger, it can be changed at runtime via /admin/loglevel
+var logLevel slog.LevelVar
+
+// NewLogger returns a text logger writing to w whose level follows the configured log level
+func NewLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: &logLevel}))
+}
+
+// OpenLogOutput opens the destination named by --log-output, stdout, stderr or a file that is appended to, empty means stderr
+// closing it closes the file but never the standard streams
+func OpenLogOutput(output string) (io.WriteCloser, error) {
+	switch output {
+	case "", "stderr":
+		return nopCloser{os.Stderr}, nil
+	case "stdout":
+		return nopCloser{os.Stdout}, nil
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open log output: %w", err)
+	}
+	return f, nil
+}
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler returns the current log level on GET and changes it on PUT or POST
+func LogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var payload LogLevelRequest
+		if !decodeRequest(w, r, &payload) {
+			return
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(payload.Level)); err != nil {
+			http.Error(w, "Invalid log level, use debug, info, warn or error", http.StatusBadRequest)
+			return
+		}
+
+		previous := logLevel.Level()
+		logLevel.Set(level)
+		slog.Warn("log level changed", "from", previous, "to", level)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevelResponse{Level: strings.ToLower(logLevel.Level().String())})
+}
+
+// redactedHeaders are the headers MiddlewareLogRequest never logs the values of, they carry credentials
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// HeaderLogging decides whether and how MiddlewareLogRequest logs the request headers
+type HeaderLogging struct {
+	Enabled bool
+	// Redact are the names of headers redacted in addition to redactedHeaders
+	Redact []string
+	// MaxLength cuts off longer values, 0 means no limit
+	MaxLength int
+}
+
+// value returns the header value as it is logged
+func (h HeaderLogging) value(name, value string) string {
+	matches := func(redacted string) bool { return strings.EqualFold(name, redacted) }
+	if slices.ContainsFunc(redactedHeaders, matches) || slices.ContainsFunc(h.Redact, matches) {
+		return "[REDACTED]"
+	}
+	if h.MaxLength > 0 && len(value) > h.MaxLength {
+		return value[:h.MaxLength] + "...[TRUNCATED]"
+	}
+	return value
+}
+
+// MiddlewareLogRequest logs the request method and URL path, and the headers if they are enabled
+// the values of credential headers are redacted and long values are cut off
+func MiddlewareLogRequest(headers HeaderLogging, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+
+		if headers.Enabled {
+			for name, values := range r.Header {
+				for _, value := range values {
+					slog.Debug("header", "name", name, "value", headers.value(name, value))
+				}
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// slowRequestHead is the number of bytes of a request body kept to find the key of a slow request
+const slowRequestHead = 1024
+
+// MiddlewareSlowRequest logs the requests taking longer than threshold at warn level, a threshold of 0 or less disables it
+// it is independent of the logging middleware so slow requests are logged even if the others are not
+// long polls like /changes?wait= are slow on purpose and not logged
+func MiddlewareSlowRequest(threshold time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if threshold <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &headReader{ReadCloser: r.Body}
+		r.Body = body
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r)
+
+		elapsed := time.Since(start)
+		if elapsed <= threshold || sw.waited {
+			return
+		}
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"duration", elapsed,
+			"status", sw.status,
+			"request_bytes", body.n,
+			"response_bytes", sw.bytes,
+			"remote_addr", r.RemoteAddr,
+		}
+		if key, ok := requestKey(r, body.head); ok {
+			attrs = append(attrs, "key", key)
+		}
+		if id := r.Header.Get("X-Request-ID"); id != "" {
+			attrs = append(attrs, "request_id", id)
+		}
+		slog.Warn("slow request", attrs...)
+	}
+}
+
+// headReader keeps the first slowRequestHead bytes read from the body and counts all of them
+type headReader struct {
+	io.ReadCloser
+	head []byte
+	n    int64
+}
+
+func (h *headReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.n += int64(n)
+	if keep := min(n, slowRequestHead-len(h.head)); keep > 0 {
+		h.head = append(h.head, p[:keep]...)
+	}
+	return n, err
+}
+
+// requestKey returns the key of the request, taken from the path of the /kv/ API or the key field of the JSON body
+// the body may be cut off, so it is read token by token up to the key
+func requestKey(r *http.Request, head []byte) (string, bool) {
+	if key, ok := strings.CutPrefix(r.URL.Path, "/kv/"); ok && key != "" {
+		return key, true
+	}
+	dec := json.NewDecoder(bytes.NewReader(head))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return "", false
+	}
+	for dec.More() {
+		name, err := dec.Token()
+		if err != nil {
+			return "", false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return "", false
+		}
+		var key string
+		if name == "key" && json.Unmarshal(value, &key) == nil {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,238 @@
+package kvservice
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	defer logLevel.Set(logLevel.Level())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: &logLevel})))
+	logLevel.Set(slog.LevelInfo)
+
+	env := Config{}
+	handler := env.adminRoutes(NewKeyValueStore(StoreOptions{}))
+	do := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/admin/loglevel", strings.NewReader(body)))
+		return w
+	}
+
+	slog.Debug("before the change")
+	if strings.Contains(buf.String(), "before the change") {
+		t.Errorf("expected debug line to be suppressed at info level but got %v", buf.String())
+	}
+
+	if w := do(http.MethodGet, ""); strings.TrimSpace(w.Body.String()) != `{"level":"info"}` {
+		t.Errorf("expected level info but got %v", w.Body.String())
+	}
+
+	if w := do(http.MethodPut, `{"level":"verbose"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %v for an invalid level but got %v", http.StatusBadRequest, w.Code)
+	}
+
+	w := do(http.MethodPut, `{"level":"debug"}`)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"level":"debug"}` {
+		t.Fatalf("expected level debug but got %v %v", w.Code, w.Body.String())
+	}
+
+	slog.Debug("after the change")
+	if !strings.Contains(buf.String(), "after the change") {
+		t.Errorf("expected debug line to be logged at debug level but got %v", buf.String())
+	}
+
+	if w := do(http.MethodGet, ""); strings.TrimSpace(w.Body.String()) != `{"level":"debug"}` {
+		t.Errorf("expected level debug but got %v", w.Body.String())
+	}
+
+	if w := do(http.MethodPost, `{"level":"warn"}`); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"level":"warn"}` {
+		t.Errorf("expected POST to set level warn but got %v %v", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, ""); strings.TrimSpace(w.Body.String()) != `{"level":"warn"}` {
+		t.Errorf("expected level warn but got %v", w.Body.String())
+	}
+	if w := do(http.MethodDelete, ""); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, PUT, POST" {
+		t.Errorf("expected status %v but got %v %q", http.StatusMethodNotAllowed, w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestMiddlewareLogRequest_Level(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	defer logLevel.Set(logLevel.Level())
+	slog.SetDefault(NewLogger(&buf))
+
+	handler := MiddlewareLogRequest(HeaderLogging{Enabled: true}, func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		level      slog.Level
+		wantLogged bool
+	}{
+		{level: slog.LevelInfo, wantLogged: false},
+		{level: slog.LevelDebug, wantLogged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			buf.Reset()
+			logLevel.Set(tt.level)
+			req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+			req.Header.Set("X-Request-Id", "abc")
+			handler(httptest.NewRecorder(), req)
+			logged := strings.Contains(buf.String(), "path=/keys") && strings.Contains(buf.String(), "value=abc")
+			if logged != tt.wantLogged {
+				t.Errorf("expected request logged %v at level %v but got %q", tt.wantLogged, tt.level, buf.String())
+			}
+		})
+	}
+}
+
+func TestMiddlewareLogRequest_Headers(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	defer logLevel.Set(logLevel.Level())
+	slog.SetDefault(NewLogger(&buf))
+	logLevel.Set(slog.LevelDebug)
+
+	long := strings.Repeat("x", 20)
+	tests := []struct {
+		name    string
+		headers HeaderLogging
+		header  string
+		value   string
+		want    string
+	}{
+		{name: "disabled", header: "X-Request-Id", value: "abc"},
+		{name: "logged", headers: HeaderLogging{Enabled: true}, header: "X-Request-Id", value: "abc", want: "value=abc"},
+		{name: "authorization", headers: HeaderLogging{Enabled: true}, header: "Authorization", value: "Bearer hunter2", want: "value=[REDACTED]"},
+		{name: "cookie", headers: HeaderLogging{Enabled: true}, header: "Cookie", value: "session=hunter2", want: "value=[REDACTED]"},
+		{name: "api key", headers: HeaderLogging{Enabled: true}, header: "X-API-Key", value: "hunter2", want: "value=[REDACTED]"},
+		{name: "configured", headers: HeaderLogging{Enabled: true, Redact: []string{"x-tenant-token"}}, header: "X-Tenant-Token", value: "hunter2", want: "value=[REDACTED]"},
+		{name: "truncated", headers: HeaderLogging{Enabled: true, MaxLength: 8}, header: "User-Agent", value: long, want: "value=xxxxxxxx...[TRUNCATED]"},
+		{name: "short enough", headers: HeaderLogging{Enabled: true, MaxLength: 20}, header: "User-Agent", value: long, want: "value=" + long},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+			req.Header.Set(tt.header, tt.value)
+			MiddlewareLogRequest(tt.headers, func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), req)
+
+			logged := buf.String()
+			if !strings.Contains(logged, "path=/keys") {
+				t.Errorf("expected the request to be logged but got %q", logged)
+			}
+			if strings.Contains(logged, "hunter2") {
+				t.Errorf("expected the credential to be redacted but got %q", logged)
+			}
+			if tt.want == "" {
+				if strings.Contains(logged, "msg=header") {
+					t.Errorf("expected no headers logged but got %q", logged)
+				}
+				return
+			}
+			if !strings.Contains(logged, tt.want+"\n") {
+				t.Errorf("expected %q in %q", tt.want, logged)
+			}
+		})
+	}
+}
+
+func TestOpenLogOutput(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	defer logLevel.Set(logLevel.Level())
+	logLevel.Set(slog.LevelWarn)
+
+	path := filepath.Join(t.TempDir(), "service.log")
+	out, err := OpenLogOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.SetDefault(NewLogger(out))
+	slog.Info("below the threshold")
+	slog.Error("above the threshold")
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "below the threshold") || !strings.Contains(string(data), "level=ERROR msg=\"above the threshold\"") {
+		t.Errorf("expected only the error to be logged to the file but got %q", data)
+	}
+
+	if _, err := OpenLogOutput(filepath.Join(t.TempDir(), "missing", "service.log")); err == nil {
+		t.Error("expected an error for a file in a missing directory")
+	}
+}
+
+func TestMiddlewareSlowRequest(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(NewLogger(&buf))
+
+	const threshold = 20 * time.Millisecond
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		path    string
+		body    string
+		wantLog bool
+		wantKey string
+	}{
+		{name: "fast", path: "/set", body: `{"key":"a","value":"1"}`},
+		{name: "slow", delay: 2 * threshold, path: "/set", body: `{"value":"1","key":"a"}`, wantLog: true, wantKey: "key=a"},
+		{name: "slow kv", delay: 2 * threshold, path: "/kv/b", wantLog: true, wantKey: "key=b"},
+		{name: "slow without key", delay: 2 * threshold, path: "/flush", wantLog: true},
+		{name: "long poll", delay: 2 * threshold, path: "/changes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			handler := MiddlewareSlowRequest(threshold, func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				if r.URL.Path == "/changes" {
+					http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Minute))
+				}
+				time.Sleep(tt.delay)
+				io.WriteString(w, "202\n")
+			})
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("X-Request-ID", "req-1")
+			handler(httptest.NewRecorder(), req)
+
+			logged := buf.String()
+			if got := strings.Contains(logged, "level=WARN") && strings.Contains(logged, "slow request"); got != tt.wantLog {
+				t.Fatalf("expected slow request logged %v but got %q", tt.wantLog, logged)
+			}
+			if !tt.wantLog {
+				return
+			}
+			for _, want := range []string{"request_id=req-1", "status=200", "response_bytes=4", "request_bytes=" + strconv.Itoa(len(tt.body)), "duration="} {
+				if !strings.Contains(logged, want) {
+					t.Errorf("expected %q in %q", want, logged)
+				}
+			}
+			if tt.wantKey != "" && !strings.Contains(logged, tt.wantKey) {
+				t.Errorf("expected %q in %q", tt.wantKey, logged)
+			}
+			if tt.wantKey == "" && strings.Contains(logged, "key=") {
+				t.Errorf("expected no key in %q", logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package kvservice
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// curl -d '{"enabled":true,"retry_after":"120s"}' http://localhost:8081/admin/maintenance
+// curl -d '{"enabled":false}' http://localhost:8081/admin/maintenance
+
+// DefaultMaintenanceRetryAfter is how long clients are asked to wait during maintenance unless another time is configured
+const DefaultMaintenanceRetryAfter = 2 * time.Minute
+
+type MaintenanceRequest struct {
+	Enabled bool `json:"enabled"`
+	// RetryAfter is a duration like 120s, empty keeps the current one
+	RetryAfter string `json:"retry_after,omitempty"`
+}
+
+type MaintenanceResponse struct {
+	Enabled    bool   `json:"enabled"`
+	RetryAfter string `json:"retry_after"`
+}
+
+// SetMaintenance takes the service out of rotation without stopping it: while enabled the data endpoints of all namespaces answer 503
+// with a Retry-After header of retryAfter and the readiness probe fails, the probes, /stats and the admin endpoints keep working
+func (kv *KeyValueStore) SetMaintenance(enabled bool, retryAfter time.Duration) {
+	kv.root.maintenanceRetryAfter.Store(int64(retryAfter))
+	kv.root.maintenance.Store(enabled)
+}
+
+// Maintenance reports whether the service is in maintenance mode and how long clients are asked to wait
+func (kv *KeyValueStore) Maintenance() (bool, time.Duration) {
+	return kv.root.maintenance.Load(), time.Duration(kv.root.maintenanceRetryAfter.Load())
+}
+
+// MiddlewareMaintenance answers 503 with a Retry-After header while the service is in maintenance mode, it must wrap every data endpoint
+func (kv *KeyValueStore) MiddlewareMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if enabled, retryAfter := kv.Maintenance(); enabled {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+			writeError(w, http.StatusServiceUnavailable, "MAINTENANCE", "the service is in maintenance mode")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// retryAfterSeconds rounds the duration up to the whole seconds of a Retry-After header
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MaintenanceHandler returns the maintenance state on GET and changes it on POST
+func (kv *KeyValueStore) MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var payload MaintenanceRequest
+		if !decodeRequest(w, r, &payload) {
+			return
+		}
+		_, retryAfter := kv.Maintenance()
+		if payload.RetryAfter != "" {
+			var err error
+			if retryAfter, err = time.ParseDuration(payload.RetryAfter); err != nil || retryAfter < 0 {
+				http.Error(w, fmt.Sprintf("Retry after must be a duration that is not negative, got %q", payload.RetryAfter), http.StatusBadRequest)
+				return
+			}
+		}
+		kv.SetMaintenance(payload.Enabled, retryAfter)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	enabled, retryAfter := kv.Maintenance()
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, kv.root.jsonCase, MaintenanceResponse{Enabled: enabled, RetryAfter: retryAfter.String()})
+}
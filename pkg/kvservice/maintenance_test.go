@@ -0,0 +1,127 @@
+package kvservice
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyValueStore_MaintenanceHandler(t *testing.T) {
+	env := &Config{AdminAddress: "localhost:9090"}
+	kv := env.newStore()
+	data, admin := env.routes(kv), env.adminRoutes(kv)
+	writeTestValue(kv, "key", "value")
+
+	if w := serveNamespace(data, "", http.MethodPost, "/admin/maintenance", `{"enabled":true}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected maintenance mode to be switched on the admin listener only but got %v", w.Code)
+	}
+	w := serveNamespace(admin, "", http.MethodPost, "/admin/maintenance", `{"enabled":true,"retry_after":"90s"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `{"enabled":true,"retry_after":"1m30s"}`) {
+		t.Fatalf("unexpected response %v %s", w.Code, w.Body.String())
+	}
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/get", `{"key":"key"}`},
+		{http.MethodPost, "/set", `{"key":"key","value":"new"}`},
+		{http.MethodGet, "/kv/key", ""},
+		{http.MethodGet, "/keys", ""},
+		{http.MethodGet, "/ping", ""},
+	} {
+		w := serveNamespace(data, "", req.method, req.path, req.body)
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "MAINTENANCE") {
+			t.Errorf("%s %s: expected status %v with code MAINTENANCE but got %v %s", req.method, req.path, http.StatusServiceUnavailable, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Retry-After"); got != "90" {
+			t.Errorf("%s %s: expected Retry-After 90 but got %q", req.method, req.path, got)
+		}
+	}
+	for _, tt := range []struct {
+		path     string
+		wantCode int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+		{"/stats", http.StatusOK},
+		{"/admin/readonly", http.StatusOK},
+		{"/admin/maintenance", http.StatusOK},
+	} {
+		if w := serveNamespace(admin, "", http.MethodGet, tt.path, ""); w.Code != tt.wantCode {
+			t.Errorf("GET %s: expected status %v but got %v %s", tt.path, tt.wantCode, w.Code, w.Body.String())
+		}
+	}
+
+	// the retry after is kept when it is not given
+	w = serveNamespace(admin, "", http.MethodPost, "/admin/maintenance", `{"enabled":false}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `{"enabled":false,"retry_after":"1m30s"}`) {
+		t.Fatalf("unexpected response %v %s", w.Code, w.Body.String())
+	}
+	if w := serveNamespace(data, "", http.MethodPost, "/get", `{"key":"key"}`); w.Code != http.StatusOK {
+		t.Errorf("expected the data endpoints to serve again but got %v %s", w.Code, w.Body.String())
+	}
+	if w := serveNamespace(admin, "", http.MethodGet, "/readyz", ""); w.Code != http.StatusOK {
+		t.Errorf("expected the service to be ready again but got %v", w.Code)
+	}
+}
+
+func TestKeyValueStore_MaintenanceHandler_InvalidRetryAfter(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	handler := (&Config{}).routes(kv)
+	for _, retryAfter := range []string{"soon", "-1s"} {
+		w := serveNamespace(handler, "", http.MethodPost, "/admin/maintenance", `{"enabled":true,"retry_after":"`+retryAfter+`"}`)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %v but got %v", retryAfter, http.StatusBadRequest, w.Code)
+		}
+	}
+	if enabled, _ := kv.Maintenance(); enabled {
+		t.Error("expected a rejected request to leave maintenance mode off")
+	}
+}
+
+func TestConfig_Maintenance(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "maintenance: true\n")
+	env, err := ParseConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvStore := env.newStore()
+	if enabled, retryAfter := kvStore.Maintenance(); !enabled || retryAfter != DefaultMaintenanceRetryAfter {
+		t.Fatalf("expected maintenance mode from the config with the default retry after but got %v, %v", enabled, retryAfter)
+	}
+
+	// switched off via /admin/maintenance, a reload without a configured change must not undo it
+	kvStore.SetMaintenance(false, time.Minute)
+	if err := env.reload(kvStore); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, _ := kvStore.Maintenance(); enabled {
+		t.Error("expected the runtime change to survive the reload")
+	}
+
+	// only the setting whose configured value changed is applied
+	if err := os.WriteFile(path, []byte("maintenance: true\nmaintenance_retry_after: 5m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.reload(kvStore); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, retryAfter := kvStore.Maintenance(); enabled || retryAfter != 5*time.Minute {
+		t.Errorf("expected the reloaded retry after with maintenance mode still off but got %v, %v", enabled, retryAfter)
+	}
+	if err := os.WriteFile(path, []byte("maintenance: false\nmaintenance_retry_after: 5m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.reload(kvStore); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("maintenance: true\nmaintenance_retry_after: 5m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.reload(kvStore); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, _ := kvStore.Maintenance(); !enabled {
+		t.Error("expected the reloaded maintenance mode")
+	}
+}
@@ -0,0 +1,249 @@
+package kvservice
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// curl -X POST http://localhost:8080/metrics/reset
+
+// NewMetricsHandler returns a handler exposing the Prometheus metrics of the service and the given store summed over all its namespaces
+// every handler gets its own registry so multiple stores in one process don't collide
+func NewMetricsHandler(kvStore *KeyValueStore) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "kv_store_bytes",
+			Help: "Total size of all keys and values currently stored.",
+		}, func() float64 {
+			var bytes int64
+			for _, name := range kvStore.Namespaces() {
+				bytes += kvStore.Namespace(name).Bytes()
+			}
+			return float64(bytes)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "kv_store_evictions_total",
+			Help: "Total number of entries evicted to make room for new writes.",
+		}, func() float64 {
+			var evictions uint64
+			for _, name := range kvStore.Namespaces() {
+				evictions += kvStore.Namespace(name).Evictions()
+			}
+			return float64(evictions)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "kv_audit_records_dropped_total",
+			Help: "Total number of audit records dropped because the audit log could not keep up.",
+		}, func() float64 {
+			if a := kvStore.root.auditLog; a != nil {
+				return float64(a.Dropped())
+			}
+			return 0
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "kv_webhook_failures_total",
+			Help: "Total number of webhook notifications that could not be delivered in any attempt.",
+		}, func() float64 {
+			if w := kvStore.root.webhooks; w != nil {
+				return float64(w.Failures())
+			}
+			return 0
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "kv_webhook_notifications_dropped_total",
+			Help: "Total number of webhook notifications dropped because the receivers could not keep up.",
+		}, func() float64 {
+			if w := kvStore.root.webhooks; w != nil {
+				return float64(w.Dropped())
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "kv_http_open_connections",
+			Help: "Number of connections currently open on the server addresses.",
+		}, func() float64 {
+			return float64(kvStore.root.connections.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "kv_lock_timeouts_total",
+			Help: "Total number of requests answered 503 because they waited longer than the lock wait timeout for a lock.",
+		}, func() float64 {
+			return float64(kvStore.LockTimeouts())
+		}),
+		kvStore.root.lockWaits,
+		kvStore.root.requestDurations,
+		namespaceQuotaCollector{kvStore: kvStore},
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+var (
+	namespaceKeysDesc     = prometheus.NewDesc("kv_namespace_keys", "Number of keys in a namespace with a quota.", []string{"namespace"}, nil)
+	namespaceBytesDesc    = prometheus.NewDesc("kv_namespace_bytes", "Total size of all keys and values in a namespace with a quota.", []string{"namespace"}, nil)
+	namespaceMaxKeysDesc  = prometheus.NewDesc("kv_namespace_quota_max_keys", "Maximum number of keys of a namespace, 0 means unbounded.", []string{"namespace"}, nil)
+	namespaceMaxBytesDesc = prometheus.NewDesc("kv_namespace_quota_max_bytes", "Maximum total size of a namespace, 0 means unbounded.", []string{"namespace"}, nil)
+)
+
+// namespaceQuotaCollector exports the usage and limits of every namespace with a quota
+// namespaces without a quota are left out, their names are chosen by clients and would make the label unbounded
+type namespaceQuotaCollector struct {
+	kvStore *KeyValueStore
+}
+
+func (c namespaceQuotaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- namespaceKeysDesc
+	ch <- namespaceBytesDesc
+	ch <- namespaceMaxKeysDesc
+	ch <- namespaceMaxBytesDesc
+}
+
+func (c namespaceQuotaCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range c.kvStore.Namespaces() {
+		q := c.kvStore.Namespace(name).quota
+		if q == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(namespaceKeysDesc, prometheus.GaugeValue, float64(q.keys.Load()), name)
+		ch <- prometheus.MustNewConstMetric(namespaceBytesDesc, prometheus.GaugeValue, float64(q.bytes.Load()), name)
+		ch <- prometheus.MustNewConstMetric(namespaceMaxKeysDesc, prometheus.GaugeValue, float64(q.MaxKeys), name)
+		ch <- prometheus.MustNewConstMetric(namespaceMaxBytesDesc, prometheus.GaugeValue, float64(q.MaxBytes), name)
+	}
+}
+
+// trackConnection counts the open connections of a server, it is its http.Server.ConnState
+// a hijacked connection like a WebSocket is no longer the server's and stops counting, although it still takes a slot of max-connections until closed
+func (kv *KeyValueStore) trackConnection(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		kv.root.connections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		kv.root.connections.Add(-1)
+	}
+}
+
+// newRequestDurations returns the histogram of the request durations with the buckets in seconds, nil buckets are prometheus.DefBuckets
+// the route is the pattern an endpoint is registered with like /kv/, never the requested path, so the clients can not grow the labels
+func newRequestDurations(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kv_http_request_duration_seconds",
+		Help:    "Duration of the HTTP requests by route pattern, method and status class.",
+		Buckets: buckets,
+	}, []string{"route", "method", "status_class"})
+}
+
+// SetRequestDurationBuckets sets the buckets in seconds of the request duration histogram of all namespaces
+// it must be called before the store is served
+func (kv *KeyValueStore) SetRequestDurationBuckets(buckets []float64) {
+	kv.root.requestDurations = newRequestDurations(buckets)
+}
+
+// MiddlewareMetrics records the duration of the requests to the route in the histogram
+func MiddlewareMetrics(durations *prometheus.HistogramVec, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r)
+		durations.WithLabelValues(route, metricMethod(r.Method), statusClass(sw.status)).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricMethod returns the method as a label, methods the service does not know are reported as other to bound the label
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// statusClass returns the class of the status like 2xx, a handler that wrote nothing answered 200
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// statusWriter remembers the status written by a handler and counts the bytes of the body
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	// waited is set once the handler extended its write deadline, which it only does to wait on purpose like a long poll
+	waited bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// SetWriteDeadline is called through http.ResponseController, it is passed on to the underlying writer
+func (sw *statusWriter) SetWriteDeadline(deadline time.Time) error {
+	sw.waited = true
+	return http.NewResponseController(sw.ResponseWriter).SetWriteDeadline(deadline)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// reset zeroes the counters
+func (s *storeStats) reset() {
+	s.hits.Store(0)
+	s.misses.Store(0)
+	s.sets.Store(0)
+	s.deletes.Store(0)
+}
+
+// ResetMetrics zeroes the counters of all namespaces and of the audit log and webhooks, and empties the request duration histogram
+// gauges like kv_store_bytes describe the stored data and keep their value, Prometheus sees the reset as a counter restart
+func (kv *KeyValueStore) ResetMetrics() {
+	root := kv.root
+	root.requestDurations.Reset()
+	for _, name := range root.Namespaces() {
+		ns := root.Namespace(name)
+		ns.stats.reset()
+		ns.evictions.Store(0)
+	}
+	if a := root.auditLog; a != nil {
+		a.dropped.Store(0)
+	}
+	if w := root.webhooks; w != nil {
+		w.failures.Store(0)
+		w.dropped.Store(0)
+	}
+}
+
+// ResetMetricsHandler zeroes the counters reported by /metrics and /stats, e.g. between the runs of an integration test
+// the metrics stay registered, it answers 204 No Content
+func (kv *KeyValueStore) ResetMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	kv.ResetMetrics()
+	w.WriteHeader(http.StatusNoContent)
+}
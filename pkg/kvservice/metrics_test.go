@@ -0,0 +1,109 @@
+package kvservice
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueStore_ResetMetricsHandler(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxKeys: 2})
+	env := Config{}
+	handler := env.routes(kv)
+
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"a","value":"1"}`)
+	serveNamespace(handler, "team-a", http.MethodPost, "/set", `{"key":"a","value":"1"}`)
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"b","value":"2"}`) // evicts a
+	serveNamespace(handler, "", http.MethodPost, "/get", `{"key":"a"}`)
+	serveNamespace(handler, "", http.MethodPost, "/get", `{"key":"b"}`)
+	if stats := kv.Stats(); stats.Sets != 2 || stats.GetHits != 1 || stats.GetMisses != 1 || stats.Evictions != 1 {
+		t.Fatalf("expected the counters to count the requests but got %+v", stats)
+	}
+
+	if w := serveNamespace(handler, "", http.MethodGet, "/metrics/reset", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %v for a GET but got %v", http.StatusMethodNotAllowed, w.Code)
+	}
+	if w := serveNamespace(handler, "", http.MethodPost, "/metrics/reset", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %v but got %v", http.StatusNoContent, w.Code)
+	}
+
+	for _, namespace := range []string{DefaultNamespace, "team-a"} {
+		stats := kv.Namespace(namespace).Stats()
+		if stats.Sets != 0 || stats.GetHits != 0 || stats.GetMisses != 0 || stats.Deletes != 0 || stats.Evictions != 0 {
+			t.Errorf("%s: expected the counters to be zero but got %+v", namespace, stats)
+		}
+		// the data is left alone
+		if stats.Keys != 1 {
+			t.Errorf("%s: expected 1 key but got %d", namespace, stats.Keys)
+		}
+	}
+
+	// the metrics are still registered and report the reset counters
+	w := serveNamespace(handler, "", http.MethodGet, "/metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+	for _, want := range []string{"kv_store_evictions_total 0", "kv_store_bytes 4"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected the metrics to contain %q", want)
+		}
+	}
+	if strings.Contains(w.Body.String(), `route="/set"`) {
+		t.Error("expected the request durations before the reset to be gone")
+	}
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"c","value":"3"}`) // evicts b
+	if w := serveNamespace(handler, "", http.MethodGet, "/metrics", ""); !strings.Contains(w.Body.String(), "kv_store_evictions_total 1") {
+		t.Error("expected the counters to count again after the reset")
+	}
+}
+
+func TestMiddlewareMetrics(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{BasePath: "/api"}
+	handler := env.routes(kv)
+
+	serveNamespace(handler, "", http.MethodPost, "/api/set", `{"key":"a","value":"1"}`)
+	serveNamespace(handler, "", http.MethodPost, "/api/get", `{"key":"a"}`)
+	serveNamespace(handler, "", http.MethodPost, "/api/get", `{"key":"missing"}`)
+	serveNamespace(handler, "", http.MethodGet, "/api/kv/a", "")
+	serveNamespace(handler, "", http.MethodGet, "/api/kv/b", "")
+	serveNamespace(handler, "", "PROPFIND", "/api/kv/a", "")
+	serveNamespace(handler, "", http.MethodGet, "/healthz", "")
+
+	w := serveNamespace(handler, "", http.MethodGet, "/metrics", "")
+	for _, want := range []string{
+		`kv_http_request_duration_seconds_count{method="POST",route="/set",status_class="2xx"} 1`,
+		`kv_http_request_duration_seconds_count{method="POST",route="/get",status_class="2xx"} 1`,
+		`kv_http_request_duration_seconds_count{method="POST",route="/get",status_class="4xx"} 1`,
+		// every key is recorded under the pattern of its route
+		`kv_http_request_duration_seconds_count{method="GET",route="/kv/",status_class="2xx"} 1`,
+		`kv_http_request_duration_seconds_count{method="GET",route="/kv/",status_class="4xx"} 1`,
+		`kv_http_request_duration_seconds_count{method="other",route="/kv/",status_class="4xx"} 1`,
+		`kv_http_request_duration_seconds_count{method="GET",route="/healthz",status_class="2xx"} 1`,
+		`kv_http_request_duration_seconds_bucket{method="POST",route="/set",status_class="2xx",le="0.005"}`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected the metrics to contain %s", want)
+		}
+	}
+	if strings.Contains(w.Body.String(), `route="/kv/a"`) {
+		t.Error("expected no route label with a key")
+	}
+}
+
+func TestKeyValueStore_SetRequestDurationBuckets(t *testing.T) {
+	env := Config{MetricsBuckets: []float64{0.1, 1}}
+	kv := env.newStore()
+	handler := env.routes(kv)
+	serveNamespace(handler, "", http.MethodGet, "/ping", "")
+
+	w := serveNamespace(handler, "", http.MethodGet, "/metrics", "")
+	for _, le := range []string{"0.1", "1", "+Inf"} {
+		if want := `route="/ping",status_class="2xx",le="` + le + `"} 1`; !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected the metrics to contain %s", want)
+		}
+	}
+	if strings.Contains(w.Body.String(), `le="0.005"`) {
+		t.Error("expected only the configured buckets")
+	}
+}
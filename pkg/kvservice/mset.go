@@ -0,0 +1,187 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// curl -H 'Content-Type: application/json' -d '[{"key":"key1","value":"value1"},{"key":"key2","value":"dmFsdWUy","encoding":"base64"}]' http://localhost:8080/mset
+// curl -H 'Content-Type: application/json' -d '[{"key":"key1","value":"a"},{"key":"key1","value":"b"}]' 'http://localhost:8080/mset?duplicates=reject'
+
+const (
+	// DuplicatesLast writes the items of a batch in order, a key given twice ends up with its last value
+	DuplicatesLast = "last"
+	// DuplicatesReject answers 400 without writing anything if a key is given twice
+	DuplicatesReject = "reject"
+)
+
+// MSetItem is a key value pair of the batch written by the mset endpoint
+type MSetItem struct {
+	Key   Key   `json:"key"`
+	Value Value `json:"value"`
+	// Encoding is base64 for binary values, empty for plain strings
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// MSetResponse is the body returned by the mset endpoint
+type MSetResponse struct {
+	Written int `json:"written"`
+}
+
+// errSchemaViolation marks a value of a batch that does not match the value schema
+var errSchemaViolation = errors.New("schema violation")
+
+// mset writes the JSON array of MSetItems read from r and returns how many items were written
+// the items are decoded and written one at a time, each under the lock of its shard only, so a batch is never held in memory as a whole
+// the batch is not atomic, an invalid item stops it and the items before it stay written
+// applied is called for every item once it is written, also if it then failed to be logged to the write-ahead log
+// it stops with the error of ctx once ctx is done
+func (kv *KeyValueStore) mset(ctx context.Context, r io.Reader, applied func(key Key, valueSize int)) (int, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		if _, ok := bodyLimit(err); ok {
+			return 0, err
+		}
+		return 0, errors.New("the batch must be a JSON array of items")
+	}
+
+	n := 0
+	for dec.More() {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		var item MSetItem
+		if err := dec.Decode(&item); err != nil {
+			return n, fmt.Errorf("item %d: %w", n, err)
+		}
+		value, err := decodeEncoding(item.Value, item.Encoding)
+		if err != nil {
+			return n, fmt.Errorf("item %d: %w", n, err)
+		}
+		if err := kv.validateKey(item.Key); err != nil {
+			return n, fmt.Errorf("item %d: %w", n, err)
+		}
+		if err := kv.validateValue(value); err != nil {
+			return n, fmt.Errorf("item %d: %w: %w", n, errSchemaViolation, err)
+		}
+
+		s := kv.shard(item.Key)
+		s.Lock()
+		_, err = s.put(item.Key, value)
+		s.Unlock()
+		if writeApplied(err) {
+			applied(item.Key, len(value))
+		}
+		if err != nil {
+			return n, fmt.Errorf("item %d: %w", n, err)
+		}
+		n++
+	}
+	if _, err := dec.Token(); err != nil {
+		return n, fmt.Errorf("the batch is not terminated: %w", err)
+	}
+	return n, nil
+}
+
+// duplicateKeys returns the keys given more than once in the JSON array of MSetItems, sorted
+// a batch that is not an array of items has none, mset reports what is wrong with it
+func duplicateKeys(data []byte) []Key {
+	var items []struct {
+		Key Key `json:"key"`
+	}
+	if json.Unmarshal(data, &items) != nil {
+		return nil
+	}
+	counts := make(map[Key]int, len(items))
+	var duplicates []Key
+	for _, item := range items {
+		counts[item.Key]++
+		if counts[item.Key] == 2 {
+			duplicates = append(duplicates, item.Key)
+		}
+	}
+	slices.Sort(duplicates)
+	return duplicates
+}
+
+// MSetHandler writes a JSON array of key value pairs into the namespace and returns how many were written
+// it answers 400 for a malformed item, 422 for a value not matching the schema and 507 if a value does not fit
+// the items before the failing one stay written, the error says how many, use /txn to write keys atomically
+// by default a key given twice ends up with its last value, with duplicates=reject such a batch is answered 400 naming the keys before anything is written,
+// which needs the whole batch in memory
+func (kv *KeyValueStore) MSetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.requestNamespace(w, r, "")
+	if !ok {
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch r.URL.Query().Get("duplicates") {
+	case "", DuplicatesLast:
+	case DuplicatesReject:
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if err := readBody(buf, r.Body, r.ContentLength); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if duplicates := duplicateKeys(buf.Bytes()); len(duplicates) > 0 {
+			quoted := make([]string, len(duplicates))
+			for i, key := range duplicates {
+				quoted[i] = strconv.Quote(string(key))
+			}
+			writeError(w, http.StatusBadRequest, "DUPLICATE_KEYS", "The batch sets these keys more than once: "+strings.Join(quoted, ", "))
+			return
+		}
+		body = bytes.NewReader(buf.Bytes())
+	default:
+		http.Error(w, "Duplicates must be "+DuplicatesLast+" or "+DuplicatesReject, http.StatusBadRequest)
+		return
+	}
+
+	n, err := kv.mset(r.Context(), body, func(key Key, valueSize int) {
+		kv.audit(r, "set", key, valueSize, 0)
+	})
+	// the items written before a failing one stay written, they are synced as well
+	if syncErr := kv.syncLog(); err == nil {
+		err = syncErr
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		writeContextError(w, r, err)
+		return
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		_, tooLarge := bodyLimit(err)
+		switch {
+		case tooLarge:
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, ErrStoreFull):
+			status = http.StatusInsufficientStorage
+		case errors.Is(err, ErrWriteAheadLog):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, errSchemaViolation):
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, "Mset failed after "+strconv.Itoa(n)+" keys: "+err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MSetResponse{Written: n})
+}
@@ -0,0 +1,139 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyValueStore_MSetHandler(t *testing.T) {
+	const items = 10000
+	var body strings.Builder
+	body.WriteString("[")
+	for i := range items {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `{"key":"key-%d","value":"value-%d"}`, i, i)
+	}
+	body.WriteString("]")
+
+	kv := newTestStore(map[Key]Value{"key-0": "old", "kept": "value"})
+	w := httptest.NewRecorder()
+	kv.MSetHandler(w, httptest.NewRequest(http.MethodPost, "/mset", strings.NewReader(body.String())))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var got MSetResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Written != items {
+		t.Errorf("expected %d written items but got %d", items, got.Written)
+	}
+	values := testValues(kv)
+	if len(values) != items+1 || values["key-0"] != "value-0" || values["key-9999"] != "value-9999" || values["kept"] != "value" {
+		t.Errorf("expected every item written next to the existing key but got %d keys", len(values))
+	}
+}
+
+func TestKeyValueStore_MSetHandler_Streaming(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	pr, pw := io.Pipe()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		kv.MSetHandler(w, httptest.NewRequest(http.MethodPost, "/mset", pr))
+		done <- w
+	}()
+
+	// the first item is written while the rest of the batch has not been sent yet
+	io.WriteString(pw, `[{"key":"first","value":"1"},`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := kv.peek("first"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the first item to be written before the batch ends")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	io.WriteString(pw, `{"key":"second","value":"2"}]`)
+	pw.Close()
+
+	w := <-done
+	if w.Code != http.StatusOK || w.Body.String() != `{"written":2}`+"\n" {
+		t.Errorf("expected 2 written items but got %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestKeyValueStore_MSetHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+		// written are the keys written before the batch failed
+		written []Key
+	}{
+		{name: "not an array", body: `{"key":"a","value":"1"}`, wantCode: http.StatusBadRequest, wantBody: "JSON array"},
+		{name: "unknown field", body: `[{"key":"a","value":"1"},{"key":"b","val":"2"}]`, wantCode: http.StatusBadRequest, wantBody: "after 1 keys", written: []Key{"a"}},
+		{name: "unknown encoding", body: `[{"key":"a","value":"1","encoding":"hex"}]`, wantCode: http.StatusBadRequest, wantBody: "Unknown encoding hex"},
+		{name: "truncated", body: `[{"key":"a","value":"1"}`, wantCode: http.StatusBadRequest, wantBody: "after 1 keys", written: []Key{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := NewKeyValueStore(StoreOptions{})
+			w := httptest.NewRecorder()
+			kv.MSetHandler(w, httptest.NewRequest(http.MethodPost, "/mset", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("expected %v %q but got %v %q", tt.wantCode, tt.wantBody, w.Code, w.Body.String())
+			}
+			if keys := kv.Keys(); len(keys) != len(tt.written) {
+				t.Errorf("expected %v written but got %v", tt.written, keys)
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_MSetHandler_Duplicates(t *testing.T) {
+	duplicated := `[{"key":"b","value":"1"},{"key":"a","value":"2"},{"key":"b","value":"3"},{"key":"a","value":"4"},{"key":"b","value":"5"}]`
+	tests := []struct {
+		name     string
+		query    string
+		body     string
+		wantCode int
+		wantBody string
+		want     map[Key]Value
+	}{
+		{name: "last wins by default", body: duplicated, wantCode: http.StatusOK, wantBody: `{"written":5}`, want: map[Key]Value{"a": "4", "b": "5"}},
+		{name: "last wins", query: "?duplicates=last", body: duplicated, wantCode: http.StatusOK, wantBody: `{"written":5}`, want: map[Key]Value{"a": "4", "b": "5"}},
+		{name: "rejected", query: "?duplicates=reject", body: duplicated, wantCode: http.StatusBadRequest, wantBody: `"code":"DUPLICATE_KEYS","message":"The batch sets these keys more than once: \"a\", \"b\""`, want: map[Key]Value{}},
+		{name: "clean batch", query: "?duplicates=reject", body: `[{"key":"a","value":"1"},{"key":"b","value":"2"}]`, wantCode: http.StatusOK, wantBody: `{"written":2}`, want: map[Key]Value{"a": "1", "b": "2"}},
+		{name: "malformed batch", query: "?duplicates=reject", body: `{"key":"a"}`, wantCode: http.StatusBadRequest, wantBody: "JSON array", want: map[Key]Value{}},
+		{name: "unknown mode", query: "?duplicates=first", body: duplicated, wantCode: http.StatusBadRequest, wantBody: "Duplicates must be", want: map[Key]Value{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := NewKeyValueStore(StoreOptions{})
+			w := httptest.NewRecorder()
+			kv.MSetHandler(w, httptest.NewRequest(http.MethodPost, "/mset"+tt.query, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("expected %v %q but got %v %q", tt.wantCode, tt.wantBody, w.Code, w.Body.String())
+			}
+			if got := testValues(kv); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
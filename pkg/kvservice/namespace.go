@@ -0,0 +1,164 @@
+package kvservice
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// curl -H 'X-Namespace: team-a' -H 'Content-Type: application/json' -d '{"key":"key1","value":"value1"}' http://localhost:8080/set
+// curl -d '{"namespace":"team-a","key":"key1"}' http://localhost:8080/get
+
+// DefaultNamespace is the namespace of requests that do not name one
+const DefaultNamespace = "default"
+
+// DefaultMaxNamespaces is the default maximum number of namespaces besides the default namespace
+const DefaultMaxNamespaces = 1000
+
+// maxNamespaceLength bounds namespace names, they are chosen by clients and kept for the lifetime of the process
+const maxNamespaceLength = 64
+
+// validateNamespace accepts names of letters, digits, dots, dashes and underscores
+func validateNamespace(name string) error {
+	if name == "" || len(name) > maxNamespaceLength {
+		return fmt.Errorf("namespace must be between 1 and %d characters long", maxNamespaceLength)
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return fmt.Errorf("namespace %q may only contain letters, digits, '.', '-' and '_'", name)
+		}
+	}
+	return nil
+}
+
+// ErrTooManyNamespaces is returned when a write would create a namespace beyond the configured maximum
+var ErrTooManyNamespaces = errors.New("too many namespaces")
+
+// Namespace returns the store of the namespace, creating it on first use with the options of the default namespace
+// every namespace is a store of its own, so keys, counters and quotas are separate per namespace, the bounds of the options apply to all of them together
+// it is used to load data that was written before, so it never refuses a namespace, the handlers create them through createNamespace
+func (kv *KeyValueStore) Namespace(name string) *KeyValueStore {
+	ns, _ := kv.namespace(name, false)
+	return ns
+}
+
+// lookupNamespace returns the store of the namespace if it exists
+func (kv *KeyValueStore) lookupNamespace(name string) (*KeyValueStore, bool) {
+	root := kv.root
+	if name == DefaultNamespace {
+		return root, true
+	}
+	root.namespacesMu.RLock()
+	defer root.namespacesMu.RUnlock()
+	ns, ok := root.namespaces[name]
+	return ns, ok
+}
+
+// createNamespace is Namespace, returning ErrTooManyNamespaces instead of creating a namespace beyond the maximum
+func (kv *KeyValueStore) createNamespace(name string) (*KeyValueStore, error) {
+	return kv.namespace(name, true)
+}
+
+// namespace returns the store of the namespace, creating it on first use, limit refuses to create more than maxNamespaces
+func (kv *KeyValueStore) namespace(name string, limit bool) (*KeyValueStore, error) {
+	if ns, ok := kv.lookupNamespace(name); ok {
+		return ns, nil
+	}
+
+	root := kv.root
+	root.namespacesMu.Lock()
+	defer root.namespacesMu.Unlock()
+	if ns, ok := root.namespaces[name]; ok {
+		return ns, nil
+	}
+	if limit && root.maxNamespaces > 0 && len(root.namespaces) >= root.maxNamespaces {
+		return nil, ErrTooManyNamespaces
+	}
+	ns := root.detachedNamespace(name, len(root.shards))
+	if root.namespaces == nil {
+		root.namespaces = make(map[string]*KeyValueStore)
+	}
+	root.namespaces[name] = ns
+	return ns, nil
+}
+
+// detachedNamespace returns an empty store with n shards for the namespace that is not registered with the root
+func (kv *KeyValueStore) detachedNamespace(name string, n int) *KeyValueStore {
+	root := kv.root
+	ns := newKeyValueStore(root.options, n)
+	ns.name = name
+	ns.root = root
+	ns.setQuota()
+	return ns
+}
+
+// SetMaxNamespaces caps the number of namespaces the handlers create besides the default namespace, 0 means unbounded
+// namespaces loaded from a snapshot, the write-ahead log or a primary are not refused, they were created within the cap before
+func (kv *KeyValueStore) SetMaxNamespaces(n int) {
+	root := kv.root
+	root.namespacesMu.Lock()
+	defer root.namespacesMu.Unlock()
+	root.maxNamespaces = n
+}
+
+// Namespaces returns the names of all namespaces in use, sorted and including the default namespace
+func (kv *KeyValueStore) Namespaces() []string {
+	root := kv.root
+	root.namespacesMu.RLock()
+	names := make([]string, 0, len(root.namespaces)+1)
+	for name := range root.namespaces {
+		names = append(names, name)
+	}
+	root.namespacesMu.RUnlock()
+
+	names = append(names, DefaultNamespace)
+	slices.Sort(names)
+	return names
+}
+
+// requestNamespace returns the store of the namespace the request writes to, creating the namespace on first use
+// the namespace field of the request body takes precedence over the X-Namespace header, without either it is the default namespace
+// an invalid name is answered with 400 and a namespace beyond the maximum with 507, both return false
+func (kv *KeyValueStore) requestNamespace(w http.ResponseWriter, r *http.Request, field string) (*KeyValueStore, bool) {
+	name, ok := requestNamespaceName(w, r, field)
+	if !ok {
+		return nil, false
+	}
+	ns, err := kv.createNamespace(name)
+	if err != nil {
+		writeError(w, http.StatusInsufficientStorage, "TOO_MANY_NAMESPACES", fmt.Sprintf("namespace %s can not be created, the maximum of %d namespaces is reached", name, kv.root.maxNamespaces))
+		return nil, false
+	}
+	return ns, true
+}
+
+// readNamespace is requestNamespace for requests that only read, it never creates a namespace
+// a namespace that does not exist is read as an empty store, so its keys are missing and its listings are empty
+func (kv *KeyValueStore) readNamespace(w http.ResponseWriter, r *http.Request, field string) (*KeyValueStore, bool) {
+	name, ok := requestNamespaceName(w, r, field)
+	if !ok {
+		return nil, false
+	}
+	if ns, ok := kv.lookupNamespace(name); ok {
+		return ns, true
+	}
+	// it holds nothing, a single shard is enough
+	return kv.detachedNamespace(name, 1), true
+}
+
+// requestNamespaceName returns the name of the namespace the request operates in, an invalid name is answered with 400 and returns false
+func requestNamespaceName(w http.ResponseWriter, r *http.Request, field string) (string, bool) {
+	name := field
+	if name == "" {
+		name = r.Header.Get("X-Namespace")
+	}
+	if name == "" {
+		return DefaultNamespace, true
+	}
+	if err := validateNamespace(name); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_NAMESPACE", err.Error())
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,204 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveNamespace sends the request to the routes of a store with the X-Namespace header set if namespace is not empty
+func serveNamespace(handler http.Handler, namespace, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if namespace != "" {
+		r.Header.Set("X-Namespace", namespace)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestKeyValueStore_Namespaces(t *testing.T) {
+	env := Config{}
+	kv := NewKeyValueStore(StoreOptions{})
+	handler := env.routes(kv)
+
+	for namespace, value := range map[string]string{"": "default", "team-a": "a", "team-b": "b"} {
+		if w := serveNamespace(handler, namespace, http.MethodPost, "/set", `{"key":"key","value":"`+value+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("set in %q: expected status %v but got %v", namespace, http.StatusOK, w.Code)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		body      string
+		want      string
+	}{
+		{name: "default namespace", body: `{"key":"key"}`, want: "default"},
+		{name: "default namespace by name", namespace: DefaultNamespace, body: `{"key":"key"}`, want: "default"},
+		{name: "header", namespace: "team-a", body: `{"key":"key"}`, want: "a"},
+		{name: "field", body: `{"key":"key","namespace":"team-b"}`, want: "b"},
+		{name: "field over header", namespace: "team-a", body: `{"key":"key","namespace":"team-b"}`, want: "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveNamespace(handler, tt.namespace, http.MethodPost, "/get", tt.body)
+			var response GetResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil || string(response.Value) != tt.want {
+				t.Errorf("expected %q but got %v %q", tt.want, w.Code, response.Value)
+			}
+		})
+	}
+
+	if got, want := kv.Namespaces(), []string{DefaultNamespace, "team-a", "team-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected namespaces %v but got %v", want, got)
+	}
+}
+
+func TestKeyValueStore_Namespaces_Flush(t *testing.T) {
+	env := Config{}
+	kv := NewKeyValueStore(StoreOptions{})
+	handler := env.routes(kv)
+
+	for _, namespace := range []string{"team-a", "team-b"} {
+		for _, key := range []string{"k1", "k2"} {
+			serveNamespace(handler, namespace, http.MethodPost, "/set", `{"key":"`+key+`","value":"`+namespace+`"}`)
+		}
+	}
+
+	w := serveNamespace(handler, "team-a", http.MethodPost, "/flush", "")
+	var flushed FlushResponse
+	if err := json.NewDecoder(w.Body).Decode(&flushed); err != nil || flushed.Deleted != 2 {
+		t.Fatalf("expected 2 deleted keys but got %v %s", w.Code, w.Body.String())
+	}
+
+	if n := kv.Namespace("team-a").Len(); n != 0 {
+		t.Errorf("expected the flushed namespace to be empty but it holds %d keys", n)
+	}
+	want := map[Key]Value{"k1": "team-b", "k2": "team-b"}
+	if got := testValues(kv.Namespace("team-b")); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the other namespace to keep %v but got %v", want, got)
+	}
+
+	// listing and export only see the keys of the caller's namespace
+	w = serveNamespace(handler, "team-a", http.MethodGet, "/scan", "")
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("expected an empty scan but got %s", w.Body.String())
+	}
+	w = serveNamespace(handler, "team-b", http.MethodGet, "/dump", "")
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 2 || strings.Contains(w.Body.String(), "namespace") {
+		t.Errorf("expected 2 records without namespace but got %s", w.Body.String())
+	}
+}
+
+func TestKeyValueStore_Namespaces_Invalid(t *testing.T) {
+	env := Config{}
+	handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+	for _, namespace := range []string{"team a", "team/a", strings.Repeat("a", maxNamespaceLength+1)} {
+		w := serveNamespace(handler, namespace, http.MethodPost, "/set", `{"key":"key","value":"value"}`)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_NAMESPACE") {
+			t.Errorf("%q: expected status %v but got %v %s", namespace, http.StatusBadRequest, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestKeyValueStore_Namespaces_SharedBounds(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxKeys: 3})
+	handler := (&Config{}).routes(kv)
+
+	set := func(namespace, key string) int {
+		t.Helper()
+		return serveNamespace(handler, namespace, http.MethodPost, "/set", `{"key":"`+key+`","value":"value"}`).Code
+	}
+	for _, write := range [][2]string{{"", "key0"}, {"team-a", "key0"}, {"team-a", "key1"}} {
+		if code := set(write[0], write[1]); code != http.StatusOK {
+			t.Fatalf("%v: unexpected status %v", write, code)
+		}
+	}
+
+	// the bound is shared, a namespace without keys has nothing to evict and is rejected
+	if code := set("team-b", "key"); code != http.StatusInsufficientStorage {
+		t.Errorf("expected a write beyond the bound of all namespaces to be rejected but got %v", code)
+	}
+	// a namespace with keys evicts its own least recently used key, never one of another namespace
+	if code := set("team-a", "key2"); code != http.StatusOK {
+		t.Errorf("unexpected status %v", code)
+	}
+	if _, ok := kv.Namespace("team-a").peek("key0"); ok {
+		t.Error("expected the least recently used key of the namespace to be evicted")
+	}
+	if n := kv.Len(); n != 1 {
+		t.Errorf("expected the default namespace to keep its key but it holds %d", n)
+	}
+}
+
+func TestKeyValueStore_Namespaces_Max(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetMaxNamespaces(1)
+	handler := (&Config{}).routes(kv)
+
+	if w := serveNamespace(handler, "team-a", http.MethodPost, "/set", `{"key":"key","value":"value"}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v %s", w.Code, w.Body.String())
+	}
+	if w := serveNamespace(handler, "team-b", http.MethodPost, "/set", `{"key":"key","value":"value"}`); w.Code != http.StatusInsufficientStorage || !strings.Contains(w.Body.String(), "TOO_MANY_NAMESPACES") {
+		t.Errorf("expected a namespace beyond the maximum to be rejected but got %v %s", w.Code, w.Body.String())
+	}
+	// the default namespace and existing namespaces are not affected
+	for _, namespace := range []string{"", DefaultNamespace, "team-a"} {
+		if w := serveNamespace(handler, namespace, http.MethodPost, "/set", `{"key":"other","value":"value"}`); w.Code != http.StatusOK {
+			t.Errorf("%q: unexpected status %v", namespace, w.Code)
+		}
+	}
+
+	// reads of unknown namespaces find nothing and create nothing
+	reads := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/get", `{"key":"key"}`, http.StatusNotFound},
+		{http.MethodGet, "/kv/key", "", http.StatusNotFound},
+		{http.MethodGet, "/keys", "", http.StatusOK},
+		{http.MethodGet, "/stats", "", http.StatusOK},
+		{http.MethodGet, "/dump", "", http.StatusOK},
+	}
+	for i, read := range reads {
+		namespace := "unknown-" + strconv.Itoa(i)
+		if w := serveNamespace(handler, namespace, read.method, read.path, read.body); w.Code != read.want {
+			t.Errorf("%s %s: expected status %v but got %v %s", read.method, read.path, read.want, w.Code, w.Body.String())
+		}
+	}
+	if got, want := kv.Namespaces(), []string{DefaultNamespace, "team-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected namespaces %v but got %v", want, got)
+	}
+}
+
+func TestSnapshotter_Namespaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	kv := newTestStore(map[Key]Value{"key": "default"})
+	writeTestValue(kv.Namespace("team-a"), "key", "a")
+
+	if err := NewSnapshotter(path, kv).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewKeyValueStore(StoreOptions{})
+	if n, err := NewSnapshotter(path, restored).Load(context.Background()); err != nil || n != 2 {
+		t.Fatalf("expected 2 restored keys but got %d (%v)", n, err)
+	}
+	for namespace, want := range map[string]Value{DefaultNamespace: "default", "team-a": "a"} {
+		if got := testValues(restored.Namespace(namespace))["key"]; got != want {
+			t.Errorf("%s: expected %q but got %q", namespace, want, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"key":"doc1","patch":[{"op":"replace","path":"/status","value":"done"}]}' http://localhost:8080/patch
+// curl -H 'Content-Type: application/json' -d '{"key":"doc1","merge_patch":{"status":"done","owner":null}}' http://localhost:8080/patch
+
+// PatchRequest changes the JSON document stored under the key with either a JSON Patch (RFC 6902) or a JSON Merge Patch (RFC 7386)
+type PatchRequest struct {
+	Key Key `json:"key"`
+	// Patch is a list of JSON Patch operations, they are applied all or nothing
+	Patch json.RawMessage `json:"patch,omitempty"`
+	// MergePatch is a JSON Merge Patch document, null members remove the member from the stored document
+	MergePatch json.RawMessage `json:"merge_patch,omitempty"`
+	Namespace  string          `json:"namespace,omitempty"`
+}
+
+type PatchResponse struct {
+	Document json.RawMessage `json:"document"`
+	Version  uint64          `json:"version"`
+}
+
+// errPatchFailed marks a patch that does not apply to the stored document
+var errPatchFailed = errors.New("patch failed")
+
+// PatchHandler applies a patch to the stored JSON document with the key locked, so concurrent patches never lose each other's changes
+// only the key is locked while the patch is applied, patches of other keys of the shard proceed in parallel, see modify
+// it answers 404 if the key does not exist and 422 if the stored value is not JSON or the patch does not apply, in which case the value is unchanged
+func (kv *KeyValueStore) PatchHandler(w http.ResponseWriter, r *http.Request) {
+	var payload PatchRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	if (payload.Patch == nil) == (payload.MergePatch == nil) {
+		http.Error(w, "Either patch or merge_patch is required", http.StatusBadRequest)
+		return
+	}
+
+	// the operations are parsed before taking the lock, applying them is left for under the lock of the key
+	apply := func(doc []byte) ([]byte, error) {
+		return jsonpatch.MergePatch(doc, payload.MergePatch)
+	}
+	if payload.Patch != nil {
+		patch, err := jsonpatch.DecodePatch(payload.Patch)
+		if err != nil {
+			http.Error(w, "Invalid patch: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		apply = patch.Apply
+	}
+
+	// cause is why the patch failed or the document does not match the schema, the error of modify only marks which of both
+	var cause error
+	e, err := kv.modify(payload.Key, func(value Value) (Value, error) {
+		if !json.Valid([]byte(value)) {
+			return "", errInvalidDocument
+		}
+		doc, err := apply([]byte(value))
+		if err != nil {
+			cause = err
+			return "", errPatchFailed
+		}
+		if err := kv.validateValue(Value(doc)); err != nil {
+			cause = err
+			return "", errSchemaViolation
+		}
+		return Value(doc), nil
+	})
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errWrongType):
+		writeWrongType(w, payload.Key)
+		return
+	case errors.Is(err, errInvalidDocument):
+		writeError(w, http.StatusUnprocessableEntity, "INVALID_DOCUMENT", errInvalidDocument.Error())
+		return
+	case errors.Is(err, errPatchFailed):
+		writeError(w, http.StatusUnprocessableEntity, "PATCH_FAILED", cause.Error())
+		return
+	case errors.Is(err, errSchemaViolation):
+		writeError(w, http.StatusUnprocessableEntity, "SCHEMA_VIOLATION", cause.Error())
+		return
+	case err != nil:
+		writeStoreError(w, err)
+		return
+	}
+	doc := []byte(e.plain())
+	kv.audit(r, "set", payload.Key, len(doc), 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", e.etag())
+	json.NewEncoder(w).Encode(PatchResponse{Document: doc, Version: e.version})
+}
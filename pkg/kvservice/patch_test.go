@@ -0,0 +1,165 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestKeyValueStore_PatchHandler(t *testing.T) {
+	const doc = `{"name":"report","status":"draft","tags":["a"]}`
+	tests := []struct {
+		name     string
+		stored   Value
+		body     string
+		wantCode int
+		want     Value
+	}{
+		{
+			name:     "add",
+			stored:   doc,
+			body:     `{"key":"doc1","patch":[{"op":"add","path":"/owner","value":"ada"},{"op":"add","path":"/tags/-","value":"b"}]}`,
+			wantCode: http.StatusOK,
+			want:     `{"name":"report","owner":"ada","status":"draft","tags":["a","b"]}`,
+		},
+		{
+			name:     "replace",
+			stored:   doc,
+			body:     `{"key":"doc1","patch":[{"op":"replace","path":"/status","value":"done"}]}`,
+			wantCode: http.StatusOK,
+			want:     `{"name":"report","status":"done","tags":["a"]}`,
+		},
+		{
+			name:     "remove",
+			stored:   doc,
+			body:     `{"key":"doc1","patch":[{"op":"remove","path":"/tags"}]}`,
+			wantCode: http.StatusOK,
+			want:     `{"name":"report","status":"draft"}`,
+		},
+		{
+			name:     "merge patch",
+			stored:   doc,
+			body:     `{"key":"doc1","merge_patch":{"status":"done","tags":null}}`,
+			wantCode: http.StatusOK,
+			want:     `{"name":"report","status":"done"}`,
+		},
+		{
+			name:     "failing test op leaves the document unchanged",
+			stored:   doc,
+			body:     `{"key":"doc1","patch":[{"op":"replace","path":"/status","value":"done"},{"op":"test","path":"/name","value":"other"}]}`,
+			wantCode: http.StatusUnprocessableEntity,
+			want:     doc,
+		},
+		{
+			name:     "missing path",
+			stored:   doc,
+			body:     `{"key":"doc1","patch":[{"op":"replace","path":"/missing","value":1}]}`,
+			wantCode: http.StatusUnprocessableEntity,
+			want:     doc,
+		},
+		{
+			name:     "stored value is not JSON",
+			stored:   "plain text",
+			body:     `{"key":"doc1","patch":[{"op":"add","path":"/a","value":1}]}`,
+			wantCode: http.StatusUnprocessableEntity,
+			want:     "plain text",
+		},
+		{
+			name:     "invalid patch",
+			stored:   doc,
+			body:     `{"key":"doc1","patch":{"op":"add"}}`,
+			wantCode: http.StatusBadRequest,
+			want:     doc,
+		},
+		{
+			name:     "both patch kinds",
+			stored:   doc,
+			body:     `{"key":"doc1","patch":[],"merge_patch":{}}`,
+			wantCode: http.StatusBadRequest,
+			want:     doc,
+		},
+		{
+			name:     "missing key",
+			body:     `{"key":"doc1","patch":[]}`,
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := map[Key]Value{}
+			if tt.stored != "" {
+				values["doc1"] = tt.stored
+			}
+			kv := newTestStore(values)
+			env := Config{}
+
+			w := serveNamespace(env.routes(kv), "", http.MethodPost, "/patch", tt.body)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %v but got %v %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if got := testValues(kv)["doc1"]; !jsonEqual(t, got, tt.want) {
+				t.Errorf("expected the stored document %s but got %s", tt.want, got)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var response PatchResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if e, _ := kv.peek("doc1"); response.Version != e.version || !jsonEqual(t, Value(response.Document), tt.want) {
+				t.Errorf("expected the new document with version %d but got %+v", e.version, response)
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_PatchHandler_Concurrent(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"doc1": `{}`})
+	env := Config{}
+	handler := env.routes(kv)
+
+	const writers = 16
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			field := "f" + strconv.Itoa(i)
+			body := `{"key":"doc1","patch":[{"op":"add","path":"/` + field + `","value":` + strconv.Itoa(i) + `}]}`
+			if w := serveNamespace(handler, "", http.MethodPost, "/patch", body); w.Code != http.StatusOK {
+				t.Errorf("%s: expected status %v but got %v %s", field, http.StatusOK, w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every patch must be applied to the result of the previous one, so no field is lost
+	var doc map[string]int
+	if err := json.Unmarshal([]byte(testValues(kv)["doc1"]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != writers {
+		t.Fatalf("expected %d fields but got %v", writers, doc)
+	}
+	for i := range writers {
+		if got := doc["f"+strconv.Itoa(i)]; got != i {
+			t.Errorf("field f%d: expected %d but got %d", i, i, got)
+		}
+	}
+}
+
+// jsonEqual reports whether a and b are the same JSON document, values that are not JSON are compared as they are
+func jsonEqual(t *testing.T, a, b Value) bool {
+	t.Helper()
+	var x, y any
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return a == b
+	}
+	xs, _ := json.Marshal(x)
+	ys, _ := json.Marshal(y)
+	return string(xs) == string(ys)
+}
@@ -0,0 +1,394 @@
+package kvservice
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotRecord is a single line of a snapshot file
+type snapshotRecord struct {
+	// Namespace is empty for the default namespace and in dumps, which hold a single namespace
+	Namespace string `json:"namespace,omitempty"`
+	Key       Key    `json:"key"`
+	Value     Value  `json:"value"`
+	// List holds the items if the key holds a list
+	List []Value `json:"list,omitempty"`
+	// Hash holds the fields if the key holds a hash
+	Hash map[string]Value `json:"hash,omitempty"`
+	// ModifiedAt is the time of the last write, records written before it was kept are restored as modified at the time of the restore
+	ModifiedAt time.Time `json:"modified_at,omitzero"`
+	// Version is the version of the entry, loading a snapshot and replaying the write-ahead log keep it so /txn checks and ETags of clients
+	// stay valid across a restart, a restore or seed hands out new versions as the records may come from another store
+	Version uint64 `json:"version,omitempty"`
+	// DeletedAt marks the record as the tombstone of a deleted key
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// entry returns the entry the record restores with the given version
+func (record snapshotRecord) entry(version uint64) entry {
+	if record.List != nil {
+		return entry{kind: kindList, items: record.List, version: version, modified: record.ModifiedAt}
+	}
+	if record.Hash != nil {
+		return entry{kind: kindHash, fields: record.Hash, version: version, modified: record.ModifiedAt}
+	}
+	return entry{value: record.Value, version: version, modified: record.ModifiedAt}
+}
+
+// Snapshotter persists the store to a file as newline delimited JSON
+// it serializes snapshots so a periodic snapshot can never overwrite the final one taken on shutdown with older data
+type Snapshotter struct {
+	mu    sync.Mutex
+	path  string
+	store *KeyValueStore
+	// snapshotted is the change count of the store the snapshot file holds, it is guarded by mu
+	snapshotted uint64
+	// aead encrypts the snapshot file, nil writes it as plaintext
+	aead cipher.AEAD
+	// wal logs the changes between snapshots, nil if only snapshots are written
+	wal *writeAheadLog
+}
+
+// NewSnapshotter returns a Snapshotter writing the store to the given path, /compact of the store writes through it
+func NewSnapshotter(path string, store *KeyValueStore) *Snapshotter {
+	s := &Snapshotter{
+		path:  path,
+		store: store,
+	}
+	store.root.snapshotter = s
+	return s
+}
+
+// SetEncryptionKey encrypts the snapshot file with AES-GCM and the key, a snapshot file that is loaded must be encrypted with the same key
+// the store itself is kept in plaintext, it must be called before the snapshotter is used
+func (s *Snapshotter) SetEncryptionKey(key []byte) error {
+	aead, err := newSnapshotCipher(key)
+	if err != nil {
+		return fmt.Errorf("encryption key: %w", err)
+	}
+	s.aead = aead
+	return nil
+}
+
+// SetFsync logs every change to a write-ahead log next to the snapshot file, path.wal, so the changes since the last snapshot survive a crash
+// the mode decides when the log is synced to disk, trading durability for write throughput, see FsyncMode
+// Load replays the log on top of the snapshot and every snapshot truncates it, Close closes it
+// the log is encrypted with the key of SetEncryptionKey like the snapshot, it must be called after it and before the store is served
+func (s *Snapshotter) SetFsync(mode FsyncMode) error {
+	wal, err := openWriteAheadLog(s.path+".wal", mode, s.aead)
+	if err != nil {
+		return err
+	}
+	s.wal = wal
+	s.store.root.wal = wal
+	return nil
+}
+
+// Close closes the write-ahead log if there is one, the changes made afterwards are only persisted by snapshots
+func (s *Snapshotter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return nil
+	}
+	s.store.root.wal = nil
+	return s.wal.Close()
+}
+
+// Snapshot writes the current state of the store to the snapshot file
+// the file is replaced atomically so a crash during the write leaves the previous snapshot intact
+func (s *Snapshotter) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// SnapshotIfChanged writes a snapshot unless the store is unchanged since the last snapshot or load, it reports whether it wrote one
+func (s *Snapshotter) SnapshotIfChanged() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store.root.changes.Load() == s.snapshotted {
+		return false, nil
+	}
+	return true, s.snapshot()
+}
+
+// snapshot writes the snapshot, the caller must hold mu
+func (s *Snapshotter) snapshot() error {
+	// changes made while the snapshot is written may be missing from it, so the count is taken before
+	changes := s.store.root.changes.Load()
+	// the changes logged so far are all part of the snapshot, the log continues in a new file
+	if s.wal != nil {
+		if err := s.wal.rotate(); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := s.writeSnapshot(w); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace snapshot: %w", err)
+	}
+	s.snapshotted = changes
+	if s.wal != nil {
+		return s.wal.removeRotated()
+	}
+	return nil
+}
+
+// writeSnapshot writes all namespaces to w, encrypted if a key is set
+func (s *Snapshotter) writeSnapshot(w io.Writer) error {
+	if s.aead == nil {
+		return s.store.writeNamespaces(context.Background(), w)
+	}
+	sw, err := newSealWriter(s.aead, w)
+	if err != nil {
+		return err
+	}
+	if err := s.store.writeNamespaces(context.Background(), sw); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// run writes a snapshot every interval until ctx is done, a store that did not change or did not finish loading is not written
+func (s *Snapshotter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.store.Loading() {
+				continue
+			}
+			if _, err := s.SnapshotIfChanged(); err != nil {
+				slog.Error("failed to write periodic snapshot", "error", err)
+			}
+		}
+	}
+}
+
+// loadProgressInterval is how often the progress of a snapshot load is logged
+const loadProgressInterval = 5 * time.Second
+
+// Load reads the snapshot file into the store, a missing file is not an error and leaves the store empty
+// with a write-ahead log the changes logged since the snapshot are replayed on top of it, they do not count as restored keys
+// the load stops when ctx is done, its progress is logged periodically so a long load is visible
+func (s *Snapshotter) Load(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return s.load(ctx)
+	}
+	// what is loaded is persisted already, the log is detached so it is not appended to while it is read
+	s.store.root.wal = nil
+	defer func() { s.store.root.wal = s.wal }()
+	n, err := s.load(ctx)
+	if err != nil {
+		return n, err
+	}
+	replayed, err := s.wal.replay(ctx, s.store)
+	if err != nil {
+		return n, err
+	}
+	// the snapshot file misses the replayed changes, they moved the change count so the next periodic snapshot is due
+	if replayed > 0 {
+		slog.Info("write-ahead log replayed", "records", replayed, "file", s.wal.path)
+	}
+	return n, nil
+}
+
+// load reads the snapshot file into the store, the caller must hold mu
+func (s *Snapshotter) load(ctx context.Context) (int, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	var total int64
+	if info, err := f.Stat(); err == nil {
+		total = info.Size()
+	}
+	counter := &countingReader{r: f}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(loadProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				slog.Info("loading snapshot", "file", s.path, "read_bytes", counter.n.Load(), "total_bytes", total)
+			}
+		}
+	}()
+
+	br := bufio.NewReader(counter)
+	var r io.Reader = br
+	if s.aead != nil {
+		or, err := newOpenReader(s.aead, br)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt snapshot: %w", err)
+		}
+		r = or
+	}
+	n, err := s.store.readRecords(ctx, r, true)
+	if err != nil {
+		return n, fmt.Errorf("read snapshot: %w", err)
+	}
+	// the store holds what the file holds, writing it back would not add anything
+	s.snapshotted = s.store.root.changes.Load()
+	return n, nil
+}
+
+// countingReader counts the bytes read so far, the count may be read concurrently
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// writeNamespaces writes the records and tombstones of all namespaces, records outside the default namespace carry the name of their namespace
+func (kv *KeyValueStore) writeNamespaces(ctx context.Context, w io.Writer) error {
+	for _, name := range kv.Namespaces() {
+		namespace := name
+		if name == DefaultNamespace {
+			namespace = ""
+		}
+		if err := kv.Namespace(name).writeRecords(ctx, w, namespace, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRecords writes the store as newline delimited JSON records, one key per line, each labelled with the given namespace
+// with tombstones the deleted keys that can still be undeleted follow as records with their deletion time
+// each shard is copied under its read lock and written after releasing it so a slow writer never blocks the store
+// it stops with the error of ctx before the next shard once ctx is done
+func (kv *KeyValueStore) writeRecords(ctx context.Context, w io.Writer, namespace string, tombstones bool) error {
+	enc := json.NewEncoder(w)
+	for _, sh := range kv.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sh.RLock()
+		records := make([]snapshotRecord, 0, len(sh.kvMap))
+		for k, e := range sh.kvMap {
+			records = append(records, snapshotRecord{Namespace: namespace, Key: k, Value: e.plain(), List: slices.Clone(e.items), Hash: e.fields, ModifiedAt: e.modified, Version: e.version})
+		}
+		if tombstones {
+			for k, t := range sh.tombstones {
+				records = append(records, snapshotRecord{Namespace: namespace, Key: k, Value: t.plain(), List: t.items, Hash: t.fields, DeletedAt: &t.deletedAt})
+			}
+		}
+		sh.RUnlock()
+
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readRecords writes the newline delimited JSON records into the store and returns how many were restored
+// records labelled with a namespace go into that namespace, so a snapshot restores all namespaces
+// existing keys are overwritten, keys that are not in the records are left untouched
+// tombstone records are kept as tombstones if soft delete is enabled and the key does not exist, they do not count as restored
+// with versions the entries keep the versions of the records, see snapshotRecord.Version
+// it stops with the error of ctx once ctx is done
+func (kv *KeyValueStore) readRecords(ctx context.Context, r io.Reader, versions bool) (int, error) {
+	n := 0
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		var record snapshotRecord
+		if err := dec.Decode(&record); err != nil {
+			return n, err
+		}
+		target := kv
+		if record.Namespace != "" {
+			if err := validateNamespace(record.Namespace); err != nil {
+				return n, err
+			}
+			target = kv.Namespace(record.Namespace)
+		}
+		sh := target.shard(record.Key)
+		sh.Lock()
+		if record.DeletedAt != nil {
+			var err error
+			if _, exists := sh.kvMap[record.Key]; !exists && target.options.SoftDelete > 0 {
+				sh.bury(record.Key, record.entry(target.revision.Add(1)), *record.DeletedAt)
+				// the key stays deleted, only the tombstone is logged so it survives a crash
+				err = sh.logChange(record.Key, WebhookEventDelete)
+			}
+			sh.Unlock()
+			if err != nil {
+				return n, fmt.Errorf("restore tombstone of key %q: %w", record.Key, err)
+			}
+			continue
+		}
+		var version uint64
+		if versions {
+			version = record.Version
+		}
+		_, err := sh.writeEntry(record.Key, record.entry(version))
+		sh.Unlock()
+		if err != nil {
+			return n, fmt.Errorf("restore key %q: %w", record.Key, err)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,223 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServer_Run_WritesFinalSnapshot(t *testing.T) {
+	env := Config{
+		ServerAddress:   "127.0.0.1:0",
+		ShutdownTimeout: time.Second,
+		SnapshotFile:    filepath.Join(t.TempDir(), "snapshot.jsonl"),
+	}
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvStore := server.store
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	for _, body := range []string{
+		`{"key":"a", "value":"1"}`,
+		`{"key":"b", "value":"2"}`,
+		`{"key":"a", "value":"3"}`,
+	} {
+		w := httptest.NewRecorder()
+		kvStore.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString(body)))
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after the context was cancelled")
+	}
+
+	restored := NewKeyValueStore(StoreOptions{})
+	n, err := NewSnapshotter(env.SnapshotFile, restored).Load(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 keys in snapshot but got %d", n)
+	}
+	for key, want := range map[Key]Value{"a": "3", "b": "2"} {
+		if got := testValues(restored)[key]; got != want {
+			t.Errorf("expected %v for key %v but got %v", want, key, got)
+		}
+	}
+}
+
+func TestServer_Run_FinalSnapshotError(t *testing.T) {
+	env := Config{
+		ServerAddress:   "127.0.0.1:0",
+		ShutdownTimeout: time.Second,
+		SnapshotFile:    filepath.Join(t.TempDir(), "missing-dir", "snapshot.jsonl"),
+	}
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := server.Run(ctx); err == nil {
+		t.Error("expected an error when the final snapshot can not be written")
+	}
+}
+
+func TestSnapshotter_LoadMissingFile(t *testing.T) {
+	kvStore := NewKeyValueStore(StoreOptions{})
+
+	n, err := NewSnapshotter(filepath.Join(t.TempDir(), "snapshot.jsonl"), kvStore).Load(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error for a missing snapshot but got %v", err)
+	}
+	if n != 0 || kvStore.Len() != 0 {
+		t.Errorf("expected an empty store but loaded %d keys", n)
+	}
+}
+
+func TestSnapshotter_Run(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	snapshotter := NewSnapshotter(path, kv)
+	const interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		snapshotter.run(ctx, interval)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// waitForFile waits a few intervals and reports whether the snapshot file exists
+	waitForFile := func() bool {
+		deadline := time.Now().Add(20 * interval)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(path); err == nil {
+				return true
+			}
+			time.Sleep(interval / 2)
+		}
+		return false
+	}
+
+	if waitForFile() {
+		t.Fatal("expected no snapshot of the unchanged store")
+	}
+
+	writeTestValue(kv, "a", "1")
+	if !waitForFile() {
+		t.Fatal("expected a snapshot after the store changed")
+	}
+	restored := NewKeyValueStore(StoreOptions{})
+	if _, err := NewSnapshotter(path, restored).Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testValues(restored)["a"]; got != "1" {
+		t.Errorf("expected the change in the snapshot but got %q", got)
+	}
+
+	// once written the clean store is not written again
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if waitForFile() {
+		t.Error("expected no snapshot of the store that did not change since the last one")
+	}
+
+	writeTestValue(kv.Namespace("team-a"), "b", "2")
+	if !waitForFile() {
+		t.Error("expected a snapshot after a namespace changed")
+	}
+}
+
+func TestSnapshotter_KeepsModified(t *testing.T) {
+	written := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.clock = func() time.Time { return written }
+	writeTestValue(kv, "a", "1")
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	if err := NewSnapshotter(path, kv).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewKeyValueStore(StoreOptions{})
+	if _, err := NewSnapshotter(path, restored).Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := restored.peek("a"); !e.modified.Equal(written) {
+		t.Errorf("expected the restored key to be modified at %v but got %v", written, e.modified)
+	}
+}
+
+func TestSnapshotter_KeepsVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(path, kv)
+	if err := snapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		writeTestValue(kv, "a", Value(strconv.Itoa(i)))
+	}
+	writeTestValue(kv, "b", "1")
+	if err := snapshotter.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	// the write after the snapshot is only in the write-ahead log
+	writeTestValue(kv, "b", "2")
+	if err := snapshotter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := kv.peek("a")
+	b, _ := kv.peek("b")
+
+	restored := NewKeyValueStore(StoreOptions{})
+	restoredSnapshotter := NewSnapshotter(path, restored)
+	if err := restoredSnapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	defer restoredSnapshotter.Close()
+	if _, err := restoredSnapshotter.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := restored.peek("a"); e.version != a.version {
+		t.Errorf("expected the version %d of the snapshot but got %d", a.version, e.version)
+	}
+	if e, _ := restored.peek("b"); e.version != b.version {
+		t.Errorf("expected the version %d of the write-ahead log but got %d", b.version, e.version)
+	}
+
+	// a check against the version read before the restart still succeeds, and a new write gets a higher version
+	body := `{"ops":[{"type":"check","key":"a","expected_version":` + strconv.FormatUint(a.version, 10) + `},{"type":"set","key":"c","value":"1"}]}`
+	if w := serveNamespace((&Config{}).routes(restored), "", http.MethodPost, "/txn", body); w.Code != http.StatusOK {
+		t.Fatalf("expected the check to pass after the restart but got %v %s", w.Code, w.Body.String())
+	}
+	if e, _ := restored.peek("c"); e.version <= b.version {
+		t.Errorf("expected the new write to get a version above %d but got %d", b.version, e.version)
+	}
+}
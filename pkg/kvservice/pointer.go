@@ -0,0 +1,83 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"key":"doc1","path":"/users/0/email"}' http://localhost:8080/get
+
+var (
+	// errInvalidDocument is returned for a pointer into a value that is not JSON
+	errInvalidDocument = errors.New("the stored value is not a JSON document")
+	// errPointerNotFound is returned for a pointer that does not resolve in the document
+	errPointerNotFound = errors.New("the path does not exist in the document")
+)
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped reference tokens, the empty pointer refers to the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("path %q must be empty or start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		// ~1 is unescaped before ~0, so ~01 becomes ~1 and not /
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || (token[j+1] != '0' && token[j+1] != '1')) {
+				return nil, fmt.Errorf("path %q has an invalid escape, ~ must be followed by 0 or 1", pointer)
+			}
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// resolvePointer returns the JSON text of the fragment of doc the tokens refer to
+// every level is only split into its members, so the parts next to the path are never decoded
+func resolvePointer(doc []byte, tokens []string) ([]byte, error) {
+	fragment := json.RawMessage(bytes.TrimSpace(doc))
+	// splitting a level validates it, so only a document that is never split needs validating on its own
+	if len(tokens) == 0 || len(fragment) == 0 || (fragment[0] != '{' && fragment[0] != '[') {
+		if !json.Valid(fragment) {
+			return nil, errInvalidDocument
+		}
+	}
+	for _, token := range tokens {
+		switch fragment[0] {
+		case '{':
+			var members map[string]json.RawMessage
+			if err := json.Unmarshal(fragment, &members); err != nil {
+				return nil, errInvalidDocument
+			}
+			member, ok := members[token]
+			if !ok {
+				return nil, errPointerNotFound
+			}
+			fragment = member
+		case '[':
+			// array indexes are decimal without leading zeros, - refers to the element after the last which never exists
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') || token[0] == '+' {
+				return nil, errPointerNotFound
+			}
+			var elements []json.RawMessage
+			if err := json.Unmarshal(fragment, &elements); err != nil {
+				return nil, errInvalidDocument
+			}
+			if index >= len(elements) {
+				return nil, errPointerNotFound
+			}
+			fragment = elements[index]
+		default:
+			return nil, errPointerNotFound
+		}
+	}
+	return fragment, nil
+}
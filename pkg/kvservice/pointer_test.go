@@ -0,0 +1,95 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueStore_GetHandler_Path(t *testing.T) {
+	const doc = `{"users":[{"email":"a@b","tags":["x","y"]}],"a/b":1,"m~n":2,"~1":3,"":{"":"empty"},"n":null}`
+	kv := newTestStore(map[Key]Value{"doc1": doc, "text": "plain text"})
+	env := Config{}
+	handler := env.routes(kv)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		want     Value
+	}{
+		{name: "leaf", body: `{"key":"doc1","path":"/users/0/email"}`, wantCode: http.StatusOK, want: `"a@b"`},
+		{name: "array element", body: `{"key":"doc1","path":"/users/0/tags/1"}`, wantCode: http.StatusOK, want: `"y"`},
+		{name: "object", body: `{"key":"doc1","path":"/users/0/tags"}`, wantCode: http.StatusOK, want: `["x","y"]`},
+		{name: "whole document", body: `{"key":"doc1","path":""}`, wantCode: http.StatusOK, want: doc},
+		{name: "escaped slash", body: `{"key":"doc1","path":"/a~1b"}`, wantCode: http.StatusOK, want: `1`},
+		{name: "escaped tilde", body: `{"key":"doc1","path":"/m~0n"}`, wantCode: http.StatusOK, want: `2`},
+		{name: "tilde unescaped after slash", body: `{"key":"doc1","path":"/~01"}`, wantCode: http.StatusOK, want: `3`},
+		{name: "empty member names", body: `{"key":"doc1","path":"//"}`, wantCode: http.StatusOK, want: `"empty"`},
+		{name: "null", body: `{"key":"doc1","path":"/n"}`, wantCode: http.StatusOK, want: `null`},
+		{name: "missing member", body: `{"key":"doc1","path":"/missing"}`, wantCode: http.StatusNotFound},
+		{name: "index out of range", body: `{"key":"doc1","path":"/users/1"}`, wantCode: http.StatusNotFound},
+		{name: "index with leading zero", body: `{"key":"doc1","path":"/users/00"}`, wantCode: http.StatusNotFound},
+		{name: "index after the last", body: `{"key":"doc1","path":"/users/-"}`, wantCode: http.StatusNotFound},
+		{name: "into a scalar", body: `{"key":"doc1","path":"/users/0/email/x"}`, wantCode: http.StatusNotFound},
+		{name: "not JSON", body: `{"key":"text","path":"/a"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "without leading slash", body: `{"key":"doc1","path":"users"}`, wantCode: http.StatusBadRequest},
+		{name: "invalid escape", body: `{"key":"doc1","path":"/m~2n"}`, wantCode: http.StatusBadRequest},
+		{name: "missing key", body: `{"key":"missing","path":"/a"}`, wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveNamespace(handler, "", http.MethodPost, "/get", tt.body)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %v but got %v %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var response GetResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Value != tt.want {
+				t.Errorf("expected %s but got %s", tt.want, response.Value)
+			}
+		})
+	}
+}
+
+func BenchmarkGetHandler_Path(b *testing.B) {
+	// a 1MB document of many records, the pointer addresses a single small leaf
+	var doc strings.Builder
+	doc.WriteString(`{"records":[`)
+	for i := 0; doc.Len() < 1<<20; i++ {
+		if i > 0 {
+			doc.WriteByte(',')
+		}
+		doc.WriteString(`{"id":` + strconv.Itoa(i) + `,"name":"record-` + strconv.Itoa(i) + `","payload":"` + strings.Repeat("x", 100) + `"}`)
+	}
+	doc.WriteString(`],"meta":{"owner":"ada"}}`)
+	kv := newTestStore(map[Key]Value{"doc1": Value(doc.String())})
+
+	for _, bm := range []struct {
+		name string
+		body string
+	}{
+		{name: "whole value", body: `{"key":"doc1"}`},
+		{name: "path", body: `{"key":"doc1","path":"/meta/owner"}`},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var responseBytes int
+			for b.Loop() {
+				w := httptest.NewRecorder()
+				kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(bm.body)))
+				responseBytes = w.Body.Len()
+			}
+			b.ReportMetric(float64(responseBytes), "response-bytes")
+		})
+	}
+}
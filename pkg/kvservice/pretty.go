@@ -0,0 +1,87 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"key":"key1"}' 'http://localhost:8080/get?pretty=true'
+// go run . --pretty-json
+
+// MiddlewarePrettyJSON indents JSON responses by two spaces if pretty is set or the request has the query parameter pretty=true
+// other responses, like the newline delimited JSON streamed by /dump and /changes, are passed through unchanged
+func MiddlewarePrettyJSON(pretty bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !pretty && r.URL.Query().Get("pretty") != "true" {
+			next(w, r)
+			return
+		}
+		pw := &prettyWriter{ResponseWriter: w}
+		next(pw, r)
+		pw.flush()
+	}
+}
+
+// prettyWriter buffers a JSON response so it can be indented once the handler finished
+type prettyWriter struct {
+	http.ResponseWriter
+	// decided is set once the status is written, buffering is only done for JSON
+	decided   bool
+	buffering bool
+	status    int
+	buf       bytes.Buffer
+}
+
+func (pw *prettyWriter) WriteHeader(status int) {
+	if pw.decided {
+		return
+	}
+	pw.decided = true
+	mediaType, _, _ := mime.ParseMediaType(pw.Header().Get("Content-Type"))
+	if mediaType == "application/json" {
+		pw.buffering = true
+		pw.status = status
+		return
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *prettyWriter) Write(p []byte) (int, error) {
+	pw.WriteHeader(http.StatusOK)
+	if pw.buffering {
+		return pw.buf.Write(p)
+	}
+	return pw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (pw *prettyWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// flush writes the buffered response indented, every value of it on its own, a response that is not valid JSON is written as is
+func (pw *prettyWriter) flush() {
+	if !pw.buffering {
+		return
+	}
+	body := pw.buf.Bytes()
+	var indented bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for dec.More() {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			indented.Reset()
+			break
+		}
+		json.Indent(&indented, value, "", "  ")
+		indented.WriteByte('\n')
+	}
+	if indented.Len() > 0 {
+		body = indented.Bytes()
+	}
+	pw.Header().Del("Content-Length")
+	pw.ResponseWriter.WriteHeader(pw.status)
+	pw.ResponseWriter.Write(body)
+}
@@ -0,0 +1,48 @@
+package kvservice
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewarePrettyJSON(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"key": "value"})
+
+	tests := []struct {
+		name   string
+		pretty bool
+		path   string
+		want   string
+	}{
+		{name: "compact", path: "/get", want: `{"value":"value"}` + "\n"},
+		{name: "query", path: "/get?pretty=true", want: "{\n  \"value\": \"value\"\n}\n"},
+		{name: "flag", pretty: true, path: "/get", want: "{\n  \"value\": \"value\"\n}\n"},
+		{name: "not JSON", pretty: true, path: "/set", want: "202\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := Config{PrettyJSON: tt.pretty}
+			handler := env.routes(kv)
+			body := `{"key":"key"}`
+			if tt.path == "/set" {
+				body = `{"key":"key","value":"value"}`
+			}
+			w := serveNamespace(handler, "", http.MethodPost, tt.path, body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %v but got %v %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("expected %q but got %q", tt.want, got)
+			}
+		})
+	}
+
+	// the streamed newline delimited JSON stays one value per line
+	env := Config{PrettyJSON: true}
+	w := serveNamespace(env.routes(kv), "", http.MethodGet, "/changes", "")
+	if got := w.Body.String(); got == "" || strings.Contains(got, "\n  ") {
+		t.Errorf("expected the changes unchanged but got %q", got)
+	}
+}
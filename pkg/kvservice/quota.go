@@ -0,0 +1,125 @@
+package kvservice
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// namespace_quotas:
+//   team-a:
+//     max_keys: 1000
+//     max_bytes: 1048576
+
+// NamespaceQuota bounds the keys and bytes of a namespace, 0 means unbounded
+// unlike max-keys and max-store-bytes a quota never evicts, writes beyond it are rejected
+type NamespaceQuota struct {
+	MaxKeys  int64 `json:"max_keys"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// QuotaError is returned when a write would exceed a quota of its namespace, it matches ErrStoreFull
+type QuotaError struct {
+	Namespace string
+	// Limit is the name of the exceeded limit, max_keys or max_bytes
+	Limit string
+	Max   int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("namespace %s exceeds its %s quota of %d", e.Namespace, e.Limit, e.Max)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrStoreFull
+}
+
+// quota tracks the usage of a namespace across all its shards
+// usage is reserved before a write is applied so concurrent writes to different shards can not overshoot the limits together
+type quota struct {
+	NamespaceQuota
+	namespace string
+	keys      atomic.Int64
+	bytes     atomic.Int64
+}
+
+// reserve adds the keys and bytes to the usage or returns a QuotaError if that exceeds a limit
+// only growth is checked, so a write that shrinks the usage always succeeds even when the namespace is over its quota
+func (q *quota) reserve(keys, bytes int64) error {
+	if q == nil {
+		return nil
+	}
+	if n := q.keys.Add(keys); keys > 0 && q.MaxKeys > 0 && n > q.MaxKeys {
+		q.keys.Add(-keys)
+		return &QuotaError{Namespace: q.namespace, Limit: "max_keys", Max: q.MaxKeys}
+	}
+	if n := q.bytes.Add(bytes); bytes > 0 && q.MaxBytes > 0 && n > q.MaxBytes {
+		q.release(keys, bytes)
+		return &QuotaError{Namespace: q.namespace, Limit: "max_bytes", Max: q.MaxBytes}
+	}
+	return nil
+}
+
+// release removes the keys and bytes from the usage
+func (q *quota) release(keys, bytes int64) {
+	if q == nil {
+		return
+	}
+	q.keys.Add(-keys)
+	q.bytes.Add(-bytes)
+}
+
+// restore adds the keys and bytes to the usage without checking the limits, it is used to bring back usage that was released before
+func (q *quota) restore(keys, bytes int64) {
+	if q == nil {
+		return
+	}
+	q.keys.Add(keys)
+	q.bytes.Add(bytes)
+}
+
+// SetNamespaceQuotas bounds the namespaces by name, namespaces without an entry are unbounded
+// it must be called before the store holds any data or is served
+func (kv *KeyValueStore) SetNamespaceQuotas(quotas map[string]NamespaceQuota) {
+	root := kv.root
+	root.namespacesMu.Lock()
+	defer root.namespacesMu.Unlock()
+
+	root.quotas = quotas
+	root.setQuota()
+	for _, ns := range root.namespaces {
+		ns.setQuota()
+	}
+}
+
+// setQuota applies the configured quota of the namespace to the store
+func (kv *KeyValueStore) setQuota() {
+	kv.quota = nil
+	if q, ok := kv.root.quotas[kv.name]; ok {
+		kv.quota = &quota{NamespaceQuota: q, namespace: kv.name}
+	}
+}
+
+// Quota returns the quota of the namespace and whether it has one
+func (kv *KeyValueStore) Quota() (NamespaceQuota, bool) {
+	if kv.quota == nil {
+		return NamespaceQuota{}, false
+	}
+	return kv.quota.NamespaceQuota, true
+}
+
+// writeStoreError answers 507 for a write that did not fit, naming the exceeded limit if it was a quota
+// a write that could not be logged to the write-ahead log is answered with 503, it is not acknowledged
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrWriteAheadLog) {
+		writeError(w, http.StatusServiceUnavailable, "WAL_FAILED", err.Error())
+		return
+	}
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		writeError(w, http.StatusInsufficientStorage, "QUOTA_EXCEEDED", quotaErr.Error())
+		return
+	}
+	http.Error(w, "Store is full", http.StatusInsufficientStorage)
+}
@@ -0,0 +1,121 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueStore_NamespaceQuotas(t *testing.T) {
+	env := Config{NamespaceQuotas: map[string]NamespaceQuota{
+		"keys":  {MaxKeys: 2},
+		"bytes": {MaxBytes: 10},
+	}}
+	kv := env.newStore()
+	handler := env.routes(kv)
+
+	tests := []struct {
+		namespace string
+		bodies    []string
+		wantLimit string
+	}{
+		{namespace: "keys", bodies: []string{`{"key":"a","value":"1"}`, `{"key":"b","value":"2"}`, `{"key":"c","value":"3"}`}, wantLimit: "max_keys"},
+		{namespace: "bytes", bodies: []string{`{"key":"a","value":"12345"}`, `{"key":"b","value":"12345"}`}, wantLimit: "max_bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			last := len(tt.bodies) - 1
+			for _, body := range tt.bodies[:last] {
+				if w := serveNamespace(handler, tt.namespace, http.MethodPost, "/set", body); w.Code != http.StatusOK {
+					t.Fatalf("expected status %v within the quota but got %v", http.StatusOK, w.Code)
+				}
+			}
+
+			w := serveNamespace(handler, tt.namespace, http.MethodPost, "/set", tt.bodies[last])
+			var response ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil || w.Code != http.StatusInsufficientStorage {
+				t.Fatalf("expected status %v but got %v (%v)", http.StatusInsufficientStorage, w.Code, err)
+			}
+			if response.Code != "QUOTA_EXCEEDED" || !strings.Contains(response.Message, tt.wantLimit) {
+				t.Errorf("expected an error naming %s but got %+v", tt.wantLimit, response)
+			}
+
+			// the same writes succeed in a namespace without a quota
+			for _, body := range tt.bodies {
+				if w := serveNamespace(handler, "unbounded-"+tt.namespace, http.MethodPost, "/set", body); w.Code != http.StatusOK {
+					t.Errorf("expected status %v in another namespace but got %v", http.StatusOK, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_NamespaceQuotas_Release(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetNamespaceQuotas(map[string]NamespaceQuota{DefaultNamespace: {MaxKeys: 2, MaxBytes: 10}})
+
+	write := func(key Key, value Value) error {
+		s := kv.shard(key)
+		s.Lock()
+		defer s.Unlock()
+		_, err := s.put(key, value)
+		return err
+	}
+
+	if err := write("a", "123456789"); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("b", "1"); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("expected the quota to be exceeded but got %v", err)
+	}
+	// shrinking the value releases its bytes
+	if err := write("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("b", "1234567"); err != nil {
+		t.Errorf("expected the released bytes to be available but got %v", err)
+	}
+
+	// deleting a key releases its key and bytes
+	s := kv.shard("b")
+	s.Lock()
+	s.remove("b")
+	s.Unlock()
+	if err := write("c", "1234567"); err != nil {
+		t.Errorf("expected the deleted key to be released but got %v", err)
+	}
+
+	kv.flush()
+	if q := kv.quota; q.keys.Load() != 0 || q.bytes.Load() != 0 {
+		t.Errorf("expected no usage after a flush but got %d keys and %d bytes", q.keys.Load(), q.bytes.Load())
+	}
+}
+
+func TestConfig_StatsHandler_Quota(t *testing.T) {
+	env := Config{NamespaceQuotas: map[string]NamespaceQuota{"team-a": {MaxKeys: 10}}}
+	kv := env.newStore()
+	writeTestValue(kv.Namespace("team-a"), "key", "value")
+
+	w := httptest.NewRecorder()
+	env.StatsHandler(kv)(w, httptest.NewRequest(http.MethodGet, "/stats?namespace=team-a", nil))
+
+	var stats StatsResponse
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 1 || stats.Quota == nil || stats.Quota.MaxKeys != 10 {
+		t.Errorf("expected 1 key of a quota of 10 but got %+v", stats)
+	}
+
+	w = httptest.NewRecorder()
+	NewMetricsHandler(kv).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{`kv_namespace_keys{namespace="team-a"} 1`, `kv_namespace_quota_max_keys{namespace="team-a"} 10`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected %s in metrics", want)
+		}
+	}
+}
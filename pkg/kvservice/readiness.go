@@ -0,0 +1,85 @@
+package kvservice
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// loadingRetryAfter is the number of seconds clients are asked to wait while the store is loading
+const loadingRetryAfter = 5
+
+// SetLoading marks the store as loading its data, while it is loading the readiness probe fails
+// and the endpoints wrapped by MiddlewareLoaded answer 503 so no client sees a partially loaded store
+func (kv *KeyValueStore) SetLoading(loading bool) {
+	kv.root.loading.Store(loading)
+}
+
+// Loading reports whether the store is still loading its data
+func (kv *KeyValueStore) Loading() bool {
+	return kv.root.loading.Load()
+}
+
+// MiddlewareLoaded answers 503 with a Retry-After header while the store is loading, it must wrap every endpoint that reads or writes data
+func (kv *KeyValueStore) MiddlewareLoaded(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if kv.Loading() {
+			w.Header().Set("Retry-After", strconv.Itoa(loadingRetryAfter))
+			writeError(w, http.StatusServiceUnavailable, "LOADING", "the store is loading its data")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// SetShuttingDown marks the store as about to shut down, from then on the readiness probe fails
+func (kv *KeyValueStore) SetShuttingDown(shuttingDown bool) {
+	kv.root.shuttingDown.Store(shuttingDown)
+}
+
+// ShuttingDown reports whether the server is about to shut down
+func (kv *KeyValueStore) ShuttingDown() bool {
+	return kv.root.shuttingDown.Load()
+}
+
+// SetStopping marks the server as stopping, from then on the endpoints wrapped by MiddlewareStopping answer 503
+func (kv *KeyValueStore) SetStopping(stopping bool) {
+	kv.root.stopping.Store(stopping)
+}
+
+// Stopping reports whether the server is stopping
+func (kv *KeyValueStore) Stopping() bool {
+	return kv.root.stopping.Load()
+}
+
+// MiddlewareStopping answers 503 and closes the connection once the server is stopping
+// requests that are already being handled complete, only the ones that slip in before the listeners are closed are rejected
+func (kv *KeyValueStore) MiddlewareStopping(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if kv.Stopping() {
+			w.Header().Set("Connection", "close")
+			writeError(w, http.StatusServiceUnavailable, "SHUTTING_DOWN", "the server is shutting down")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ReadinessProbeHandler handles the readiness probe, the service is ready once the store has loaded its data and until it shuts down,
+// except while it is in maintenance mode
+func (kv *KeyValueStore) ReadinessProbeHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("readiness probe called", "path", r.URL.Path)
+	if kv.Loading() {
+		http.Error(w, "Loading", http.StatusServiceUnavailable)
+		return
+	}
+	if kv.ShuttingDown() {
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if enabled, _ := kv.Maintenance(); enabled {
+		http.Error(w, "Maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,171 @@
+package kvservice
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Run_ReadinessAfterLoad(t *testing.T) {
+	env := Config{
+		ServerAddress:   "127.0.0.1:0",
+		ShutdownTimeout: time.Second,
+		SnapshotFile:    filepath.Join(t.TempDir(), "snapshot.jsonl"),
+	}
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	server.loadSnapshot = func(ctx context.Context) (int, error) {
+		select {
+		case <-release:
+			return 0, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+	defer func() {
+		http.DefaultClient.CloseIdleConnections()
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	url := "http://" + server.Addr().String()
+	status := func(method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, url+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// while loading the process is alive but neither ready nor serving data
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable, "/get": http.StatusServiceUnavailable} {
+		if got := status(http.MethodPost, path, `{"key":"key"}`); got != want {
+			t.Errorf("%s while loading: expected status %v but got %v", path, want, got)
+		}
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for status(http.MethodGet, "/readyz", "") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("the readiness probe did not succeed after the load completed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := status(http.MethodPost, "/get", `{"key":"key"}`); got != http.StatusNotFound {
+		t.Errorf("/get after loading: expected status %v but got %v", http.StatusNotFound, got)
+	}
+}
+
+func TestServer_Run_PreShutdownDelay(t *testing.T) {
+	env := Config{
+		ServerAddress:    "127.0.0.1:0",
+		ShutdownTimeout:  time.Second,
+		PreShutdownDelay: 300 * time.Millisecond,
+	}
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	url := "http://" + server.Addr().String()
+	status := func(method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, url+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status(http.MethodGet, "/readyz", ""); got != http.StatusOK {
+		t.Fatalf("expected status %v before the shutdown but got %v", http.StatusOK, got)
+	}
+
+	start := time.Now()
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for status(http.MethodGet, "/readyz", "") != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("the readiness probe did not fail after the shutdown started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// requests are still served during the delay
+	if got := status(http.MethodPost, "/set", `{"key":"key","value":"value"}`); got != http.StatusOK {
+		t.Errorf("/set during the delay: expected status %v but got %v", http.StatusOK, got)
+	}
+
+	http.DefaultClient.CloseIdleConnections()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed < env.PreShutdownDelay {
+		t.Errorf("expected the shutdown to wait for %v but it took %v", env.PreShutdownDelay, elapsed)
+	}
+}
+
+func TestKeyValueStore_MiddlewareStopping(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+	writeTestValue(kv, "a", "1")
+
+	// a request in flight when the server starts stopping
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		inFlight <- serveNamespace(handler, "", http.MethodGet, "/changes?since=1&wait=30s", "")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	kv.SetStopping(true)
+	for _, path := range []string{"/get", "/metrics"} {
+		w := serveNamespace(handler, "", http.MethodPost, path, `{"key":"a"}`)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status %v but got %v", path, http.StatusServiceUnavailable, w.Code)
+		}
+		if got := w.Header().Get("Connection"); got != "close" {
+			t.Errorf("%s: expected the connection to be closed but got %q", path, got)
+		}
+	}
+
+	writeTestValue(kv, "b", "2")
+	select {
+	case w := <-inFlight:
+		if w.Code != http.StatusOK || len(decodeChanges(t, w)) != 1 {
+			t.Errorf("expected the request in flight to complete but got %v %s", w.Code, w.Body.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request in flight to complete")
+	}
+}
@@ -0,0 +1,89 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// curl -X POST -d '{"enabled": true}' http://localhost:8080/admin/readonly
+
+// ErrorResponse is the body of errors that clients are expected to handle programmatically
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes an ErrorResponse with the given status
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Code: code, Message: message})
+}
+
+type ReadOnlyRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type ReadOnlyResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SetReadOnly enables or disables mutations of the store and all its namespaces through the handlers
+func (kv *KeyValueStore) SetReadOnly(enabled bool) {
+	kv.root.readOnly.Store(enabled)
+}
+
+// ReadOnly reports whether mutations through the handlers are disabled
+func (kv *KeyValueStore) ReadOnly() bool {
+	return kv.root.readOnly.Load()
+}
+
+// rejectReadOnly answers 403 and returns true if the store is read-only
+func (kv *KeyValueStore) rejectReadOnly(w http.ResponseWriter) bool {
+	if !kv.ReadOnly() {
+		return false
+	}
+	writeError(w, http.StatusForbidden, "READ_ONLY", "the store is in read-only mode")
+	return true
+}
+
+// MiddlewareReadOnly rejects every request with 403 while the store is read-only, it must only wrap mutating endpoints
+func (kv *KeyValueStore) MiddlewareReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if kv.rejectReadOnly(w) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// MiddlewareReadOnlyWrites rejects every request but GET and HEAD with 403 while the store is read-only, for endpoints serving reads and writes
+func (kv *KeyValueStore) MiddlewareReadOnlyWrites(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && kv.rejectReadOnly(w) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ReadOnlyHandler returns the read-only state on GET and changes it on POST
+func (kv *KeyValueStore) ReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var payload ReadOnlyRequest
+		if !decodeRequest(w, r, &payload) {
+			return
+		}
+		kv.SetReadOnly(payload.Enabled)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReadOnlyResponse{Enabled: kv.ReadOnly()})
+}
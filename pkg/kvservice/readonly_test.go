@@ -0,0 +1,87 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	env := Config{
+		ReadOnly: true,
+	}
+	kv := env.newStore()
+	writeTestValue(kv, "a", "1")
+	handler := env.routes(kv)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	mutations := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: http.MethodPost, path: "/set", body: `{"key":"a","value":"2"}`},
+		{method: http.MethodPost, path: "/setnx", body: `{"key":"b","value":"2"}`},
+		{method: http.MethodPost, path: "/pop", body: `{"key":"a"}`},
+		{method: http.MethodPost, path: "/flush"},
+		{method: http.MethodPut, path: "/kv/a", body: "2"},
+		{method: http.MethodDelete, path: "/kv/a"},
+	}
+
+	// the startup flag rejects every mutation
+	for _, m := range mutations {
+		w := do(m.method, m.path, m.body)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status %v but got %v", m.method, m.path, http.StatusForbidden, w.Code)
+			continue
+		}
+		var got ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.Code != "READ_ONLY" {
+			t.Errorf("%s %s: expected READ_ONLY error but got %v (%v)", m.method, m.path, got, err)
+		}
+	}
+	if values := testValues(kv); values["a"] != "1" || len(values) != 1 {
+		t.Fatalf("expected the store to be unchanged but got %v", values)
+	}
+
+	// reads are unaffected
+	if w := do(http.MethodPost, "/get", `{"key":"a"}`); w.Code != http.StatusOK {
+		t.Errorf("get: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+	if w := do(http.MethodGet, "/kv/a", ""); w.Code != http.StatusOK {
+		t.Errorf("kv get: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+	if w := do(http.MethodPost, "/exists", `{"key":"a"}`); w.Code != http.StatusOK {
+		t.Errorf("exists: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+
+	// the admin endpoint turns mutations back on at runtime
+	w := do(http.MethodPost, "/admin/readonly", `{"enabled":false}`)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"enabled":false}` {
+		t.Fatalf("expected read-only to be disabled but got %v %v", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/set", `{"key":"a","value":"2"}`); w.Code != http.StatusOK {
+		t.Errorf("set: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+
+	// and off again
+	do(http.MethodPost, "/admin/readonly", `{"enabled":true}`)
+	if w := do(http.MethodGet, "/admin/readonly", ""); strings.TrimSpace(w.Body.String()) != `{"enabled":true}` {
+		t.Errorf("expected read-only to be enabled but got %v", w.Body.String())
+	}
+	if w := do(http.MethodPut, "/kv/a", "3"); w.Code != http.StatusForbidden {
+		t.Errorf("kv put: expected status %v but got %v", http.StatusForbidden, w.Code)
+	}
+	if got := testValues(kv)["a"]; got != "2" {
+		t.Errorf("expected value %q but got %q", "2", got)
+	}
+}
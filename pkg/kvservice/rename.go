@@ -0,0 +1,85 @@
+package kvservice
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"from":"a","to":"b"}' http://localhost:8080/rename
+
+type RenameRequest struct {
+	From      Key    `json:"from"`
+	To        Key    `json:"to"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RenameHandler moves the value of from to to with both keys locked, so readers see either the old or the new key but never both or neither
+// an existing to is overwritten like rename(2) does, use /copy without overwrite followed by /delete to keep it instead
+// it answers 404 if from does not exist
+func (kv *KeyValueStore) RenameHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	var payload RenameRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	if kv.rejectInvalidKey(w, payload.To) {
+		return
+	}
+
+	unlock := kv.lockKeys(payload.From, payload.To)
+
+	from := kv.shard(payload.From)
+	e, ok := from.kvMap[payload.From]
+	if !ok {
+		unlock()
+		http.Error(w, "Source key not found", http.StatusNotFound)
+		return
+	}
+	if payload.From == payload.To {
+		unlock()
+		fmt.Fprintln(w, http.StatusOK)
+		return
+	}
+
+	// the tombstone is kept before the source is unlinked so the deletion is logged with it, see logChange
+	// it must not share the items the new key modifies in place
+	tombstone := e
+	tombstone.items = slices.Clone(e.items)
+	from.bury(payload.From, tombstone, kv.now())
+	// the source is unlinked first so the value is not counted twice against the limits while it moves
+	if _, _, err := from.unlink(payload.From); err != nil {
+		kv.rollback([]undo{{s: from, key: payload.From, prev: e, existed: true}})
+		unlock()
+		writeStoreError(w, err)
+		return
+	}
+	to := kv.shard(payload.To)
+	_, err := to.writeEntry(payload.To, entry{value: e.value, rawLen: e.rawLen, kind: e.kind, items: e.items, fields: e.fields})
+	if !writeApplied(err) {
+		kv.rollback([]undo{{s: from, key: payload.From, prev: e, existed: true}})
+		unlock()
+		writeStoreError(w, err)
+		return
+	}
+	// a write of to that could not be logged is applied, the rename is complete and only not acknowledged
+	from.kv.stats.deletes.Add(1)
+	to.kv.stats.sets.Add(1)
+	unlock()
+	kv.auditRename(r, payload.From, payload.To, e.valueLen())
+	if err == nil {
+		err = kv.syncLog()
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, http.StatusOK)
+}
@@ -0,0 +1,59 @@
+package kvservice
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestKeyValueStore_RenameHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		want     map[Key]Value
+	}{
+		{
+			name:     "rename",
+			body:     `{"from":"a","to":"c"}`,
+			wantCode: http.StatusOK,
+			want:     map[Key]Value{"b": "2", "c": "1"},
+		},
+		{
+			name:     "missing source",
+			body:     `{"from":"missing","to":"c"}`,
+			wantCode: http.StatusNotFound,
+			want:     map[Key]Value{"a": "1", "b": "2"},
+		},
+		{
+			name:     "existing target is overwritten",
+			body:     `{"from":"a","to":"b"}`,
+			wantCode: http.StatusOK,
+			want:     map[Key]Value{"b": "1"},
+		},
+		{
+			name:     "same key",
+			body:     `{"from":"a","to":"a"}`,
+			wantCode: http.StatusOK,
+			want:     map[Key]Value{"a": "1", "b": "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newTestStore(map[Key]Value{"a": "1", "b": "2"})
+			env := Config{}
+
+			w := serveNamespace(env.routes(kv), "", http.MethodPost, "/rename", tt.body)
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if got := testValues(kv); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+			if got, want := kv.Bytes(), int64(2*len(tt.want)); got != want {
+				t.Errorf("expected %d bytes but got %d", want, got)
+			}
+		})
+	}
+}
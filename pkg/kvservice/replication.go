@@ -0,0 +1,298 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// go run . --address :8081 --replicate-from http://primary:8080
+
+const (
+	// replicationWait is how long a request for changes waits on the primary, it stays below common handler timeouts
+	replicationWait = 10 * time.Second
+	// replicationBackoff is the wait after the first failed request to the primary, it doubles up to maxReplicationBackoff
+	replicationBackoff    = 500 * time.Millisecond
+	maxReplicationBackoff = 30 * time.Second
+)
+
+// errChangesGone is returned when the primary no longer keeps the changes the replica needs, it has to resync
+var errChangesGone = errors.New("the primary no longer keeps the changes after the last applied one")
+
+// ReplicationStatus is the state of a replica as reported by /stats
+type ReplicationStatus struct {
+	Primary string `json:"primary"`
+	// Synced is set once the initial sync completed
+	Synced bool `json:"synced"`
+	// AppliedSeq is the sequence number of the last change of the primary that was applied
+	AppliedSeq uint64 `json:"applied_seq"`
+	// PrimarySeq is the sequence number of the last change of the primary the replica knows of
+	PrimarySeq uint64 `json:"primary_seq"`
+	// Lag is the number of changes of the primary that are not applied yet
+	Lag uint64 `json:"lag"`
+}
+
+// Replicator keeps the store a copy of a primary, it syncs with /dump?all=true and then tails /changes?all=true&values=true
+// the store is marked as loading until the initial sync completed, on errors it reconnects with backoff and a 410 from /changes resyncs
+// the primary must serve both endpoints on the given address, so it can not have a dedicated admin address or a base path
+type Replicator struct {
+	primary string
+	client  *http.Client
+	store   *KeyValueStore
+
+	synced     atomic.Bool
+	appliedSeq atomic.Uint64
+	primarySeq atomic.Uint64
+}
+
+// NewReplicator returns a Replicator copying the primary at the given URL into the store, Run starts it
+func NewReplicator(primary string, client *http.Client, store *KeyValueStore) *Replicator {
+	r := &Replicator{primary: primary, client: client, store: store}
+	store.root.replicator = r
+	return r
+}
+
+// Status returns the replication state
+func (r *Replicator) Status() ReplicationStatus {
+	status := ReplicationStatus{
+		Primary:    redactURL(r.primary),
+		Synced:     r.synced.Load(),
+		AppliedSeq: r.appliedSeq.Load(),
+		PrimarySeq: r.primarySeq.Load(),
+	}
+	if status.PrimarySeq > status.AppliedSeq {
+		status.Lag = status.PrimarySeq - status.AppliedSeq
+	}
+	return status
+}
+
+// check is the health check of the replica, it fails until the initial sync from the primary completed
+func (r *Replicator) check(context.Context) error {
+	if !r.synced.Load() {
+		return fmt.Errorf("not synced with %s", redactURL(r.primary))
+	}
+	return nil
+}
+
+// Run replicates until ctx is done
+func (r *Replicator) Run(ctx context.Context) {
+	backoff := replicationBackoff
+	resync := true
+	for ctx.Err() == nil {
+		var err error
+		if resync {
+			if err = r.resync(ctx); err == nil {
+				resync = false
+				if !r.synced.Swap(true) {
+					r.store.SetLoading(false)
+					slog.Info("initial sync from primary completed", "primary", redactURL(r.primary), "seq", r.appliedSeq.Load())
+				}
+			}
+		} else if err = r.tail(ctx); errors.Is(err, errChangesGone) {
+			slog.Warn("resyncing from primary", "primary", redactURL(r.primary), "reason", err)
+			resync = true
+			continue
+		}
+		if err == nil {
+			backoff = replicationBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("failed to replicate from primary", "primary", redactURL(r.primary), "error", err, "retry_in", backoff)
+		select {
+		case <-time.After(backoff):
+			backoff = min(2*backoff, maxReplicationBackoff)
+		case <-ctx.Done():
+		}
+	}
+}
+
+// get requests the path of the primary and returns the response if it is answered with one of the accepted statuses
+func (r *Replicator) get(ctx context.Context, path string, query url.Values, accept ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.primary+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range accept {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s answered %s: %s", path, resp.Status, body)
+}
+
+// changeSeq returns the X-Change-Seq header of a response of the primary
+func changeSeq(resp *http.Response) (uint64, error) {
+	seq, err := strconv.ParseUint(resp.Header.Get("X-Change-Seq"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid X-Change-Seq header %q", resp.Header.Get("X-Change-Seq"))
+	}
+	return seq, nil
+}
+
+// resync copies all namespaces of the primary and removes the keys the primary does not hold
+// the store keeps serving its previous data while it is overwritten, it is only consistent again once the changes since the dump are applied
+func (r *Replicator) resync(ctx context.Context) error {
+	resp, err := r.get(ctx, "/dump", url.Values{"all": {"true"}}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	seq, err := changeSeq(resp)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]map[Key]struct{})
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var record snapshotRecord
+		if err := dec.Decode(&record); err != nil {
+			return fmt.Errorf("read dump: %w", err)
+		}
+		// tombstones are the primary's to undelete, an undelete shows up as a set in its changes
+		if record.DeletedAt != nil {
+			continue
+		}
+		namespace := DefaultNamespace
+		if record.Namespace != "" {
+			if err := validateNamespace(record.Namespace); err != nil {
+				return err
+			}
+			namespace = record.Namespace
+		}
+		if keys[namespace] == nil {
+			keys[namespace] = make(map[Key]struct{})
+		}
+		keys[namespace][record.Key] = struct{}{}
+		if err := r.write(namespace, record.Key, record.entry(0)); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range r.store.Namespaces() {
+		kv := r.store.Namespace(name)
+		for _, s := range kv.shards {
+			s.Lock()
+			for key := range s.kvMap {
+				if _, ok := keys[name][key]; !ok {
+					if _, _, err := s.unlink(key); err != nil {
+						s.Unlock()
+						return fmt.Errorf("delete key %q: %w", key, err)
+					}
+				}
+			}
+			s.Unlock()
+		}
+	}
+	if err := r.store.syncLog(); err != nil {
+		return err
+	}
+
+	r.appliedSeq.Store(seq)
+	r.primarySeq.Store(seq)
+	return nil
+}
+
+// tail waits for the changes after the last applied one and applies them in order
+func (r *Replicator) tail(ctx context.Context) error {
+	query := url.Values{
+		"since":  {strconv.FormatUint(r.appliedSeq.Load(), 10)},
+		"all":    {"true"},
+		"values": {"true"},
+		"wait":   {replicationWait.String()},
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*replicationWait)
+	defer cancel()
+	resp, err := r.get(ctx, "/changes", query, http.StatusOK, http.StatusGone)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	seq, err := changeSeq(resp)
+	if err != nil {
+		return err
+	}
+	r.primarySeq.Store(seq)
+	if resp.StatusCode == http.StatusGone {
+		return errChangesGone
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var c Change
+		if err := dec.Decode(&c); err != nil {
+			return fmt.Errorf("read changes: %w", err)
+		}
+		if err := r.apply(c); err != nil {
+			return fmt.Errorf("apply change %d: %w", c.Seq, err)
+		}
+		if err := r.store.syncLog(); err != nil {
+			return fmt.Errorf("apply change %d: %w", c.Seq, err)
+		}
+		r.appliedSeq.Store(c.Seq)
+	}
+	return nil
+}
+
+// apply applies a change of the primary to the store
+func (r *Replicator) apply(c Change) error {
+	if err := validateNamespace(c.Namespace); err != nil {
+		return err
+	}
+	switch c.Event {
+	case WebhookEventSet:
+		record := snapshotRecord{List: c.List, Hash: c.Hash}
+		switch {
+		case c.Value != nil:
+			record.Value = *c.Value
+		case c.List == nil && c.Hash == nil:
+			// the key was deleted since, the deletion follows
+			return nil
+		}
+		record.ModifiedAt = c.Time
+		return r.write(c.Namespace, c.Key, record.entry(0))
+	case WebhookEventDelete:
+		s := r.store.Namespace(c.Namespace).shard(c.Key)
+		s.Lock()
+		_, _, err := s.unlink(c.Key)
+		s.Unlock()
+		if err != nil {
+			return fmt.Errorf("delete key %q: %w", c.Key, err)
+		}
+	case ChangeEventFlush:
+		if _, err := r.store.Namespace(c.Namespace).flush(); err != nil {
+			return fmt.Errorf("flush: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown event %q", c.Event)
+	}
+	return nil
+}
+
+// write stores the entry under the key of the namespace
+func (r *Replicator) write(namespace string, key Key, e entry) error {
+	s := r.store.Namespace(namespace).shard(key)
+	s.Lock()
+	defer s.Unlock()
+	if _, err := s.writeEntry(key, e); err != nil {
+		return fmt.Errorf("write key %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer runs a server for the test and returns its URL, it is shut down when the test ends
+func startServer(t *testing.T, env Config) (*Server, string) {
+	t.Helper()
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return server, "http://" + server.Addr().String()
+}
+
+func TestReplicator_Integration(t *testing.T) {
+	_, primary := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second})
+	do := func(url, method, path, namespace, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, url+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if namespace != "" {
+			req.Header.Set("X-Namespace", namespace)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	// eventually polls the replica until the check passes
+	eventually := func(what string, check func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !check() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not replicate", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the initial sync copies every namespace and type
+	do(primary, http.MethodPost, "/set", "", `{"key":"a","value":"1"}`)
+	do(primary, http.MethodPost, "/set", "team-a", `{"key":"a","value":"2"}`)
+	do(primary, http.MethodPost, "/list/push", "", `{"key":"q","value":"x"}`)
+	do(primary, http.MethodPost, "/hset", "", `{"key":"h","field":"f","value":"v"}`)
+
+	server, replica := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second, ReplicateFrom: primary})
+	eventually("the initial sync", func() bool {
+		code, _ := do(replica, http.MethodGet, "/readyz", "", "")
+		return code == http.StatusOK
+	})
+	for _, tt := range []struct {
+		namespace, path, body, want string
+	}{
+		{path: "/get", body: `{"key":"a"}`, want: `"1"`},
+		{namespace: "team-a", path: "/get", body: `{"key":"a"}`, want: `"2"`},
+		{path: "/hget", body: `{"key":"h","field":"f"}`, want: `"v"`},
+		{path: "/list/pop", body: `{"key":"q"}`, want: "403"},
+	} {
+		code, body := do(replica, http.MethodPost, tt.path, tt.namespace, tt.body)
+		if tt.want == "403" {
+			if code != http.StatusForbidden {
+				t.Errorf("%s: expected the read-only replica to answer %v but got %v", tt.path, http.StatusForbidden, code)
+			}
+			continue
+		}
+		if code != http.StatusOK || !strings.Contains(body, tt.want) {
+			t.Errorf("%s %s: expected %s but got %v %s", tt.namespace, tt.path, tt.want, code, body)
+		}
+	}
+
+	// later changes are tailed
+	do(primary, http.MethodPost, "/set", "", `{"key":"b","value":"3"}`)
+	eventually("a set", func() bool {
+		_, body := do(replica, http.MethodPost, "/get", "", `{"key":"b"}`)
+		return strings.Contains(body, `"3"`)
+	})
+	do(primary, http.MethodDelete, "/kv/a", "", "")
+	eventually("a delete", func() bool {
+		code, _ := do(replica, http.MethodPost, "/get", "", `{"key":"a"}`)
+		return code == http.StatusNotFound
+	})
+	do(primary, http.MethodPost, "/flush", "team-a", "")
+	eventually("a flush", func() bool {
+		code, _ := do(replica, http.MethodPost, "/get", "team-a", `{"key":"a"}`)
+		return code == http.StatusNotFound
+	})
+
+	var stats StatsResponse
+	_, body := do(replica, http.MethodGet, "/stats", "", "")
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Replication == nil || !stats.Replication.Synced || stats.Replication.Lag != 0 || stats.Replication.AppliedSeq == 0 {
+		t.Errorf("expected a synced replica without lag but got %+v", stats.Replication)
+	}
+	if status := server.replicator.Status(); status.Primary != primary {
+		t.Errorf("expected primary %s but got %s", primary, status.Primary)
+	}
+}
+
+func TestReplicator_Resync(t *testing.T) {
+	primary := newTestStore(map[Key]Value{"a": "1", "b": "2"})
+	env := Config{}
+	srv := httptest.NewServer(env.routes(primary))
+	defer srv.Close()
+
+	replica := newTestStore(map[Key]Value{"stale": "x", "a": "old"})
+	r := NewReplicator(srv.URL, srv.Client(), replica)
+	if err := r.resync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testValues(replica); len(got) != 2 || got["a"] != "1" || got["b"] != "2" {
+		t.Errorf("expected the keys of the primary only but got %v", got)
+	}
+
+	// a sequence number the primary does not know, e.g. after it restarted, needs a resync
+	r.appliedSeq.Store(primary.root.feed.last() + 1)
+	if err := r.tail(context.Background()); err != errChangesGone {
+		t.Errorf("expected %v but got %v", errChangesGone, err)
+	}
+}
@@ -0,0 +1,248 @@
+package kvservice
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// curl -X PUT --data-binary 'value1' http://localhost:8080/kv/key1
+// curl -i http://localhost:8080/kv/key1
+// curl -X PUT -H 'If-Match: "<etag of the GET>"' --data-binary 'value2' http://localhost:8080/kv/key1
+
+// KVHandler serves the path based API on /kv/{key}
+// GET returns the raw value with an ETag, PUT stores the request body as the value and DELETE removes the key.
+// Writes honor If-Match so concurrent writers get 412 Precondition Failed instead of clobbering each other.
+// The X-Namespace header selects the namespace.
+func (kv *KeyValueStore) KVHandler(w http.ResponseWriter, r *http.Request) {
+	key := Key(strings.TrimPrefix(r.URL.Path, "/kv/"))
+	if key == "" {
+		http.Error(w, "Key is required", http.StatusBadRequest)
+		return
+	}
+	requestNamespace := kv.requestNamespace
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		requestNamespace = kv.readNamespace
+	}
+	kv, ok := requestNamespace(w, r, "")
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		kv.getKV(w, r, key)
+	case http.MethodPut:
+		if kv.rejectReadOnly(w) {
+			return
+		}
+		kv.putKV(w, r, key)
+	case http.MethodDelete:
+		if kv.rejectReadOnly(w) {
+			return
+		}
+		kv.deleteKV(w, r, key)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (kv *KeyValueStore) getKV(w http.ResponseWriter, r *http.Request, key Key) {
+	e, ok, err := kv.getEntry(r.Context(), key)
+	if err != nil {
+		writeLockError(w, r, err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if e.kind != kindString {
+		writeWrongType(w, key)
+		return
+	}
+
+	if notModified(w, r, e) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(e.valueLen()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.WriteString(w, string(e.plain()))
+}
+
+func (kv *KeyValueStore) putKV(w http.ResponseWriter, r *http.Request, key Key) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if kv.rejectInvalidKey(w, key) || kv.rejectInvalidValue(w, Value(body)) {
+		return
+	}
+
+	s := kv.shard(key)
+	if err := s.lockContext(r.Context()); err != nil {
+		writeLockError(w, r, err)
+		return
+	}
+	current, exists := s.kvMap[key]
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!exists || !etagMatches(ifMatch, current.etag(), false)) {
+		s.Unlock()
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && exists && etagMatches(ifNoneMatch, current.etag(), true) {
+		s.Unlock()
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	e, err := s.put(key, Value(body))
+	s.Unlock()
+	if err == nil {
+		err = kv.syncLog()
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", e.etag())
+	kv.audit(r, "set", key, len(body), 0)
+
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// deleteKV removes the key, a delete without If-Match goes through Delete of the Store interface
+func (kv *KeyValueStore) deleteKV(w http.ResponseWriter, r *http.Request, key Key) {
+	if r.Header.Get("If-Match") == "" {
+		ok, err := kv.Delete(r.Context(), key)
+		if ok {
+			kv.audit(r, "delete", key, 0, 0)
+		}
+		switch {
+		case err != nil:
+			writeBackendError(w, r, key, err)
+		case !ok:
+			http.Error(w, "Key not found", http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	s := kv.shard(key)
+	if err := s.lockContext(r.Context()); err != nil {
+		writeLockError(w, r, err)
+		return
+	}
+	current, exists := s.kvMap[key]
+	if !exists {
+		s.Unlock()
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, current.etag(), false) {
+		s.Unlock()
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	_, _, err := s.remove(key)
+	s.Unlock()
+	kv.audit(r, "delete", key, 0, 0)
+	if err == nil {
+		err = kv.syncLog()
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// etag returns the strong entity tag for the entry, the one hashed when it was written unless the entry was never stored
+func (e entry) etag() string {
+	if e.tag != "" {
+		return e.tag
+	}
+	return e.hashETag()
+}
+
+// hashETag hashes the type and content of the entry into its entity tag
+// the version of an entry restarts with the process, a hash stays the same for the same value across restarts
+// and never matches another value, so a conditional request can not succeed against a value the client has not seen
+func (e entry) hashETag() string {
+	h := sha256.New()
+	h.Write([]byte{byte(e.kind)})
+	writeField := func(v string) {
+		h.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		io.WriteString(h, v)
+	}
+	switch e.kind {
+	case kindList:
+		for _, item := range e.items {
+			writeField(string(item))
+		}
+	case kindHash:
+		for _, field := range slices.Sorted(maps.Keys(e.fields)) {
+			writeField(field)
+			writeField(string(e.fields[field]))
+		}
+	default:
+		writeField(string(e.plain()))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified sets the ETag and Last-Modified headers of the entry and reports whether the request is conditional on a representation the client already has
+// If-Modified-Since is only considered without If-None-Match, which is the more precise of the two, and at the one second precision of HTTP dates
+func notModified(w http.ResponseWriter, r *http.Request, e entry) bool {
+	etag := e.etag()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", e.modified.UTC().Format(http.TimeFormat))
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag, true)
+	}
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	return err == nil && !e.modified.Truncate(time.Second).After(since)
+}
+
+// etagMatches reports whether the If-Match / If-None-Match header value matches the given strong entity tag
+// the header may be "*" or a comma separated list of tags, with weak a weak tag is compared by its opaque value like If-None-Match does,
+// otherwise it never matches, as If-Match uses the strong comparison of RFC 9110 so a write is never based on a weakly equal value
+func etagMatches(header string, etag string, weak bool) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package kvservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKVHandler_OptimisticUpdate(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+
+	do := func(method, key, body string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/kv/"+key, strings.NewReader(body))
+		for name, value := range header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		kv.KVHandler(w, r)
+		return w
+	}
+
+	resp := do(http.MethodPut, "key", "v1", nil)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("create: expected status %v but got %v", http.StatusCreated, resp.Code)
+	}
+
+	// GET returns the value together with an ETag
+	resp = do(http.MethodGet, "key", "", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("get: expected status %v but got %v", http.StatusOK, resp.Code)
+	}
+	if resp.Body.String() != "v1" {
+		t.Errorf("get: expected body %q but got %q", "v1", resp.Body.String())
+	}
+	etag := resp.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("get: expected an ETag header")
+	}
+
+	// If-None-Match with the current ETag is answered with 304
+	resp = do(http.MethodGet, "key", "", map[string]string{"If-None-Match": etag})
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("conditional get: expected status %v but got %v", http.StatusNotModified, resp.Code)
+	}
+	if resp.Body.Len() != 0 {
+		t.Errorf("conditional get: expected empty body but got %q", resp.Body.String())
+	}
+
+	// PUT with the current ETag succeeds and hands out a new one
+	resp = do(http.MethodPut, "key", "v2", map[string]string{"If-Match": etag})
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("update: expected status %v but got %v", http.StatusNoContent, resp.Code)
+	}
+	newETag := resp.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("update: expected a new ETag but got %q", newETag)
+	}
+
+	// PUT with the stale ETag fails and leaves the value untouched
+	resp = do(http.MethodPut, "key", "v3", map[string]string{"If-Match": etag})
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale update: expected status %v but got %v", http.StatusPreconditionFailed, resp.Code)
+	}
+
+	// If-Match compares strongly, the weak form of the current ETag does not match, while If-None-Match compares weakly
+	resp = do(http.MethodPut, "key", "v3", map[string]string{"If-Match": "W/" + newETag})
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("weak update: expected status %v but got %v", http.StatusPreconditionFailed, resp.Code)
+	}
+	resp = do(http.MethodGet, "key", "", map[string]string{"If-None-Match": "W/" + newETag})
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("weak conditional get: expected status %v but got %v", http.StatusNotModified, resp.Code)
+	}
+
+	resp = do(http.MethodGet, "key", "", map[string]string{"If-None-Match": etag})
+	if resp.Code != http.StatusOK || resp.Body.String() != "v2" {
+		t.Errorf("expected status %v with body %q but got %v with %q", http.StatusOK, "v2", resp.Code, resp.Body.String())
+	}
+	if resp.Header().Get("ETag") != newETag {
+		t.Errorf("expected ETag %v but got %v", newETag, resp.Header().Get("ETag"))
+	}
+}
+
+func TestKVHandler_IfMatchOnMissingKey(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+
+	r := httptest.NewRequest(http.MethodPut, "/kv/missing", strings.NewReader("value"))
+	r.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	kv.KVHandler(w, r)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status %v but got %v", http.StatusPreconditionFailed, w.Code)
+	}
+	if _, ok := kv.peek("missing"); ok {
+		t.Error("expected key to not be created")
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{header: "", etag: `"1"`, want: false},
+		{header: `"1"`, etag: `"1"`, want: true},
+		{header: `"2"`, etag: `"1"`, want: false},
+		{header: `"2", "1"`, etag: `"1"`, want: true},
+		{header: `W/"1"`, etag: `"1"`, weak: true, want: true},
+		{header: `W/"1"`, etag: `"1"`, want: false},
+		{header: `W/"2", "1"`, etag: `"1"`, want: true},
+		{header: "*", etag: `"1"`, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+			t.Errorf("etagMatches(%q, %q, %v) = %v, want %v", tt.header, tt.etag, tt.weak, got, tt.want)
+		}
+	}
+}
+
+func TestEntry_Etag(t *testing.T) {
+	serve := func(kv *KeyValueStore, method string, key Key, body string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		kv.KVHandler(w, httptest.NewRequest(method, "/kv/"+string(key), strings.NewReader(body)))
+		return w.Header().Get("ETag")
+	}
+
+	// a restarted process numbers its writes from the start again, the same version must not hand out the same ETag for another value
+	before, after := NewKeyValueStore(StoreOptions{}), NewKeyValueStore(StoreOptions{})
+	serve(before, http.MethodPut, "key", "v1")
+	serve(after, http.MethodPut, "key", "v2")
+	if serve(before, http.MethodGet, "key", "") == serve(after, http.MethodGet, "key", "") {
+		t.Error("expected different values written with the same version to have different ETags")
+	}
+
+	// the same value keeps its ETag when it is written to another store, whatever its version there
+	serve(after, http.MethodPut, "other", "value")
+	serve(after, http.MethodPut, "key", "v1")
+	if a, b := serve(before, http.MethodGet, "key", ""), serve(after, http.MethodGet, "key", ""); a != b {
+		t.Errorf("expected the same value to keep its ETag but got %s and %s", a, b)
+	}
+
+	// lists and hashes with the same content as a string do not share its ETag
+	list := entry{kind: kindList, items: []Value{"a", "b"}}
+	if list.etag() == (entry{value: "ab"}).etag() || list.etag() == (entry{kind: kindList, items: []Value{"ab"}}).etag() {
+		t.Error("expected the ETag to depend on the type and the boundaries of the items")
+	}
+	hash := entry{kind: kindHash, fields: map[string]Value{"a": "1", "b": "2"}}
+	if hash.etag() != (entry{kind: kindHash, fields: map[string]Value{"b": "2", "a": "1"}}).etag() {
+		t.Error("expected the ETag of a hash not to depend on the order of its fields")
+	}
+
+	// the ETag is hashed when the entry is written and every write replaces it, also one appending to a list
+	kv := NewKeyValueStore(StoreOptions{})
+	handler := (&Config{}).routes(kv)
+	var tags []string
+	for _, value := range []string{"job-1", "job-2"} {
+		serveNamespace(handler, "", http.MethodPost, "/list/push", `{"key":"queue","value":"`+value+`"}`)
+		e, _ := kv.peek("queue")
+		if e.tag == "" || e.tag != e.hashETag() {
+			t.Errorf("expected the ETag of the written entry to be kept with it but got %q", e.tag)
+		}
+		tags = append(tags, e.tag)
+	}
+	if tags[0] == tags[1] {
+		t.Error("expected the write to replace the ETag")
+	}
+}
@@ -0,0 +1,159 @@
+package kvservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// go run . --address :8080 --peers localhost:8080,localhost:8081,localhost:8082
+// go run . --address :8081 --peers localhost:8080,localhost:8081,localhost:8082 --peer-address localhost:8081
+
+// ringReplicas is the number of points every peer has on the ring, more points spread the keys more evenly
+const ringReplicas = 128
+
+// forwardedHeader marks a request forwarded by a peer, it is always handled locally so peers with different rings never forward in circles
+const forwardedHeader = "X-Forwarded-By-Peer"
+
+// ring is a consistent-hash ring of peers, adding or removing a peer only moves the keys of the ring segments it gains or loses
+type ring struct {
+	// self is the peer handled locally, it may be missing from the ring for a node that only routes
+	self    string
+	points  []uint64
+	owners  map[uint64]string
+	proxies map[string]*httputil.ReverseProxy
+}
+
+// newRing places ringReplicas points per peer on the ring
+func newRing(self string, peers []string) *ring {
+	r := &ring{self: self, owners: make(map[uint64]string), proxies: make(map[string]*httputil.ReverseProxy)}
+	for _, peer := range peers {
+		for i := range ringReplicas {
+			point := ringHash(peer + "#" + strconv.Itoa(i))
+			// on the rare collision the smaller name wins, so every node builds the same ring
+			if owner, ok := r.owners[point]; ok && owner < peer {
+				continue
+			}
+			r.owners[point] = peer
+		}
+		if peer != self {
+			r.proxies[peer] = newPeerProxy(peer)
+		}
+	}
+	for point := range r.owners {
+		r.points = append(r.points, point)
+	}
+	slices.Sort(r.points)
+	return r
+}
+
+// ringHash hashes s onto the ring, the same on every node
+// FNV-1a alone leaves similar strings like the point names of a peer close together, the finalizer of MurmurHash3 spreads them
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, s)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// owner returns the peer owning the key of the namespace, the first point on the ring at or after the hash of both
+func (r *ring) owner(namespace string, key Key) string {
+	if len(r.points) == 0 {
+		return r.self
+	}
+	point := ringHash(namespace + "\x00" + string(key))
+	i, _ := slices.BinarySearch(r.points, point)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// newPeerProxy forwards requests to the peer and answers 502 naming it if the peer can not be reached
+func newPeerProxy(peer string) *httputil.ReverseProxy {
+	target := &url.URL{Scheme: "http", Host: peer}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeError(w, http.StatusBadGateway, "PEER_UNAVAILABLE", fmt.Sprintf("peer %s: %v", peer, err))
+		},
+	}
+}
+
+// Router spreads the keys across the peers of a consistent-hash ring, a node handles the keys it owns and forwards the others
+type Router struct {
+	ring atomic.Pointer[ring]
+}
+
+// NewRouter returns a router for the peers, self is the entry of the peers that is handled locally
+func NewRouter(self string, peers []string) *Router {
+	router := &Router{}
+	router.SetPeers(self, peers)
+	return router
+}
+
+// SetPeers replaces the ring, requests in flight finish with the ring they started with
+func (router *Router) SetPeers(self string, peers []string) {
+	router.ring.Store(newRing(self, peers))
+}
+
+// routedRequest holds the fields of a request body that decide its owner
+type routedRequest struct {
+	Key       Key    `json:"key"`
+	Namespace string `json:"namespace"`
+}
+
+// Middleware forwards the request to the peer owning its key, the request is handled locally if this node owns the key
+// or if its body is not JSON, in which case the handler answers the malformed request
+func (router *Router) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ring := router.ring.Load()
+		if len(ring.points) == 0 || r.Header.Get(forwardedHeader) != "" {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var payload routedRequest
+		if json.Unmarshal(body, &payload) != nil {
+			next(w, r)
+			return
+		}
+		namespace := payload.Namespace
+		if namespace == "" {
+			namespace = r.Header.Get("X-Namespace")
+		}
+		if namespace == "" {
+			namespace = DefaultNamespace
+		}
+
+		owner := ring.owner(namespace, payload.Key)
+		if owner == ring.self {
+			next(w, r)
+			return
+		}
+		r.Header.Set(forwardedHeader, ring.self)
+		ring.proxies[owner].ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,112 @@
+package kvservice
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRing_Consistent(t *testing.T) {
+	before := newRing("a:1", []string{"a:1", "b:1", "c:1"})
+	after := newRing("a:1", []string{"a:1", "b:1", "c:1", "d:1"})
+
+	moved := 0
+	owned := make(map[string]int)
+	for i := range 10000 {
+		key := Key(fmt.Sprintf("key%d", i))
+		from, to := before.owner(DefaultNamespace, key), after.owner(DefaultNamespace, key)
+		owned[from]++
+		if from != to {
+			moved++
+			if to != "d:1" {
+				t.Fatalf("expected %s to only move to the new peer but it moved from %s to %s", key, from, to)
+			}
+		}
+	}
+	// the new peer takes about a quarter of the keys
+	if moved < 1500 || moved > 3500 {
+		t.Errorf("expected about 2500 keys to move but %d did", moved)
+	}
+	for peer, n := range owned {
+		if n < 2500 || n > 4200 {
+			t.Errorf("expected peer %s to own about a third of the keys but it owns %d", peer, n)
+		}
+	}
+}
+
+func TestRouter_Forward(t *testing.T) {
+	var servers []*Server
+	var urls, peers []string
+	for range 3 {
+		server, url := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second})
+		servers = append(servers, server)
+		urls = append(urls, url)
+		peers = append(peers, server.Addr().String())
+	}
+	for i, server := range servers {
+		server.env.router.SetPeers(peers[i], peers)
+	}
+	do := func(url, path, body string) (int, string) {
+		t.Helper()
+		resp, err := http.Post(url+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	ring := newRing("", peers)
+	for i := range 30 {
+		key := Key(fmt.Sprintf("key%d", i))
+		if code, body := do(urls[i%3], "/set", fmt.Sprintf(`{"key":%q,"value":"v%d"}`, key, i)); code != http.StatusOK {
+			t.Fatalf("set %s: expected status %v but got %v %s", key, http.StatusOK, code, body)
+		}
+
+		// the key is only stored on its owner
+		for j, server := range servers {
+			_, stored := server.store.peek(key)
+			if owner := peers[j] == ring.owner(DefaultNamespace, key); stored != owner {
+				t.Errorf("%s: expected stored on %s to be %v", key, peers[j], owner)
+			}
+		}
+		for _, url := range urls {
+			if code, body := do(url, "/get", fmt.Sprintf(`{"key":%q}`, key)); code != http.StatusOK || !strings.Contains(body, fmt.Sprintf(`"v%d"`, i)) {
+				t.Errorf("get %s through %s: expected the value but got %v %s", key, url, code, body)
+			}
+		}
+	}
+}
+
+func TestRouter_PeerUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := listener.Addr().String()
+	listener.Close()
+
+	server, url := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second})
+	self := server.Addr().String()
+	server.env.router.SetPeers(self, []string{self, dead})
+
+	ring := newRing(self, []string{self, dead})
+	key := "key0"
+	for i := 0; ring.owner(DefaultNamespace, Key(key)) != dead; i++ {
+		key = fmt.Sprintf("key%d", i)
+	}
+	resp, err := http.Post(url+"/get", "application/json", strings.NewReader(fmt.Sprintf(`{"key":%q}`, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(string(body), "PEER_UNAVAILABLE") || !strings.Contains(string(body), dead) {
+		t.Errorf("expected status %v naming peer %s but got %v %s", http.StatusBadGateway, dead, resp.StatusCode, body)
+	}
+}
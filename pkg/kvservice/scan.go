@@ -0,0 +1,107 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// curl 'http://localhost:8080/scan?prefix=user:&limit=100'
+// curl 'http://localhost:8080/scan?prefix=user:&limit=100&cursor=dXNlcjo0Mg'
+
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// ScanItem is a key with its value as returned by the scan endpoint
+type ScanItem struct {
+	Key   Key   `json:"key"`
+	Value Value `json:"value"`
+	// List holds the items if the key holds a list
+	List []Value `json:"list,omitempty"`
+	// Hash holds the fields if the key holds a hash
+	Hash map[string]Value `json:"hash,omitempty"`
+}
+
+// scan returns up to limit items whose key starts with prefix and sorts after the key after, in key order
+// more reports whether further matching items exist
+// it stops with the error of ctx before the next shard once ctx is done
+func (kv *KeyValueStore) scan(ctx context.Context, prefix string, after Key, limit int) (items []ScanItem, more bool, err error) {
+	for _, sh := range kv.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		sh.RLock()
+		for k, e := range sh.kvMap {
+			if strings.HasPrefix(string(k), prefix) && k > after {
+				items = append(items, ScanItem{Key: k, Value: e.plain(), List: slices.Clone(e.items), Hash: e.fields})
+			}
+		}
+		sh.RUnlock()
+	}
+
+	slices.SortFunc(items, func(a, b ScanItem) int {
+		return strings.Compare(string(a.Key), string(b.Key))
+	})
+	if len(items) > limit {
+		return items[:limit], true, nil
+	}
+	return items, false, nil
+}
+
+// ScanHandler returns the key value pairs of the namespace whose key starts with the prefix parameter as a JSON array sorted by key
+// at most limit pairs are returned, if there are more the X-Next-Cursor header holds the cursor to pass for the next page
+func (kv *KeyValueStore) ScanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.readNamespace(w, r, "")
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultScanLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxScanLimit {
+			http.Error(w, "Limit must be between 1 and "+strconv.Itoa(maxScanLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// the cursor is the last key of the previous page, encoded so clients do not rely on its format
+	var after Key
+	if v := query.Get("cursor"); v != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = Key(decoded)
+	}
+
+	items, more, err := kv.scan(r.Context(), query.Get("prefix"), after, limit)
+	if err != nil {
+		writeContextError(w, r, err)
+		return
+	}
+	if items == nil {
+		items = []ScanItem{}
+	}
+	if more {
+		w.Header().Set("X-Next-Cursor", base64.RawURLEncoding.EncodeToString([]byte(items[len(items)-1].Key)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
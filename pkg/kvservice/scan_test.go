@@ -0,0 +1,141 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scanPage(t *testing.T, kv *KeyValueStore, query url.Values) ([]ScanItem, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	kv.ScanHandler(w, httptest.NewRequest(http.MethodGet, "/scan?"+query.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var items []ScanItem
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return items, w.Header().Get("X-Next-Cursor")
+}
+
+func TestKeyValueStore_ScanHandler(t *testing.T) {
+	kv := newTestStore(map[Key]Value{
+		"user:3": "c",
+		"user:1": "a",
+		"user:2": "b",
+		"users":  "x",
+		"order:": "o",
+	})
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		want       []ScanItem
+		wantCursor bool
+	}{
+		{
+			name:  "prefix",
+			query: url.Values{"prefix": {"user:"}},
+			want:  []ScanItem{{Key: "user:1", Value: "a"}, {Key: "user:2", Value: "b"}, {Key: "user:3", Value: "c"}},
+		},
+		{
+			name:       "limit",
+			query:      url.Values{"prefix": {"user"}, "limit": {"2"}},
+			want:       []ScanItem{{Key: "user:1", Value: "a"}, {Key: "user:2", Value: "b"}},
+			wantCursor: true,
+		},
+		{
+			name:       "no prefix returns everything",
+			query:      url.Values{"limit": {"1"}},
+			want:       []ScanItem{{Key: "order:", Value: "o"}},
+			wantCursor: true,
+		},
+		{
+			name:  "no match",
+			query: url.Values{"prefix": {"missing"}},
+			want:  []ScanItem{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, cursor := scanPage(t, kv, tt.query)
+			if !reflect.DeepEqual(items, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, items)
+			}
+			if (cursor != "") != tt.wantCursor {
+				t.Errorf("expected a cursor %v but got %q", tt.wantCursor, cursor)
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_ScanHandler_Cursor(t *testing.T) {
+	values := map[Key]Value{}
+	for _, k := range []Key{"k1", "k2", "k3", "k4", "k5", "other"} {
+		values[k] = Value(k)
+	}
+	kv := newTestStore(values)
+
+	var keys []Key
+	query := url.Values{"prefix": {"k"}, "limit": {"2"}}
+	for pages := 1; ; pages++ {
+		items, cursor := scanPage(t, kv, query)
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+		if cursor == "" {
+			if pages != 3 {
+				t.Errorf("expected 3 pages but got %d", pages)
+			}
+			break
+		}
+		query.Set("cursor", cursor)
+	}
+
+	want := []Key{"k1", "k2", "k3", "k4", "k5"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected %v but got %v", want, keys)
+	}
+}
+
+func TestKeyValueStore_ScanHandler_Invalid(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=ten", "cursor=not*base64"} {
+		w := httptest.NewRecorder()
+		kv.ScanHandler(w, httptest.NewRequest(http.MethodGet, "/scan?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %v but got %v", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestKeyValueStore_ScanHandler_Context(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"user:1": "a", "user:2": "b"})
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	kv.ScanHandler(w, httptest.NewRequest(http.MethodGet, "/scan", nil).WithContext(canceled))
+	if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("expected no response to a client that went away but got %q", w.Body.String())
+	}
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	w = httptest.NewRecorder()
+	kv.ScanHandler(w, httptest.NewRequest(http.MethodGet, "/scan", nil).WithContext(expired))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "DEADLINE_EXCEEDED") {
+		t.Errorf("expected status %v but got %v %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+}
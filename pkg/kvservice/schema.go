@@ -0,0 +1,48 @@
+package kvservice
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// loadValueSchema compiles the JSON Schema file the values are validated against
+func loadValueSchema(path string) (*jsonschema.Schema, error) {
+	schema, err := jsonschema.NewCompiler().Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("compile value schema: %w", err)
+	}
+	return schema, nil
+}
+
+// SetValueSchema makes the write handlers of all namespaces reject values that do not match the schema, nil disables validation
+// it must be called before the store is served
+func (kv *KeyValueStore) SetValueSchema(schema *jsonschema.Schema) {
+	kv.root.valueSchema = schema
+}
+
+// validateValue checks the value against the schema if one is configured
+// a value holding a JSON document is validated as that document, any other value as a JSON string
+func (kv *KeyValueStore) validateValue(value Value) error {
+	schema := kv.root.valueSchema
+	if schema == nil {
+		return nil
+	}
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(string(value)))
+	if err != nil {
+		doc = string(value)
+	}
+	return schema.Validate(doc)
+}
+
+// rejectInvalidValue answers 422 and returns true if the value does not match the schema
+func (kv *KeyValueStore) rejectInvalidValue(w http.ResponseWriter, value Value) bool {
+	err := kv.validateValue(value)
+	if err == nil {
+		return false
+	}
+	writeError(w, http.StatusUnprocessableEntity, "SCHEMA_VIOLATION", err.Error())
+	return true
+}
@@ -0,0 +1,80 @@
+package kvservice
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testValueSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string"},
+		"age": {"type": "integer", "minimum": 0}
+	},
+	"required": ["name"]
+}`
+
+func TestKeyValueStore_SetHandler_ValueSchema(t *testing.T) {
+	schema, err := loadValueSchema(writeConfigFile(t, "schema.json", testValueSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		wantCode int
+	}{
+		{name: "conforming value", value: `{\"name\":\"gopher\",\"age\":13}`, wantCode: http.StatusOK},
+		{name: "missing required property", value: `{\"age\":13}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "wrong property type", value: `{\"name\":\"gopher\",\"age\":-1}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "value that is not JSON", value: `gopher`, wantCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := NewKeyValueStore(StoreOptions{})
+			kv.SetValueSchema(schema)
+
+			w := httptest.NewRecorder()
+			kv.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString(`{"key":"k","value":"`+tt.value+`"}`)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %v but got %v: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+
+			_, stored := kv.peek("k")
+			if stored != (tt.wantCode == http.StatusOK) {
+				t.Errorf("expected the value to be stored only if it matches the schema")
+			}
+			if tt.wantCode == http.StatusUnprocessableEntity && !strings.Contains(w.Body.String(), "SCHEMA_VIOLATION") {
+				t.Errorf("expected error code SCHEMA_VIOLATION but got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_KVHandler_ValueSchema(t *testing.T) {
+	schema, err := loadValueSchema(writeConfigFile(t, "schema.json", `{"type": "string", "maxLength": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetValueSchema(schema)
+
+	for body, want := range map[string]int{"abc": http.StatusCreated, "abcd": http.StatusUnprocessableEntity} {
+		w := httptest.NewRecorder()
+		kv.KVHandler(w, httptest.NewRequest(http.MethodPut, "/kv/"+body, strings.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("PUT %q: expected status %v but got %v", body, want, w.Code)
+		}
+	}
+}
+
+func TestLoadValueSchema_Invalid(t *testing.T) {
+	if _, err := loadValueSchema(writeConfigFile(t, "schema.json", `{"type": 42}`)); err == nil {
+		t.Error("expected an error for an invalid schema")
+	}
+}
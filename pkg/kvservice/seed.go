@@ -0,0 +1,105 @@
+package kvservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// go run . --seed testdata/demo.jsonl
+// go run . --seed https://example.com/demo.jsonl --snapshot-file data.jsonl
+
+// seed loads the configured seed into the new store before the listeners open
+// with persistence it is only loaded while the snapshot file does not exist, so a restart keeps the data written since
+// a write-ahead log left by a crash before the first snapshot is replayed on top of the seed as usual
+func (env *Config) seed(kvStore *KeyValueStore) error {
+	if env.SnapshotFile != "" {
+		_, err := os.Stat(env.SnapshotFile)
+		if err == nil {
+			slog.Info("seed skipped, the snapshot file exists", "seed", redactURL(env.Seed), "file", env.SnapshotFile)
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to seed: %w", err)
+		}
+	}
+
+	ctx := context.Background()
+	if env.LoadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, env.LoadTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	r, err := openSeed(ctx, env.Seed)
+	if err != nil {
+		return fmt.Errorf("failed to seed: %w", err)
+	}
+	defer r.Close()
+
+	// the seed is no news to the change feed, like a snapshot being loaded
+	kvStore.SetLoading(true)
+	n, skipped, err := kvStore.Seed(ctx, r, env.SeedIgnoreErrors)
+	kvStore.SetLoading(false)
+	if err != nil {
+		return fmt.Errorf("failed to seed from %s after %d keys: %w", redactURL(env.Seed), n, err)
+	}
+	slog.Info("store seeded", "keys", n, "skipped_lines", skipped, "seed", redactURL(env.Seed), "duration", time.Since(start))
+	return nil
+}
+
+// openSeed opens the seed file, or fetches it if it is an http or https URL
+func openSeed(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", redactURL(source), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Seed writes the records of a dump read from r into the store one line at a time and returns how many keys were written
+// a malformed line stops it with the number of the line, unless ignoreErrors skips such lines and counts them as skipped
+// a full store always stops it, skipping the rest would leave a seed that is incomplete in no predictable way
+func (kv *KeyValueStore) Seed(ctx context.Context, r io.Reader, ignoreErrors bool) (n, skipped int, err error) {
+	br := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			written, err := kv.readRecords(ctx, bytes.NewReader(line), false)
+			n += written
+			switch {
+			case err == nil:
+			case ignoreErrors && ctx.Err() == nil && !errors.Is(err, ErrStoreFull):
+				slog.Warn("skipping malformed seed line", "line", lineNumber, "error", err)
+				skipped++
+			default:
+				return n, skipped, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+		}
+		if readErr == io.EOF {
+			return n, skipped, nil
+		}
+		if readErr != nil {
+			return n, skipped, readErr
+		}
+	}
+}
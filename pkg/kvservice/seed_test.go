@@ -0,0 +1,125 @@
+package kvservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSeed = `{"key":"a","value":"1"}
+{"namespace":"tenant","key":"b","value":"2"}
+
+{"key":"c","list":["x","y"]}
+`
+
+// listen opens the listeners of the server without serving them and closes them when the test ends
+func listen(t *testing.T, env Config) *Server {
+	t.Helper()
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, l := range server.listeners {
+			l.Close()
+		}
+	})
+	return server
+}
+
+func TestListen_Seed(t *testing.T) {
+	file := writeConfigFile(t, "seed.jsonl", testSeed)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/seed.jsonl" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testSeed))
+	}))
+	defer ts.Close()
+
+	for _, seed := range []string{file, ts.URL + "/seed.jsonl"} {
+		kv := listen(t, Config{ServerAddress: "127.0.0.1:0", Seed: seed}).store
+		if e, _ := kv.peek("a"); e.plain() != "1" {
+			t.Errorf("%s: expected the seeded value but got %q", seed, e.plain())
+		}
+		if e, _ := kv.Namespace("tenant").peek("b"); e.plain() != "2" {
+			t.Errorf("%s: expected the seeded value of the namespace but got %q", seed, e.plain())
+		}
+		if e, _ := kv.peek("c"); len(e.items) != 2 {
+			t.Errorf("%s: expected the seeded list but got %v", seed, e.items)
+		}
+		if kv.Loading() {
+			t.Errorf("%s: expected the store to be loaded", seed)
+		}
+	}
+
+	for _, seed := range []string{filepath.Join(t.TempDir(), "missing.jsonl"), ts.URL + "/missing.jsonl"} {
+		if _, err := Listen(Config{ServerAddress: "127.0.0.1:0", Seed: seed}); err == nil {
+			t.Errorf("%s: expected a missing seed to fail the startup", seed)
+		}
+	}
+}
+
+func TestListen_Seed_Malformed(t *testing.T) {
+	seed := writeConfigFile(t, "seed.jsonl", `{"key":"a","value":"1"}
+{"key":"b","value":
+{"key":"c","value":"3"}
+`)
+	_, err := Listen(Config{ServerAddress: "127.0.0.1:0", Seed: seed})
+	if err == nil || !strings.Contains(err.Error(), "after 1 keys: line 2") {
+		t.Fatalf("expected the malformed line to fail the startup but got %v", err)
+	}
+
+	kv := listen(t, Config{ServerAddress: "127.0.0.1:0", Seed: seed, SeedIgnoreErrors: true}).store
+	if got := kv.Len(); got != 2 {
+		t.Errorf("expected the valid lines to be seeded but got %d keys", got)
+	}
+}
+
+func TestListen_Seed_SnapshotFile(t *testing.T) {
+	seed := writeConfigFile(t, "seed.jsonl", testSeed)
+	snapshotFile := filepath.Join(t.TempDir(), "data.jsonl")
+
+	// without a snapshot the store is seeded and the seed ends up in the final snapshot
+	server := listen(t, Config{ServerAddress: "127.0.0.1:0", Seed: seed, SnapshotFile: snapshotFile})
+	if e, _ := server.store.peek("a"); e.plain() != "1" {
+		t.Fatalf("expected the seeded value but got %q", e.plain())
+	}
+	server.store.Set(context.Background(), "a", "live")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := server.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart must not overwrite what was written since
+	server = listen(t, Config{ServerAddress: "127.0.0.1:0", Seed: seed, SnapshotFile: snapshotFile})
+	if n := server.store.Len(); n != 0 {
+		t.Errorf("expected the seed to be skipped but the store holds %d keys before the snapshot is loaded", n)
+	}
+	if _, err := server.loadSnapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := server.store.peek("a"); e.plain() != "live" {
+		t.Errorf("expected the value of the snapshot but got %q", e.plain())
+	}
+}
+
+func TestKeyValueStore_Seed(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	n, skipped, err := kv.Seed(context.Background(), strings.NewReader("{\"key\":\"a\",\"value\":\"1\"}\nnot json\n{\"key\":\"b\",\"value\":\"2\"}"), true)
+	if err != nil || n != 2 || skipped != 1 {
+		t.Errorf("expected 2 keys and 1 skipped line but got %d, %d, %v", n, skipped, err)
+	}
+
+	// a full store is not a malformed line, it stops the seed either way
+	kv = NewKeyValueStore(StoreOptions{MaxBytes: 10, Eviction: EvictionReject})
+	if _, _, err := kv.Seed(context.Background(), strings.NewReader(`{"key":"a","value":"more than ten bytes"}`), true); !errors.Is(err, ErrStoreFull) {
+		t.Error("expected a full store to stop the seed")
+	}
+}
@@ -0,0 +1,1339 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
+)
+
+// curl -X POST -H "Content-Type: application/json" -d '{"key": "key1", "value": "value1"}' http://localhost:8080/set
+// curl -X POST -H "Content-Type: application/json" -d '{"key": "key1"}' http://localhost:8080/get
+// curl -X POST -H "Content-Type: application/json" -d '{"key": "key1"}' http://localhost:8080/exists
+// curl -X POST -H "Content-Type: application/json" -d '{"key": "key1", "value": "value1"}' http://localhost:8080/setnx
+// curl -X POST -H "Content-Type: application/json" -d '{"key": "key1"}' http://localhost:8080/pop
+// curl -X POST http://localhost:8080/flush
+
+type Key string
+
+type Value string
+
+type SetRequest struct {
+	Key   Key   `json:"key"`
+	Value Value `json:"value"`
+	// Namespace overrides the X-Namespace header, see requestNamespace
+	Namespace string `json:"namespace,omitempty"`
+	// Encoding is base64 for binary values, empty for plain strings
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type GetRequest struct {
+	Key       Key    `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+	// Encoding base64 asks for the value base64 encoded
+	Encoding string `json:"encoding,omitempty"`
+	// Path is a JSON pointer like /users/0/email, the value is then the JSON text of that part of the stored document
+	Path *string `json:"path,omitempty"`
+	// Default is answered with 200 instead of the missing key status if the key does not exist, for feature flag style lookups
+	Default *Value `json:"default,omitempty"`
+}
+
+type GetResponse struct {
+	Value Value `json:"value"`
+	// Encoding is base64 if the value is base64 encoded, see newGetResponse
+	Encoding string `json:"encoding,omitempty"`
+	// Version and ModifiedAt are only set with ?withmeta=true
+	Version    uint64    `json:"version,omitempty"`
+	ModifiedAt time.Time `json:"modified_at,omitzero"`
+}
+
+// withMeta adds the version and modification time of the entry to the response if meta is set
+func (resp GetResponse) withMeta(e entry, meta bool) GetResponse {
+	if meta {
+		resp.Version = e.version
+		resp.ModifiedAt = e.modified.UTC()
+	}
+	return resp
+}
+
+// MissingKeyResponse is returned by /get for a missing key when the missing key status is 200
+type MissingKeyResponse struct {
+	Value *Value `json:"value"`
+	Found bool   `json:"found"`
+}
+
+type PopRequest struct {
+	Key       Key    `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type FlushResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+type ExistsRequest struct {
+	Key       Key    `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type ExistsResponse struct {
+	Exists bool `json:"exists"`
+}
+
+type PingResponse struct {
+	Message string `json:"message"`
+	Time    string `json:"time"`
+}
+
+type Config struct {
+	ServiceName             string
+	ServerAddress           string
+	ShutdownTimeout         time.Duration
+	ShutdownExitCode        int
+	PreShutdownDelay        time.Duration
+	LoadTimeout             time.Duration
+	HandlerTimeout          time.Duration
+	LockWaitTimeout         time.Duration
+	IdempotencyTTL          time.Duration
+	IdempotencyMaxKeys      int
+	MaxBodyBytes            int64
+	SlowRequestThreshold    time.Duration
+	EnableLoggingMiddleware bool
+	LogHeaders              bool
+	RedactHeaders           []string
+	LogHeaderMaxLength      int
+	PrettyJSON              bool
+	JSONCase                JSONCase
+	SnapshotFile            string
+	EncryptionKeyFile       string `secret:"true"`
+	Fsync                   FsyncMode
+	SnapshotInterval        time.Duration
+	ReplicateFrom           string `secret:"true"`
+	Seed                    string `secret:"true"`
+	SeedIgnoreErrors        bool
+	Peers                   []string
+	PeerAddress             string
+	Store                   StoreBackend
+	MaxStoreBytes           int64
+	Eviction                EvictionPolicy
+	MaxKeys                 int
+	MaxNamespaces           int
+	SoftDelete              time.Duration
+	MissingKeyStatus        int
+	HistoryDepth            int
+	CompressThreshold       int
+	SingleWriter            bool
+	EnableH2C               bool
+	EnableDebugEndpoints    bool
+	ReadOnly                bool
+	Maintenance             bool
+	MaintenanceRetryAfter   time.Duration
+	BasePath                string
+	BasePathAdmin           bool
+	AdminAddress            string
+	MaxConnections          int
+	ReadHeaderTimeout       time.Duration
+	ReadTimeout             time.Duration
+	WriteTimeout            time.Duration
+	IdleTimeout             time.Duration
+	MaxHeaderBytes          int
+	MaxListKeys             int
+	// SocketMode is the octal file mode of the Unix socket files, e.g. 0660, empty leaves it to the umask
+	SocketMode       string
+	ValueSchema      string
+	KeyPattern       string
+	LogLevel         slog.Level
+	LogOutput        string
+	OtelEndpoint     string
+	AuditLog         string
+	AuditLogMaxBytes int64
+	// NamespaceQuotas, Webhooks and MetricsBuckets can only be set in the config file
+	NamespaceQuotas map[string]NamespaceQuota
+	Webhooks        []Webhook
+	// MetricsBuckets are the buckets of the request duration histogram in seconds, empty uses the Prometheus defaults
+	MetricsBuckets []float64
+	Build          BuildInfo
+
+	// args are the command line arguments the configuration was parsed from, a reload parses them again
+	args []string
+	// tracerProvider creates the request spans, nil disables tracing
+	tracerProvider trace.TracerProvider
+	// router forwards the requests for keys owned by other peers, nil handles every key locally
+	router *Router
+	// backend serves /kv/ instead of the in-memory store, nil serves the in-memory store on all endpoints
+	backend Store
+}
+
+// serverAddresses returns the addresses of ServerAddress, which is a comma separated list
+func (env *Config) serverAddresses() []string {
+	return splitList(env.ServerAddress)
+}
+
+// peerAddress returns the entry of the peers that is this node, by default the first server address
+func (env *Config) peerAddress() string {
+	if env.PeerAddress != "" {
+		return env.PeerAddress
+	}
+	if addresses := env.serverAddresses(); len(addresses) > 0 {
+		return addresses[0]
+	}
+	return env.ServerAddress
+}
+
+// routed forwards the requests for keys owned by other peers before they reach the handler
+func (env *Config) routed(h http.HandlerFunc) http.HandlerFunc {
+	if env.router == nil {
+		return h
+	}
+	return env.router.Middleware(h)
+}
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// NewBuildInfo returns the build information from the values set at build time via ldflags
+// unset values fall back to the module version and VCS information the go command embeds in the binary
+func NewBuildInfo(version, commit, date string) BuildInfo {
+	build := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if build.Version == "" {
+			build.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && build.Commit == "":
+				build.Commit = setting.Value
+			case setting.Key == "vcs.time" && build.BuildDate == "":
+				build.BuildDate = setting.Value
+			}
+		}
+	}
+	return build
+}
+
+// Server is the service with its store loaded and its listeners open, Run serves it
+type Server struct {
+	env            *Config
+	store          *KeyValueStore
+	snapshotter    *Snapshotter
+	tracerProvider *sdktrace.TracerProvider
+	auditLog       *AuditLog
+	webhooks       *Webhooks
+	writer         *batchWriter
+	replicator     *Replicator
+	servers        []*http.Server
+	listeners      []net.Listener
+	// addresses is the number of server addresses, the first listeners serve them and the one after them the admin endpoints
+	addresses int
+	// loadSnapshot loads the snapshot into the store, tests replace it to simulate a slow load
+	loadSnapshot func(context.Context) (int, error)
+}
+
+// ErrUncleanShutdown is wrapped by the error of Run when the connections were not drained within the shutdown timeout or the final snapshot failed
+var ErrUncleanShutdown = errors.New("unclean shutdown")
+
+// ExitCode returns the exit code for the error Run returned: 0 for a clean shutdown, the configured shutdown exit code
+// for an unclean one, so operators can tell a forced shutdown from a failing server, and 1 for every other error
+func (env *Config) ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrUncleanShutdown) && env.ShutdownExitCode != 0:
+		return env.ShutdownExitCode
+	default:
+		return 1
+	}
+}
+
+// Run listens on the configured addresses and serves the store until ctx is cancelled
+func Run(ctx context.Context, cfg Config) error {
+	s, err := Listen(cfg)
+	if err != nil {
+		return err
+	}
+	return s.Run(ctx)
+}
+
+// Listen creates the store, loads the snapshot and opens every listener without serving them yet
+// a bad address fails the startup as a whole, and Addr reports the bound address even for port 0
+func Listen(cfg Config) (*Server, error) {
+	env := &cfg
+	logLevel.Set(env.LogLevel)
+
+	kvStore := env.newStore()
+	if env.Store == StoreCOW {
+		env.backend = NewCowStore(env.MaxKeys, env.MaxStoreBytes)
+	}
+	if env.ValueSchema != "" {
+		schema, err := loadValueSchema(env.ValueSchema)
+		if err != nil {
+			return nil, err
+		}
+		kvStore.SetValueSchema(schema)
+	}
+	if err := kvStore.SetKeyPattern(env.KeyPattern); err != nil {
+		return nil, err
+	}
+	if env.Seed != "" {
+		if err := env.seed(kvStore); err != nil {
+			return nil, err
+		}
+	}
+
+	var tracerProvider *sdktrace.TracerProvider
+	if env.OtelEndpoint != "" {
+		var err error
+		if tracerProvider, err = newTracerProvider(env.OtelEndpoint, env.ServiceName); err != nil {
+			return nil, err
+		}
+		env.tracerProvider = tracerProvider
+	}
+
+	// the snapshot is loaded by Run once the listeners serve, so the liveness probe answers during a long load
+	var snapshotter *Snapshotter
+	if env.SnapshotFile != "" {
+		snapshotter = NewSnapshotter(env.SnapshotFile, kvStore)
+		if env.EncryptionKeyFile != "" {
+			key, err := ReadEncryptionKey(env.EncryptionKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if err := snapshotter.SetEncryptionKey(key); err != nil {
+				return nil, err
+			}
+		}
+		if env.Fsync != "" {
+			if err := snapshotter.SetFsync(env.Fsync); err != nil {
+				return nil, err
+			}
+			kvStore.RegisterHealthCheck("wal", snapshotter.wal.check)
+		}
+		kvStore.SetLoading(true)
+	}
+
+	// a replica only changes through replication, and is not ready until it holds the data of the primary
+	var replicator *Replicator
+	if env.ReplicateFrom != "" {
+		replicator = NewReplicator(strings.TrimSuffix(env.ReplicateFrom, "/"), &http.Client{}, kvStore)
+		kvStore.RegisterHealthCheck("replication", replicator.check)
+		kvStore.SetReadOnly(true)
+		kvStore.SetLoading(true)
+	}
+
+	// the router is there even without peers, so peers can be added by a reload
+	env.router = NewRouter(env.peerAddress(), env.Peers)
+	if len(env.Peers) > 0 && !slices.Contains(env.Peers, env.peerAddress()) {
+		slog.Warn("this node is not one of the peers, it forwards every request", "address", env.peerAddress())
+	}
+
+	var auditLog *AuditLog
+	if env.AuditLog != "" {
+		var err error
+		if auditLog, err = openAuditLog(env.AuditLog, env.AuditLogMaxBytes); err != nil {
+			return nil, err
+		}
+		kvStore.SetAuditLog(auditLog)
+	}
+
+	var webhooks *Webhooks
+	if len(env.Webhooks) > 0 {
+		webhooks = NewWebhooks(env.Webhooks, &http.Client{})
+		kvStore.SetWebhooks(webhooks)
+	}
+
+	var writer *batchWriter
+	if env.SingleWriter {
+		writer = newBatchWriter()
+		kvStore.root.writer = writer
+	}
+
+	// with socket activation the sockets passed by systemd replace the server addresses, the admin address is still listened on
+	activated, err := activationListeners()
+	if err != nil {
+		return nil, err
+	}
+	serverAddresses := env.serverAddresses()
+	if len(activated) > 0 {
+		serverAddresses = serverAddresses[:0]
+		for _, listener := range activated {
+			serverAddresses = append(serverAddresses, listener.Addr().String())
+		}
+		slog.Info("using systemd socket activation", "addresses", serverAddresses)
+	}
+
+	// every server address serves the same routes, each with a server of its own
+	handler := env.routes(kvStore)
+	var servers []*http.Server
+	for _, address := range serverAddresses {
+		server := env.newServer(address, handler)
+		server.ConnState = kvStore.trackConnection
+		servers = append(servers, server)
+	}
+	addresses := len(servers)
+	servers[0].RegisterOnShutdown(kvStore.CloseWebSockets)
+	if env.AdminAddress != "" {
+		servers = append(servers, env.newServer(env.AdminAddress, env.adminRoutes(kvStore)))
+	}
+
+	listeners := make([]net.Listener, 0, len(servers))
+	for i, server := range servers {
+		if i < len(activated) {
+			listeners = append(listeners, activated[i])
+			continue
+		}
+		listener, err := env.listen(server.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			if auditLog != nil {
+				auditLog.Close()
+			}
+			if webhooks != nil {
+				webhooks.Close(context.Background())
+			}
+			if writer != nil {
+				writer.Close()
+			}
+			if snapshotter != nil {
+				snapshotter.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", server.Addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	for i := range addresses {
+		listeners[i] = env.limitListener(listeners[i])
+	}
+
+	server := &Server{
+		env:            env,
+		store:          kvStore,
+		snapshotter:    snapshotter,
+		tracerProvider: tracerProvider,
+		auditLog:       auditLog,
+		webhooks:       webhooks,
+		writer:         writer,
+		replicator:     replicator,
+		servers:        servers,
+		listeners:      listeners,
+		addresses:      addresses,
+	}
+	if snapshotter != nil {
+		server.loadSnapshot = snapshotter.Load
+	}
+	return server, nil
+}
+
+// Addr returns the first address the server listens on, e.g. to find the port it was given for :0
+func (s *Server) Addr() net.Addr {
+	return s.listeners[0].Addr()
+}
+
+// Addrs returns every address the server listens on in the order they were configured, without the admin address
+func (s *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, s.addresses)
+	for i, listener := range s.listeners[:s.addresses] {
+		addrs[i] = listener.Addr()
+	}
+	return addrs
+}
+
+// AdminAddr returns the address the admin endpoints listen on, nil if they are served on the server addresses
+func (s *Server) AdminAddr() net.Addr {
+	if len(s.listeners) == s.addresses {
+		return nil
+	}
+	return s.listeners[s.addresses].Addr()
+}
+
+// Reload parses the configuration again and applies the settings that can change at runtime
+func (s *Server) Reload() error {
+	return s.env.reload(s.store)
+}
+
+// load loads the snapshot in the background within the load timeout, if one is set, and marks the store as loaded once it is complete
+// the returned channel receives the result, it is nil without persistence
+func (s *Server) load(ctx context.Context) <-chan error {
+	if s.snapshotter == nil {
+		return nil
+	}
+	result := make(chan error, 1)
+	go func() {
+		if s.env.LoadTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.env.LoadTimeout)
+			defer cancel()
+		}
+
+		start := time.Now()
+		n, err := s.loadSnapshot(ctx)
+		if err != nil {
+			result <- fmt.Errorf("failed to load snapshot after %d keys: %w", n, err)
+			return
+		}
+		s.store.SetLoading(false)
+		slog.Info("snapshot loaded", "keys", n, "file", s.env.SnapshotFile, "duration", time.Since(start))
+		result <- nil
+	}()
+	return result
+}
+
+// Run serves the store until ctx is cancelled or a listener fails, then shuts the server down gracefully
+// with persistence the snapshot is loaded while serving, until it is loaded the data endpoints and the readiness probe answer 503
+// a replica likewise answers 503 until the initial sync from the primary completed, then keeps replicating until shutdown
+// on cancellation the readiness probe fails right away, the listeners keep serving for the pre-shutdown delay so load balancers stop routing first
+// once all connections are drained a final snapshot is taken if persistence is enabled
+// if draining times out or the final snapshot fails the returned error wraps ErrUncleanShutdown
+func (s *Server) Run(ctx context.Context) error {
+	serveErrs := make(chan error, len(s.servers))
+	for i, server := range s.servers {
+		go func(server *http.Server, listener net.Listener) {
+			slog.Info("starting server", "address", listener.Addr())
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				serveErrs <- fmt.Errorf("failed to serve on %s: %w", listener.Addr(), err)
+			}
+		}(server, s.listeners[i])
+	}
+
+	loaded := s.load(ctx)
+
+	if s.env.SoftDelete > 0 {
+		go s.store.runPurge(ctx)
+	}
+	replicationDone := make(chan struct{})
+	if s.replicator != nil {
+		go func() {
+			defer close(replicationDone)
+			s.replicator.Run(ctx)
+		}()
+	} else {
+		close(replicationDone)
+	}
+	snapshotsDone := make(chan struct{})
+	if s.snapshotter != nil && s.env.SnapshotInterval > 0 {
+		go func() {
+			defer close(snapshotsDone)
+			s.snapshotter.run(ctx, s.env.SnapshotInterval)
+		}()
+	} else {
+		close(snapshotsDone)
+	}
+
+	var serveErr error
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			break wait
+		case serveErr = <-serveErrs:
+			slog.Error("server failed", "error", serveErr)
+			break wait
+		case err := <-loaded:
+			loaded = nil
+			if err != nil {
+				serveErr = err
+				slog.Error("failed to load snapshot", "error", err)
+				break wait
+			}
+		}
+	}
+	// load balancers stop routing to the failing readiness probe before the listeners go away
+	s.store.SetShuttingDown(true)
+	if s.env.PreShutdownDelay > 0 && serveErr == nil {
+		slog.Info("waiting before shutting down", "delay", s.env.PreShutdownDelay)
+		time.Sleep(s.env.PreShutdownDelay)
+	}
+	// the load balancers had the pre-shutdown delay to stop routing here, requests that still come in are not
+	// handled against a store that is about to be snapshotted
+	s.store.SetStopping(true)
+	slog.Info("shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.env.ShutdownTimeout)
+	defer cancel()
+
+	shutdownErr := shutdown(shutdownCtx, s.servers)
+	if shutdownErr != nil {
+		slog.Error("failed to shutdown server", "error", shutdownErr)
+	}
+
+	// the sets still queued for the writer are applied before the final snapshot, the handlers that sent them can still answer
+	if s.writer != nil {
+		s.writer.Close()
+	}
+
+	// the spans of the drained requests are still buffered, losing them is logged but does not fail the shutdown
+	if s.tracerProvider != nil {
+		if err := s.tracerProvider.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}
+
+	// no request is left to record a mutation, so the queued records can be written out
+	if s.auditLog != nil {
+		if err := s.auditLog.Close(); err != nil {
+			slog.Error("failed to close audit log", "error", err)
+		}
+		if dropped := s.auditLog.Dropped(); dropped > 0 {
+			slog.Warn("audit records were dropped because the audit log could not keep up", "dropped", dropped)
+		}
+	}
+
+	// the notifications of the last requests are still queued, they are delivered within what is left of the shutdown timeout
+	if s.webhooks != nil {
+		if err := s.webhooks.Close(shutdownCtx); err != nil {
+			slog.Error("failed to deliver the queued webhook notifications", "error", err)
+		}
+		if dropped := s.webhooks.Dropped(); dropped > 0 {
+			slog.Warn("webhook notifications were dropped because the receivers could not keep up", "dropped", dropped)
+		}
+	}
+
+	// the load was cancelled with ctx, its result decides whether the store is complete enough for a final snapshot
+	if loaded != nil {
+		if err := <-loaded; err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("failed to load snapshot", "error", err)
+		}
+	}
+
+	<-snapshotsDone
+	<-replicationDone
+
+	// nothing changes the store anymore, the write-ahead log is synced and closed whether or not the final snapshot succeeds
+	if s.snapshotter != nil {
+		defer func() {
+			if err := s.snapshotter.Close(); err != nil {
+				slog.Error("failed to close write-ahead log", "error", err)
+			}
+		}()
+	}
+
+	// the final snapshot is taken even if draining timed out, the store still holds the latest data
+	// a store that never finished loading must not replace the snapshot it was loading from
+	if s.snapshotter != nil && s.store.Loading() {
+		slog.Warn("final snapshot skipped, the store did not finish loading")
+	} else if s.snapshotter != nil {
+		if err := s.snapshotter.Snapshot(); err != nil {
+			slog.Error("failed to write final snapshot", "error", err)
+			return errors.Join(serveErr, fmt.Errorf("%w: failed to write final snapshot: %w", ErrUncleanShutdown, err))
+		}
+		slog.Info("final snapshot written", "file", s.env.SnapshotFile)
+	}
+
+	if shutdownErr != nil {
+		return errors.Join(serveErr, fmt.Errorf("%w: failed to shutdown server: %w", ErrUncleanShutdown, shutdownErr))
+	}
+	if serveErr != nil {
+		return serveErr
+	}
+
+	slog.Info("server shut down successfully")
+	return nil
+}
+
+// NewHandler returns the routes of the service backed by a new empty store, e.g. to serve it from an httptest.Server
+func NewHandler(cfg Config) http.Handler {
+	env := &cfg
+	return env.routes(env.newStore())
+}
+
+// NewBackendHandler returns the routes of the service serving /kv/ from the backend through NewStoreHandler, e.g. a remote store
+// the endpoints built on the in-memory store, like /get, /list/push or /dump, are not served, read-only mode rejects the writes to the backend
+func NewBackendHandler(cfg Config, backend Store) http.Handler {
+	env := &cfg
+	env.backend = backend
+	return env.routes(env.newStore())
+}
+
+// newStore returns an empty store configured from the server config
+func (env *Config) newStore() *KeyValueStore {
+	kvStore := NewKeyValueStore(StoreOptions{
+		MaxBytes:          env.MaxStoreBytes,
+		Eviction:          env.Eviction,
+		MaxKeys:           env.MaxKeys,
+		SoftDelete:        env.SoftDelete,
+		HistoryDepth:      env.HistoryDepth,
+		CompressThreshold: env.CompressThreshold,
+	})
+	kvStore.SetReadOnly(env.ReadOnly)
+	kvStore.SetMaintenance(env.Maintenance, env.MaintenanceRetryAfter)
+	kvStore.SetMissingKeyStatus(env.MissingKeyStatus)
+	kvStore.SetJSONCase(env.JSONCase)
+	kvStore.SetLockWaitTimeout(env.LockWaitTimeout)
+	kvStore.SetIdempotency(env.IdempotencyTTL, env.IdempotencyMaxKeys)
+	kvStore.SetMaxNamespaces(env.MaxNamespaces)
+	kvStore.SetNamespaceQuotas(env.NamespaceQuotas)
+	if len(env.MetricsBuckets) > 0 {
+		kvStore.SetRequestDurationBuckets(env.MetricsBuckets)
+	}
+	return kvStore
+}
+
+// limitListener caps the concurrently served connections at MaxConnections
+// connections beyond the limit are not accepted and wait in the kernel's backlog until a slot frees up
+func (env *Config) limitListener(listener net.Listener) net.Listener {
+	if env.MaxConnections <= 0 {
+		return listener
+	}
+	return netutil.LimitListener(listener, env.MaxConnections)
+}
+
+// newServer returns a server for the address with the timeouts shared by all listeners, a zero timeout means none
+func (env *Config) newServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: env.ReadHeaderTimeout,
+		ReadTimeout:       env.ReadTimeout,
+		WriteTimeout:      env.WriteTimeout,
+		IdleTimeout:       env.IdleTimeout,
+		MaxHeaderBytes:    env.MaxHeaderBytes,
+	}
+}
+
+// streaming exempts a handler streaming its response from the write timeout as long as the stream makes progress
+// every write moves the write deadline to the write timeout from now, so a long dump completes while a client that stops reading is still disconnected
+func (env *Config) streaming(next http.HandlerFunc) http.HandlerFunc {
+	if env.WriteTimeout <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&streamingWriter{ResponseWriter: w, rc: http.NewResponseController(w), timeout: env.WriteTimeout}, r)
+	}
+}
+
+// streamingWriter moves the write deadline before every write, see Config.streaming
+type streamingWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (sw *streamingWriter) Write(p []byte) (int, error) {
+	// the error is ErrNotSupported behind the handler timeout, which buffers the whole response and can not stream anyway
+	sw.rc.SetWriteDeadline(time.Now().Add(sw.timeout))
+	return sw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *streamingWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// shutdown gracefully shuts all servers down in parallel so they share the deadline of the context
+func shutdown(ctx context.Context, servers []*http.Server) error {
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+
+	var err error
+	for range servers {
+		err = errors.Join(err, <-errs)
+	}
+	return err
+}
+
+// listen opens the listener for the address
+// an address of the form unix:/path/to/socket or unix:///path/to/socket listens on a Unix domain socket instead of TCP,
+// the socket file is removed again when the server shuts down and closes the listener
+// a socket file left behind by a server that did not shut down cleanly is removed first, one a server still listens on fails the listen
+// the socket file gets the configured SocketMode, without one it is created as the umask allows
+func (env *Config) listen(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	path = strings.TrimPrefix(path, "//")
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if env.SocketMode != "" {
+		mode, _ := strconv.ParseUint(env.SocketMode, 8, 32)
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket file at path if no server accepts connections on it
+// it leaves anything that is not a socket alone, the listen then fails on it
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode().Type() != os.ModeSocket {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("another server listens on %s", path)
+	}
+	slog.Info("removing stale socket", "path", path)
+	return os.Remove(path)
+}
+
+// dataEndpoints are the endpoints serving the store to clients
+// the mutations taking a JSON body answer 415 for any other content type, so a mistyped form post is not half parsed
+func (env *Config) dataEndpoints(kvStore *KeyValueStore) map[string]http.HandlerFunc {
+	if env.backend != nil {
+		// the backend only has the keys, the endpoints built on the in-memory store are not served
+		return map[string]http.HandlerFunc{
+			"/version": env.VersionHandler,
+			"/ping":    PingHandler,
+			"/kv/":     kvStore.MiddlewareReadOnlyWrites(kvStore.MiddlewareKeyPattern(NewStoreHandler(env.backend).ServeHTTP)),
+		}
+	}
+	return map[string]http.HandlerFunc{
+		"/version":      env.VersionHandler,
+		"/ping":         PingHandler,
+		"/get":          env.routed(kvStore.MiddlewareLoaded(kvStore.GetHandler)),
+		"/set":          env.routed(kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.MiddlewareIdempotency(kvStore.SetHandler))))),
+		"/setnx":        kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.SetNXHandler))),
+		"/exists":       kvStore.MiddlewareLoaded(kvStore.ExistsHandler),
+		"/scan":         kvStore.MiddlewareLoaded(kvStore.ScanHandler),
+		"/keys":         kvStore.MiddlewareLoaded(env.KeysHandler(kvStore)),
+		"/pop":          kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.PopHandler))),
+		"/undelete":     kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.UndeleteHandler))),
+		"/history":      kvStore.MiddlewareLoaded(kvStore.HistoryHandler),
+		"/changes":      kvStore.MiddlewareLoaded(env.streaming(kvStore.ChangesHandler)),
+		"/list/push":    kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.ListPushHandler))),
+		"/list/pop":     kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.ListPopHandler))),
+		"/hset":         kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.HashSetHandler))),
+		"/hget":         kvStore.MiddlewareLoaded(kvStore.HashGetHandler),
+		"/hgetall":      kvStore.MiddlewareLoaded(kvStore.HashGetAllHandler),
+		"/hdel":         kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.HashDeleteHandler))),
+		"/copy":         kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.CopyHandler))),
+		"/rename":       kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.RenameHandler))),
+		"/patch":        kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.PatchHandler))),
+		"/txn":          kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.TxnHandler))),
+		"/mset":         kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.MSetHandler))),
+		"/lock/acquire": kvStore.LockAcquireHandler,
+		"/lock/release": kvStore.LockReleaseHandler,
+		"/deleteprefix": kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(MiddlewareContentType("application/json", kvStore.DeletePrefixHandler))),
+		"/kv/":          kvStore.MiddlewareLoaded(kvStore.KVHandler),
+	}
+}
+
+// adminEndpoints are the endpoints for operating the service, they must not be exposed to clients
+func (env *Config) adminEndpoints(kvStore *KeyValueStore) map[string]http.HandlerFunc {
+	if env.backend != nil {
+		// the in-memory store is not served, there is nothing to inspect, flush or restore
+		return map[string]http.HandlerFunc{
+			"/healthz":           LivenessProbeHandler,
+			"/readyz":            kvStore.ReadinessProbeHandler,
+			"/health":            kvStore.HealthHandler,
+			"/metrics":           NewMetricsHandler(kvStore).ServeHTTP,
+			"/metrics/reset":     kvStore.ResetMetricsHandler,
+			"/admin/readonly":    kvStore.ReadOnlyHandler,
+			"/admin/loglevel":    LogLevelHandler,
+			"/admin/maintenance": kvStore.MaintenanceHandler,
+		}
+	}
+	return map[string]http.HandlerFunc{
+		"/healthz": LivenessProbeHandler,
+		"/readyz":  kvStore.ReadinessProbeHandler,
+		"/health":  kvStore.HealthHandler,
+		"/metrics": NewMetricsHandler(kvStore).ServeHTTP,
+		"/stats":   env.StatsHandler(kvStore),
+
+		"/metrics/reset": kvStore.ResetMetricsHandler,
+		"/stats/values":  kvStore.StatsValuesHandler,
+		"/flush":         kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(kvStore.FlushHandler)),
+		"/dump":          kvStore.MiddlewareLoaded(env.streaming(kvStore.DumpHandler)),
+		"/restore":       kvStore.MiddlewareLoaded(kvStore.MiddlewareReadOnly(kvStore.RestoreHandler)),
+		"/compact":       kvStore.MiddlewareLoaded(kvStore.CompactHandler),
+
+		"/admin/readonly":    kvStore.ReadOnlyHandler,
+		"/admin/maintenance": kvStore.MaintenanceHandler,
+		"/admin/loglevel":    LogLevelHandler,
+	}
+}
+
+// routes registers the endpoints served on the server address on a new mux
+// without a dedicated admin address the admin endpoints are served there as well
+func (env *Config) routes(kvStore *KeyValueStore) http.Handler {
+	mux := env.newMux(kvStore, env.dataEndpoints(kvStore))
+	// /ws hijacks the connection, which the response writers of the middleware do not support, and streams beyond the handler timeout
+	if env.backend == nil {
+		mux.HandleFunc("/ws", kvStore.MiddlewareStopping(kvStore.MiddlewareLoaded(kvStore.WebSocketHandler)))
+	}
+	root := env.mountBasePath(mux)
+	if env.AdminAddress == "" {
+		if env.BasePathAdmin {
+			env.registerAdmin(mux, kvStore)
+		} else {
+			env.registerAdmin(root, kvStore)
+		}
+	}
+	handler := middlewareTracing(env.tracerProvider, env.MiddlewareServiceVersion(root.ServeHTTP))
+
+	// h2c lets clients speak HTTP/2 without TLS, plain HTTP/1.1 requests are passed through unchanged
+	if env.EnableH2C {
+		return h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	return handler
+}
+
+// adminRoutes registers the endpoints served on the admin address on a new mux
+func (env *Config) adminRoutes(kvStore *KeyValueStore) http.Handler {
+	mux := env.newMux(kvStore, nil)
+	env.registerAdmin(mux, kvStore)
+	if env.BasePathAdmin {
+		return env.MiddlewareServiceVersion(env.mountBasePath(mux).ServeHTTP)
+	}
+	return env.MiddlewareServiceVersion(mux.ServeHTTP)
+}
+
+// mountBasePath returns a mux serving the given mux below the base path, the handlers see the path without the prefix
+// without a base path the given mux is returned unchanged
+func (env *Config) mountBasePath(mux *http.ServeMux) *http.ServeMux {
+	prefix := strings.TrimSuffix(env.BasePath, "/")
+	if prefix == "" {
+		return mux
+	}
+
+	root := http.NewServeMux()
+	root.Handle(prefix+"/", http.StripPrefix(prefix, mux))
+	return root
+}
+
+// registerAdmin registers the admin endpoints and, if enabled, the debug endpoints on the mux
+func (env *Config) registerAdmin(mux *http.ServeMux, kvStore *KeyValueStore) {
+	for path, ep := range env.adminEndpoints(kvStore) {
+		mux.HandleFunc(path, MiddlewareMetrics(kvStore.root.requestDurations, path, kvStore.MiddlewareStopping(env.middleware(ep))))
+	}
+
+	if env.EnableDebugEndpoints {
+		mux.Handle("/debug/", debugRoutes(kvStore))
+	}
+}
+
+// newMux returns a mux with the endpoints registered behind the configured middleware
+// only these endpoints are bounded by the handler timeout and the body limit and closed by maintenance mode,
+// admin endpoints like /dump and /restore stream arbitrarily large responses and bodies
+// every endpoint records its requests under the path it is registered with
+func (env *Config) newMux(kvStore *KeyValueStore, endpoints map[string]http.HandlerFunc) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	for path, ep := range endpoints {
+		mux.HandleFunc(path, MiddlewareMetrics(kvStore.root.requestDurations, path, kvStore.MiddlewareStopping(kvStore.MiddlewareMaintenance(env.middleware(MiddlewareMaxBodyBytes(env.MaxBodyBytes, MiddlewareTimeout(env.HandlerTimeout, ep)))))))
+	}
+
+	return mux
+}
+
+// middleware wraps the handler with the configured middleware
+func (env *Config) middleware(h http.HandlerFunc) http.HandlerFunc {
+	h = MiddlewarePrettyJSON(env.PrettyJSON, h)
+	h = MiddlewareSlowRequest(env.SlowRequestThreshold, h)
+	if env.EnableLoggingMiddleware {
+		return MiddlewareLogRequest(HeaderLogging{Enabled: env.LogHeaders, Redact: env.RedactHeaders, MaxLength: env.LogHeaderMaxLength}, h)
+	}
+	return h
+}
+
+// VersionResponse is the build information of the running service together with its name
+type VersionResponse struct {
+	Service string `json:"service"`
+	BuildInfo
+}
+
+// VersionHandler returns the build information of the running service
+func (env *Config) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, env.JSONCase, VersionResponse{Service: env.ServiceName, BuildInfo: env.Build})
+}
+
+// LivenessProbeHandler handles the liveness probe
+func LivenessProbeHandler(w http.ResponseWriter, r *http.Request) {
+	// TDOO: Add more checks here, perhaps introduce global state to check if the server is still alive
+	slog.Info("liveness probe called", "path", r.URL.Path)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PingHandler answers pong with the server time so clients can check connectivity and compare clocks
+// it is served next to the data endpoints so it is reachable wherever clients are
+func PingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PingResponse{Message: "pong", Time: time.Now().UTC().Format(time.RFC3339)})
+}
+
+// SetHandler handles the set request
+func (kv *KeyValueStore) SetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	var payload SetRequest
+	span := startSpan(r, "decode request")
+	ok := decodeRequest(w, r, &payload)
+	span.End()
+	if !ok {
+		return
+	}
+	kv, ok = kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	if payload.Value, ok = decodeValue(w, payload.Value, payload.Encoding); !ok {
+		return
+	}
+	if kv.rejectInvalidKey(w, payload.Key) || kv.rejectInvalidValue(w, payload.Value) {
+		return
+	}
+
+	if writer := kv.root.writer; writer != nil {
+		span = startSpan(r, "wait for writer")
+		_, err := writer.set(kv, payload.Key, payload.Value)
+		span.End()
+		if errors.Is(err, errWriterClosed) {
+			writeError(w, http.StatusServiceUnavailable, "SHUTTING_DOWN", "the server is shutting down")
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		kv.audit(r, "set", payload.Key, len(payload.Value), 0)
+		fmt.Fprintln(w, http.StatusAccepted)
+		return
+	}
+
+	span = startSpan(r, "store set")
+	err := kv.Set(r.Context(), payload.Key, payload.Value)
+	span.End()
+	if err != nil {
+		writeBackendError(w, r, payload.Key, err)
+		return
+	}
+	kv.audit(r, "set", payload.Key, len(payload.Value), 0)
+
+	fmt.Fprintln(w, http.StatusAccepted)
+}
+
+// SetNXHandler sets the value only if the key does not exist yet
+// it answers 201 Created when the value was written and 409 Conflict when the key already exists
+func (kv *KeyValueStore) SetNXHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	var payload SetRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	if payload.Value, ok = decodeValue(w, payload.Value, payload.Encoding); !ok {
+		return
+	}
+	if kv.rejectInvalidKey(w, payload.Key) || kv.rejectInvalidValue(w, payload.Value) {
+		return
+	}
+
+	s := kv.shard(payload.Key)
+	if err := s.lockContext(r.Context()); err != nil {
+		writeLockError(w, r, err)
+		return
+	}
+	if _, exists := s.kvMap[payload.Key]; exists {
+		s.Unlock()
+		http.Error(w, "Key already exists", http.StatusConflict)
+		return
+	}
+
+	_, err := s.put(payload.Key, payload.Value)
+	s.Unlock()
+	if err == nil {
+		err = kv.syncLog()
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	kv.audit(r, "set", payload.Key, len(payload.Value), 0)
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, http.StatusCreated)
+}
+
+// GetHandler returns the value for a given key, or with a path only the addressed part of the JSON document stored under it
+// the response carries an ETag and Last-Modified, a matching If-None-Match or If-Modified-Since is answered with 304 Not Modified
+// with ?withmeta=true the response also holds the version and modification time of the value
+func (kv *KeyValueStore) GetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload GetRequest
+	span := startSpan(r, "decode request")
+	ok := decodeRequest(w, r, &payload)
+	span.End()
+	if !ok {
+		return
+	}
+	kv, ok = kv.readNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	var tokens []string
+	if payload.Path != nil {
+		var err error
+		if tokens, err = parsePointer(*payload.Path); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	span = startSpan(r, "store get")
+	e, ok, err := kv.getEntry(r.Context(), payload.Key)
+	span.End()
+	if err != nil {
+		writeLockError(w, r, err)
+		return
+	}
+	if !ok && payload.Default != nil {
+		writeGetResponse(w, kv.root.jsonCase, newGetResponse(*payload.Default, payload.Encoding))
+		return
+	}
+	if !ok {
+		kv.writeMissingKey(w)
+		return
+	}
+	if e.kind != kindString {
+		writeWrongType(w, payload.Key)
+		return
+	}
+
+	// the ETag and Last-Modified are the same the /kv/ API hands out, so a client can mix both APIs for caching
+	if notModified(w, r, e) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	withMeta := r.URL.Query().Get("withmeta") == "true"
+
+	if payload.Path != nil {
+		fragment, err := resolvePointer([]byte(e.plain()), tokens)
+		switch {
+		case errors.Is(err, errPointerNotFound):
+			http.Error(w, "Path not found", http.StatusNotFound)
+		case err != nil:
+			writeError(w, http.StatusUnprocessableEntity, "INVALID_DOCUMENT", err.Error())
+		default:
+			writeGetResponse(w, kv.root.jsonCase, newGetResponse(Value(fragment), payload.Encoding).withMeta(e, withMeta))
+		}
+		return
+	}
+
+	writeGetResponse(w, kv.root.jsonCase, newGetResponse(e.plain(), payload.Encoding).withMeta(e, withMeta))
+}
+
+// SetMissingKeyStatus sets the status GetHandler answers for a missing key in all namespaces
+// http.StatusOK answers with a MissingKeyResponse for clients that do not want to treat a missing key as an error, anything else answers 404
+// it must be called before the store is served
+func (kv *KeyValueStore) SetMissingKeyStatus(status int) {
+	kv.root.missingKeyStatus = status
+}
+
+// writeMissingKey answers a get of a missing key with the configured status
+func (kv *KeyValueStore) writeMissingKey(w http.ResponseWriter) {
+	if kv.root.missingKeyStatus == http.StatusOK {
+		json.NewEncoder(w).Encode(MissingKeyResponse{Found: false})
+		return
+	}
+	http.Error(w, "Key not found", http.StatusNotFound)
+}
+
+// PopHandler atomically returns the value for a given key and deletes it
+func (kv *KeyValueStore) PopHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload PopRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	s := kv.shard(payload.Key)
+	if err := s.lockContext(r.Context()); err != nil {
+		writeLockError(w, r, err)
+		return
+	}
+	if e, ok := s.kvMap[payload.Key]; ok && e.kind != kindString {
+		s.Unlock()
+		writeWrongType(w, payload.Key)
+		return
+	}
+	e, ok, err := s.remove(payload.Key)
+	s.Unlock()
+	if err == nil && ok {
+		err = kv.syncLog()
+	}
+
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	kv.audit(r, "delete", payload.Key, 0, 0)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(newGetResponse(e.plain(), ""))
+}
+
+// FlushHandler deletes all keys of the namespace and returns how many were removed
+func (kv *KeyValueStore) FlushHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.requestNamespace(w, r, "")
+	if !ok {
+		return
+	}
+	n, err := kv.flush()
+	kv.audit(r, "flush", "", 0, n)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FlushResponse{Deleted: n})
+}
+
+// ExistsHandler reports whether a key is present without transferring its value
+// it answers 200 in both cases and does not count as a read of the key
+func (kv *KeyValueStore) ExistsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload ExistsRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.readNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	_, exists := kv.peek(payload.Key)
+
+	json.NewEncoder(w).Encode(ExistsResponse{Exists: exists})
+}
+
+// decodeRequest decodes the JSON request body into v and answers 400 Bad Request if that fails
+// an empty or whitespace only body gets a clear message instead of the decoder's EOF
+// unknown fields are rejected so a typo like "ke" does not silently operate on the empty key
+// the body is read into a pooled buffer and flat request types are decoded in place, which saves the decoder growing its own copy of a large value
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := readBody(buf, r.Body, r.ContentLength); err != nil {
+		writeBodyError(w, err)
+		return false
+	}
+	data := buf.Bytes()
+	if !decodeInPlace(v) {
+		return decodeFirstValue(w, bytes.NewReader(data), v)
+	}
+	if json.Unmarshal(data, v) != nil {
+		// the decoder reads only the first value of the body, so the error is the one of that value and trailing data is ignored
+		reflect.ValueOf(v).Elem().SetZero()
+		return decodeFirstValue(w, bytes.NewReader(data), v)
+	}
+	if field := unknownField(data, v); field != "" {
+		http.Error(w, "Unknown field "+strconv.Quote(field)+" in request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// decodeFirstValue decodes the first JSON value of body into v and answers 400 Bad Request if that fails
+func decodeFirstValue(w http.ResponseWriter, body io.Reader, v any) bool {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if errors.Is(err, io.EOF) {
+		http.Error(w, "Request body is required", http.StatusBadRequest)
+		return false
+	}
+	if field, ok := strings.CutPrefix(fmt.Sprint(err), "json: unknown field "); ok {
+		http.Error(w, "Unknown field "+field+" in request body", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// MiddlewareServiceVersion sets the X-Service-Version header on every response
+// so a client can tell which build handled a request during a rolling deploy
+func (env *Config) MiddlewareServiceVersion(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Service-Version", env.Build.Version)
+		next(w, r)
+	}
+}
+
+// MiddlewareTimeout answers 503 if the handler has not finished after d, a d of 0 or less disables the timeout
+// the request context of the handler is cancelled at the deadline and everything it writes afterwards is discarded
+func MiddlewareTimeout(d time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if d <= 0 {
+		return next
+	}
+	return http.TimeoutHandler(next, d, "Handler timeout").ServeHTTP
+}
+
+// writeContextError answers a request whose context ended before a handler finished a full scan of the store
+// a deadline answers 503 so the client may retry, a client that went away gets no response since nobody reads it
+func writeContextError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		slog.Debug("client went away", "method", r.Method, "path", r.URL.Path)
+		return
+	}
+	writeError(w, http.StatusServiceUnavailable, "DEADLINE_EXCEEDED", "Request deadline exceeded")
+}
@@ -0,0 +1,1300 @@
+package kvservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func BenchmarkSetHandler(b *testing.B) {
+	kvStore := NewKeyValueStore(StoreOptions{})
+
+	for _, bm := range []struct {
+		name string
+		size int
+	}{
+		{name: "1 byte", size: 1},
+		{name: "1KB", size: 1024},
+		{name: "100KB", size: 100 * 1024},
+		{name: "1MB", size: 1024 * 1024},
+	} {
+		body, _ := json.Marshal(SetRequest{Key: Key("benchmark-key-" + bm.name), Value: Value(strings.Repeat("x", bm.size))})
+
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				// the request is created for every iteration, a reused request has its body drained after the first
+				w := httptest.NewRecorder()
+				kvStore.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", bytes.NewReader(body)))
+				if w.Code != http.StatusOK {
+					b.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetHandler(b *testing.B) {
+	kvStore := NewKeyValueStore(StoreOptions{})
+	body, _ := json.Marshal(GetRequest{Key: "benchmark-key"})
+
+	for _, bm := range []struct {
+		name string
+		size int
+	}{
+		{name: "1Byte", size: 1},
+		{name: "1KB", size: 1024},
+		{name: "100KB", size: 100 * 1024},
+		{name: "1MB", size: 1024 * 1024},
+	} {
+		writeTestValue(kvStore, "benchmark-key", Value(strings.Repeat("x", bm.size)))
+
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				// the request is created for every iteration, a reused request has its body drained after the first
+				w := httptest.NewRecorder()
+				kvStore.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", bytes.NewReader(body)))
+				if w.Code != http.StatusOK {
+					b.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_SetHandler(t *testing.T) {
+	type fields struct {
+		values map[Key]Value
+	}
+	type args struct {
+		w http.ResponseWriter
+		r *http.Request
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		args        args
+		expectedMap map[Key]Value
+		expectedMsg string
+		expectError bool
+	}{
+		{
+			name: "valid request",
+			fields: fields{
+				values: map[Key]Value{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString(`{"key":"test", "value":"value"}`)),
+			},
+			expectedMap: map[Key]Value{"test": "value"},
+			expectedMsg: "202" + "\n",
+			expectError: false,
+		},
+		{
+			name: "invalid request body",
+			fields: fields{
+				values: map[Key]Value{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString(`"value":"value"}`)),
+			},
+			expectedMap: map[Key]Value{},
+			expectedMsg: "json: cannot unmarshal string into Go value of type kvservice.SetRequest",
+			expectError: true,
+		},
+		{
+			name: "unknown field",
+			fields: fields{
+				values: map[Key]Value{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString(`{"ke":"test", "value":"value"}`)),
+			},
+			expectedMap: map[Key]Value{},
+			expectedMsg: `Unknown field "ke" in request body`,
+			expectError: true,
+		},
+		{
+			name: "empty request body",
+			fields: fields{
+				values: map[Key]Value{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString("")),
+			},
+			expectedMap: map[Key]Value{},
+			expectedMsg: "Request body is required",
+			expectError: true,
+		},
+		{
+			name: "whitespace only request body",
+			fields: fields{
+				values: map[Key]Value{},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString(" \n\t")),
+			},
+			expectedMap: map[Key]Value{},
+			expectedMsg: "Request body is required",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newTestStore(tt.fields.values)
+
+			kv.SetHandler(tt.args.w, tt.args.r)
+
+			if tt.expectError {
+				resp := tt.args.w.(*httptest.ResponseRecorder)
+				if resp.Code != http.StatusBadRequest {
+					t.Errorf("expected status %v but got %v", http.StatusBadRequest, resp.Code)
+				}
+				if !strings.Contains(resp.Body.String(), tt.expectedMsg) {
+					t.Errorf("expected message %v but got %v", tt.expectedMsg, resp.Body.String())
+				}
+				return
+			}
+
+			values := testValues(kv)
+			if !reflect.DeepEqual(values, tt.expectedMap) {
+				t.Errorf("expected map %v but got %v", tt.expectedMap, values)
+			}
+
+			resp := tt.args.w.(*httptest.ResponseRecorder)
+			if resp.Code != http.StatusOK {
+				t.Errorf("expected status %v but got %v", http.StatusOK, resp.Code)
+			}
+			if resp.Body.String() != tt.expectedMsg {
+				t.Errorf("expected message %v but got %v", tt.expectedMsg, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_GetHandler_EmptyBody(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"test": "value"})
+
+	for _, body := range []string{"", " \n\t"} {
+		w := httptest.NewRecorder()
+		kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(body)))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("body %q: expected status %v but got %v", body, http.StatusBadRequest, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "Request body is required") {
+			t.Errorf("body %q: expected message %v but got %v", body, "Request body is required", w.Body.String())
+		}
+	}
+}
+
+func TestKeyValueStore_GetHandler_ETag(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"test": "value"})
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(`{"key":"test"}`))
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		kv.GetHandler(w, r)
+		return w
+	}
+
+	resp := get("")
+	etag := resp.Header().Get("ETag")
+	if resp.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected status %v with an ETag but got %v and %q", http.StatusOK, resp.Code, etag)
+	}
+
+	resp = get(etag)
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("expected status %v but got %v", http.StatusNotModified, resp.Code)
+	}
+	if resp.Body.Len() != 0 {
+		t.Errorf("expected empty body but got %q", resp.Body.String())
+	}
+
+	// a write changes the ETag so the cached value is no longer valid
+	set(t, kv, `{"key":"test", "value":"new"}`)
+	resp = get(etag)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "new") {
+		t.Errorf("expected status %v with the new value but got %v and %q", http.StatusOK, resp.Code, resp.Body.String())
+	}
+	if resp.Header().Get("ETag") == etag {
+		t.Errorf("expected a new ETag after the write")
+	}
+}
+
+func TestKeyValueStore_GetHandler_LastModified(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 500_000_000, time.UTC)
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.clock = func() time.Time { return now }
+	set(t, kv, `{"key":"test", "value":"value"}`)
+	etag := (entry{value: "value"}).etag()
+
+	tests := []struct {
+		name            string
+		ifModifiedSince string
+		ifNoneMatch     string
+		wantCode        int
+	}{
+		{name: "unconditional", wantCode: http.StatusOK},
+		{name: "same second", ifModifiedSince: "Wed, 01 May 2024 12:00:00 GMT", wantCode: http.StatusNotModified},
+		{name: "later", ifModifiedSince: "Wed, 01 May 2024 13:00:00 GMT", wantCode: http.StatusNotModified},
+		{name: "earlier", ifModifiedSince: "Wed, 01 May 2024 11:59:59 GMT", wantCode: http.StatusOK},
+		{name: "invalid date", ifModifiedSince: "yesterday", wantCode: http.StatusOK},
+		{name: "If-None-Match takes precedence", ifModifiedSince: "Wed, 01 May 2024 13:00:00 GMT", ifNoneMatch: `"2"`, wantCode: http.StatusOK},
+		{name: "If-None-Match matches", ifModifiedSince: "Wed, 01 May 2024 11:00:00 GMT", ifNoneMatch: etag, wantCode: http.StatusNotModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(`{"key":"test"}`))
+			if tt.ifModifiedSince != "" {
+				r.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			kv.GetHandler(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v", tt.wantCode, w.Code)
+			}
+			if got := w.Header().Get("Last-Modified"); got != "Wed, 01 May 2024 12:00:00 GMT" {
+				t.Errorf("expected Last-Modified of the write but got %q", got)
+			}
+		})
+	}
+
+	// the /kv/ API hands out the same Last-Modified
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/kv/test", nil)
+	r.Header.Set("If-Modified-Since", "Wed, 01 May 2024 12:00:00 GMT")
+	kv.KVHandler(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status %v from /kv/ but got %v", http.StatusNotModified, w.Code)
+	}
+}
+
+func TestKeyValueStore_GetHandler_WithMeta(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.clock = func() time.Time { return now }
+	set(t, kv, `{"key":"test", "value":"value"}`)
+
+	for _, tt := range []struct {
+		jsonCase JSONCase
+		query    string
+		want     string
+	}{
+		{query: "", want: `{"value":"value"}`},
+		{query: "?withmeta=true", want: `{"value":"value","version":1,"modified_at":"2024-05-01T12:00:00Z"}`},
+		{jsonCase: JSONCaseSnake, query: "?withmeta=true", want: `{"value":"value","version":1,"modified_at":"2024-05-01T12:00:00Z"}`},
+		{jsonCase: JSONCaseCamel, query: "", want: `{"value":"value"}`},
+		{jsonCase: JSONCaseCamel, query: "?withmeta=true", want: `{"value":"value","version":1,"modifiedAt":"2024-05-01T12:00:00Z"}`},
+	} {
+		kv.SetJSONCase(tt.jsonCase)
+		w := httptest.NewRecorder()
+		kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get"+tt.query, bytes.NewBufferString(`{"key":"test"}`)))
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("case %q query %q: expected %s but got %s", tt.jsonCase, tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestKeyValueStore_GetHandler_UnknownField(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"test": "value"})
+
+	w := httptest.NewRecorder()
+	kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(`{"key":"test", "version":1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %v but got %v", http.StatusBadRequest, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `Unknown field "version"`) {
+		t.Errorf("expected message naming the field but got %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(`{"key":"test"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %v for a valid request but got %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestKeyValueStore_GetHandler_MissingKeyStatus(t *testing.T) {
+	tests := []struct {
+		status   int
+		wantCode int
+		wantBody string
+	}{
+		{wantCode: http.StatusNotFound, wantBody: "Key not found\n"},
+		{status: http.StatusNotFound, wantCode: http.StatusNotFound, wantBody: "Key not found\n"},
+		{status: http.StatusOK, wantCode: http.StatusOK, wantBody: `{"value":null,"found":false}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		kv := newTestStore(map[Key]Value{"test": "value"})
+		kv.SetMissingKeyStatus(tt.status)
+
+		w := httptest.NewRecorder()
+		kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(`{"key":"missing"}`)))
+		if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+			t.Errorf("status %d: expected %v %q but got %v %q", tt.status, tt.wantCode, tt.wantBody, w.Code, w.Body.String())
+		}
+
+		// an existing key is answered the same in both modes
+		w = httptest.NewRecorder()
+		kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(`{"key":"test"}`)))
+		if w.Code != http.StatusOK || w.Body.String() != `{"value":"value"}`+"\n" {
+			t.Errorf("status %d: expected the value but got %v %q", tt.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestKeyValueStore_GetHandler_Default(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "present key", body: `{"key":"flag","default":"off"}`, wantCode: http.StatusOK, wantBody: `{"value":"on"}` + "\n"},
+		{name: "absent key with default", body: `{"key":"missing","default":"off"}`, wantCode: http.StatusOK, wantBody: `{"value":"off"}` + "\n"},
+		{name: "empty default", body: `{"key":"missing","default":""}`, wantCode: http.StatusOK, wantBody: `{"value":""}` + "\n"},
+		{name: "absent key without default", body: `{"key":"missing"}`, wantCode: http.StatusNotFound, wantBody: "Key not found\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newTestStore(map[Key]Value{"flag": "on"})
+
+			w := httptest.NewRecorder()
+			kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", bytes.NewBufferString(tt.body)))
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("expected %v %q but got %v %q", tt.wantCode, tt.wantBody, w.Code, w.Body.String())
+			}
+			if _, ok := kv.peek("missing"); ok {
+				t.Errorf("expected the default not to be stored")
+			}
+		})
+	}
+}
+
+func TestConfig_VersionHandler(t *testing.T) {
+	build := BuildInfo{
+		Version:   "1.5.0",
+		Commit:    "0123abc",
+		BuildDate: "2024-01-02T03:04:05Z",
+		GoVersion: "go1.22.0",
+	}
+	env := Config{
+		ServiceName: "key-value-service-v1",
+		Build:       build,
+	}
+	kvStore := NewKeyValueStore(StoreOptions{})
+
+	w := httptest.NewRecorder()
+	env.routes(kvStore).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+
+	var got VersionResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := VersionResponse{Service: "key-value-service-v1", BuildInfo: build}
+	if got != want {
+		t.Errorf("expected %+v but got %+v", want, got)
+	}
+}
+
+func TestPingHandler(t *testing.T) {
+	env := Config{AdminAddress: "localhost:9090"}
+	w := httptest.NewRecorder()
+	env.routes(NewKeyValueStore(StoreOptions{})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response["message"] != "pong" || len(response) != 2 {
+		t.Errorf("expected message and time but got %v", response)
+	}
+	if _, err := time.Parse(time.RFC3339, response["time"]); err != nil {
+		t.Errorf("expected an RFC 3339 time but got %q", response["time"])
+	}
+}
+
+func TestNewBuildInfo(t *testing.T) {
+	build := NewBuildInfo("", "", "")
+	if build.GoVersion != runtime.Version() {
+		t.Errorf("expected go version %v but got %v", runtime.Version(), build.GoVersion)
+	}
+	if build.Version == "" {
+		t.Error("expected the module version as fallback")
+	}
+}
+
+func TestConfig_MiddlewareServiceVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		env  Config
+		path string
+	}{
+		{name: "data endpoint", path: "/version"},
+		{name: "admin endpoint", path: "/healthz"},
+		{name: "unknown path", path: "/missing"},
+		{name: "admin address", env: Config{AdminAddress: "localhost:9090"}, path: "/healthz"},
+		{name: "base path", env: Config{BasePath: "/kv"}, path: "/kv/version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			env.Build = BuildInfo{Version: "1.5.0"}
+			handler := env.routes(NewKeyValueStore(StoreOptions{}))
+			if env.AdminAddress != "" {
+				handler = env.adminRoutes(NewKeyValueStore(StoreOptions{}))
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := w.Header().Get("X-Service-Version"); got != "1.5.0" {
+				t.Errorf("expected X-Service-Version %v but got %q", "1.5.0", got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		handler  http.HandlerFunc
+		wantCode int
+	}{
+		{name: "slow handler", timeout: 10 * time.Millisecond, handler: slow, wantCode: http.StatusServiceUnavailable},
+		{name: "fast handler", timeout: time.Second, handler: func(w http.ResponseWriter, r *http.Request) {}, wantCode: http.StatusOK},
+		{name: "disabled", timeout: 0, handler: func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Deadline(); ok {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}, wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			MiddlewareTimeout(tt.timeout, tt.handler)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v", tt.wantCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestConfig_routes_HandlerTimeout(t *testing.T) {
+	env := Config{HandlerTimeout: 10 * time.Millisecond}
+	kv := NewKeyValueStore(StoreOptions{})
+	handler := env.routes(kv)
+
+	// a write waiting for the lock of its shard runs into the timeout
+	s := kv.shard("key")
+	s.Lock()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"key","value":"value"}`))
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, r)
+	s.Unlock()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %v but got %v", http.StatusServiceUnavailable, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"key","value":"value"}`))
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestConfig_routes_H2C(t *testing.T) {
+	env := Config{
+		EnableH2C: true,
+	}
+	server := httptest.NewServer(env.routes(NewKeyValueStore(StoreOptions{})))
+	defer server.Close()
+
+	// an HTTP/2 transport that dials plain TCP instead of TLS
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	resp, err := client.Post(server.URL+"/set", "application/json", strings.NewReader(`{"key":"key","value":"value"}`))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 but got %v", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = client.Post(server.URL+"/get", "application/json", strings.NewReader(`{"key":"key"}`))
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 but got %v", resp.Proto)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if strings.TrimSpace(string(body)) != `{"value":"value"}` {
+		t.Errorf("expected value in body but got %v", string(body))
+	}
+
+	// HTTP/1.1 keeps working on the same listener
+	resp, err = http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("healthz failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected HTTP/1.1 200 but got %v %v", resp.Proto, resp.StatusCode)
+	}
+}
+
+func TestServer_Run_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "kv.sock")
+	env := Config{
+		ServerAddress:   "unix://" + socket,
+		ShutdownTimeout: time.Second,
+		SocketMode:      "0660",
+	}
+
+	// a server that was killed left its socket file behind
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o660 {
+		t.Errorf("expected the socket to have mode 0660 but got %v %v", info.Mode(), err)
+	}
+	// the socket is in use now
+	if _, err := Listen(env); err == nil || !strings.Contains(err.Error(), "another server listens") {
+		t.Errorf("expected a second server to fail but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	resp, err := client.Post("http://unix/set", "application/json", strings.NewReader(`{"key":"key","value":"value"}`))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = client.Post("http://unix/get", "application/json", strings.NewReader(`{"key":"key"}`))
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.TrimSpace(string(body)) != `{"value":"value"}` {
+		t.Errorf("expected value in body but got %v", string(body))
+	}
+
+	client.CloseIdleConnections()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected socket file to be removed on shutdown but got %v", err)
+	}
+}
+
+func TestServer_Run_MultipleAddresses(t *testing.T) {
+	env := Config{
+		ServerAddress:   "127.0.0.1:0, 127.0.0.1:0",
+		ShutdownTimeout: time.Second,
+	}
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := server.Addrs()
+	if len(addrs) != 2 || addrs[0].String() == addrs[1].String() || server.AdminAddr() != nil {
+		t.Fatalf("expected two server addresses and no admin address but got %v and %v", addrs, server.AdminAddr())
+	}
+
+	// an address that can not be bound fails the startup as a whole
+	taken := Config{ServerAddress: "127.0.0.1:0," + addrs[0].String(), ShutdownTimeout: time.Second}
+	if _, err := Listen(taken); err == nil || !strings.Contains(err.Error(), addrs[0].String()) {
+		t.Errorf("expected the taken address to fail the startup but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	for _, addr := range addrs {
+		resp, err := http.Get("http://" + addr.String() + "/healthz")
+		if err != nil {
+			t.Fatalf("healthz on %v failed: %v", addr, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("healthz on %v: expected status %v but got %v", addr, http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	// both addresses serve the same store
+	resp, err := http.Post("http://"+addrs[0].String()+"/set", "application/json", strings.NewReader(`{"key":"key","value":"value"}`))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post("http://"+addrs[1].String()+"/get", "application/json", strings.NewReader(`{"key":"key"}`))
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.TrimSpace(string(body)) != `{"value":"value"}` {
+		t.Errorf("expected the value set on the other address but got %v", string(body))
+	}
+
+	http.DefaultClient.CloseIdleConnections()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+	for _, addr := range addrs {
+		if conn, err := net.Dial("tcp", addr.String()); err == nil {
+			conn.Close()
+			t.Errorf("expected %v to be closed after the shutdown", addr)
+		}
+	}
+}
+
+func TestKeyValueStore_ExistsHandler(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"present": "value"})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "present key", body: `{"key":"present"}`, wantCode: http.StatusOK, wantBody: `{"exists":true}`},
+		{name: "absent key", body: `{"key":"absent"}`, wantCode: http.StatusOK, wantBody: `{"exists":false}`},
+		{name: "invalid request body", body: `"key"}`, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			kv.ExistsHandler(w, httptest.NewRequest(http.MethodPost, "/exists", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v", tt.wantCode, w.Code)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(w.Body.String()) != tt.wantBody {
+				t.Errorf("expected body %v but got %v", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_SetNXHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    map[Key]Value
+		body      string
+		wantCode  int
+		wantValue Value
+	}{
+		{
+			name:      "absent key is created",
+			values:    map[Key]Value{},
+			body:      `{"key":"lock", "value":"owner-a"}`,
+			wantCode:  http.StatusCreated,
+			wantValue: "owner-a",
+		},
+		{
+			name:      "existing key is left untouched",
+			values:    map[Key]Value{"lock": "owner-a"},
+			body:      `{"key":"lock", "value":"owner-b"}`,
+			wantCode:  http.StatusConflict,
+			wantValue: "owner-a",
+		},
+		{
+			name:     "invalid request body",
+			values:   map[Key]Value{},
+			body:     `"value":"value"}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newTestStore(tt.values)
+
+			w := httptest.NewRecorder()
+			kv.SetNXHandler(w, httptest.NewRequest(http.MethodPost, "/setnx", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %v but got %v", tt.wantCode, w.Code)
+			}
+			if got := testValues(kv)["lock"]; got != tt.wantValue {
+				t.Errorf("expected value %q but got %q", tt.wantValue, got)
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_PopHandler(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	set(t, kv, `{"key":"job", "value":"payload"}`)
+
+	w := httptest.NewRecorder()
+	kv.PopHandler(w, httptest.NewRequest(http.MethodPost, "/pop", strings.NewReader(`{"key":"job"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+	if strings.TrimSpace(w.Body.String()) != `{"value":"payload"}` {
+		t.Errorf("expected popped value but got %v", w.Body.String())
+	}
+	if _, ok := kv.peek("job"); ok {
+		t.Error("expected key to be deleted after pop")
+	}
+	if kv.Bytes() != 0 {
+		t.Errorf("expected popped bytes to be released but got %d", kv.Bytes())
+	}
+
+	w = httptest.NewRecorder()
+	kv.PopHandler(w, httptest.NewRequest(http.MethodPost, "/pop", strings.NewReader(`{"key":"job"}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %v for a missing key but got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestConfig_AdminAddress(t *testing.T) {
+	env := Config{
+		AdminAddress:         "127.0.0.1:0",
+		EnableDebugEndpoints: true,
+	}
+	kv := NewKeyValueStore(StoreOptions{})
+
+	data := httptest.NewServer(env.routes(kv))
+	defer data.Close()
+	admin := httptest.NewServer(env.adminRoutes(kv))
+	defer admin.Close()
+
+	tests := []struct {
+		path      string
+		method    string
+		body      string
+		dataCode  int
+		adminCode int
+	}{
+		{path: "/set", method: http.MethodPost, body: `{"key":"a","value":"1"}`, dataCode: http.StatusOK, adminCode: http.StatusNotFound},
+		{path: "/kv/a", method: http.MethodGet, dataCode: http.StatusOK, adminCode: http.StatusNotFound},
+		{path: "/healthz", method: http.MethodGet, dataCode: http.StatusNotFound, adminCode: http.StatusOK},
+		{path: "/readyz", method: http.MethodGet, dataCode: http.StatusNotFound, adminCode: http.StatusOK},
+		{path: "/metrics", method: http.MethodGet, dataCode: http.StatusNotFound, adminCode: http.StatusOK},
+		{path: "/stats", method: http.MethodGet, dataCode: http.StatusNotFound, adminCode: http.StatusOK},
+		{path: "/debug/pprof/", method: http.MethodGet, dataCode: http.StatusNotFound, adminCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		for _, target := range []struct {
+			url  string
+			want int
+		}{{data.URL, tt.dataCode}, {admin.URL, tt.adminCode}} {
+			req, _ := http.NewRequest(tt.method, target.url+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s %s: %v", tt.method, target.url+tt.path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != target.want {
+				t.Errorf("%s %s: expected status %v but got %v", tt.method, target.url+tt.path, target.want, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestKeyValueStore_FlushHandler(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxKeys: 10})
+	set(t, kv, `{"key":"a","value":"1"}`)
+	set(t, kv, `{"key":"b","value":"2"}`)
+
+	w := httptest.NewRecorder()
+	kv.FlushHandler(w, httptest.NewRequest(http.MethodPost, "/flush", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+	if strings.TrimSpace(w.Body.String()) != `{"deleted":2}` {
+		t.Errorf("expected deleted count in body but got %v", w.Body.String())
+	}
+	if kv.Len() != 0 || kv.Bytes() != 0 || kv.shards[0].lru.Len() != 0 {
+		t.Errorf("expected an empty store but got %d keys, %d bytes, %d lru entries", kv.Len(), kv.Bytes(), kv.shards[0].lru.Len())
+	}
+}
+
+func TestConfig_routes_BasePath(t *testing.T) {
+	tests := []struct {
+		name          string
+		basePathAdmin bool
+		wantCodes     map[string]int
+	}{
+		{
+			name: "admin endpoints stay at the root",
+			wantCodes: map[string]int{
+				"/kv/set":         http.StatusOK,
+				"/kv/kv/a":        http.StatusOK,
+				"/set":            http.StatusNotFound,
+				"/kv/a":           http.StatusNotFound,
+				"/healthz":        http.StatusOK,
+				"/metrics":        http.StatusOK,
+				"/kv/healthz":     http.StatusNotFound,
+				"/debug/vars":     http.StatusOK,
+				"/kv/exists":      http.StatusOK,
+				"/kv/":            http.StatusNotFound,
+				"/kv/stats":       http.StatusNotFound,
+				"/stats":          http.StatusOK,
+				"/kv/metrics":     http.StatusNotFound,
+				"/admin/loglevel": http.StatusOK,
+			},
+		},
+		{
+			name:          "admin endpoints are prefixed as well",
+			basePathAdmin: true,
+			wantCodes: map[string]int{
+				"/kv/set":        http.StatusOK,
+				"/set":           http.StatusNotFound,
+				"/healthz":       http.StatusNotFound,
+				"/kv/healthz":    http.StatusOK,
+				"/kv/metrics":    http.StatusOK,
+				"/kv/debug/vars": http.StatusOK,
+				"/debug/vars":    http.StatusNotFound,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := Config{
+				BasePath:             "/kv/",
+				BasePathAdmin:        tt.basePathAdmin,
+				EnableDebugEndpoints: true,
+			}
+			handler := env.routes(newTestStore(map[Key]Value{"a": "1"}))
+
+			for path, want := range tt.wantCodes {
+				method, body := http.MethodGet, ""
+				if strings.HasSuffix(path, "set") {
+					method, body = http.MethodPost, `{"key":"a","value":"1"}`
+				}
+				if strings.HasSuffix(path, "exists") {
+					method, body = http.MethodPost, `{"key":"a"}`
+				}
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(method, path, strings.NewReader(body))
+				r.Header.Set("Content-Type", "application/json")
+				handler.ServeHTTP(w, r)
+				if w.Code != want {
+					t.Errorf("%s %s: expected status %v but got %v", method, path, want, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_limitListener(t *testing.T) {
+	const limit, clients = 2, 4
+	env := Config{MaxConnections: limit}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entered := make(chan struct{}, clients)
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})}
+	go server.Serve(env.limitListener(listener))
+	defer server.Close()
+
+	// every client uses its own connection so the limit applies to the requests
+	done := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			resp, err := client.Get("http://" + listener.Addr().String())
+			if err == nil {
+				resp.Body.Close()
+			}
+			done <- err
+		}()
+	}
+
+	for i := 0; i < limit; i++ {
+		select {
+		case <-entered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d requests to be served but got %d", limit, i)
+		}
+	}
+	select {
+	case <-entered:
+		t.Fatalf("expected at most %d concurrent requests", limit)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the waiting clients are served once the slow ones finish
+	close(release)
+	for i := 0; i < clients; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected all requests to finish")
+		}
+	}
+}
+
+func TestServer_Run_MaxConnections(t *testing.T) {
+	const limit = 2
+	server, _ := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second, MaxConnections: limit})
+	address := server.Addr().String()
+	openConnections := func(want int64) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); server.store.connections.Load() != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d open connections but got %d", want, server.store.connections.Load())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// idle connections that never send a request, like a slow-loris client
+	var conns []net.Conn
+	for range limit + 1 {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	openConnections(limit)
+
+	// the extra connection sits in the backlog, its request is not read until a slot frees up
+	extra := conns[limit]
+	if _, err := io.WriteString(extra, "GET /healthz HTTP/1.1\r\nHost: kv\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	extra.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := extra.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection beyond the limit not to be served")
+	}
+
+	conns[0].Close()
+	extra.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(extra), nil)
+	if err != nil {
+		t.Fatalf("expected the connection to be served once another closed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+	openConnections(limit)
+
+	for _, conn := range conns[1:] {
+		conn.Close()
+	}
+	openConnections(0)
+
+	w := httptest.NewRecorder()
+	NewMetricsHandler(server.store).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "kv_http_open_connections 0") {
+		t.Error("expected the open connections in the metrics")
+	}
+}
+
+func TestServer_Run_ReadHeaderTimeout(t *testing.T) {
+	server, _ := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second, ReadHeaderTimeout: 100 * time.Millisecond})
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the headers never complete, the server closes the connection instead of holding it until the read timeout
+	io.WriteString(conn, "GET /healthz HTTP/1.1\r\n")
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	io.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the connection to be closed after the read header timeout but it took %v", elapsed)
+	}
+}
+
+// serveWithTimeouts serves the handler with the timeouts of the config and returns its URL
+func serveWithTimeouts(t *testing.T, env *Config, handler http.HandlerFunc) string {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Config = env.newServer("", handler)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestConfig_newServer_WriteTimeout(t *testing.T) {
+	env := &Config{WriteTimeout: 50 * time.Millisecond}
+	url := serveWithTimeouts(t, env, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "too late")
+	})
+
+	resp, err := http.Get(url)
+	if err == nil {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Fatalf("expected the write timeout to kill the slow handler but got %v %q", resp.StatusCode, body)
+	}
+}
+
+func TestConfig_streaming(t *testing.T) {
+	env := &Config{WriteTimeout: 50 * time.Millisecond}
+	// the stream takes far longer than the write timeout, but no write takes longer than it
+	stream := func(w http.ResponseWriter, r *http.Request) {
+		for i := range 10 {
+			fmt.Fprintln(w, i)
+			http.NewResponseController(w).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	url := serveWithTimeouts(t, env, env.streaming(stream))
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected the stream to complete but got %v after %q", err, body)
+	}
+	if lines := strings.Count(string(body), "\n"); lines != 10 {
+		t.Errorf("expected 10 lines but got %d: %q", lines, body)
+	}
+}
+
+func TestServer_Run_EndToEnd(t *testing.T) {
+	server, err := Listen(Config{
+		ServerAddress:   "127.0.0.1:0",
+		ShutdownTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := "http://" + server.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	resp, err := http.Post(baseURL+"/set", "application/json", strings.NewReader(`{"key":"key","value":"value"}`))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set: expected status %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = http.Post(baseURL+"/get", "application/json", strings.NewReader(`{"key":"key"}`))
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	var got GetResponse
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil || got.Value != "value" {
+		t.Errorf("get: expected value %v but got %v (%v)", "value", got.Value, err)
+	}
+
+	http.DefaultClient.CloseIdleConnections()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after the context was cancelled")
+	}
+
+	if _, err := http.Get(baseURL + "/healthz"); err == nil {
+		t.Error("expected the server to be closed")
+	}
+}
+
+func TestRun_InvalidAddress(t *testing.T) {
+	if err := Run(context.Background(), Config{ServerAddress: "127.0.0.1:-1"}); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
+
+func TestServer_Run_ShutdownTimeout(t *testing.T) {
+	env := Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: 100 * time.Millisecond, ShutdownExitCode: 3}
+	server, err := Listen(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Run(ctx)
+	}()
+
+	// a client that never finishes its request keeps its connection active, so draining it times out
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /ping HTTP/1.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	err = <-done
+	if !errors.Is(err, ErrUncleanShutdown) {
+		t.Fatalf("expected an unclean shutdown but got %v", err)
+	}
+	if code := env.ExitCode(err); code != 3 {
+		t.Errorf("expected the configured exit code 3 but got %d", code)
+	}
+}
+
+func TestServer_Run_FinalSnapshotFails(t *testing.T) {
+	dir := t.TempDir()
+	server, err := Listen(Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second, SnapshotFile: filepath.Join(dir, "data", "snapshot.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := server.Run(ctx); !errors.Is(err, ErrUncleanShutdown) {
+		t.Errorf("expected the failed final snapshot to make the shutdown unclean but got %v", err)
+	}
+}
+
+func TestConfig_ExitCode(t *testing.T) {
+	env := Config{ShutdownExitCode: 4}
+	for _, tt := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "clean shutdown", err: nil, want: 0},
+		{name: "unclean shutdown", err: fmt.Errorf("%w: failed to shutdown server: %w", ErrUncleanShutdown, context.DeadlineExceeded), want: 4},
+		{name: "unclean shutdown after a failure", err: errors.Join(errors.New("failed to serve"), ErrUncleanShutdown), want: 4},
+		{name: "failure", err: errors.New("failed to load snapshot"), want: 1},
+	} {
+		if got := env.ExitCode(tt.err); got != tt.want {
+			t.Errorf("%s: expected exit code %d but got %d", tt.name, tt.want, got)
+		}
+	}
+	if got := (&Config{}).ExitCode(ErrUncleanShutdown); got != 1 {
+		t.Errorf("expected an unset shutdown exit code to exit with 1 but got %d", got)
+	}
+}
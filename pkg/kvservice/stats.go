@@ -0,0 +1,143 @@
+package kvservice
+
+import (
+	"net/http"
+	"time"
+)
+
+// curl http://localhost:8080/stats
+// curl http://localhost:8080/stats?namespace=team-a
+// curl http://localhost:8080/stats/values
+
+// startTime is when the process started, it is used to report the uptime
+var startTime = time.Now()
+
+// StatsResponse is the body returned by the stats endpoint
+type StatsResponse struct {
+	Keys int `json:"keys"`
+	// Bytes is the size of the keys and values in memory, RawBytes their size before compression
+	Bytes         int64   `json:"bytes"`
+	RawBytes      int64   `json:"raw_bytes"`
+	GetHits       uint64  `json:"get_hits"`
+	GetMisses     uint64  `json:"get_misses"`
+	Sets          uint64  `json:"sets"`
+	Deletes       uint64  `json:"deletes"`
+	Evictions     uint64  `json:"evictions"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Version       string  `json:"version"`
+	// Quota is the quota of the namespace, its usage are Keys and Bytes
+	Quota *NamespaceQuota `json:"quota,omitempty"`
+	// Replication is the state of the replication of all namespaces if the service is a replica
+	Replication *ReplicationStatus `json:"replication,omitempty"`
+}
+
+// Stats returns a point in time view of the store counters
+func (kv *KeyValueStore) Stats() StatsResponse {
+	stats := StatsResponse{
+		Keys:          kv.Len(),
+		Bytes:         kv.Bytes(),
+		RawBytes:      kv.RawBytes(),
+		GetHits:       kv.stats.hits.Load(),
+		GetMisses:     kv.stats.misses.Load(),
+		Sets:          kv.stats.sets.Load(),
+		Deletes:       kv.stats.deletes.Load(),
+		Evictions:     kv.evictions.Load(),
+		UptimeSeconds: time.Since(startTime).Seconds(),
+	}
+	if q, ok := kv.Quota(); ok {
+		stats.Quota = &q
+	}
+	if r := kv.root.replicator; r != nil {
+		status := r.Status()
+		stats.Replication = &status
+	}
+	return stats
+}
+
+// StatsHandler returns the counters and quota of the namespace, the process uptime and the service version
+// the namespace is taken from the namespace parameter or the X-Namespace header
+// it only holds the store lock to read the key count and size so it is cheap enough to be polled
+func (env *Config) StatsHandler(kvStore *KeyValueStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		kvStore, ok := kvStore.readNamespace(w, r, r.URL.Query().Get("namespace"))
+		if !ok {
+			return
+		}
+		stats := kvStore.Stats()
+		stats.Version = env.Build.Version
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, kvStore.root.jsonCase, stats)
+	}
+}
+
+// valueSizeBuckets are the upper bounds of the value size histogram, values at or above the last bound fall into an open bucket
+var valueSizeBuckets = []struct {
+	label string
+	upper int
+}{
+	{label: "<1KB", upper: 1024},
+	{label: "1-10KB", upper: 10 * 1024},
+	{label: "10-100KB", upper: 100 * 1024},
+	{label: ">100KB"},
+}
+
+// ValueSizeBucket counts the values whose size is at least MinBytes and below MaxBytes, a MaxBytes of 0 means no upper bound
+type ValueSizeBucket struct {
+	Label    string `json:"label"`
+	MinBytes int    `json:"min_bytes"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+	Count    int    `json:"count"`
+}
+
+// ValueSizeHistogram is the body returned by the value stats endpoint
+type ValueSizeHistogram struct {
+	Buckets []ValueSizeBucket `json:"buckets"`
+}
+
+// ValueSizeHistogram buckets all stored values by their size, a list or hash by the size of its items or fields
+// it visits every entry so unlike Stats it is not meant to be polled frequently
+func (kv *KeyValueStore) ValueSizeHistogram() ValueSizeHistogram {
+	histogram := ValueSizeHistogram{Buckets: make([]ValueSizeBucket, len(valueSizeBuckets))}
+	lower := 0
+	for i, b := range valueSizeBuckets {
+		histogram.Buckets[i] = ValueSizeBucket{Label: b.label, MinBytes: lower, MaxBytes: b.upper}
+		lower = b.upper
+	}
+
+	for _, s := range kv.shards {
+		s.RLock()
+		for _, e := range s.kvMap {
+			i := 0
+			for i < len(valueSizeBuckets)-1 && e.contentLen() >= valueSizeBuckets[i].upper {
+				i++
+			}
+			histogram.Buckets[i].Count++
+		}
+		s.RUnlock()
+	}
+	return histogram
+}
+
+// StatsValuesHandler returns the histogram of value sizes in the namespace
+func (kv *KeyValueStore) StatsValuesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.readNamespace(w, r, "")
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, kv.root.jsonCase, kv.ValueSizeHistogram())
+}
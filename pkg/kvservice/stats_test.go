@@ -0,0 +1,107 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfig_StatsHandler(t *testing.T) {
+	env := Config{
+		Build: BuildInfo{Version: "1.5.0"},
+	}
+	kv := NewKeyValueStore(StoreOptions{MaxKeys: 2})
+	handler := env.routes(kv)
+
+	do := func(method, path, body string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+	}
+
+	do(http.MethodPost, "/set", `{"key":"a","value":"1"}`)
+	do(http.MethodPost, "/set", `{"key":"b","value":"22"}`)
+	do(http.MethodPost, "/set", `{"key":"a","value":"333"}`)
+	do(http.MethodPost, "/get", `{"key":"a"}`)
+	do(http.MethodGet, "/kv/b", "")
+	do(http.MethodPost, "/get", `{"key":"missing"}`)
+	do(http.MethodPost, "/set", `{"key":"c","value":"4444"}`) // evicts a
+	do(http.MethodDelete, "/kv/c", "")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+
+	var got StatsResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.UptimeSeconds <= 0 {
+		t.Errorf("expected a positive uptime but got %v", got.UptimeSeconds)
+	}
+	got.UptimeSeconds = 0
+
+	want := StatsResponse{
+		Keys:      1,
+		Bytes:     3,
+		RawBytes:  3,
+		GetHits:   2,
+		GetMisses: 1,
+		Sets:      4,
+		Deletes:   1,
+		Evictions: 1,
+		Version:   "1.5.0",
+	}
+	if got != want {
+		t.Errorf("expected stats %+v but got %+v", want, got)
+	}
+}
+
+func TestKeyValueStore_StatsValuesHandler(t *testing.T) {
+	kv := newTestStore(map[Key]Value{
+		"empty":  "",
+		"small":  Value(strings.Repeat("x", 1023)),
+		"1kb":    Value(strings.Repeat("x", 1024)),
+		"5kb":    Value(strings.Repeat("x", 5*1024)),
+		"10kb":   Value(strings.Repeat("x", 10*1024)),
+		"100kb":  Value(strings.Repeat("x", 100*1024)),
+		"1mb":    Value(strings.Repeat("x", 1024*1024)),
+		"99.9kb": Value(strings.Repeat("x", 100*1024-1)),
+	})
+	// a list or hash is as large as its items or fields together, its value is empty
+	for key, e := range map[Key]entry{
+		"list": {kind: kindList, items: []Value{Value(strings.Repeat("x", 5*1024)), Value(strings.Repeat("x", 5*1024))}},
+		"hash": {kind: kindHash, fields: map[string]Value{"field": Value(strings.Repeat("x", 2*1024))}},
+	} {
+		s := kv.shard(key)
+		s.Lock()
+		s.writeEntry(key, e)
+		s.Unlock()
+	}
+
+	w := httptest.NewRecorder()
+	kv.StatsValuesHandler(w, httptest.NewRequest(http.MethodGet, "/stats/values", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+
+	var got ValueSizeHistogram
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	want := map[string]int{"<1KB": 2, "1-10KB": 3, "10-100KB": 3, ">100KB": 2}
+	if len(got.Buckets) != len(want) {
+		t.Fatalf("expected %d buckets but got %v", len(want), got.Buckets)
+	}
+	for _, b := range got.Buckets {
+		if b.Count != want[b.Label] {
+			t.Errorf("bucket %s: expected %d values but got %d", b.Label, want[b.Label], b.Count)
+		}
+	}
+}
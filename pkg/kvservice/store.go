@@ -0,0 +1,586 @@
+package kvservice
+
+import (
+	"container/list"
+	"errors"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// ErrStoreFull is returned when a write would grow the store beyond its configured capacity
+var ErrStoreFull = errors.New("store is full")
+
+// EvictionPolicy decides what happens when a write would exceed the configured capacity
+type EvictionPolicy string
+
+const (
+	// EvictionReject rejects the write and leaves the store untouched
+	EvictionReject EvictionPolicy = "reject"
+	// EvictionLRU evicts the least recently used entries until the write fits
+	EvictionLRU EvictionPolicy = "lru"
+)
+
+// StoreOptions bounds the store, the zero value is an unbounded store
+type StoreOptions struct {
+	// MaxBytes caps the total size of all keys and values, 0 means unbounded
+	MaxBytes int64
+	Eviction EvictionPolicy
+	// MaxKeys caps the number of entries, inserting beyond it evicts the least recently used entry, 0 means unbounded
+	MaxKeys int
+	// SoftDelete keeps deleted entries as tombstones for this long so they can be undeleted, 0 deletes immediately
+	SoftDelete time.Duration
+	// HistoryDepth is the number of overwritten values kept per key, 0 keeps none
+	HistoryDepth int
+	// CompressThreshold compresses string values larger than this many bytes, MaxBytes bounds their compressed size, 0 disables compression
+	CompressThreshold int
+}
+
+// defaultShards is the number of shards of an unbounded store, it must be a power of two
+const defaultShards = 256
+
+// KeyValueStore holds the data in shards, each with its own lock and map, so writes to different keys rarely contend
+// bounded stores use a single shard so the limits and the eviction order stay exact across all keys
+type KeyValueStore struct {
+	shards  []*shard
+	options StoreOptions
+	// revision is the last version handed out to a write, it only ever grows
+	revision atomic.Uint64
+	// evictions counts the entries removed to make room for new writes
+	evictions atomic.Uint64
+	stats     storeStats
+	// readOnly rejects mutations through the handlers, it can be toggled at runtime
+	readOnly atomic.Bool
+	// maintenance makes the data endpoints answer 503 with a Retry-After of maintenanceRetryAfter, it can be toggled at runtime
+	maintenance           atomic.Bool
+	maintenanceRetryAfter atomic.Int64
+	// loading is set while the data is loaded at startup
+	loading atomic.Bool
+	// shuttingDown is set once the server is about to shut down
+	shuttingDown atomic.Bool
+	// stopping is set once the server stops serving, after the pre-shutdown delay
+	stopping atomic.Bool
+	// webhooks are notified about every change of a key, nil if none are configured
+	webhooks *Webhooks
+	// writer applies the sets of all namespaces in batches, nil if the handlers lock the shards themselves
+	writer *batchWriter
+	// wal logs the changes of all namespaces between snapshots, nil if only snapshots are written
+	wal *writeAheadLog
+	// snapshotter writes the snapshots, which truncate the write-ahead log, /compact uses it, nil without a snapshot file
+	snapshotter *Snapshotter
+	// feed keeps the recent changes of all namespaces for /changes
+	feed changeFeed
+	// replicator copies a primary into the store, nil unless the store is a replica
+	replicator *Replicator
+	// changes counts the changes to the entries and tombstones of all namespaces, a snapshot is only due if it moved
+	changes atomic.Uint64
+	// valueSchema is the JSON Schema the write handlers validate values against, nil accepts every value
+	valueSchema *jsonschema.Schema
+	// keyPattern is the pattern the write handlers validate keys against, nil accepts every key, keyPatternSource is the pattern as configured
+	keyPattern       *regexp.Regexp
+	keyPatternSource string
+	// missingKeyStatus is the status GetHandler answers for a missing key, 0 means 404
+	missingKeyStatus int
+	// jsonCase is how writeJSON names the fields of the responses, empty means snake case
+	jsonCase JSONCase
+	// connections is the number of open connections on the server addresses, see trackConnection
+	connections atomic.Int64
+	// requestDurations is the histogram of the durations of the requests to the endpoints, see MiddlewareMetrics
+	requestDurations *prometheus.HistogramVec
+	// idempotency remembers the responses of /set to requests with an Idempotency-Key header, nil ignores the header
+	idempotency *idempotencyCache
+	// lockWaitTimeout is how long the handlers wait for the lock of a shard before answering 503, 0 waits as long as it takes
+	lockWaitTimeout time.Duration
+	// lockWaits is the histogram of the time the handlers waited for the lock of a shard, lockTimeouts counts the waits that timed out
+	lockWaits    prometheus.Histogram
+	lockTimeouts atomic.Uint64
+
+	// name is the namespace the store holds
+	name string
+	// root is the store of the default namespace, it holds the other namespaces and the read-only state and schema they share
+	// for the default namespace it is the store itself
+	root         *KeyValueStore
+	namespacesMu sync.RWMutex
+	namespaces   map[string]*KeyValueStore
+	// maxNamespaces caps the namespaces the handlers create besides the default namespace, 0 means unbounded
+	maxNamespaces int
+	// usage counts the keys and bytes of all namespaces, MaxKeys and MaxBytes of the options bound them together
+	usage usage
+	// quotas are the configured quotas by namespace, quota is the one of this namespace, nil if it is unbounded
+	quotas map[string]NamespaceQuota
+	quota  *quota
+	// auditLog records the mutations of all namespaces, nil disables auditing
+	auditLog *AuditLog
+	// locks are the distributed locks of the namespace, see LockAcquireHandler
+	locks lockTable
+	// keyLocks serialize the read-modify-writes of a key, see update
+	keyLocks keyedMutex
+	// health are the checks of the components reported by /health
+	health healthChecks
+	// webSockets are the open connections of /ws of all namespaces
+	webSockets webSocketSet
+	// clock returns the current time for tombstones and history, tests replace it to control the time
+	clock func() time.Time
+}
+
+// usage counts the keys and bytes stored in all namespaces
+type usage struct {
+	keys  atomic.Int64
+	bytes atomic.Int64
+}
+
+// release removes the keys and bytes from the usage
+func (u *usage) release(keys, bytes int64) {
+	u.keys.Add(-keys)
+	u.bytes.Add(-bytes)
+}
+
+// restore adds the keys and bytes to the usage without checking the bounds, it is used to bring back usage that was released before
+func (u *usage) restore(keys, bytes int64) {
+	u.keys.Add(keys)
+	u.bytes.Add(bytes)
+}
+
+// shard is a part of the store, all methods require the caller to hold the lock of the shard
+type shard struct {
+	sync.RWMutex
+	kv    *KeyValueStore
+	kvMap map[Key]entry
+	// bytes is the total size of all keys and values stored in the shard, compressed values are accounted with their compressed size
+	bytes int64
+	// rawBytes is bytes with the values accounted before compression
+	rawBytes int64
+	// lru orders the keys from most to least recently used, it is only maintained when entries can be evicted
+	lru *list.List
+	// tombstones holds the deleted entries while they can be undeleted, they are not part of kvMap nor accounted in bytes
+	tombstones map[Key]tombstone
+	// waiters holds a channel per list key that blocked pops wait on, it is closed by the next push
+	waiters map[Key]chan struct{}
+}
+
+// storeStats counts the operations served by the store, the counters are atomic so reading them needs no lock
+type storeStats struct {
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	sets    atomic.Uint64
+	deletes atomic.Uint64
+}
+
+// valueKind is the type of value an entry holds
+type valueKind uint8
+
+const (
+	kindString valueKind = iota
+	kindList
+	kindHash
+)
+
+// entry is a stored value together with the version of the write that produced it
+type entry struct {
+	value Value
+	// rawLen is the length of the value before compression, 0 if the value is not compressed
+	rawLen  int
+	version uint64
+	// modified is the time of the write that produced the entry
+	modified time.Time
+	kind     valueKind
+	// items are the values of a list, the slice is modified in place so readers must copy it under the shard lock
+	items []Value
+	// fields are the fields of a hash, the map is never modified but replaced on every write so it may be shared
+	fields map[string]Value
+	// elem is the position of the key in the lru list, nil when the list is not maintained
+	elem *list.Element
+	// history holds the overwritten values newest first, at most HistoryDepth of them
+	history []historyVersion
+	// tag is the ETag of the entry, hashed once by writeEntry so conditional requests do not hash large values again and again
+	tag string
+}
+
+// NewKeyValueStore returns an empty store bounded by the given options
+func NewKeyValueStore(options StoreOptions) *KeyValueStore {
+	n := defaultShards
+	if options.MaxBytes > 0 || options.MaxKeys > 0 {
+		n = 1
+	}
+	kv := newKeyValueStore(options, n)
+	kv.requestDurations = newRequestDurations(nil)
+	kv.lockWaits = newLockWaits()
+	return kv
+}
+
+// newKeyValueStore returns an empty store with n shards, n must be a power of two
+func newKeyValueStore(options StoreOptions, n int) *KeyValueStore {
+	kv := &KeyValueStore{
+		options: options,
+		name:    DefaultNamespace,
+		clock:   time.Now,
+	}
+	kv.root = kv
+
+	kv.shards = make([]*shard, n)
+	for i := range kv.shards {
+		kv.shards[i] = &shard{
+			kv:    kv,
+			kvMap: make(map[Key]entry),
+		}
+		if options.Eviction == EvictionLRU || options.MaxKeys > 0 {
+			kv.shards[i].lru = list.New()
+		}
+	}
+	return kv
+}
+
+// now returns the current time of the clock shared by all namespaces
+func (kv *KeyValueStore) now() time.Time {
+	return kv.root.clock()
+}
+
+// shard returns the shard holding the key
+func (kv *KeyValueStore) shard(key Key) *shard {
+	return kv.shards[kv.shardIndex(key)]
+}
+
+// shardIndex returns the index of the shard holding the key
+func (kv *KeyValueStore) shardIndex(key Key) int {
+	// inlined 32 bit FNV-1a so selecting a shard does not allocate
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h & uint32(len(kv.shards)-1))
+}
+
+// entrySize is the number of bytes a key value pair is accounted for
+func entrySize(key Key, value Value) int64 {
+	return int64(len(key) + len(value))
+}
+
+// size is the number of bytes the entry is accounted for, including the items of a list, the fields of a hash and the values in its history
+func (e entry) size(key Key) int64 {
+	size := entrySize(key, e.value)
+	for _, item := range e.items {
+		size += int64(len(item))
+	}
+	for field, value := range e.fields {
+		size += int64(len(field) + len(value))
+	}
+	for _, v := range e.history {
+		size += int64(len(v.value))
+	}
+	return size
+}
+
+// rawSize is size with the values accounted before compression
+func (e entry) rawSize(key Key) int64 {
+	size := e.size(key) - int64(len(e.value)) + int64(e.valueLen())
+	for _, v := range e.history {
+		if v.rawLen > 0 {
+			size += int64(v.rawLen - len(v.value))
+		}
+	}
+	return size
+}
+
+// put stores the value under the key and returns the new entry
+// it returns ErrStoreFull when the value does not fit and nothing may be evicted to make room
+func (s *shard) put(key Key, value Value) (entry, error) {
+	e, err := s.write(key, value)
+	if writeApplied(err) {
+		s.kv.stats.sets.Add(1)
+	}
+	return e, err
+}
+
+// write is put without counting the operation, it is used to restore data that was written before
+func (s *shard) write(key Key, value Value) (entry, error) {
+	return s.writeEntry(key, entry{value: value})
+}
+
+// writeEntry stores the value or list of e under the key with a new version and returns the stored entry
+// an entry loaded from a snapshot or the write-ahead log keeps its version, the revision moves past it
+func (s *shard) writeEntry(key Key, e entry) (entry, error) {
+	current, exists := s.kvMap[key]
+
+	// every write stores a new entry, so the tag of the entry is replaced together with its content
+	e.tag = e.hashETag()
+	e = s.kv.compress(e)
+	e.elem = current.elem
+	if exists {
+		e.history = s.kv.pushHistory(current)
+	}
+	size := e.size(key)
+	delta := size
+	rawDelta := e.rawSize(key)
+	var keys int64 = 1
+	if exists {
+		delta -= current.size(key)
+		rawDelta -= current.rawSize(key)
+		keys = 0
+	}
+
+	// the quota is checked first so a rejected write never evicts anything
+	if err := s.kv.quota.reserve(keys, delta); err != nil {
+		return entry{}, err
+	}
+	if err := s.reserve(key, current, exists, keys, delta, size); err != nil {
+		s.kv.quota.release(keys, delta)
+		return entry{}, err
+	}
+
+	// writing a deleted key makes it live again, its tombstone must not resurrect the old value later
+	delete(s.tombstones, key)
+
+	if e.version == 0 {
+		e.version = s.kv.revision.Add(1)
+	} else {
+		s.kv.advanceRevision(e.version)
+	}
+	// an entry restored from a snapshot keeps the time it was originally written
+	if e.modified.IsZero() {
+		e.modified = s.kv.now()
+	}
+	if s.lru != nil {
+		if e.elem == nil {
+			e.elem = s.lru.PushFront(key)
+		} else {
+			s.lru.MoveToFront(e.elem)
+		}
+	}
+	s.kvMap[key] = e
+	s.bytes += delta
+	s.rawBytes += rawDelta
+	s.kv.root.changes.Add(1)
+	if err := s.notify(key, WebhookEventSet, e.version); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// reserve adds the keys and bytes of a write to the usage of all namespaces, evicting entries of the shard until they fit the options
+// current is the entry the write replaces, it is never evicted to make room for itself
+// entries of other namespaces are never evicted, if they hold too much the write is rejected without evicting anything in vain
+// it returns ErrStoreFull when the write does not fit and nothing may be evicted to make room
+func (s *shard) reserve(key Key, current entry, exists bool, keys, bytes, size int64) error {
+	usage := &s.kv.root.usage
+	maxKeys, maxBytes := int64(s.kv.options.MaxKeys), s.kv.options.MaxBytes
+	over := func(k, b int64) bool {
+		return keys > 0 && maxKeys > 0 && k > maxKeys || bytes > 0 && maxBytes > 0 && b > maxBytes
+	}
+	// a value larger than the whole store would evict everything and still not fit
+	if maxBytes > 0 && size > maxBytes {
+		return ErrStoreFull
+	}
+
+	var evictable, freeable int64
+	if s.lru != nil {
+		evictable, freeable = int64(s.lru.Len()), s.bytes
+		if exists {
+			evictable, freeable = evictable-1, freeable-current.size(key)
+			s.lru.MoveToFront(current.elem)
+		}
+	}
+	if over(usage.keys.Load()-evictable+keys, usage.bytes.Load()-freeable+bytes) {
+		return ErrStoreFull
+	}
+
+	for {
+		if !over(usage.keys.Add(keys), usage.bytes.Add(bytes)) {
+			return nil
+		}
+		usage.keys.Add(-keys)
+		usage.bytes.Add(-bytes)
+		if evictable == 0 {
+			return ErrStoreFull
+		}
+		if err := s.evict(); err != nil {
+			return err
+		}
+		evictable--
+	}
+}
+
+// lockGet locks the shard for get, which reorders the lru list and so needs the write lock when the list is maintained
+func (s *shard) lockGet() {
+	if s.lru != nil {
+		s.Lock()
+		return
+	}
+	s.RLock()
+}
+
+// unlockGet releases the lock taken by lockGet
+func (s *shard) unlockGet() {
+	if s.lru != nil {
+		s.Unlock()
+		return
+	}
+	s.RUnlock()
+}
+
+// get returns the entry for the key and marks it as recently used
+func (s *shard) get(key Key) (entry, bool) {
+	e, ok := s.kvMap[key]
+	if !ok {
+		s.kv.stats.misses.Add(1)
+		return e, false
+	}
+	s.kv.stats.hits.Add(1)
+	if e.elem != nil {
+		s.lru.MoveToFront(e.elem)
+	}
+	return e, true
+}
+
+// remove deletes the key and releases its bytes, with soft delete the entry is kept as a tombstone
+// an ErrWriteAheadLog is returned with the key removed, the deletion just could not be logged
+func (s *shard) remove(key Key) (entry, bool, error) {
+	// the tombstone is kept before the key is unlinked, so the deletion is logged together with it, see logChange
+	if e, ok := s.kvMap[key]; ok {
+		s.bury(key, e, s.kv.now())
+	}
+	e, ok, err := s.unlink(key)
+	if ok {
+		s.kv.stats.deletes.Add(1)
+	}
+	return e, ok, err
+}
+
+// unlink is remove without counting the operation and without keeping a tombstone
+func (s *shard) unlink(key Key) (entry, bool, error) {
+	e, ok := s.kvMap[key]
+	if !ok {
+		return entry{}, false, nil
+	}
+	if e.elem != nil {
+		s.lru.Remove(e.elem)
+	}
+	delete(s.kvMap, key)
+	s.bytes -= e.size(key)
+	s.rawBytes -= e.rawSize(key)
+	s.kv.quota.release(1, e.size(key))
+	s.kv.root.usage.release(1, e.size(key))
+	s.kv.root.changes.Add(1)
+	err := s.notify(key, WebhookEventDelete, 0)
+	return e, true, err
+}
+
+// flush removes all entries and tombstones and returns how many entries were removed
+func (s *shard) flush() int {
+	n := len(s.kvMap)
+	s.kv.quota.release(int64(n), s.bytes)
+	s.kv.root.usage.release(int64(n), s.bytes)
+	s.kvMap = make(map[Key]entry)
+	s.tombstones = nil
+	s.bytes = 0
+	s.rawBytes = 0
+	if s.lru != nil {
+		s.lru.Init()
+	}
+	s.kv.stats.deletes.Add(uint64(n))
+	s.kv.root.changes.Add(1)
+	return n
+}
+
+// evict removes the least recently used entry, the lru list must not be empty
+func (s *shard) evict() error {
+	_, _, err := s.unlink(s.lru.Back().Value.(Key))
+	s.kv.evictions.Add(1)
+	return err
+}
+
+// peek returns the entry for the key without counting the read or marking it as recently used
+func (kv *KeyValueStore) peek(key Key) (entry, bool) {
+	s := kv.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+	e, ok := s.kvMap[key]
+	return e, ok
+}
+
+// advanceRevision moves the revision to at least version, so the versions handed out afterwards are higher than a loaded one
+func (kv *KeyValueStore) advanceRevision(version uint64) {
+	for {
+		current := kv.revision.Load()
+		if current >= version || kv.revision.CompareAndSwap(current, version) {
+			return
+		}
+	}
+}
+
+// flush removes all entries from every shard and returns how many were removed
+// all shards are locked at once so the flush is a single change in the change feed, no write lands in between
+// an ErrWriteAheadLog is returned with the entries removed, the flush just could not be logged
+func (kv *KeyValueStore) flush() (int, error) {
+	for _, s := range kv.shards {
+		s.Lock()
+	}
+	n := 0
+	for _, s := range kv.shards {
+		n += s.flush()
+	}
+	err := kv.shards[0].notify("", ChangeEventFlush, 0)
+	for _, s := range slices.Backward(kv.shards) {
+		s.Unlock()
+	}
+	if err == nil {
+		err = kv.syncLog()
+	}
+	return n, err
+}
+
+// Evictions returns the number of entries removed to make room for new writes
+func (kv *KeyValueStore) Evictions() uint64 {
+	return kv.evictions.Load()
+}
+
+// Bytes returns the total size of all keys and values currently stored, compressed values count with their compressed size
+func (kv *KeyValueStore) Bytes() int64 {
+	var bytes int64
+	for _, s := range kv.shards {
+		s.RLock()
+		bytes += s.bytes
+		s.RUnlock()
+	}
+	return bytes
+}
+
+// RawBytes returns Bytes with the values accounted before compression
+func (kv *KeyValueStore) RawBytes() int64 {
+	var bytes int64
+	for _, s := range kv.shards {
+		s.RLock()
+		bytes += s.rawBytes
+		s.RUnlock()
+	}
+	return bytes
+}
+
+// Len returns the number of keys currently stored
+func (kv *KeyValueStore) Len() int {
+	n := 0
+	for _, s := range kv.shards {
+		s.RLock()
+		n += len(s.kvMap)
+		s.RUnlock()
+	}
+	return n
+}
+
+// Keys returns all keys currently stored in no particular order
+func (kv *KeyValueStore) Keys() []Key {
+	keys := make([]Key, 0, kv.Len())
+	for _, s := range kv.shards {
+		s.RLock()
+		for k := range s.kvMap {
+			keys = append(keys, k)
+		}
+		s.RUnlock()
+	}
+	return keys
+}
@@ -0,0 +1,303 @@
+package kvservice
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestStore returns an unbounded store holding the values
+func newTestStore(values map[Key]Value) *KeyValueStore {
+	kv := NewKeyValueStore(StoreOptions{})
+	for k, v := range values {
+		writeTestValue(kv, k, v)
+	}
+	return kv
+}
+
+// writeTestValue stores the value without counting it as a set
+func writeTestValue(kv *KeyValueStore, key Key, value Value) {
+	s := kv.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	if _, err := s.write(key, value); err != nil {
+		panic(err)
+	}
+}
+
+// testValues returns all values of the store
+func testValues(kv *KeyValueStore) map[Key]Value {
+	values := make(map[Key]Value)
+	for _, k := range kv.Keys() {
+		e, _ := kv.peek(k)
+		values[k] = e.plain()
+	}
+	return values
+}
+
+func set(t *testing.T, kv *KeyValueStore, body string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	kv.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", bytes.NewBufferString(body)))
+	return w.Code
+}
+
+func TestKeyValueStore_MaxBytesReject(t *testing.T) {
+	// every entry below is 1 byte key + 4 bytes value
+	kv := NewKeyValueStore(StoreOptions{MaxBytes: 10, Eviction: EvictionReject})
+
+	for _, body := range []string{`{"key":"a","value":"1111"}`, `{"key":"b","value":"2222"}`} {
+		if code := set(t, kv, body); code != http.StatusOK {
+			t.Fatalf("expected status %v but got %v", http.StatusOK, code)
+		}
+	}
+	if kv.Bytes() != 10 {
+		t.Fatalf("expected 10 bytes but got %d", kv.Bytes())
+	}
+
+	if code := set(t, kv, `{"key":"c","value":"3333"}`); code != http.StatusInsufficientStorage {
+		t.Errorf("expected status %v but got %v", http.StatusInsufficientStorage, code)
+	}
+	if _, ok := kv.peek("c"); ok {
+		t.Error("expected rejected key to not be stored")
+	}
+
+	// overwriting with a value of the same size still fits
+	if code := set(t, kv, `{"key":"a","value":"xxxx"}`); code != http.StatusOK {
+		t.Errorf("expected status %v but got %v", http.StatusOK, code)
+	}
+
+	// deleting releases the bytes
+	kv.shard("a").Lock()
+	kv.shard("a").remove("a")
+	kv.shard("a").Unlock()
+	if kv.Bytes() != 5 {
+		t.Errorf("expected 5 bytes after delete but got %d", kv.Bytes())
+	}
+	if code := set(t, kv, `{"key":"c","value":"3333"}`); code != http.StatusOK {
+		t.Errorf("expected status %v but got %v", http.StatusOK, code)
+	}
+}
+
+func TestKeyValueStore_MaxBytesBoundary(t *testing.T) {
+	// a holds 1 byte key + 4 bytes value, a limit of 10 leaves room for 5 more bytes
+	tests := []struct {
+		name     string
+		maxBytes int64
+		body     string
+		wantCode int
+		want     int64
+	}{
+		{name: "just under", maxBytes: 10, body: `{"key":"b","value":"222"}`, wantCode: http.StatusOK, want: 9},
+		{name: "exactly at", maxBytes: 10, body: `{"key":"b","value":"2222"}`, wantCode: http.StatusOK, want: 10},
+		{name: "just over", maxBytes: 10, body: `{"key":"b","value":"22222"}`, wantCode: http.StatusInsufficientStorage, want: 5},
+		{name: "overwrite growing to the limit", maxBytes: 10, body: `{"key":"a","value":"111111111"}`, wantCode: http.StatusOK, want: 10},
+		{name: "overwrite growing beyond the limit", maxBytes: 10, body: `{"key":"a","value":"1111111111"}`, wantCode: http.StatusInsufficientStorage, want: 5},
+		{name: "unbounded", maxBytes: 0, body: `{"key":"b","value":"22222"}`, wantCode: http.StatusOK, want: 11},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := NewKeyValueStore(StoreOptions{MaxBytes: tt.maxBytes, Eviction: EvictionReject})
+			if code := set(t, kv, `{"key":"a","value":"1111"}`); code != http.StatusOK {
+				t.Fatalf("expected status %v but got %v", http.StatusOK, code)
+			}
+
+			if code := set(t, kv, tt.body); code != tt.wantCode {
+				t.Errorf("expected status %v but got %v", tt.wantCode, code)
+			}
+			if kv.Bytes() != tt.want {
+				t.Errorf("expected %d bytes but got %d", tt.want, kv.Bytes())
+			}
+		})
+	}
+}
+
+func TestKeyValueStore_MaxBytesLRU(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxBytes: 15, Eviction: EvictionLRU})
+
+	for _, body := range []string{
+		`{"key":"a","value":"1111"}`,
+		`{"key":"b","value":"2222"}`,
+		`{"key":"c","value":"3333"}`,
+	} {
+		if code := set(t, kv, body); code != http.StatusOK {
+			t.Fatalf("expected status %v but got %v", http.StatusOK, code)
+		}
+	}
+
+	// reading a makes b the least recently used entry
+	w := httptest.NewRecorder()
+	kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"a"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, w.Code)
+	}
+
+	if code := set(t, kv, `{"key":"d","value":"4444"}`); code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, code)
+	}
+	if _, ok := kv.peek("b"); ok {
+		t.Error("expected least recently used key b to be evicted")
+	}
+	for _, key := range []Key{"a", "c", "d"} {
+		if _, ok := kv.peek(key); !ok {
+			t.Errorf("expected key %v to be kept", key)
+		}
+	}
+
+	// a value that needs two evictions to fit
+	if code := set(t, kv, `{"key":"e","value":"555555555"}`); code != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, code)
+	}
+	if kv.Len() != 2 || kv.Bytes() != 15 {
+		t.Errorf("expected 2 keys with 15 bytes but got %d keys with %d bytes", kv.Len(), kv.Bytes())
+	}
+	if kv.shards[0].lru.Len() != kv.Len() {
+		t.Errorf("expected lru list with %d entries but got %d", kv.Len(), kv.shards[0].lru.Len())
+	}
+}
+
+func TestKeyValueStore_ValueLargerThanCap(t *testing.T) {
+	for _, eviction := range []EvictionPolicy{EvictionReject, EvictionLRU} {
+		t.Run(string(eviction), func(t *testing.T) {
+			kv := NewKeyValueStore(StoreOptions{MaxBytes: 10, Eviction: eviction})
+			if code := set(t, kv, `{"key":"a","value":"1111"}`); code != http.StatusOK {
+				t.Fatalf("expected status %v but got %v", http.StatusOK, code)
+			}
+
+			kv.shard("b").Lock()
+			_, err := kv.shard("b").put("b", "this value does not fit")
+			kv.shard("b").Unlock()
+			if !errors.Is(err, ErrStoreFull) {
+				t.Errorf("expected %v but got %v", ErrStoreFull, err)
+			}
+			if _, ok := kv.peek("a"); !ok {
+				t.Error("expected existing key to survive a write that can never fit")
+			}
+		})
+	}
+}
+
+func TestNewMetricsHandler(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	set(t, kv, `{"key":"a","value":"1111"}`)
+
+	w := httptest.NewRecorder()
+	NewMetricsHandler(kv).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if !strings.Contains(w.Body.String(), "kv_store_bytes 5") {
+		t.Errorf("expected kv_store_bytes gauge in metrics but got %v", w.Body.String())
+	}
+}
+
+func TestKeyValueStore_MaxKeys(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxKeys: 2})
+
+	set(t, kv, `{"key":"a","value":"1"}`)
+	set(t, kv, `{"key":"b","value":"2"}`)
+
+	// reading a makes b the least recently used entry
+	w := httptest.NewRecorder()
+	kv.GetHandler(w, httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"key":"a"}`)))
+
+	// overwriting an existing key never evicts
+	set(t, kv, `{"key":"a","value":"3"}`)
+	if kv.Evictions() != 0 {
+		t.Fatalf("expected no evictions for an overwrite but got %d", kv.Evictions())
+	}
+
+	set(t, kv, `{"key":"c","value":"4"}`)
+	if _, ok := kv.peek("b"); ok {
+		t.Error("expected least recently used key b to be evicted")
+	}
+	if kv.Len() != 2 {
+		t.Errorf("expected 2 keys but got %d", kv.Len())
+	}
+
+	set(t, kv, `{"key":"d","value":"5"}`)
+	if _, ok := kv.peek("a"); ok {
+		t.Error("expected least recently used key a to be evicted")
+	}
+	if kv.Evictions() != 2 {
+		t.Errorf("expected 2 evictions but got %d", kv.Evictions())
+	}
+	if kv.Bytes() != 4 {
+		t.Errorf("expected 4 bytes but got %d", kv.Bytes())
+	}
+}
+
+func TestKeyValueStore_ConcurrentAccess(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+
+	const workers, keys = 16, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := Key(fmt.Sprintf("key-%d", i))
+				s := kv.shard(key)
+
+				s.Lock()
+				s.put(key, Value(fmt.Sprintf("%d-%d", w, i%10)))
+				s.Unlock()
+
+				s.lockGet()
+				s.get(key)
+				s.unlockGet()
+
+				if i%3 == 0 {
+					s.Lock()
+					s.remove(key)
+					s.Unlock()
+				}
+			}
+			kv.Len()
+			kv.Bytes()
+		}(w)
+	}
+	wg.Wait()
+
+	// the per shard byte counts must match what is actually stored
+	var want int64
+	for k, v := range testValues(kv) {
+		want += entrySize(k, v)
+	}
+	if got := kv.Bytes(); got != want {
+		t.Errorf("expected %d bytes but got %d", want, got)
+	}
+	if got := kv.stats.sets.Load(); got != workers*keys {
+		t.Errorf("expected %d sets but got %d", workers*keys, got)
+	}
+}
+
+func BenchmarkKeyValueStore_ParallelSet(b *testing.B) {
+	keys := make([]Key, 1024)
+	for i := range keys {
+		keys[i] = Key(fmt.Sprintf("benchmark-key-%d", i))
+	}
+
+	for _, shards := range []int{1, defaultShards} {
+		b.Run(fmt.Sprintf("%d shards", shards), func(b *testing.B) {
+			kv := newKeyValueStore(StoreOptions{}, shards)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					s := kv.shard(key)
+					s.Lock()
+					s.put(key, "benchmark-value")
+					s.Unlock()
+					i++
+				}
+			})
+		})
+	}
+}
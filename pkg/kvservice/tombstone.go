@@ -0,0 +1,149 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// --soft-delete 24h
+// curl -X DELETE http://localhost:8080/kv/key1
+// curl -H 'Content-Type: application/json' -d '{"key":"key1"}' http://localhost:8080/undelete
+
+// maxPurgeInterval bounds how long an expired tombstone may outlive its window
+const maxPurgeInterval = time.Minute
+
+var (
+	errKeyExists   = errors.New("key already exists")
+	errNoTombstone = errors.New("no tombstone for the key")
+)
+
+// tombstone is a deleted entry together with the time it was deleted
+type tombstone struct {
+	entry
+	deletedAt time.Time
+}
+
+type UndeleteRequest struct {
+	Key       Key    `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// bury keeps the removed entry as a tombstone if soft delete is enabled
+func (s *shard) bury(key Key, e entry, deletedAt time.Time) {
+	if s.kv.options.SoftDelete <= 0 {
+		return
+	}
+	if s.tombstones == nil {
+		s.tombstones = make(map[Key]tombstone)
+	}
+	e.elem, e.history = nil, nil
+	s.tombstones[key] = tombstone{entry: e, deletedAt: deletedAt}
+}
+
+// expired reports whether the tombstone is older than the soft delete window at now
+func (s *shard) expired(t tombstone, now time.Time) bool {
+	return now.Sub(t.deletedAt) >= s.kv.options.SoftDelete
+}
+
+// undelete restores the tombstoned entry of the key
+// it fails with errKeyExists if the key is live and errNoTombstone if there is no tombstone within the window
+func (s *shard) undelete(key Key) (entry, error) {
+	if _, exists := s.kvMap[key]; exists {
+		return entry{}, errKeyExists
+	}
+	t, ok := s.tombstones[key]
+	if !ok || s.expired(t, s.kv.now()) {
+		return entry{}, errNoTombstone
+	}
+	return s.writeEntry(key, entry{value: t.value, rawLen: t.rawLen, kind: t.kind, items: t.items, fields: t.fields})
+}
+
+// PurgeTombstones removes the tombstones of the namespace that are older than the soft delete window and returns how many were removed
+func (kv *KeyValueStore) PurgeTombstones() int {
+	now := kv.now()
+	n := 0
+	for _, s := range kv.shards {
+		s.Lock()
+		for key, t := range s.tombstones {
+			if s.expired(t, now) {
+				delete(s.tombstones, key)
+				n++
+			}
+		}
+		s.Unlock()
+	}
+	if n > 0 {
+		kv.root.changes.Add(1)
+	}
+	return n
+}
+
+// Tombstones returns the number of deleted keys that can still be undeleted or are waiting to be purged
+func (kv *KeyValueStore) Tombstones() int {
+	n := 0
+	for _, s := range kv.shards {
+		s.RLock()
+		n += len(s.tombstones)
+		s.RUnlock()
+	}
+	return n
+}
+
+// runPurge purges the expired tombstones of all namespaces periodically until ctx is done
+// the interval follows the window so short windows are honored, but it never exceeds maxPurgeInterval
+func (kv *KeyValueStore) runPurge(ctx context.Context) {
+	interval := min(kv.options.SoftDelete, maxPurgeInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, name := range kv.Namespaces() {
+				kv.Namespace(name).PurgeTombstones()
+			}
+		}
+	}
+}
+
+// UndeleteHandler restores a deleted key from its tombstone within the soft delete window
+// it answers 404 if the key has no tombstone, e.g. because soft delete is disabled or the window passed, and 409 if the key exists again
+func (kv *KeyValueStore) UndeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload UndeleteRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+
+	s := kv.shard(payload.Key)
+	s.Lock()
+	e, err := s.undelete(payload.Key)
+	s.Unlock()
+	if err == nil {
+		err = kv.syncLog()
+	}
+	if errors.Is(err, errKeyExists) {
+		http.Error(w, "Key already exists", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errNoTombstone) {
+		http.Error(w, "Deleted key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	kv.audit(r, "undelete", payload.Key, e.valueLen(), 0)
+
+	json.NewEncoder(w).Encode(GetResponse{Value: e.plain()})
+}
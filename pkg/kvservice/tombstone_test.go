@@ -0,0 +1,134 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSoftDeleteStore returns a store keeping tombstones for an hour and a function advancing its clock
+func newSoftDeleteStore() (*KeyValueStore, func(time.Duration)) {
+	kv := NewKeyValueStore(StoreOptions{SoftDelete: time.Hour})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	kv.clock = func() time.Time { return now }
+	return kv, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestKeyValueStore_SoftDelete(t *testing.T) {
+	kv, advance := newSoftDeleteStore()
+	env := Config{}
+	handler := env.routes(kv)
+	serveNamespace(handler, "", http.MethodPut, "/kv/key", "value")
+
+	if w := serveNamespace(handler, "", http.MethodDelete, "/kv/key", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected status %v but got %v", http.StatusNoContent, w.Code)
+	}
+	if w := serveNamespace(handler, "", http.MethodPost, "/get", `{"key":"key"}`); w.Code != http.StatusNotFound {
+		t.Errorf("get of a deleted key: expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+	if kv.Len() != 0 || kv.Tombstones() != 1 {
+		t.Errorf("expected a tombstone instead of a key but got %d keys and %d tombstones", kv.Len(), kv.Tombstones())
+	}
+
+	advance(30 * time.Minute)
+	w := serveNamespace(handler, "", http.MethodPost, "/undelete", `{"key":"key"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "value") {
+		t.Fatalf("undelete: expected status %v but got %v %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if got := testValues(kv)["key"]; got != "value" {
+		t.Errorf("expected the undeleted value but got %q", got)
+	}
+	if w := serveNamespace(handler, "", http.MethodPost, "/undelete", `{"key":"key"}`); w.Code != http.StatusConflict {
+		t.Errorf("undelete of a live key: expected status %v but got %v", http.StatusConflict, w.Code)
+	}
+}
+
+func TestKeyValueStore_PurgeTombstones(t *testing.T) {
+	kv, advance := newSoftDeleteStore()
+	env := Config{}
+	handler := env.routes(kv)
+	for _, key := range []string{"old", "new"} {
+		serveNamespace(handler, "", http.MethodPut, "/kv/"+key, "value")
+	}
+
+	serveNamespace(handler, "", http.MethodDelete, "/kv/old", "")
+	advance(45 * time.Minute)
+	serveNamespace(handler, "", http.MethodDelete, "/kv/new", "")
+	advance(15 * time.Minute)
+
+	if n := kv.PurgeTombstones(); n != 1 {
+		t.Errorf("expected 1 purged tombstone but got %d", n)
+	}
+	if w := serveNamespace(handler, "", http.MethodPost, "/undelete", `{"key":"old"}`); w.Code != http.StatusNotFound {
+		t.Errorf("undelete after the window: expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+	if w := serveNamespace(handler, "", http.MethodPost, "/undelete", `{"key":"new"}`); w.Code != http.StatusOK {
+		t.Errorf("undelete within the window: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestKeyValueStore_SoftDelete_SetClearsTombstone(t *testing.T) {
+	kv, advance := newSoftDeleteStore()
+	env := Config{}
+	handler := env.routes(kv)
+	serveNamespace(handler, "", http.MethodPut, "/kv/key", "old")
+	serveNamespace(handler, "", http.MethodDelete, "/kv/key", "")
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"key","value":"new"}`)
+
+	if kv.Tombstones() != 0 {
+		t.Errorf("expected the write to clear the tombstone but %d are left", kv.Tombstones())
+	}
+
+	// deleting the new value must not bring back the old one
+	serveNamespace(handler, "", http.MethodDelete, "/kv/key", "")
+	advance(time.Minute)
+	w := serveNamespace(handler, "", http.MethodPost, "/undelete", `{"key":"key"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "new") {
+		t.Errorf("expected the latest value to be undeleted but got %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestKeyValueStore_SoftDelete_Disabled(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	env := Config{}
+	handler := env.routes(kv)
+	serveNamespace(handler, "", http.MethodPut, "/kv/key", "value")
+	serveNamespace(handler, "", http.MethodDelete, "/kv/key", "")
+
+	if w := serveNamespace(handler, "", http.MethodPost, "/undelete", `{"key":"key"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %v but got %v", http.StatusNotFound, w.Code)
+	}
+	if kv.Tombstones() != 0 {
+		t.Errorf("expected no tombstones without soft delete but got %d", kv.Tombstones())
+	}
+}
+
+func TestKeyValueStore_DumpTombstones(t *testing.T) {
+	kv, _ := newSoftDeleteStore()
+	env := Config{}
+	handler := env.routes(kv)
+	for _, key := range []string{"live", "deleted"} {
+		serveNamespace(handler, "", http.MethodPut, "/kv/"+key, "value")
+	}
+	serveNamespace(handler, "", http.MethodDelete, "/kv/deleted", "")
+
+	if w := serveNamespace(handler, "", http.MethodGet, "/dump", ""); strings.Contains(w.Body.String(), "deleted") {
+		t.Errorf("expected the dump to omit tombstones by default but got %s", w.Body.String())
+	}
+	dump := serveNamespace(handler, "", http.MethodGet, "/dump?tombstones=true", "").Body.String()
+	if !strings.Contains(dump, `"deleted_at"`) {
+		t.Fatalf("expected a tombstone record but got %s", dump)
+	}
+
+	restored, _ := newSoftDeleteStore()
+	n, err := restored.readRecords(context.Background(), bytes.NewBufferString(dump), false)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 restored key but got %d (%v)", n, err)
+	}
+	if w := serveNamespace(env.routes(restored), "", http.MethodPost, "/undelete", `{"key":"deleted"}`); w.Code != http.StatusOK {
+		t.Errorf("undelete of a restored tombstone: expected status %v but got %v", http.StatusOK, w.Code)
+	}
+}
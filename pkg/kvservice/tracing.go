@@ -0,0 +1,52 @@
+package kvservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// --otel-endpoint http://localhost:4318
+
+// tracerName is the instrumentation scope of the spans created by the handlers
+const tracerName = "golang-web-service-template/pkg/kvservice"
+
+// newTracerProvider returns a provider exporting spans in batches to the OTLP/HTTP endpoint
+// the exporter connects lazily, so an unreachable collector does not fail the startup and only drops spans
+func newTracerProvider(endpoint, serviceName string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	), nil
+}
+
+// middlewareTracing turns every request into a span named after its method, nil disables tracing
+func middlewareTracing(tp trace.TracerProvider, next http.Handler) http.Handler {
+	if tp == nil {
+		return next
+	}
+	return otelhttp.NewHandler(next, "kvservice", otelhttp.WithTracerProvider(tp))
+}
+
+// startSpan starts a child span of the request span
+// untraced requests get a no-op span without touching the context, so the handlers pay nothing when tracing is off
+func startSpan(r *http.Request, name string) trace.Span {
+	parent := trace.SpanFromContext(r.Context())
+	if !parent.IsRecording() {
+		return noop.Span{}
+	}
+	_, span := parent.TracerProvider().Tracer(tracerName).Start(r.Context(), name)
+	return span
+}
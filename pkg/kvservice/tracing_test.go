@@ -0,0 +1,57 @@
+package kvservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestConfig_routes_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	env := Config{tracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))}
+	handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+	for path, body := range map[string]string{"/set": `{"key":"key","value":"value"}`, "/get": `{"key":"key"}`} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	}
+
+	spans := exporter.GetSpans()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	for _, want := range []string{"decode request", "store get"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("expected a %q span but got %v", want, names)
+		}
+	}
+
+	// the child spans belong to the trace of their request span
+	traces := map[string]bool{}
+	for _, span := range spans {
+		if !span.Parent.IsValid() {
+			traces[span.SpanContext.TraceID().String()] = true
+		}
+	}
+	for _, span := range spans {
+		if span.Parent.IsValid() && !traces[span.SpanContext.TraceID().String()] {
+			t.Errorf("span %q is not part of a request trace", span.Name)
+		}
+	}
+	if len(traces) != 2 {
+		t.Errorf("expected a trace per request but got %d", len(traces))
+	}
+}
+
+func TestStartSpan_Untraced(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if span := startSpan(r, "decode request"); span.IsRecording() {
+		t.Error("expected a no-op span for an untraced request")
+	}
+}
@@ -0,0 +1,251 @@
+package kvservice
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// curl -H 'Content-Type: application/json' -d '{"ops":[{"type":"check","key":"balance:a","expected_version":3},{"type":"set","key":"balance:a","value":"90"},{"type":"set","key":"balance:b","value":"110"}]}' http://localhost:8080/txn
+
+// maxTxnOps bounds the operations of a transaction, all their shards stay locked until it is applied
+const maxTxnOps = 100
+
+const (
+	TxnCheck  = "check"
+	TxnSet    = "set"
+	TxnDelete = "delete"
+)
+
+// TxnOp is an operation of a transaction
+// a check compares the version of the key with ExpectedVersion, 0 expects the key not to exist
+type TxnOp struct {
+	Type            string  `json:"type"`
+	Key             Key     `json:"key"`
+	Value           Value   `json:"value,omitempty"`
+	ExpectedVersion *uint64 `json:"expected_version,omitempty"`
+}
+
+type TxnRequest struct {
+	Ops       []TxnOp `json:"ops"`
+	Namespace string  `json:"namespace,omitempty"`
+}
+
+// TxnResult is the outcome of an operation of a committed transaction
+// Version is the version the key has after the operation, 0 if it does not exist
+type TxnResult struct {
+	Type    string `json:"type"`
+	Key     Key    `json:"key"`
+	Version uint64 `json:"version"`
+	// Deleted reports for a delete whether the key existed
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// TxnResponse is the body returned by the transaction endpoint
+// if the transaction was not committed FailedOp is the index of the operation that failed and Error says why
+type TxnResponse struct {
+	Committed bool        `json:"committed"`
+	Results   []TxnResult `json:"results,omitempty"`
+	FailedOp  *int        `json:"failed_op,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// txnError is the failure of the operation at index op, status is the status the transaction is answered with
+type txnError struct {
+	op     int
+	status int
+	err    error
+}
+
+// undo holds what an operation of a transaction replaced, so the transaction can be rolled back
+type undo struct {
+	s         *shard
+	key       Key
+	prev      entry
+	existed   bool
+	tombstone tombstone
+	buried    bool
+}
+
+// validateTxn checks the operations before any lock is taken
+func (kv *KeyValueStore) validateTxn(ops []TxnOp) *txnError {
+	for i, op := range ops {
+		switch op.Type {
+		case TxnCheck:
+			if op.ExpectedVersion == nil {
+				return &txnError{op: i, status: http.StatusBadRequest, err: errors.New("check requires expected_version")}
+			}
+		case TxnSet:
+			if err := kv.validateKey(op.Key); err != nil {
+				return &txnError{op: i, status: http.StatusBadRequest, err: err}
+			}
+			if err := kv.validateValue(op.Value); err != nil {
+				return &txnError{op: i, status: http.StatusUnprocessableEntity, err: err}
+			}
+		case TxnDelete:
+		default:
+			return &txnError{op: i, status: http.StatusBadRequest, err: fmt.Errorf("unknown operation type %q", op.Type)}
+		}
+	}
+	return nil
+}
+
+// lockKeys write locks the shards of all keys in index order, so callers locking overlapping shards can not deadlock
+// it returns the function releasing the locks
+func (kv *KeyValueStore) lockKeys(keys ...Key) func() {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, kv.shardIndex(key))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	for _, i := range indexes {
+		kv.shards[i].Lock()
+	}
+	return func() {
+		for _, i := range slices.Backward(indexes) {
+			kv.shards[i].Unlock()
+		}
+	}
+}
+
+// txn evaluates all checks and applies all mutations while holding the locks of every key involved
+// if a check fails or a write does not fit the applied operations are rolled back and nothing changes
+// keys evicted to make room for a write are the exception, they stay evicted when the transaction is rolled back
+func (kv *KeyValueStore) txn(ops []TxnOp) ([]TxnResult, *txnError) {
+	keys := make([]Key, 0, len(ops))
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+	}
+	unlock := kv.lockKeys(keys...)
+	defer unlock()
+
+	for i, op := range ops {
+		if op.Type != TxnCheck {
+			continue
+		}
+		var version uint64
+		if e, ok := kv.shard(op.Key).kvMap[op.Key]; ok {
+			version = e.version
+		}
+		if version != *op.ExpectedVersion {
+			return nil, &txnError{op: i, status: http.StatusConflict, err: fmt.Errorf("key %q has version %d, expected %d", op.Key, version, *op.ExpectedVersion)}
+		}
+	}
+
+	results := make([]TxnResult, 0, len(ops))
+	undos := make([]undo, 0, len(ops))
+	for i, op := range ops {
+		s := kv.shard(op.Key)
+		u := undo{s: s, key: op.Key}
+		u.prev, u.existed = s.kvMap[op.Key]
+		u.tombstone, u.buried = s.tombstones[op.Key]
+
+		result := TxnResult{Type: op.Type, Key: op.Key}
+		switch op.Type {
+		case TxnCheck:
+			result.Version = u.prev.version
+		case TxnSet:
+			e, err := s.put(op.Key, op.Value)
+			if err != nil {
+				return nil, kv.abort(undos, u, i, err)
+			}
+			result.Version = e.version
+		case TxnDelete:
+			var err error
+			if _, result.Deleted, err = s.remove(op.Key); err != nil {
+				return nil, kv.abort(undos, u, i, err)
+			}
+		}
+		undos = append(undos, u)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// abort rolls back the operations of a transaction after operation i failed with err and returns the error answering it
+// an operation that failed to be logged to the write-ahead log was applied, so it is rolled back with the others and answered with 503
+func (kv *KeyValueStore) abort(undos []undo, u undo, i int, err error) *txnError {
+	status := http.StatusInsufficientStorage
+	if errors.Is(err, ErrWriteAheadLog) {
+		undos = append(undos, u)
+		status = http.StatusServiceUnavailable
+	}
+	kv.rollback(undos)
+	return &txnError{op: i, status: status, err: err}
+}
+
+// rollback restores the entries and tombstones replaced by a transaction, newest first, with their original versions
+func (kv *KeyValueStore) rollback(undos []undo) {
+	for _, u := range slices.Backward(undos) {
+		s := u.s
+		s.unlink(u.key)
+		delete(s.tombstones, u.key)
+		if u.buried {
+			s.tombstones[u.key] = u.tombstone
+		}
+		if !u.existed {
+			continue
+		}
+		e := u.prev
+		e.elem = nil
+		if s.lru != nil {
+			e.elem = s.lru.PushFront(u.key)
+		}
+		s.kvMap[u.key] = e
+		s.bytes += e.size(u.key)
+		s.rawBytes += e.rawSize(u.key)
+		s.kv.quota.restore(1, e.size(u.key))
+		s.kv.root.usage.restore(1, e.size(u.key))
+		// the receivers were told about the write that is undone, so they are told about the restored entry as well
+		s.notify(u.key, WebhookEventSet, e.version)
+	}
+}
+
+// TxnHandler applies a list of operations atomically
+// it answers 200 with the result of every operation, or 409 if a check failed and 507 if a write did not fit, in which case nothing was applied
+// a 503 for a transaction that could not be synced to the write-ahead log leaves it applied but unacknowledged
+func (kv *KeyValueStore) TxnHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var payload TxnRequest
+	if !decodeRequest(w, r, &payload) {
+		return
+	}
+	kv, ok := kv.requestNamespace(w, r, payload.Namespace)
+	if !ok {
+		return
+	}
+	if len(payload.Ops) == 0 || len(payload.Ops) > maxTxnOps {
+		http.Error(w, fmt.Sprintf("A transaction must have between 1 and %d ops", maxTxnOps), http.StatusBadRequest)
+		return
+	}
+
+	txnErr := kv.validateTxn(payload.Ops)
+	var results []TxnResult
+	if txnErr == nil {
+		results, txnErr = kv.txn(payload.Ops)
+	}
+	if txnErr != nil {
+		w.WriteHeader(txnErr.status)
+		writeJSON(w, kv.root.jsonCase, TxnResponse{FailedOp: &txnErr.op, Error: txnErr.err.Error()})
+		return
+	}
+
+	for i, op := range payload.Ops {
+		switch {
+		case op.Type == TxnSet:
+			kv.audit(r, "set", op.Key, len(op.Value), 0)
+		case op.Type == TxnDelete && results[i].Deleted:
+			kv.audit(r, "delete", op.Key, 0, 0)
+		}
+	}
+	// the transaction is applied, a sync of the write-ahead log that fails only leaves it unacknowledged
+	if err := kv.syncLog(); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, kv.root.jsonCase, TxnResponse{Committed: true, Results: results})
+}
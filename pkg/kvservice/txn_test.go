@@ -0,0 +1,155 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// serveTxn sends the transaction to the handler and decodes the response
+func serveTxn(t *testing.T, handler http.Handler, body string) (int, TxnResponse) {
+	t.Helper()
+	w := serveNamespace(handler, "", http.MethodPost, "/txn", body)
+	var response TxnResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("malformed response %v: %v", w.Code, err)
+	}
+	return w.Code, response
+}
+
+func TestKeyValueStore_TxnHandler(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"a": "1", "b": "2"})
+	env := Config{}
+	handler := env.routes(kv)
+	a, _ := kv.peek("a")
+
+	code, response := serveTxn(t, handler, `{"ops":[
+		{"type":"check","key":"a","expected_version":`+strconv.FormatUint(a.version, 10)+`},
+		{"type":"check","key":"c","expected_version":0},
+		{"type":"set","key":"a","value":"10"},
+		{"type":"set","key":"c","value":"30"},
+		{"type":"delete","key":"b"}
+	]}`)
+	if code != http.StatusOK || !response.Committed || len(response.Results) != 5 {
+		t.Fatalf("expected a committed transaction but got %v %+v", code, response)
+	}
+	if response.Results[0].Version != a.version || response.Results[2].Version <= a.version || !response.Results[4].Deleted {
+		t.Errorf("expected the versions and deletions of every op but got %+v", response.Results)
+	}
+	if e, _ := kv.peek("c"); response.Results[3].Version != e.version {
+		t.Errorf("expected the new version %d of c but got %d", e.version, response.Results[3].Version)
+	}
+
+	want := map[Key]Value{"a": "10", "c": "30"}
+	if got := testValues(kv); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v but got %v", want, got)
+	}
+}
+
+func TestKeyValueStore_TxnHandler_FailedCheck(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"a": "1", "b": "2"})
+	env := Config{}
+	handler := env.routes(kv)
+	before, _ := kv.peek("a")
+
+	code, response := serveTxn(t, handler, `{"ops":[
+		{"type":"set","key":"a","value":"10"},
+		{"type":"delete","key":"b"},
+		{"type":"check","key":"b","expected_version":999}
+	]}`)
+	if code != http.StatusConflict || response.Committed || response.FailedOp == nil || *response.FailedOp != 2 {
+		t.Fatalf("expected op 2 to fail with status %v but got %v %+v", http.StatusConflict, code, response)
+	}
+
+	want := map[Key]Value{"a": "1", "b": "2"}
+	if got := testValues(kv); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the store to be untouched %v but got %v", want, got)
+	}
+	if after, _ := kv.peek("a"); after.version != before.version {
+		t.Errorf("expected version %d to be kept but got %d", before.version, after.version)
+	}
+}
+
+func TestKeyValueStore_TxnHandler_RollbackStoreFull(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxBytes: 8, Eviction: EvictionReject})
+	writeTestValue(kv, "a", "1")
+	writeTestValue(kv, "b", "2")
+	before, _ := kv.peek("a")
+	env := Config{}
+
+	code, response := serveTxn(t, env.routes(kv), `{"ops":[
+		{"type":"set","key":"a","value":"11"},
+		{"type":"delete","key":"b"},
+		{"type":"set","key":"c","value":"too large"}
+	]}`)
+	if code != http.StatusInsufficientStorage || response.FailedOp == nil || *response.FailedOp != 2 {
+		t.Fatalf("expected op 2 to fail with status %v but got %v %+v", http.StatusInsufficientStorage, code, response)
+	}
+
+	want := map[Key]Value{"a": "1", "b": "2"}
+	if got := testValues(kv); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the store to be rolled back to %v but got %v", want, got)
+	}
+	if after, _ := kv.peek("a"); after.version != before.version || kv.Bytes() != 4 {
+		t.Errorf("expected version %d and 4 bytes but got %d and %d", before.version, after.version, kv.Bytes())
+	}
+}
+
+func TestKeyValueStore_TxnHandler_Invalid(t *testing.T) {
+	env := Config{}
+	handler := env.routes(NewKeyValueStore(StoreOptions{}))
+
+	for _, body := range []string{
+		`{"ops":[]}`,
+		`{"ops":[{"type":"increment","key":"a"}]}`,
+		`{"ops":[{"type":"check","key":"a"}]}`,
+	} {
+		if w := serveNamespace(handler, "", http.MethodPost, "/txn", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %v but got %v", body, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestKeyValueStore_TxnHandler_Concurrent(t *testing.T) {
+	kv := newTestStore(map[Key]Value{"a": "0", "b": "0"})
+	env := Config{}
+	handler := env.routes(kv)
+	a, _ := kv.peek("a")
+
+	// every transaction expects the same version of a, so only the first one to lock it can commit
+	const n = 20
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value := strconv.Itoa(i)
+			w := serveNamespace(handler, "", http.MethodPost, "/txn", `{"ops":[
+				{"type":"check","key":"a","expected_version":`+strconv.FormatUint(a.version, 10)+`},
+				{"type":"set","key":"b","value":"`+value+`"},
+				{"type":"set","key":"a","value":"`+value+`"}
+			]}`)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+
+	committed := 0
+	for _, code := range codes {
+		if code == http.StatusOK {
+			committed++
+		} else if code != http.StatusConflict {
+			t.Errorf("expected status %v or %v but got %v", http.StatusOK, http.StatusConflict, code)
+		}
+	}
+	if committed != 1 {
+		t.Errorf("expected exactly one committed transaction but got %d", committed)
+	}
+	if values := testValues(kv); values["a"] != values["b"] {
+		t.Errorf("expected a and b to be written by the same transaction but got %v", values)
+	}
+}
@@ -0,0 +1,473 @@
+package kvservice
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// go run . --snapshot-file data/snapshot.jsonl --fsync always
+// {"namespace":"team-a","key":"key1","value":"value1","modified_at":"2024-05-01T12:00:00Z","version":3}
+// {"key":"key2","value":"","deleted":true}
+
+// FsyncMode is when the write-ahead log is flushed to disk, see Snapshotter.SetFsync
+type FsyncMode string
+
+const (
+	// FsyncAlways syncs every write before it is acknowledged, an acknowledged write survives a crash of the machine
+	FsyncAlways FsyncMode = "always"
+	// FsyncInterval syncs once per walSyncInterval, a crash of the machine loses the writes of the last interval
+	FsyncInterval FsyncMode = "interval"
+	// FsyncNever leaves syncing to the OS, a crash of the machine loses what the OS did not write back yet
+	FsyncNever FsyncMode = "never"
+)
+
+// encryptedWALHeader is the plaintext of the first record of an encrypted write-ahead log, like encryptedSnapshotHeader for the snapshot
+const encryptedWALHeader = "kvservice encrypted write-ahead log v1"
+
+// walSyncInterval is how often the write-ahead log is synced in FsyncInterval mode
+const walSyncInterval = time.Second
+
+// walRecord is a single line of the write-ahead log, it holds the state of the key after the change
+type walRecord struct {
+	snapshotRecord
+	// Deleted marks the deletion of the key, with DeletedAt the record holds the tombstone kept of it
+	Deleted bool `json:"deleted,omitempty"`
+	// Flushed marks the flush of the namespace, the record has no key
+	Flushed bool `json:"flushed,omitempty"`
+}
+
+// writeAheadLog appends every change of the store to a file as newline delimited JSON, so the changes since the last snapshot survive a crash
+// each record is written to the file as it is appended, the mode only decides when the file is synced
+// so even without syncing a crash of the process loses nothing that was acknowledged
+// with a key every line is sealed like a record of an encrypted snapshot, the index of the line in the file is its additional data
+// a snapshot moves the log aside before it starts and removes it once it is written, replaying the moved log and the current one
+// on top of the snapshot restores the latest state, as every key ends up with the state of its last record
+type writeAheadLog struct {
+	path string
+	mode FsyncMode
+	// aead encrypts the records, nil writes them as plaintext
+	aead cipher.AEAD
+	// syncMu serializes the syncs so a sync covers all records written before it, it is taken before mu
+	syncMu sync.Mutex
+	// synced and syncedSize are the number of records and bytes of the file known to be on disk, they are guarded by syncMu
+	synced     uint64
+	syncedSize int64
+	// mu guards the file, the number of records and bytes written to it and the index of the next line of the file
+	mu      sync.Mutex
+	f       *os.File
+	written uint64
+	size    int64
+	lines   uint64
+	stop    chan struct{}
+	done    chan struct{}
+	// failure is the error of the last write or sync, nil once a later one succeeded
+	failure atomic.Pointer[error]
+}
+
+// openWriteAheadLog opens the log at path for appending and starts syncing it periodically in FsyncInterval mode
+// an incomplete last line left by a crash is cut off first, so the next record starts on a line of its own
+// with aead the records are encrypted, a new file starts with the header record
+func openWriteAheadLog(path string, mode FsyncMode, aead cipher.AEAD) (*writeAheadLog, error) {
+	lines, size, err := completeLines(path)
+	if err != nil {
+		return nil, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() > size {
+		slog.Warn("cut off the incomplete last record of the write-ahead log", "file", path, "line", lines+1)
+		err = f.Truncate(size)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	l := &writeAheadLog{
+		path:       path,
+		mode:       mode,
+		aead:       aead,
+		f:          f,
+		size:       size,
+		syncedSize: size,
+		lines:      lines,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if err := l.writeHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	if mode == FsyncInterval {
+		go l.run()
+	} else {
+		close(l.done)
+	}
+	return l, nil
+}
+
+// completeLines returns the number of complete lines of the file at path and the size they take up, a missing file has none
+func completeLines(path string) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var lines uint64
+	var size, line int64
+	r := bufio.NewReader(f)
+	for {
+		data, err := r.ReadSlice('\n')
+		line += int64(len(data))
+		switch err {
+		case nil:
+			lines++
+			size += line
+			line = 0
+		case bufio.ErrBufferFull:
+			// only a part of a long line, the rest follows
+		case io.EOF:
+			return lines, size, nil
+		default:
+			return 0, 0, err
+		}
+	}
+}
+
+// writeHeader starts an empty encrypted log with the header record, the caller holds mu or has the log to itself
+func (l *writeAheadLog) writeHeader() error {
+	if l.aead == nil || l.lines > 0 {
+		return nil
+	}
+	n, err := l.f.Write(sealRecord(l.aead, []byte(encryptedWALHeader), 0))
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	l.lines++
+	return nil
+}
+
+func (l *writeAheadLog) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(walSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			err := l.sync()
+			if err != nil {
+				slog.Error("failed to sync write-ahead log", "error", err)
+			}
+			l.setFailure(err)
+		}
+	}
+}
+
+// ErrWriteAheadLog is returned for a change that was applied in memory but could not be written to the write-ahead log
+// the change is not acknowledged, it is still part of the next snapshot
+var ErrWriteAheadLog = errors.New("write-ahead log failed")
+
+// writeApplied reports whether a write that returned err changed the store, which is the case if only logging it failed
+func writeApplied(err error) bool {
+	return err == nil || errors.Is(err, ErrWriteAheadLog)
+}
+
+// append writes the record to the log, a failed write is returned in every mode since the record is lost
+// the caller holds the lock of the shard, so in FsyncAlways mode the record is synced by commit once the lock is released
+// in FsyncInterval mode the periodic sync reports its failures through the health check
+func (l *writeAheadLog) append(record walRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("%w: encode record: %w", ErrWriteAheadLog, err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	if l.aead != nil {
+		line = sealRecord(l.aead, line[:len(line)-1], l.lines)
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	l.lines++
+	l.written++
+	l.mu.Unlock()
+	if err != nil {
+		slog.Error("failed to write write-ahead log", "error", err, "key", record.Key)
+		l.setFailure(err)
+		return fmt.Errorf("%w: %w", ErrWriteAheadLog, err)
+	}
+	if l.mode == FsyncNever {
+		l.setFailure(nil)
+	}
+	return nil
+}
+
+// commit returns once every record written so far is synced in FsyncAlways mode, in the other modes it returns right away
+// a sync takes milliseconds, so it runs without the lock of a shard and writers committing at once share it, see syncTo
+func (l *writeAheadLog) commit() error {
+	if l == nil || l.mode != FsyncAlways {
+		return nil
+	}
+	l.mu.Lock()
+	written := l.written
+	l.mu.Unlock()
+	err := l.syncTo(written)
+	l.setFailure(err)
+	if err != nil {
+		slog.Error("failed to sync write-ahead log", "error", err)
+		return fmt.Errorf("%w: sync: %w", ErrWriteAheadLog, err)
+	}
+	return nil
+}
+
+// syncLog returns once the changes of the store logged so far survive a crash of the machine in FsyncAlways mode
+// writers call it after releasing the locks of the shards and before acknowledging the write, an error is an ErrWriteAheadLog
+func (kv *KeyValueStore) syncLog() error {
+	return kv.root.wal.commit()
+}
+
+// setFailure records the result of a write or sync, in FsyncInterval mode only the syncs clear a failure
+func (l *writeAheadLog) setFailure(err error) {
+	if err == nil {
+		l.failure.Store(nil)
+		return
+	}
+	l.failure.Store(&err)
+}
+
+// check is the health check of the log, it fails while the last write or sync failed
+func (l *writeAheadLog) check(context.Context) error {
+	if err := l.failure.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// syncTo syncs the log unless the first n records are already synced
+// concurrent writers share a sync: while one syncs the others queue up their records, and the next sync covers them all
+func (l *writeAheadLog) syncTo(n uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if l.synced >= n {
+		return nil
+	}
+	return l.syncLocked()
+}
+
+// sync syncs all records written so far
+func (l *writeAheadLog) sync() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	return l.syncLocked()
+}
+
+// syncLocked syncs the file, the caller must hold syncMu
+func (l *writeAheadLog) syncLocked() error {
+	l.mu.Lock()
+	f, written, size := l.f, l.written, l.size
+	l.mu.Unlock()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	l.synced, l.syncedSize = written, size
+	return nil
+}
+
+// rotatedPath is where rotate moves the log while a snapshot is written
+func (l *writeAheadLog) rotatedPath() string {
+	return l.path + ".old"
+}
+
+// rotate moves the log aside and continues in a new file, the records of the moved log are all older than the snapshot about to be written
+// a moved log left behind by a failed snapshot is kept, the current log then continues it until a snapshot succeeds
+func (l *writeAheadLog) rotate() error {
+	if _, err := os.Stat(l.rotatedPath()); err == nil {
+		return nil
+	}
+
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("sync write-ahead log: %w", err)
+	}
+	if err := os.Rename(l.path, l.rotatedPath()); err != nil {
+		return fmt.Errorf("rotate write-ahead log: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open write-ahead log: %w", err)
+	}
+	l.f.Close()
+	l.f, l.size, l.lines = f, 0, 0
+	l.synced, l.syncedSize = l.written, 0
+	if err := l.writeHeader(); err != nil {
+		return fmt.Errorf("write write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// removeRotated removes the moved log once the snapshot holding its records is written
+func (l *writeAheadLog) removeRotated() error {
+	if err := os.Remove(l.rotatedPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Close stops the periodic sync, syncs the log a last time and closes it, no record may be appended afterwards
+func (l *writeAheadLog) Close() error {
+	close(l.stop)
+	<-l.done
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if err := l.syncLocked(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// replay applies the moved log and then the current one to the store and returns the number of records applied
+func (l *writeAheadLog) replay(ctx context.Context, kv *KeyValueStore) (int, error) {
+	total := 0
+	for _, path := range []string{l.rotatedPath(), l.path} {
+		n, err := replayFile(ctx, kv, path, l.aead)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// replayFile applies the records of the log at path to the store, a missing file is not an error
+// a last line without a newline is a record the crash cut off while it was written, it was never acknowledged and is skipped
+// with aead the first line must be the header record and every line is opened with its index
+func replayFile(ctx context.Context, kv *KeyValueStore, path string, aead cipher.AEAD) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	defer f.Close()
+
+	n := 0
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(data)) > 0 {
+				slog.Warn("skipped the incomplete last record of the write-ahead log", "file", path, "line", line)
+			}
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("read write-ahead log: %w", err)
+		}
+		if aead != nil {
+			data, err = openRecord(aead, data, uint64(line-1))
+			if err == nil && line == 1 && string(data) != encryptedWALHeader {
+				err = errSnapshotAuthentication
+			}
+			if err != nil {
+				return n, fmt.Errorf("write-ahead log %s line %d: %w", path, line, err)
+			}
+			if line == 1 {
+				continue
+			}
+		}
+		var record walRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return n, fmt.Errorf("write-ahead log %s line %d: %w", path, line, err)
+		}
+		if err := kv.applyWALRecord(record); err != nil {
+			return n, fmt.Errorf("write-ahead log %s line %d: %w", path, line, err)
+		}
+		n++
+	}
+}
+
+// applyWALRecord applies a single record of the write-ahead log to the store
+func (kv *KeyValueStore) applyWALRecord(record walRecord) error {
+	target := kv
+	if record.Namespace != "" {
+		if err := validateNamespace(record.Namespace); err != nil {
+			return err
+		}
+		target = kv.Namespace(record.Namespace)
+	}
+	if record.Flushed {
+		target.flush()
+		return nil
+	}
+	sh := target.shard(record.Key)
+	sh.Lock()
+	defer sh.Unlock()
+	if record.Deleted {
+		sh.unlink(record.Key)
+		if record.DeletedAt != nil {
+			sh.bury(record.Key, record.entry(target.revision.Add(1)), *record.DeletedAt)
+		}
+		return nil
+	}
+	if _, err := sh.writeEntry(record.Key, record.entry(record.Version)); err != nil {
+		return fmt.Errorf("restore key %q: %w", record.Key, err)
+	}
+	return nil
+}
+
+// logChange appends the change of the key to the write-ahead log if there is one and returns the error of the append, the caller holds the lock of the shard
+// a flush is logged with an empty key, a deletion with the key only or with its tombstone and a set with the entry the key holds now
+func (s *shard) logChange(key Key, event string) error {
+	wal := s.kv.root.wal
+	if wal == nil {
+		return nil
+	}
+	record := walRecord{}
+	record.Namespace, record.Key = s.kv.name, key
+	if s.kv.name == DefaultNamespace {
+		record.Namespace = ""
+	}
+	switch event {
+	case ChangeEventFlush:
+		record.Flushed = true
+	case WebhookEventDelete:
+		record.Deleted = true
+		// with soft delete the removed entry is kept as a tombstone, the record carries it so /undelete works after a crash
+		if t, ok := s.tombstones[key]; ok {
+			record.Value, record.List, record.Hash, record.ModifiedAt = t.plain(), t.items, t.fields, t.modified
+			record.DeletedAt = &t.deletedAt
+		}
+	default:
+		e := s.kvMap[key]
+		record.Value, record.List, record.Hash, record.ModifiedAt, record.Version = e.plain(), e.items, e.fields, e.modified, e.version
+	}
+	return wal.append(record)
+}
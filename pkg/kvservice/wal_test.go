@@ -0,0 +1,338 @@
+package kvservice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotter_WriteAheadLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(path, kv)
+	if err := snapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	handler := (&Config{}).routes(kv)
+
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"a","value":"1"}`)
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"b","value":"1"}`)
+	if err := snapshotter.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".wal.old"); !os.IsNotExist(err) {
+		t.Errorf("expected the moved log to be removed after the snapshot but got %v", err)
+	}
+
+	// the changes after the snapshot are only in the log
+	for _, req := range []struct{ namespace, method, path, body string }{
+		{"", http.MethodPost, "/set", `{"key":"b","value":"2"}`},
+		{"", http.MethodDelete, "/kv/a", ""},
+		{"", http.MethodPost, "/hset", `{"key":"user:1","field":"name","value":"Ada"}`},
+		{"", http.MethodPost, "/list/push", `{"key":"queue","value":"job"}`},
+		{"team-a", http.MethodPost, "/set", `{"key":"gone","value":"1"}`},
+	} {
+		if w := serveNamespace(handler, req.namespace, req.method, req.path, req.body); w.Code >= 300 {
+			t.Fatalf("%s %s: unexpected status %v %s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+	kv.Namespace("team-a").flush()
+	serveNamespace(handler, "team-a", http.MethodPost, "/set", `{"key":"kept","value":"1"}`)
+	// the process ends without a final snapshot
+	if err := snapshotter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewKeyValueStore(StoreOptions{})
+	restoredSnapshotter := NewSnapshotter(path, restored)
+	if err := restoredSnapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	defer restoredSnapshotter.Close()
+	if n, err := restoredSnapshotter.Load(context.Background()); err != nil || n != 2 {
+		t.Fatalf("expected the 2 keys of the snapshot but got %d, %v", n, err)
+	}
+
+	if keys := restored.Keys(); len(keys) != 3 {
+		t.Errorf("expected 3 keys but got %v", keys)
+	}
+	if e, ok := restored.peek("b"); !ok || e.plain() != "2" {
+		t.Errorf("expected the logged value of b but got %q", e.plain())
+	}
+	if _, ok := restored.peek("a"); ok {
+		t.Error("expected the logged deletion of a")
+	}
+	if e, _ := restored.peek("user:1"); !reflect.DeepEqual(e.fields, map[string]Value{"name": "Ada"}) {
+		t.Errorf("expected the logged hash but got %v", e.fields)
+	}
+	if e, _ := restored.peek("queue"); !reflect.DeepEqual(e.items, []Value{"job"}) {
+		t.Errorf("expected the logged list but got %v", e.items)
+	}
+	if keys := restored.Namespace("team-a").Keys(); !reflect.DeepEqual(keys, []Key{"kept"}) {
+		t.Errorf("expected only the key set after the flush but got %v", keys)
+	}
+}
+
+// crashCopy copies what a crash of the machine leaves of the log to path: the part that was synced,
+// followed by the first bytes of a record whose write the crash interrupted
+func crashCopy(t *testing.T, l *writeAheadLog, path string) {
+	t.Helper()
+	l.syncMu.Lock()
+	synced := l.syncedSize
+	l.syncMu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data := make([]byte, synced)
+	if _, err := io.ReadFull(f, data); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, `{"key":"torn","val`...)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteAheadLog_AlwaysSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(filepath.Join(dir, "snapshot.jsonl"), kv)
+	if err := snapshotter.SetFsync(FsyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	defer snapshotter.Close()
+	handler := (&Config{}).routes(kv)
+
+	// the machine crashes while the writers are in the middle of their batches
+	crashed := filepath.Join(dir, "crashed.jsonl")
+	var mu sync.Mutex
+	acknowledged := make(map[Key]Value)
+	var beforeCrash map[Key]Value
+	var wg sync.WaitGroup
+	for worker := range 10 {
+		wg.Go(func() {
+			for i := range 50 {
+				key, value := Key(fmt.Sprintf("worker-%d-%d", worker, i)), Value(fmt.Sprint(i))
+				w := serveNamespace(handler, "", http.MethodPost, "/set", fmt.Sprintf(`{"key":%q,"value":%q}`, key, value))
+				if w.Code != http.StatusOK {
+					t.Errorf("expected status %v but got %v %s", http.StatusOK, w.Code, w.Body.String())
+					return
+				}
+				mu.Lock()
+				acknowledged[key] = value
+				if worker == 0 && i == 25 {
+					crashCopy(t, kv.root.wal, crashed+".wal")
+					beforeCrash = maps.Clone(acknowledged)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	restored := NewKeyValueStore(StoreOptions{})
+	restoredSnapshotter := NewSnapshotter(crashed, restored)
+	if err := restoredSnapshotter.SetFsync(FsyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	defer restoredSnapshotter.Close()
+	if _, err := restoredSnapshotter.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range beforeCrash {
+		if e, ok := restored.peek(key); !ok || e.plain() != want {
+			t.Errorf("%s: expected the acknowledged value %q to survive the crash but got %q", key, want, e.plain())
+		}
+	}
+	if _, ok := restored.peek("torn"); ok {
+		t.Error("expected the torn record to be skipped")
+	}
+}
+
+func TestWriteAheadLog_FailedWrite(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(filepath.Join(t.TempDir(), "snapshot.jsonl"), kv)
+	if err := snapshotter.SetFsync(FsyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	handler := (&Config{}).routes(kv)
+	writeTestValue(kv, "a", "1")
+	writeTestValue(kv, "x", "old")
+	// the log can no longer be written, like on a full or failed disk
+	kv.root.wal.f.Close()
+	defer snapshotter.Close()
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/set", `{"key":"b","value":"1"}`},
+		{http.MethodPut, "/kv/c", "1"},
+		{http.MethodDelete, "/kv/a", ""},
+		{http.MethodPost, "/list/push", `{"key":"queue","value":"job"}`},
+		{http.MethodPost, "/txn", `{"ops":[{"type":"set","key":"x","value":"new"}]}`},
+		{http.MethodPost, "/flush", ""},
+	} {
+		w := serveNamespace(handler, "", req.method, req.path, req.body)
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "write-ahead log failed") {
+			t.Errorf("%s %s: expected the write not to be acknowledged but got %v %s", req.method, req.path, w.Code, w.Body.String())
+		}
+		// a transaction is rolled back, including the write that failed to be logged
+		if e, _ := kv.peek("x"); req.path == "/txn" && e.plain() != "old" {
+			t.Errorf("expected the transaction to be rolled back but got %q", e.plain())
+		}
+	}
+	if err := kv.root.wal.check(context.Background()); err == nil {
+		t.Error("expected the health check to fail")
+	}
+}
+
+func TestWriteAheadLog_AlwaysSyncsOutsideTheShardLock(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	snapshotter := NewSnapshotter(filepath.Join(t.TempDir(), "snapshot.jsonl"), kv)
+	if err := snapshotter.SetFsync(FsyncAlways); err != nil {
+		t.Fatal(err)
+	}
+	defer snapshotter.Close()
+	handler := (&Config{}).routes(kv)
+	wal := kv.root.wal
+
+	// the sync of the write takes until the read of the key got through
+	wal.syncMu.Lock()
+	set := make(chan int, 1)
+	go func() {
+		set <- serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"a","value":"1"}`).Code
+	}()
+	for written := uint64(0); written == 0; runtime.Gosched() {
+		wal.mu.Lock()
+		written = wal.written
+		wal.mu.Unlock()
+	}
+	read := make(chan Value, 1)
+	go func() {
+		e, _ := kv.peek("a")
+		read <- e.plain()
+	}()
+	select {
+	case value := <-read:
+		if value != "1" {
+			t.Errorf("expected the written value but got %q", value)
+		}
+	case <-time.After(5 * time.Second):
+		wal.syncMu.Unlock()
+		t.Fatal("expected the shard to be unlocked while the write waits for the sync")
+	}
+	select {
+	case code := <-set:
+		t.Errorf("expected the write to wait for the sync but it was answered %v", code)
+	default:
+	}
+	wal.syncMu.Unlock()
+
+	if code := <-set; code != http.StatusOK {
+		t.Errorf("expected status %v but got %v", http.StatusOK, code)
+	}
+	wal.syncMu.Lock()
+	defer wal.syncMu.Unlock()
+	if wal.synced != 1 {
+		t.Errorf("expected the acknowledged write to be synced but %d records are", wal.synced)
+	}
+}
+
+func TestWriteAheadLog_Tombstones(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	kv, _ := newSoftDeleteStore()
+	snapshotter := NewSnapshotter(path, kv)
+	if err := snapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	handler := (&Config{}).routes(kv)
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/set", `{"key":"a","value":"1"}`},
+		{http.MethodPost, "/list/push", `{"key":"queue","value":"job"}`},
+		{http.MethodPost, "/set", `{"key":"b","value":"2"}`},
+		{http.MethodDelete, "/kv/a", ""},
+		{http.MethodPost, "/rename", `{"from":"queue","to":"jobs"}`},
+		{http.MethodDelete, "/kv/b", ""},
+		{http.MethodPost, "/undelete", `{"key":"b"}`},
+	} {
+		if w := serveNamespace(handler, "", req.method, req.path, req.body); w.Code >= 300 {
+			t.Fatalf("%s %s: unexpected status %v %s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+	if err := snapshotter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := newSoftDeleteStore()
+	restoredSnapshotter := NewSnapshotter(path, restored)
+	if err := restoredSnapshotter.SetFsync(FsyncNever); err != nil {
+		t.Fatal(err)
+	}
+	defer restoredSnapshotter.Close()
+	if _, err := restoredSnapshotter.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := restored.Tombstones(); n != 2 {
+		t.Errorf("expected the logged tombstones of a and queue but got %d", n)
+	}
+	restoredHandler := (&Config{}).routes(restored)
+	if w := serveNamespace(restoredHandler, "", http.MethodPost, "/undelete", `{"key":"a"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"1"`) {
+		t.Errorf("expected the deleted key to be undeleted after a restart but got %v %s", w.Code, w.Body.String())
+	}
+	if w := serveNamespace(restoredHandler, "", http.MethodPost, "/undelete", `{"key":"queue"}`); w.Code != http.StatusOK {
+		t.Errorf("expected the renamed key to be undeleted after a restart but got %v %s", w.Code, w.Body.String())
+	}
+	if e, _ := restored.peek("queue"); !reflect.DeepEqual(e.items, []Value{"job"}) {
+		t.Errorf("expected the list to be undeleted but got %v", e.items)
+	}
+	if e, ok := restored.peek("b"); !ok || e.plain() != "2" {
+		t.Errorf("expected the undeleted key to be live but got %q", e.plain())
+	}
+}
+
+// BenchmarkSetHandler_Fsync compares the write throughput of the fsync modes under 100 concurrent writers
+// none writes no log, like persistence with snapshots only
+func BenchmarkSetHandler_Fsync(b *testing.B) {
+	const writers = 100
+	for _, mode := range []FsyncMode{"", FsyncNever, FsyncInterval, FsyncAlways} {
+		name := string(mode)
+		if mode == "" {
+			name = "none"
+		}
+		b.Run(name, func(b *testing.B) {
+			kv := NewKeyValueStore(StoreOptions{})
+			snapshotter := NewSnapshotter(filepath.Join(b.TempDir(), "snapshot.jsonl"), kv)
+			if mode != "" {
+				if err := snapshotter.SetFsync(mode); err != nil {
+					b.Fatal(err)
+				}
+			}
+			defer snapshotter.Close()
+
+			var worker atomic.Int64
+			b.SetParallelism((writers + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := worker.Add(1)
+				for i := 0; pb.Next(); i++ {
+					body := fmt.Sprintf(`{"key":"key-%d-%d","value":"value"}`, id, i%100)
+					kv.SetHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(body)))
+				}
+			})
+		})
+	}
+}
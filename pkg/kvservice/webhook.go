@@ -0,0 +1,226 @@
+package kvservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webhooks:
+//   - url: http://inventory.internal/hooks/kv
+//     prefix: "sku:"
+//     events: [set, delete]
+// {"namespace":"default","key":"sku:42","event":"set","version":17,"time":"2024-05-01T12:00:00Z"}
+
+const (
+	WebhookEventSet    = "set"
+	WebhookEventDelete = "delete"
+)
+
+const (
+	// webhookBuffer is the number of notifications that may wait for delivery before further notifications are dropped
+	webhookBuffer = 1024
+	// webhookWorkers is the number of notifications delivered concurrently, so a slow receiver does not hold up the others
+	webhookWorkers = 4
+	// webhookAttempts is the number of times a notification is posted before it counts as failed
+	webhookAttempts = 5
+	// webhookBackoff is the wait after the first failed attempt, it doubles with every further attempt
+	webhookBackoff = 500 * time.Millisecond
+	// webhookTimeout bounds a single attempt
+	webhookTimeout = 10 * time.Second
+)
+
+// Webhook is a receiver notified about the changes of the keys with the prefix in any namespace
+// every write and delete is notified, including evictions and the last item popped from a list, but a flush is not notified key by key
+type Webhook struct {
+	// URL is redacted in the log, webhook URLs often carry a token in their path or query
+	URL    string `json:"url" secret:"true"`
+	Prefix string `json:"prefix"`
+	// Events are the events the receiver is notified about, set or delete, empty means all
+	Events []string `json:"events"`
+}
+
+// matches reports whether the receiver is notified about the event of the key
+func (h Webhook) matches(key Key, event string) bool {
+	if !strings.HasPrefix(string(key), h.Prefix) {
+		return false
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookNotification is the body posted to a receiver, it never contains the value itself
+type WebhookNotification struct {
+	Namespace string `json:"namespace"`
+	Key       Key    `json:"key"`
+	Event     string `json:"event"`
+	// Version is the version of the write for a set
+	Version uint64    `json:"version,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// webhookDelivery is a notification on its way to a receiver
+type webhookDelivery struct {
+	url          string
+	notification WebhookNotification
+}
+
+// Webhooks posts notifications about changed keys to the configured receivers in the background
+// notifying never blocks, when the receivers fall behind and the buffer is full the notification is dropped and counted
+// a notification is retried with exponential backoff and counted as failed once all attempts failed
+type Webhooks struct {
+	hooks  []Webhook
+	client *http.Client
+	queue  chan webhookDelivery
+	// stop aborts the deliveries in progress when Close runs out of time
+	stop     context.Context
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+	attempts int
+	backoff  time.Duration
+
+	failures atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// NewWebhooks starts delivering notifications to the receivers, Close stops it
+func NewWebhooks(hooks []Webhook, client *http.Client) *Webhooks {
+	return newWebhooks(hooks, client, webhookAttempts, webhookBackoff)
+}
+
+func newWebhooks(hooks []Webhook, client *http.Client, attempts int, backoff time.Duration) *Webhooks {
+	w := &Webhooks{
+		hooks:    hooks,
+		client:   client,
+		queue:    make(chan webhookDelivery, webhookBuffer),
+		attempts: attempts,
+		backoff:  backoff,
+	}
+	w.stop, w.cancel = context.WithCancel(context.Background())
+	for range webhookWorkers {
+		w.workers.Add(1)
+		go w.run()
+	}
+	return w
+}
+
+func (w *Webhooks) run() {
+	defer w.workers.Done()
+	for d := range w.queue {
+		// Close ran out of time, the rest of the queue is given up on
+		if w.stop.Err() != nil {
+			w.dropped.Add(1)
+			continue
+		}
+		if err := w.deliver(d); err != nil {
+			w.failures.Add(1)
+			slog.Error("failed to deliver webhook notification", "error", err, "url", redactURL(d.url), "event", d.notification.Event, "key", d.notification.Key)
+		}
+	}
+}
+
+// deliver posts the notification until the receiver accepts it with a 2xx status or the attempts are used up
+func (w *Webhooks) deliver(d webhookDelivery) error {
+	body, err := json.Marshal(d.notification)
+	if err != nil {
+		return err
+	}
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		err = w.post(d.url, body)
+		if err == nil || attempt == w.attempts {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-w.stop.Done():
+			return fmt.Errorf("shutting down after %d attempts: %w", attempt, err)
+		}
+	}
+}
+
+func (w *Webhooks) post(url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(w.stop, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// notify queues the notification for every receiver it matches or drops it if the buffer is full
+func (w *Webhooks) notify(n WebhookNotification) {
+	for _, h := range w.hooks {
+		if !h.matches(n.Key, n.Event) {
+			continue
+		}
+		select {
+		case w.queue <- webhookDelivery{url: h.URL, notification: n}:
+		default:
+			w.dropped.Add(1)
+		}
+	}
+}
+
+// Failures returns the number of notifications that could not be delivered in any attempt
+func (w *Webhooks) Failures() uint64 {
+	return w.failures.Load()
+}
+
+// Dropped returns the number of notifications that were dropped because the receivers could not keep up
+func (w *Webhooks) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Close delivers the queued notifications until ctx is done, then aborts the remaining ones, no notification may be added afterwards
+func (w *Webhooks) Close(ctx context.Context) error {
+	close(w.queue)
+	done := make(chan struct{})
+	go func() {
+		w.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// SetWebhooks notifies the receivers about the changes of the keys of the store and all its namespaces, nil disables notifications
+// it must be called before the store is served
+func (kv *KeyValueStore) SetWebhooks(w *Webhooks) {
+	kv.root.webhooks = w
+}
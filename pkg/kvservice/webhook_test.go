@@ -0,0 +1,142 @@
+package kvservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the notifications posted to it, answering the first failFirst attempts with 500
+type webhookReceiver struct {
+	*httptest.Server
+	attempts atomic.Int32
+	mu       sync.Mutex
+	received []WebhookNotification
+}
+
+func newWebhookReceiver(t *testing.T, failFirst int32) *webhookReceiver {
+	rcv := &webhookReceiver{}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rcv.attempts.Add(1) <= failFirst {
+			http.Error(w, "Unavailable", http.StatusInternalServerError)
+			return
+		}
+		var n WebhookNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		rcv.mu.Lock()
+		rcv.received = append(rcv.received, n)
+		rcv.mu.Unlock()
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *webhookReceiver) notifications() []WebhookNotification {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]WebhookNotification(nil), rcv.received...)
+}
+
+func TestWebhooks_Retry(t *testing.T) {
+	rcv := newWebhookReceiver(t, 1)
+	webhooks := newWebhooks([]Webhook{{URL: rcv.URL}}, rcv.Client(), 3, time.Millisecond)
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetWebhooks(webhooks)
+	env := Config{}
+
+	serveNamespace(env.routes(kv), "", http.MethodPost, "/set", `{"key":"a","value":"1"}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := webhooks.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := kv.peek("a")
+	received := rcv.notifications()
+	if len(received) != 1 || received[0].Key != "a" || received[0].Event != WebhookEventSet || received[0].Version != e.version || received[0].Namespace != DefaultNamespace {
+		t.Fatalf("expected a set notification of a with version %d but got %+v", e.version, received)
+	}
+	if got := rcv.attempts.Load(); got != 2 {
+		t.Errorf("expected the failed attempt to be retried once but got %d attempts", got)
+	}
+	if webhooks.Failures() != 0 {
+		t.Errorf("expected no failure but got %d", webhooks.Failures())
+	}
+}
+
+func TestWebhooks_Failure(t *testing.T) {
+	rcv := newWebhookReceiver(t, 100)
+	webhooks := newWebhooks([]Webhook{{URL: rcv.URL}}, rcv.Client(), 3, time.Millisecond)
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetWebhooks(webhooks)
+
+	writeTestValue(kv, "a", "1")
+	if err := webhooks.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rcv.attempts.Load(); got != 3 {
+		t.Errorf("expected 3 attempts but got %d", got)
+	}
+	if webhooks.Failures() != 1 {
+		t.Errorf("expected 1 failure but got %d", webhooks.Failures())
+	}
+}
+
+func TestWebhooks_Filter(t *testing.T) {
+	rcv := newWebhookReceiver(t, 0)
+	webhooks := newWebhooks([]Webhook{{URL: rcv.URL, Prefix: "sku:", Events: []string{WebhookEventDelete}}}, rcv.Client(), 1, time.Millisecond)
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetWebhooks(webhooks)
+	env := Config{}
+	handler := env.routes(kv)
+
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"sku:1","value":"1"}`)
+	serveNamespace(handler, "", http.MethodPost, "/set", `{"key":"order:1","value":"1"}`)
+	serveNamespace(handler, "", http.MethodDelete, "/kv/order:1", "")
+	serveNamespace(handler, "team-a", http.MethodDelete, "/kv/sku:1", "")
+	serveNamespace(handler, "", http.MethodDelete, "/kv/sku:1", "")
+	if err := webhooks.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	received := rcv.notifications()
+	if len(received) != 1 || received[0].Key != "sku:1" || received[0].Event != WebhookEventDelete {
+		t.Errorf("expected only the delete of sku:1 but got %+v", received)
+	}
+}
+
+func TestWebhooks_CloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer blocked.Close()
+	defer close(release)
+	webhooks := newWebhooks([]Webhook{{URL: blocked.URL}}, blocked.Client(), 1, time.Millisecond)
+	kv := NewKeyValueStore(StoreOptions{})
+	kv.SetWebhooks(webhooks)
+	for _, key := range []Key{"a", "b", "c", "d", "e", "f"} {
+		writeTestValue(kv, key, "1")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := webhooks.Close(ctx); err == nil {
+		t.Error("expected an error for the notifications that could not be delivered in time")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Close to give up at the deadline but it took %v", elapsed)
+	}
+	if got := webhooks.Failures() + webhooks.Dropped(); got != 6 {
+		t.Errorf("expected all 6 notifications to be failed or dropped but got %d", got)
+	}
+}
@@ -0,0 +1,299 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// websocat 'ws://localhost:8080/ws?values=true'
+// then send {"id":"1","op":"set","key":"key1","value":"value1"} or {"id":"2","op":"get","key":"key1"}
+
+const (
+	// webSocketPingInterval is how often the server pings a connection, so proxies do not drop it while no change happens
+	webSocketPingInterval = 30 * time.Second
+	// webSocketWriteTimeout bounds every message sent, a client that stops reading is disconnected
+	webSocketWriteTimeout = 10 * time.Second
+	// maxWebSocketCommand bounds the size of a command sent by a client
+	maxWebSocketCommand = 1 << 20
+)
+
+const (
+	// WebSocketOpGet reads the value of a key
+	WebSocketOpGet = "get"
+	// WebSocketOpSet writes the value of a key
+	WebSocketOpSet = "set"
+)
+
+// WebSocketCommand is a command a client sends over /ws, it is answered with a WebSocketReply carrying the same id
+type WebSocketCommand struct {
+	// ID is chosen by the client to match the reply to the command
+	ID    string `json:"id,omitempty"`
+	Op    string `json:"op"`
+	Key   Key    `json:"key"`
+	Value Value  `json:"value,omitempty"`
+	// Encoding is base64 for binary values, empty for plain strings
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// WebSocketReply answers a WebSocketCommand, it is told apart from the changes on the same connection by its op
+type WebSocketReply struct {
+	ID  string `json:"id,omitempty"`
+	Op  string `json:"op"`
+	Key Key    `json:"key,omitempty"`
+	// Value is the value of the key for a get that found it
+	Value *Value `json:"value,omitempty"`
+	// Found reports whether a get found the key
+	Found bool `json:"found,omitempty"`
+	// Version is the version of the write for a set
+	Version uint64 `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// webSocketSet tracks the open WebSocket connections, the server does not track hijacked connections so it closes them through it on shutdown
+type webSocketSet struct {
+	mu    sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}
+
+// add tracks the connection
+func (ws *webSocketSet) add(conn *websocket.Conn) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.conns == nil {
+		ws.conns = make(map[*websocket.Conn]struct{})
+	}
+	ws.conns[conn] = struct{}{}
+}
+
+// remove stops tracking the connection
+func (ws *webSocketSet) remove(conn *websocket.Conn) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.conns, conn)
+}
+
+// closeAll closes every open connection, the clients see a close frame and reconnect to another instance
+func (ws *webSocketSet) closeAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for conn := range ws.conns {
+		// a client that stops reading does not hold up the shutdown
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
+
+// CloseWebSockets closes the connections of /ws, register it with http.Server.RegisterOnShutdown as Shutdown does not close hijacked connections
+func (kv *KeyValueStore) CloseWebSockets() {
+	kv.root.webSockets.closeAll()
+}
+
+// webSocketOrigin accepts clients that send no Origin, which are not browsers, and browsers on a page of the service itself
+// a page of another site could otherwise read and write the store through the browser of the user
+func webSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil && origin.Host != r.Host {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// WebSocketHandler upgrades the connection to a WebSocket and streams the changes of the namespace on it, like /changes but pushed as they happen
+// every change is a text message holding a Change, since, all and values work as for /changes and without since the stream starts with the next change
+// clients may send WebSocketCommands to get and set keys of the namespace on the same connection, each is answered with a WebSocketReply
+// the connection is pinged every 30 seconds and closed once the client closes it, a message cannot be sent or the server shuts down
+// if the changes after since are no longer kept it answers 410 instead of upgrading
+func (kv *KeyValueStore) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kv, ok := kv.readNamespace(w, r, "")
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	since := kv.root.feed.last()
+	if v := query.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "Since must be a sequence number", http.StatusBadRequest)
+			return
+		}
+	}
+	namespace := kv.name
+	if query.Get("all") == "true" {
+		namespace = ""
+	}
+	values := query.Get("values") == "true"
+
+	if _, seq, _, ok := kv.root.feed.since(since, namespace); !ok {
+		w.Header().Set("X-Change-Seq", strconv.FormatUint(seq, 10))
+		writeError(w, http.StatusGone, "CHANGES_GONE", fmt.Sprintf("changes after %d are no longer kept, resync via /dump", since))
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: webSocketOrigin,
+		Handler: func(conn *websocket.Conn) {
+			kv.serveWebSocket(conn, r, since, namespace, values)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// serveWebSocket streams the changes after since to the connection and answers the commands read from it until either side fails
+func (kv *KeyValueStore) serveWebSocket(conn *websocket.Conn, r *http.Request, since uint64, namespace string, values bool) {
+	defer conn.Close()
+	kv.root.webSockets.add(conn)
+	defer kv.root.webSockets.remove(conn)
+	// the server may have closed the connections it knew of between the handshake and add
+	if kv.Stopping() {
+		return
+	}
+
+	// the deadlines the server set for the request would end the stream
+	conn.SetDeadline(time.Time{})
+	conn.MaxPayloadBytes = maxWebSocketCommand
+
+	// the changes, the replies and the pings are sent from two goroutines, a message must not be cut into by another
+	var mu sync.Mutex
+	send := func(payloadType byte, msg []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+		conn.PayloadType = payloadType
+		_, err := conn.Write(msg)
+		// the pongs the reader answers pings with are written without a deadline of their own
+		conn.SetWriteDeadline(time.Time{})
+		return err
+	}
+	sendJSON := func(v any) error {
+		msg, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return send(websocket.TextFrame, msg)
+	}
+
+	// the reader ends when the client closes the connection, or once the connection is closed below
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var msg []byte
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				if !errors.Is(err, io.EOF) {
+					slog.Debug("websocket closed", "client", r.RemoteAddr, "error", err)
+				}
+				return
+			}
+			var cmd WebSocketCommand
+			reply := WebSocketReply{Error: "invalid command"}
+			if err := json.Unmarshal(msg, &cmd); err == nil {
+				reply = kv.webSocketCommand(r, cmd)
+			}
+			if err := sendJSON(reply); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(webSocketPingInterval)
+	defer ping.Stop()
+	for {
+		changes, seq, woken, ok := kv.root.feed.since(since, namespace)
+		if !ok {
+			sendJSON(ErrorResponse{Code: "CHANGES_GONE", Message: fmt.Sprintf("changes after %d are no longer kept, resync via /dump", since)})
+			return
+		}
+		for _, c := range changes {
+			if values && c.Event == WebhookEventSet {
+				c = kv.Namespace(c.Namespace).withValue(c)
+			}
+			if err := sendJSON(c); err != nil {
+				return
+			}
+		}
+		since = seq
+
+		select {
+		case <-woken:
+		case <-ping.C:
+			if err := send(websocket.PingFrame, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// webSocketCommand executes the command in the namespace and returns its reply
+func (kv *KeyValueStore) webSocketCommand(r *http.Request, cmd WebSocketCommand) WebSocketReply {
+	reply := WebSocketReply{ID: cmd.ID, Op: cmd.Op, Key: cmd.Key}
+	switch cmd.Op {
+	case WebSocketOpGet:
+		s := kv.shard(cmd.Key)
+		s.lockGet()
+		e, ok := s.get(cmd.Key)
+		s.unlockGet()
+		switch {
+		case !ok:
+		case e.kind != kindString:
+			reply.Error = fmt.Sprintf("key %q does not hold a string value", cmd.Key)
+		default:
+			value := e.plain()
+			reply.Value, reply.Found = &value, true
+		}
+	case WebSocketOpSet:
+		if kv.ReadOnly() {
+			reply.Error = "the store is read-only"
+			return reply
+		}
+		value, err := decodeEncoding(cmd.Value, cmd.Encoding)
+		if err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		if err := kv.validateKey(cmd.Key); err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		if err := kv.validateValue(value); err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		s := kv.shard(cmd.Key)
+		s.Lock()
+		e, err := s.put(cmd.Key, value)
+		s.Unlock()
+		if err == nil {
+			err = kv.syncLog()
+		}
+		if err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		reply.Version = e.version
+		kv.audit(r, "set", cmd.Key, len(value), 0)
+	default:
+		reply.Error = "op must be " + WebSocketOpGet + " or " + WebSocketOpSet
+	}
+	return reply
+}
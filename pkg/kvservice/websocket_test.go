@@ -0,0 +1,99 @@
+package kvservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestKeyValueStore_WebSocketHandler(t *testing.T) {
+	_, url := startServer(t, Config{ServerAddress: "127.0.0.1:0", ShutdownTimeout: time.Second})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(url + "/readyz")
+		if err == nil && resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(url, "http") + "/ws?values=true"
+	conn, err := websocket.Dial(wsURL, "", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// receive reads the next message into v
+	receive := func(v any) {
+		t.Helper()
+		var msg []byte
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(msg, v); err != nil {
+			t.Fatalf("%v: %s", err, msg)
+		}
+	}
+
+	// a set over HTTP is pushed to the connection
+	resp, err := http.Post(url+"/set", "application/json", strings.NewReader(`{"key":"a","value":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var change Change
+	receive(&change)
+	if change.Event != WebhookEventSet || change.Key != "a" || change.Value == nil || *change.Value != "1" {
+		t.Errorf("expected the set of a with its value but got %+v", change)
+	}
+
+	// a set over the connection is answered and pushed like any other, in either order
+	websocket.JSON.Send(conn, WebSocketCommand{ID: "1", Op: WebSocketOpSet, Key: "b", Value: "2"})
+	var gotReply, gotChange bool
+	for !gotReply || !gotChange {
+		var msg map[string]any
+		receive(&msg)
+		switch {
+		case msg["op"] == WebSocketOpSet && msg["id"] == "1" && msg["key"] == "b" && msg["error"] == nil:
+			gotReply = true
+		case msg["event"] == WebhookEventSet && msg["key"] == "b" && msg["value"] == "2":
+			gotChange = true
+		default:
+			t.Fatalf("unexpected message %v", msg)
+		}
+	}
+
+	for _, tt := range []struct {
+		cmd  string
+		want WebSocketReply
+	}{
+		{cmd: `{"id":"2","op":"get","key":"b"}`, want: WebSocketReply{ID: "2", Op: WebSocketOpGet, Key: "b", Value: new(Value("2")), Found: true}},
+		{cmd: `{"id":"3","op":"get","key":"missing"}`, want: WebSocketReply{ID: "3", Op: WebSocketOpGet, Key: "missing"}},
+		{cmd: `{"id":"4","op":"delete","key":"b"}`, want: WebSocketReply{ID: "4", Op: "delete", Key: "b", Error: "op must be get or set"}},
+		{cmd: `{"id":`, want: WebSocketReply{Error: "invalid command"}},
+	} {
+		websocket.Message.Send(conn, tt.cmd)
+		var reply WebSocketReply
+		receive(&reply)
+		if reply.ID != tt.want.ID || reply.Op != tt.want.Op || reply.Key != tt.want.Key || reply.Found != tt.want.Found || reply.Error != tt.want.Error ||
+			(reply.Value == nil) != (tt.want.Value == nil) || (reply.Value != nil && *reply.Value != *tt.want.Value) {
+			t.Errorf("%s: expected %+v but got %+v", tt.cmd, tt.want, reply)
+		}
+	}
+
+	// a page of another site cannot use the browser of the user to reach the store
+	if other, err := websocket.Dial(wsURL, "", "http://example.com"); err == nil {
+		other.Close()
+		t.Error("expected a page of another site to be rejected")
+	}
+}
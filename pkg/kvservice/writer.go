@@ -0,0 +1,134 @@
+package kvservice
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// writerQueueSize is the number of writes waiting for the writer before the handlers block
+	writerQueueSize = 1024
+	// maxWriterBatch bounds the writes applied per batch, so the shards are not held for long while writes keep coming
+	maxWriterBatch = 256
+)
+
+// errWriterClosed is returned for writes sent after the writer was closed
+var errWriterClosed = errors.New("the writer is closed")
+
+// pendingWrite is a set waiting to be applied by the writer, its result is sent on done
+type pendingWrite struct {
+	kv    *KeyValueStore
+	key   Key
+	value Value
+	done  chan writeResult
+}
+
+// writeResult is the outcome of a pendingWrite
+type writeResult struct {
+	entry entry
+	err   error
+}
+
+// batchWriter applies the sets of all namespaces on a single goroutine, see Config.SingleWriter
+// it takes whatever queued up while the previous batch was applied and locks the shard of each write once per batch,
+// so under many concurrent writers the locks are taken far less often than once per write
+type batchWriter struct {
+	writes chan pendingWrite
+	// mu guards closed against the writes still being sent while the writer closes
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// newBatchWriter starts the writer, Close stops it
+func newBatchWriter() *batchWriter {
+	w := &batchWriter{
+		writes: make(chan pendingWrite, writerQueueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// set stores the value under the key of the namespace and returns once it is applied
+func (w *batchWriter) set(kv *KeyValueStore, key Key, value Value) (entry, error) {
+	write := pendingWrite{kv: kv, key: key, value: value, done: make(chan writeResult, 1)}
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return entry{}, errWriterClosed
+	}
+	w.writes <- write
+	w.mu.RUnlock()
+	result := <-write.done
+	return result.entry, result.err
+}
+
+// Close applies the writes already sent and stops the writer, later writes fail with errWriterClosed
+func (w *batchWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.writes)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *batchWriter) run() {
+	defer close(w.done)
+	batch := make([]pendingWrite, 0, maxWriterBatch)
+	for write := range w.writes {
+		batch = append(batch[:0], write)
+	collect:
+		for len(batch) < maxWriterBatch {
+			select {
+			case write, ok := <-w.writes:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, write)
+			default:
+				break collect
+			}
+		}
+		apply(batch)
+	}
+}
+
+// apply writes the batch shard by shard, the writes to a shard are applied in the order they were sent
+// so two sets of the same key end up with the later value, the handlers of a shard are released as soon as it is unlocked
+func apply(batch []pendingWrite) {
+	var order []*shard
+	byShard := make(map[*shard][]pendingWrite)
+	for _, write := range batch {
+		s := write.kv.shard(write.key)
+		if _, ok := byShard[s]; !ok {
+			order = append(order, s)
+		}
+		byShard[s] = append(byShard[s], write)
+	}
+
+	results := make([]writeResult, 0, len(batch))
+	for _, s := range order {
+		writes := byShard[s]
+		results = results[:0]
+		s.Lock()
+		for _, write := range writes {
+			e, err := s.put(write.key, write.value)
+			results = append(results, writeResult{entry: e, err: err})
+		}
+		s.Unlock()
+		// one sync of the write-ahead log covers the writes of the shard
+		if err := s.kv.syncLog(); err != nil {
+			for i := range results {
+				if results[i].err == nil {
+					results[i].err = err
+				}
+			}
+		}
+		for i, write := range writes {
+			write.done <- results[i]
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package kvservice
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchWriter(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{})
+	writer := newBatchWriter()
+	kv.writer = writer
+	team := kv.Namespace("team-a")
+
+	// every writer sets its own keys in order, the last value of each key must win
+	var wg sync.WaitGroup
+	for worker := range 100 {
+		wg.Go(func() {
+			for i := range 20 {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(fmt.Sprintf(`{"key":"worker-%d","value":"%d"}`, worker, i)))
+				if worker%2 == 1 {
+					r.Header.Set("X-Namespace", "team-a")
+				}
+				kv.SetHandler(w, r)
+				if w.Code != http.StatusOK {
+					t.Errorf("expected status %v but got %v %s", http.StatusOK, w.Code, w.Body.String())
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	for worker := range 100 {
+		ns := kv
+		if worker%2 == 1 {
+			ns = team
+		}
+		if e, ok := ns.peek(Key(fmt.Sprintf("worker-%d", worker))); !ok || string(e.plain()) != "19" {
+			t.Errorf("worker %d: expected the last value but got %q", worker, e.plain())
+		}
+	}
+
+	// once closed the writes are refused instead of lost
+	writer.Close()
+	w := httptest.NewRecorder()
+	kv.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"late","value":"1"}`)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %v but got %v %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+	if _, ok := kv.peek("late"); ok {
+		t.Error("expected the late write to be refused")
+	}
+}
+
+func TestBatchWriter_StoreFull(t *testing.T) {
+	kv := NewKeyValueStore(StoreOptions{MaxBytes: 10})
+	writer := newBatchWriter()
+	defer writer.Close()
+	kv.writer = writer
+
+	w := httptest.NewRecorder()
+	kv.SetHandler(w, httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(`{"key":"a","value":"this value does not fit"}`)))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("expected status %v but got %v %s", http.StatusInsufficientStorage, w.Code, w.Body.String())
+	}
+}
+
+// BenchmarkSetHandler_Writers compares the handlers locking the shards themselves with the single writer under 100 concurrent writers
+func BenchmarkSetHandler_Writers(b *testing.B) {
+	const writers = 100
+	for _, tt := range []struct {
+		name   string
+		single bool
+		shards int
+	}{
+		{name: "direct/1 shard", shards: 1},
+		{name: "single writer/1 shard", single: true, shards: 1},
+		{name: "direct/256 shards", shards: 256},
+		{name: "single writer/256 shards", single: true, shards: 256},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			kv := newKeyValueStore(StoreOptions{}, tt.shards)
+			if tt.single {
+				writer := newBatchWriter()
+				defer writer.Close()
+				kv.writer = writer
+			}
+
+			var mu sync.Mutex
+			var latencies []time.Duration
+			var worker atomic.Int64
+			b.SetParallelism((writers + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				id := worker.Add(1)
+				var own []time.Duration
+				for i := 0; pb.Next(); i++ {
+					body := fmt.Sprintf(`{"key":"key-%d-%d","value":"value"}`, id, i%100)
+					r := httptest.NewRequest(http.MethodPost, "/set", strings.NewReader(body))
+					start := time.Now()
+					kv.SetHandler(httptest.NewRecorder(), r)
+					own = append(own, time.Since(start))
+				}
+				mu.Lock()
+				latencies = append(latencies, own...)
+				mu.Unlock()
+			})
+			b.StopTimer()
+
+			slices.Sort(latencies)
+			if len(latencies) > 0 {
+				b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+			}
+		})
+	}
+}